@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey is a long-lived credential for scripts. Only the SHA-256 of the key
+// is stored; Prefix keeps enough of it to tell keys apart in listings. An
+// empty Scopes grants everything the owner can do.
+type APIKey struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id"`
+	UserId     primitive.ObjectID `json:"userId" bson:"userId"`
+	Label      string             `json:"label" bson:"label"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	KeyHash    string             `json:"-" bson:"keyHash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	ExpiresAt  *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Blob tracks how many file and version documents reference stored content,
+// so identical content is stored once. Its id is the BlobId of the content,
+// which StorageBackend keeps under StorageKey; records without them predate
+// configurable backends and point into GridFS.
+type Blob struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id"`
+	StorageBackend string             `json:"-" bson:"storageBackend,omitempty"`
+	StorageKey     string             `json:"-" bson:"storageKey,omitempty"`
+	Encrypted      bool               `json:"-" bson:"encrypted,omitempty"`
+	SHA256         string             `json:"sha256" bson:"sha256"`
+	Size           int64              `json:"size" bson:"size"`
+	RefCount       int64              `json:"refCount" bson:"refCount"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// Ref locates the content the record counts references to.
+func (b Blob) Ref() BlobRef {
+	return BlobRef{BlobId: b.Id, StorageBackend: b.StorageBackend, StorageKey: b.StorageKey, Encrypted: b.Encrypted}
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BlobKey is what encrypted content is decrypted with. Its id is the BlobId
+// of the content. The data key is stored wrapped by the master key KeyId
+// names; each chunk of ChunkSize plaintext bytes is sealed on its own with a
+// nonce of NoncePrefix and the chunk's index, and Size is the plaintext size.
+type BlobKey struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id"`
+	Scheme      string             `json:"scheme" bson:"scheme"`
+	KeyId       string             `json:"keyId" bson:"keyId"`
+	WrappedKey  []byte             `json:"-" bson:"wrappedKey"`
+	NoncePrefix []byte             `json:"-" bson:"noncePrefix"`
+	ChunkSize   int                `json:"chunkSize" bson:"chunkSize"`
+	Size        int64              `json:"size" bson:"size"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	RewrappedAt *time.Time         `json:"rewrappedAt,omitempty" bson:"rewrappedAt,omitempty"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Comment is a remark left on a file by someone with access to it. Mentions
+// are the users the text mentions by email who could see the file when it
+// was written.
+type Comment struct {
+	Id        primitive.ObjectID   `json:"id" bson:"_id"`
+	FileId    primitive.ObjectID   `json:"fileId" bson:"fileId"`
+	AuthorId  primitive.ObjectID   `json:"authorId" bson:"authorId"`
+	Text      string               `json:"text" bson:"text"`
+	Mentions  []primitive.ObjectID `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	CreatedAt time.Time            `json:"createdAt" bson:"createdAt"`
+	UpdatedAt *time.Time           `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
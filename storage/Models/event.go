@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event is an audit log entry. ActorId is nil for anonymous actions such as
+// failed logins and share link downloads.
+type Event struct {
+	Id         primitive.ObjectID  `json:"id" bson:"_id"`
+	ActorId    *primitive.ObjectID `json:"actorId" bson:"actorId"`
+	Action     string              `json:"action" bson:"action"`
+	TargetType string              `json:"targetType,omitempty" bson:"targetType,omitempty"`
+	TargetId   *primitive.ObjectID `json:"targetId,omitempty" bson:"targetId,omitempty"`
+	Details    map[string]any      `json:"details,omitempty" bson:"details,omitempty"`
+	IP         string              `json:"ip" bson:"ip"`
+	UserAgent  string              `json:"userAgent" bson:"userAgent"`
+	CreatedAt  time.Time           `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BlobRef locates stored content. BlobId identifies the content for
+// deduplication and derived data such as thumbnails; StorageKey is where
+// StorageBackend keeps it. Content stored before backends were configurable
+// has neither and lives in GridFS under BlobId. Encrypted content is
+// decrypted with the BlobKey under BlobId.
+type BlobRef struct {
+	BlobId         primitive.ObjectID `json:"-" bson:"gridfsId"`
+	StorageBackend string             `json:"-" bson:"storageBackend,omitempty"`
+	StorageKey     string             `json:"-" bson:"storageKey,omitempty"`
+	Encrypted      bool               `json:"-" bson:"encrypted,omitempty"`
+}
+
+// Virus scan states of stored content. Content stored while scanning was
+// off has no state and counts as clean.
+const (
+	ScanScanning   = "scanning"
+	ScanClean      = "clean"
+	ScanQuarantine = "quarantined"
+	ScanFailed     = "failed"
+)
+
+// ScanResult is the virus scan verdict on stored content. Signature names
+// what was found in quarantined content, or why the scanner refused failed
+// content.
+type ScanResult struct {
+	ScanStatus    string     `json:"scanStatus,omitempty" bson:"scanStatus,omitempty"`
+	ScanSignature string     `json:"scanSignature,omitempty" bson:"scanSignature,omitempty"`
+	ScannedAt     *time.Time `json:"scannedAt,omitempty" bson:"scannedAt,omitempty"`
+}
+
+// File is the metadata document for an uploaded file.
+type File struct {
+	Id            primitive.ObjectID  `json:"id" bson:"_id"`
+	OwnerId       primitive.ObjectID  `json:"ownerId" bson:"ownerId"`
+	FolderId      *primitive.ObjectID `json:"folderId" bson:"folderId"`
+	Name          string              `json:"name" bson:"name"`
+	Size          int64               `json:"size" bson:"size"`
+	ContentType   string              `json:"contentType" bson:"contentType"`
+	Checksum      string              `json:"checksum" bson:"checksum"`
+	Version       int                 `json:"version" bson:"version,omitempty"`
+	Versions      []FileVersion       `json:"-" bson:"versions,omitempty"`
+	Tags          []string            `json:"tags,omitempty" bson:"tags,omitempty"`
+	CommentCount  int                 `json:"commentCount" bson:"commentCount,omitempty"`
+	Uploader      *FileUploader       `json:"uploader,omitempty" bson:"uploader,omitempty"` // received through a file request
+	ExtractedText string              `json:"-" bson:"extractedText,omitempty"`             // capped excerpt for search
+	CreatedAt     time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     *time.Time          `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+	DeletedAt     *time.Time          `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	ExpiresAt     *time.Time          `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	ExpiresIn     *int64              `json:"expiresIn,omitempty" bson:"-"` // seconds left, responses only
+	ScanLease     *time.Time          `json:"-" bson:"scanLease,omitempty"` // held by a scan worker until then
+	Live          bool                `json:"-" bson:"live,omitempty"`      // holds its name; unset once trashed or expired
+
+	BlobRef    `json:"-" bson:",inline"`
+	ScanResult `bson:",inline"`
+}
+
+// FileVersion is an earlier revision of a file's content, kept in
+// File.Versions oldest first.
+type FileVersion struct {
+	N           int       `json:"n" bson:"n"`
+	Size        int64     `json:"size" bson:"size"`
+	ContentType string    `json:"contentType" bson:"contentType"`
+	Checksum    string    `json:"checksum" bson:"checksum"`
+	UploadedAt  time.Time `json:"uploadedAt" bson:"uploadedAt"`
+
+	BlobRef    `json:"-" bson:",inline"`
+	ScanResult `bson:",inline"`
+}
+
+// CurrentVersion describes the file's current content as a FileVersion.
+// Files stored before versioning existed are version 1.
+func (f File) CurrentVersion() FileVersion {
+	n := f.Version
+	if n == 0 {
+		n = 1
+	}
+
+	uploadedAt := f.CreatedAt
+	if f.UpdatedAt != nil {
+		uploadedAt = *f.UpdatedAt
+	}
+
+	return FileVersion{
+		N:           n,
+		BlobRef:     f.BlobRef,
+		Size:        f.Size,
+		ContentType: f.ContentType,
+		Checksum:    f.Checksum,
+		UploadedAt:  uploadedAt,
+		ScanResult:  f.ScanResult,
+	}
+}
+
+// StoredBytes is the size of the current content plus every retained version.
+func (f File) StoredBytes() int64 {
+	total := f.Size
+	for _, version := range f.Versions {
+		total += version.Size
+	}
+	return total
+}
+
+// Blobs returns the stored content of the current version and every
+// retained one.
+func (f File) Blobs() []BlobRef {
+	refs := []BlobRef{f.BlobRef}
+	for _, version := range f.Versions {
+		refs = append(refs, version.BlobRef)
+	}
+	return refs
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FileRequest is a public link, resolved by its random Token, through which
+// anyone can upload files into the owner's FolderId without an account.
+// MaxFiles and MaxBytes cap what the request takes in total; FilesReceived
+// and BytesReceived count what it has taken so far. ClosedAt is set when the
+// owner closes the request.
+type FileRequest struct {
+	Id            primitive.ObjectID  `json:"id" bson:"_id"`
+	OwnerId       primitive.ObjectID  `json:"ownerId" bson:"ownerId"`
+	FolderId      *primitive.ObjectID `json:"folderId" bson:"folderId"`
+	Token         string              `json:"token" bson:"token"`
+	Title         string              `json:"title" bson:"title"`
+	Message       string              `json:"message,omitempty" bson:"message,omitempty"`
+	MaxFiles      *int                `json:"maxFiles,omitempty" bson:"maxFiles,omitempty"`
+	MaxBytes      *int64              `json:"maxBytes,omitempty" bson:"maxBytes,omitempty"`
+	ExpiresAt     *time.Time          `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	PasswordHash  string              `json:"-" bson:"passwordHash,omitempty"`
+	FilesReceived int                 `json:"filesReceived" bson:"filesReceived"`
+	BytesReceived int64               `json:"bytesReceived" bson:"bytesReceived"`
+	CreatedAt     time.Time           `json:"createdAt" bson:"createdAt"`
+	ClosedAt      *time.Time          `json:"closedAt,omitempty" bson:"closedAt,omitempty"`
+}
+
+// Expired reports whether the request's expiry has passed at now.
+func (r FileRequest) Expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
+// Full reports whether the request has taken as many files or bytes as it
+// allows.
+func (r FileRequest) Full() bool {
+	return (r.MaxFiles != nil && r.FilesReceived >= *r.MaxFiles) || (r.MaxBytes != nil && r.BytesReceived >= *r.MaxBytes)
+}
+
+// FileUploader records who sent a file through a file request, as they
+// described themselves.
+type FileUploader struct {
+	RequestId primitive.ObjectID `json:"requestId" bson:"requestId"`
+	Name      string             `json:"name,omitempty" bson:"name,omitempty"`
+	Email     string             `json:"email,omitempty" bson:"email,omitempty"`
+}
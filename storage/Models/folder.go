@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Folder groups files and other folders. A nil ParentId places it at the
+// owner's root.
+type Folder struct {
+	Id        primitive.ObjectID  `json:"id" bson:"_id"`
+	OwnerId   primitive.ObjectID  `json:"ownerId" bson:"ownerId"`
+	Name      string              `json:"name" bson:"name"`
+	ParentId  *primitive.ObjectID `json:"parentId" bson:"parentId"`
+	CreatedAt time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	GroupRoleAdmin  = "admin"
+	GroupRoleMember = "member"
+)
+
+// Group is a named set of users that files can be shared with at once. The
+// owner is always one of the members, as an admin; admins manage the
+// membership and members only benefit from it.
+type Group struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	OwnerId   primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+	Members   []GroupMember      `json:"members" bson:"members"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt *time.Time         `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+type GroupMember struct {
+	UserId  primitive.ObjectID `json:"userId" bson:"userId"`
+	Role    string             `json:"role" bson:"role"`
+	AddedAt time.Time          `json:"addedAt" bson:"addedAt"`
+}
+
+// Member returns the membership of userId, or nil when they aren't a member.
+func (g Group) Member(userId primitive.ObjectID) *GroupMember {
+	for i := range g.Members {
+		if g.Members[i].UserId == userId {
+			return &g.Members[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification is something that happened to a user's files while they may
+// not have been looking, kept until ExpiresAt so clients can catch up. Ids
+// increase over time, so clients resume streams from the last one they saw.
+type Notification struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id"`
+	UserId    primitive.ObjectID `json:"-" bson:"userId"`
+	Type      string             `json:"type" bson:"type"`
+	Data      map[string]any     `json:"data" bson:"data"`
+	ReadAt    *time.Time         `json:"readAt,omitempty" bson:"readAt,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time          `json:"-" bson:"expiresAt"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordResetToken lets the holder set a new password for UserId. Only the
+// SHA-256 of the token is stored; it is deleted once used.
+type PasswordResetToken struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	UserId    primitive.ObjectID `bson:"userId"`
+	Email     string             `bson:"email"`
+	TokenHash string             `bson:"tokenHash"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PermissionViewer = "viewer"
+	PermissionEditor = "editor"
+)
+
+// Permission grants a registered user, or every member of a group, access
+// to a file owned by someone else. Exactly one of UserId and GroupId is set.
+// OwnerId mirrors the file's owner so grants can be cleaned up with the
+// owner's other data.
+type Permission struct {
+	Id        primitive.ObjectID  `json:"id" bson:"_id"`
+	FileId    primitive.ObjectID  `json:"fileId" bson:"fileId"`
+	OwnerId   primitive.ObjectID  `json:"ownerId" bson:"ownerId"`
+	UserId    *primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"`
+	GroupId   *primitive.ObjectID `json:"groupId,omitempty" bson:"groupId,omitempty"`
+	Role      string              `json:"role" bson:"role"`
+	GrantedBy primitive.ObjectID  `json:"grantedBy" bson:"grantedBy"`
+	CreatedAt time.Time           `json:"createdAt" bson:"createdAt"`
+}
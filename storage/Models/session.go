@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is a refresh token family. TokenHash is the current refresh token;
+// every rotated-out hash is kept in PreviousHashes so reuse can be detected.
+type Session struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id"`
+	UserId         primitive.ObjectID `json:"userId" bson:"userId"`
+	TokenHash      string             `json:"-" bson:"tokenHash"`
+	PreviousHashes []string           `json:"-" bson:"previousHashes"`
+	UserAgent      string             `json:"userAgent" bson:"userAgent"`
+	IP             string             `json:"ip" bson:"ip"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+	LastUsedAt     time.Time          `json:"lastUsedAt" bson:"lastUsedAt"`
+	ExpiresAt      time.Time          `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt      *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Share is a public link to a file, resolved by its random Token or, when it
+// has one, its short Code. When PasswordHash is set the link is protected; PasswordVersion is bumped on
+// every password change so previously unlocked download tokens stop working.
+// Suspended is set while the owner's account is suspended.
+type Share struct {
+	Id              primitive.ObjectID `json:"id" bson:"_id"`
+	FileId          primitive.ObjectID `json:"fileId" bson:"fileId"`
+	OwnerId         primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+	CreatedBy       primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	Token           string             `json:"token" bson:"token"`
+	Code            string             `json:"code,omitempty" bson:"code,omitempty"`
+	CreatedAt       time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt       *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	PasswordHash    string             `json:"-" bson:"passwordHash,omitempty"`
+	PasswordVersion int                `json:"-" bson:"passwordVersion"`
+	Downloads       int64              `json:"downloads" bson:"downloads"`
+	MaxDownloads    *int64             `json:"maxDownloads,omitempty" bson:"maxDownloads,omitempty"`
+	RevokedAt       *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	Suspended       bool               `json:"suspended,omitempty" bson:"suspended,omitempty"`
+}
+
+// Expired reports whether the share's expiry has passed at now.
+func (s Share) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShareAccess is one download through a share link. Visitor is a keyed hash
+// of the visitor's IP address with its last bits cleared, so visits can be
+// told apart without keeping the address; it is empty while IP storage is
+// off. Country is set when a GeoIP database is configured.
+type ShareAccess struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id"`
+	ShareId     primitive.ObjectID `json:"-" bson:"shareId"`
+	OwnerId     primitive.ObjectID `json:"-" bson:"ownerId"`
+	Visitor     string             `json:"visitor,omitempty" bson:"visitor,omitempty"`
+	UserAgent   string             `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	Country     string             `json:"country,omitempty" bson:"country,omitempty"`
+	BytesServed int64              `json:"bytesServed" bson:"bytesServed"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+}
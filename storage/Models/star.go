@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	StarFile   = "file"
+	StarFolder = "folder"
+)
+
+// Star marks a file or folder as a favorite of one user. Stars live apart
+// from the target so users can star files shared with them.
+type Star struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id"`
+	UserId    primitive.ObjectID `json:"userId" bson:"userId"`
+	TargetId  primitive.ObjectID `json:"targetId" bson:"targetId"`
+	Kind      string             `json:"kind" bson:"kind"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
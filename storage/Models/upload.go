@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UploadSession tracks a resumable upload whose chunks arrive in separate
+// requests.
+type UploadSession struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id"`
+	OwnerId     primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+	Name        string             `json:"name" bson:"name"`
+	ContentType string             `json:"contentType" bson:"contentType"`
+	Size        int64              `json:"size" bson:"size"`
+	ChunkSize   int64              `json:"chunkSize" bson:"chunkSize"`
+	SHA256      string             `json:"sha256" bson:"sha256"`
+	Received    []int64            `json:"received" bson:"received"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time          `json:"expiresAt" bson:"expiresAt"`
+}
+
+// ChunkCount is the number of chunks the declared size splits into.
+func (s UploadSession) ChunkCount() int64 {
+	if s.Size == 0 {
+		return 1
+	}
+	return (s.Size + s.ChunkSize - 1) / s.ChunkSize
+}
+
+// ChunkLength is the exact length chunk n must have.
+func (s UploadSession) ChunkLength(n int64) int64 {
+	if n == s.ChunkCount()-1 {
+		return s.Size - n*s.ChunkSize
+	}
+	return s.ChunkSize
+}
+
+// UploadChunk is one temporary chunk of an UploadSession.
+type UploadChunk struct {
+	UploadId  primitive.ObjectID `bson:"uploadId"`
+	N         int64              `bson:"n"`
+	Data      []byte             `bson:"data"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+// Direct upload states. A pending upload waits for the client to put the
+// content in storage; a confirming one is being turned into a file.
+const (
+	DirectUploadPending    = "pending"
+	DirectUploadConfirming = "confirming"
+)
+
+// DirectUpload reserves a file whose content the client uploads straight to
+// the storage backend. It becomes the file, under the same id, once
+// confirmed.
+type DirectUpload struct {
+	Id          primitive.ObjectID  `json:"id" bson:"_id"`
+	OwnerId     primitive.ObjectID  `json:"ownerId" bson:"ownerId"`
+	FolderId    *primitive.ObjectID `json:"folderId" bson:"folderId"`
+	Name        string              `json:"name" bson:"name"`
+	Size        int64               `json:"size" bson:"size"`
+	SHA256      string              `json:"sha256" bson:"sha256"`
+	Status      string              `json:"status" bson:"status"`
+	MultipartId string              `json:"-" bson:"multipartId,omitempty"`
+	PartSize    int64               `json:"partSize,omitempty" bson:"partSize,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time           `json:"expiresAt" bson:"expiresAt"`
+
+	BlobRef `json:"-" bson:",inline"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// User is an account.
+type User struct {
+	Id                    primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	Name                  string              `json:"name" bson:"name" binding:"required,max=100"`
+	Email                 string              `json:"email" bson:"email" binding:"required,email"`
+	Password              string              `json:"password,omitempty" bson:"password,omitempty" binding:"required,min=8"` // unset for provider-only accounts
+	Role                  string              `json:"role" bson:"role" binding:"omitempty,oneof=user admin"`
+	Status                string              `json:"status,omitempty" bson:"status,omitempty"`
+	QuotaBytes            *int64              `json:"quotaBytes,omitempty" bson:"quotaBytes,omitempty" binding:"omitempty,min=0"`     // overrides the default quota
+	UsedBytes             int64               `json:"usedBytes" bson:"usedBytes"`                                                     // every file and version owned
+	DownloadRate          *int64              `json:"downloadRate,omitempty" bson:"downloadRate,omitempty" binding:"omitempty,min=0"` // bytes/s, overrides the role's
+	DisableAccessTracking bool                `json:"disableAccessTracking" bson:"disableAccessTracking,omitempty"`
+	DisableShareEmails    bool                `json:"disableShareEmails" bson:"disableShareEmails,omitempty"`
+	EmailVerified         *bool               `json:"emailVerified,omitempty" bson:"emailVerified,omitempty"`
+	TwoFactorEnabled      bool                `json:"twoFactorEnabled" bson:"twoFactorEnabled,omitempty"`
+	TOTPSecret            string              `json:"-" bson:"totpSecret,omitempty"`
+	TOTPPendingSecret     string              `json:"-" bson:"totpPendingSecret,omitempty"`
+	TOTPLastStep          int64               `json:"-" bson:"totpLastStep,omitempty"`
+	RecoveryCodes         []string            `json:"-" bson:"recoveryCodes,omitempty"` // hashed
+	Identities            []Identity          `json:"identities,omitempty" bson:"identities,omitempty"`
+	MustResetPassword     bool                `json:"mustResetPassword,omitempty" bson:"mustResetPassword,omitempty"`
+	AvatarId              *primitive.ObjectID `json:"-" bson:"avatarId,omitempty"`
+	AvatarURL             string              `json:"avatarUrl,omitempty" bson:"-"` // from AvatarId, responses only
+	DeletedAt             *time.Time          `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletionKeepsFiles    bool                `json:"-" bson:"deletionKeepsFiles,omitempty"`
+	Live                  bool                `json:"-" bson:"live,omitempty"` // holds its email; unset once deleted
+	CreatedAt             time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+const IdentityProviderGoogle = "google"
+
+// Identity links a User to an account at an external identity provider.
+// Subject is the provider's stable id for that account.
+type Identity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"-" bson:"subject"`
+	Email    string    `json:"email" bson:"email"`
+	LinkedAt time.Time `json:"linkedAt" bson:"linkedAt"`
+}
+
+// IsDeleted reports whether the account is deleted and waiting to be purged.
+func (u User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
+// IsVerified reports whether the user may upload and share. Accounts created
+// before verification existed have no EmailVerified and count as verified.
+func (u User) IsVerified() bool {
+	return u.EmailVerified == nil || *u.EmailVerified
+}
+
+// MarshalJSON drops the password hash so a User can be written to any
+// response as-is. Decoding still reads "password" from request bodies.
+func (u User) MarshalJSON() ([]byte, error) {
+	type safeUser User
+	out := safeUser(u)
+	out.Password = ""
+	return json.Marshal(out)
+}
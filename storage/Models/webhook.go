@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint that receives a signed POST for each subscribed
+// event on its owner's files. Repeated failed deliveries disable it, with the
+// cause kept in DisabledReason.
+type Webhook struct {
+	Id                  primitive.ObjectID `json:"id" bson:"_id"`
+	OwnerId             primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+	URL                 string             `json:"url" bson:"url"`
+	Secret              string             `json:"-" bson:"secret"`
+	Events              []string           `json:"events" bson:"events"`
+	Active              bool               `json:"active" bson:"active"`
+	DisabledReason      string             `json:"disabledReason,omitempty" bson:"disabledReason,omitempty"`
+	ConsecutiveFailures int                `json:"consecutiveFailures" bson:"consecutiveFailures"`
+	CreatedAt           time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt           *time.Time         `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+// WebhookDelivery is one event sent, or still to be sent, to a webhook. Body
+// is kept verbatim so every retry carries the same signature.
+type WebhookDelivery struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id"`
+	WebhookId      primitive.ObjectID `json:"webhookId" bson:"webhookId"`
+	EventId        primitive.ObjectID `json:"eventId" bson:"eventId"`
+	Event          string             `json:"event" bson:"event"`
+	Body           string             `json:"body" bson:"body"`
+	Status         string             `json:"status" bson:"status"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	ResponseStatus int                `json:"responseStatus,omitempty" bson:"responseStatus,omitempty"`
+	LastError      string             `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	NextAttemptAt  time.Time          `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+	DeliveredAt    *time.Time         `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+}
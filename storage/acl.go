@@ -0,0 +1,426 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var PermissionCollection string = "permissions"
+
+// fileAccess is the caller's level of access to a file. Higher levels include
+// everything the lower ones allow.
+type fileAccess int
+
+const (
+	accessNone fileAccess = iota
+	accessViewer
+	accessEditor
+	accessOwner
+)
+
+// resolveFileAccess works out the caller's access to file from ownership, the
+// admin role and any permission granted to them or to one of their groups.
+// The highest level granted wins.
+func resolveFileAccess(ctx context.Context, db *mongo.Database, c *gin.Context, file *models.File) (fileAccess, error) {
+	userId := currentUserID(c)
+	if file.OwnerId == userId || isAdmin(c) {
+		return accessOwner, nil
+	}
+
+	collection := db.Collection(PermissionCollection)
+
+	filter, err := grantFilter(ctx, db, userId)
+	if err != nil {
+		return accessNone, err
+	}
+	filter["fileId"] = file.Id
+
+	var permissions []models.Permission
+	if err := findAll(ctx, collection, filter, &permissions, options.Find().SetProjection(bson.M{"role": 1})); err != nil {
+		return accessNone, err
+	}
+
+	access := accessNone
+	for _, permission := range permissions {
+		if permission.Role == models.PermissionEditor {
+			return accessEditor, nil
+		}
+		access = accessViewer
+	}
+	return access, nil
+}
+
+// authorizeFileAccess reports whether the caller has at least the needed
+// access to file, writing a 403 or 500 response when it doesn't.
+func authorizeFileAccess(ctx context.Context, db *mongo.Database, c *gin.Context, file *models.File, need fileAccess) bool {
+	access, err := resolveFileAccess(ctx, db, c, file)
+	if err != nil {
+		respondError(c, err)
+		return false
+	}
+
+	if access < need {
+		respondError(c, forbidden("not allowed to access this file"))
+		return false
+	}
+	return true
+}
+
+// grantPermissionRequest names the grantee by userId, email or, to share
+// with every member of a group, groupId.
+type grantPermissionRequest struct {
+	UserId  string `json:"userId" binding:"required_without_all=Email GroupId,omitempty,len=24,hexadecimal"`
+	Email   string `json:"email" binding:"required_without_all=UserId GroupId,omitempty,email"`
+	GroupId string `json:"groupId" binding:"omitempty,excluded_with=UserId Email,len=24,hexadecimal"`
+	Role    string `json:"role" binding:"required,oneof=viewer editor"`
+}
+
+// GrantPermission handler
+func (fc *FileController) GrantPermission() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessEditor) {
+			return
+		}
+
+		if !scanAllowsSharing(c, file) {
+			return
+		}
+
+		var req grantPermissionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		if req.GroupId != "" {
+			grantToGroup(ctx, db, fc.cfg, c, file, req)
+			return
+		}
+
+		filter := bson.M{"email": normalizeEmail(req.Email), "deletedAt": userNotDeleted}
+		if req.UserId != "" {
+			objId, _ := primitive.ObjectIDFromHex(req.UserId)
+			filter = bson.M{"_id": objId, "deletedAt": userNotDeleted}
+		}
+
+		var grantee models.User
+		if err := db.Collection(UserCollection).FindOne(ctx, filter).Decode(&grantee); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("User not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		if grantee.Id == file.OwnerId {
+			respondError(c, badRequest("the owner already has full access"))
+			return
+		}
+
+		permission, err := upsertPermission(ctx, db, c, file, bson.M{"userId": grantee.Id}, req.Role)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		queueShareEmail(shareEmail{
+			Template: mailFileShared,
+			SharerId: currentUserID(c),
+			To:       grantee.Email,
+			FileName: file.Name,
+			Link:     fc.cfg.apiURL("/files/" + file.Id.Hex()),
+			Role:     req.Role,
+		})
+		notify(ctx, db, fc.cfg, grantee.Id, NotificationFileShared, gin.H{"fileId": file.Id, "name": file.Name, "role": req.Role, "sharedBy": currentUserID(c)})
+
+		c.JSON(http.StatusOK, permission)
+	}
+}
+
+// grantToGroup shares file with every member of the group req names, which
+// the caller must belong to. Members are notified in the app but not mailed,
+// since a group can be large.
+func grantToGroup(ctx context.Context, db *mongo.Database, cfg *Config, c *gin.Context, file *models.File, req grantPermissionRequest) {
+	groupId, _ := primitive.ObjectIDFromHex(req.GroupId)
+
+	var group models.Group
+	if err := db.Collection(GroupCollection).FindOne(ctx, bson.M{"_id": groupId}).Decode(&group); err != nil {
+		respondError(c, orNotFound(err, "Group not found"))
+		return
+	}
+	if group.Member(currentUserID(c)) == nil && !isAdmin(c) {
+		respondError(c, forbidden("not a member of this group"))
+		return
+	}
+
+	permission, err := upsertPermission(ctx, db, c, file, bson.M{"groupId": group.Id}, req.Role)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	for _, member := range group.Members {
+		if member.UserId == currentUserID(c) || member.UserId == file.OwnerId {
+			continue
+		}
+		notify(ctx, db, cfg, member.UserId, NotificationFileShared, gin.H{
+			"fileId": file.Id, "name": file.Name, "role": req.Role, "sharedBy": currentUserID(c), "groupId": group.Id,
+		})
+	}
+
+	c.JSON(http.StatusOK, permission)
+}
+
+// upsertPermission grants role on file to grantee, a userId or groupId
+// filter, or changes the role of an existing grant.
+func upsertPermission(ctx context.Context, db *mongo.Database, c *gin.Context, file *models.File, grantee bson.M, role string) (models.Permission, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"role":      role,
+			"grantedBy": currentUserID(c),
+			"ownerId":   file.OwnerId,
+		},
+		"$setOnInsert": bson.M{
+			"_id":       primitive.NewObjectID(),
+			"createdAt": now,
+		},
+	}
+
+	filter := bson.M{"fileId": file.Id}
+	for key, value := range grantee {
+		filter[key] = value
+	}
+
+	var permission models.Permission
+	err := db.Collection(PermissionCollection).FindOneAndUpdate(ctx,
+		filter,
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&permission)
+	return permission, err
+}
+
+// GetPermissions handler
+func (fc *FileController) GetPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(PermissionCollection)
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessEditor) {
+			return
+		}
+
+		cursor, err := collection.Find(ctx, bson.M{"fileId": file.Id}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		permissions := []models.Permission{}
+		if err = cursor.All(ctx, &permissions); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, permissions)
+	}
+}
+
+// RevokePermission handler revokes a grant to a user or, given a group's id,
+// to a group.
+func (fc *FileController) RevokePermission() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(PermissionCollection)
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		granteeId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		// Grantees may always drop their own access; a group's access is
+		// left to the owner.
+		if granteeId != currentUserID(c) && !authorizeFileOwner(c, file) {
+			return
+		}
+
+		filter := bson.M{"fileId": file.Id, "$or": bson.A{bson.M{"userId": granteeId}, bson.M{"groupId": granteeId}}}
+		result, err := collection.DeleteOne(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if result.DeletedCount == 0 {
+			respondError(c, notFound("Permission not found"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Permission revoked successfully"})
+	}
+}
+
+// sharedFileItem is a file listed in GET /files/shared-with-me.
+type sharedFileItem struct {
+	models.File
+	Role     string    `json:"role"`
+	SharedAt time.Time `json:"sharedAt"`
+}
+
+// GetSharedWithMe handler lists the files shared with the caller or with
+// their groups, most recently shared first. A file shared several ways is
+// listed once, with the highest role granted.
+func (fc *FileController) GetSharedWithMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+		permissions := db.Collection(PermissionCollection)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter, err := grantFilter(ctx, db, currentUserID(c))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		// Owners can be members of the groups they share with.
+		filter["ownerId"] = bson.M{"$ne": currentUserID(c)}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$group", Value: bson.M{
+				"_id":       "$fileId",
+				"editor":    bson.M{"$max": bson.M{"$eq": bson.A{"$role", models.PermissionEditor}}},
+				"createdAt": bson.M{"$min": "$createdAt"},
+			}}},
+			{{Key: "$facet", Value: bson.M{
+				"items": bson.A{
+					bson.D{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+					bson.D{{Key: "$skip", Value: (page.Page - 1) * page.Limit}},
+					bson.D{{Key: "$limit", Value: page.Limit}},
+				},
+				"total": bson.A{bson.D{{Key: "$count", Value: "n"}}},
+			}}},
+		}
+
+		cursor, err := permissions.Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var results []struct {
+			Items []struct {
+				FileId    primitive.ObjectID `bson:"_id"`
+				Editor    bool               `bson:"editor"`
+				CreatedAt time.Time          `bson:"createdAt"`
+			} `bson:"items"`
+			Total []struct {
+				N int64 `bson:"n"`
+			} `bson:"total"`
+		}
+		if err = cursor.All(ctx, &results); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var grants []models.Permission
+		var total int64
+		if len(results) > 0 {
+			for _, item := range results[0].Items {
+				role := models.PermissionViewer
+				if item.Editor {
+					role = models.PermissionEditor
+				}
+				grants = append(grants, models.Permission{FileId: item.FileId, Role: role, CreatedAt: item.CreatedAt})
+			}
+			if len(results[0].Total) > 0 {
+				total = results[0].Total[0].N
+			}
+		}
+
+		items, err := fc.filesForGrants(ctx, grants)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, page.Result(items, total))
+	}
+}
+
+// filesForGrants loads the files behind grants, keeping the grant order.
+func (fc *FileController) filesForGrants(ctx context.Context, grants []models.Permission) ([]sharedFileItem, error) {
+	items := []sharedFileItem{}
+	if len(grants) == 0 {
+		return items, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(grants))
+	for i, grant := range grants {
+		ids[i] = grant.FileId
+	}
+
+	collection := fc.db.Collection(FileCollection)
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deletedAt": notTrashed, "expiresAt": notExpired()})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []models.File
+	if err = cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+
+	byId := make(map[primitive.ObjectID]models.File, len(files))
+	for _, file := range files {
+		byId[file.Id] = file
+	}
+
+	for _, grant := range grants {
+		if file, ok := byId[grant.FileId]; ok {
+			items = append(items, sharedFileItem{File: file, Role: grant.Role, SharedAt: grant.CreatedAt})
+		}
+	}
+	return items, nil
+}
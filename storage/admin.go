@@ -0,0 +1,37 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AdminController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewAdminController(db *mongo.Database, cfg *Config) *AdminController {
+	return &AdminController{db, cfg}
+}
+
+// SetupRouter function
+func (ac *AdminController) BasicRoute(router *gin.RouterGroup) {
+	adminRouter := router.Group("/admin", AuthRequired(ac.db, ac.cfg), RequireScope(ac.cfg, scopeAdmin), RequireRole(models.RoleAdmin))
+	adminRouter.POST("/trash/purge", ac.PurgeTrash())
+	adminRouter.GET("/dedup/stats", ac.GetDedupStats())
+	adminRouter.POST("/encryption/rewrap", ac.RewrapKeys())
+	adminRouter.GET("/audit", ac.GetAuditLog())
+	adminRouter.GET("/audit/stats", ac.GetAuditStats())
+	adminRouter.DELETE("/lockouts", ac.ClearLockout())
+	adminRouter.GET("/users/export", ac.ExportUsers())
+	adminRouter.POST("/users/import", ac.ImportUsers())
+	adminRouter.POST("/users/:id/suspend", ac.SuspendUser())
+	adminRouter.POST("/users/:id/activate", ac.ActivateUser())
+	adminRouter.POST("/users/:id/restore", ac.RestoreUser())
+	adminRouter.GET("/files", ac.GetAllFiles())
+	adminRouter.GET("/files/export", ac.ExportFiles())
+	adminRouter.DELETE("/files/:id", ac.TakedownFile())
+	adminRouter.GET("/stats", ac.GetStats())
+}
@@ -0,0 +1,278 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// StatsDays is how many days of uploads GetStats reports.
+const StatsDays = 30
+
+// fileOwner is the part of a User shown next to files in admin listings.
+type fileOwner struct {
+	Id    primitive.ObjectID `json:"id" bson:"_id"`
+	Name  string             `json:"name" bson:"name"`
+	Email string             `json:"email" bson:"email"`
+}
+
+type adminFileItem struct {
+	models.File `bson:",inline"`
+	Owner       *fileOwner `json:"owner" bson:"owner"`
+}
+
+// fileOwnerStages join each file's owner as a fileOwner under "owner".
+var fileOwnerStages = mongo.Pipeline{
+	{{Key: "$lookup", Value: bson.M{
+		"from":         UserCollection,
+		"localField":   "ownerId",
+		"foreignField": "_id",
+		"pipeline":     bson.A{bson.M{"$project": bson.M{"name": 1, "email": 1}}},
+		"as":           "owner",
+	}}},
+	{{Key: "$set", Value: bson.M{"owner": bson.M{"$arrayElemAt": bson.A{"$owner", 0}}}}},
+}
+
+// adminFileFilter builds the GetAllFiles query. ?contentType= ending in "/"
+// matches the whole family, e.g. "image/". ?trashed=true|false limits the
+// listing to trashed or live files; both are listed by default.
+func adminFileFilter(c *gin.Context) (bson.M, error) {
+	filter := bson.M{}
+
+	if owner := c.Query("owner"); owner != "" {
+		ownerId, err := primitive.ObjectIDFromHex(owner)
+		if err != nil {
+			return nil, errors.New("invalid owner ID")
+		}
+		filter["ownerId"] = ownerId
+	}
+
+	if contentType := c.Query("contentType"); contentType != "" {
+		if strings.HasSuffix(contentType, "/") {
+			filter["contentType"] = bson.M{"$regex": "^" + regexp.QuoteMeta(contentType)}
+		} else {
+			filter["contentType"] = contentType
+		}
+	}
+
+	switch c.Query("trashed") {
+	case "true":
+		filter["deletedAt"] = bson.M{"$exists": true}
+	case "false":
+		filter["deletedAt"] = notTrashed
+	}
+
+	if err := rangeFilters(c, filter); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// GetAllFiles handler lists the files of every user, newest first, with
+// their owner's name and email.
+func (ac *AdminController) GetAllFiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := ac.db.Collection(FileCollection)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter, err := adminFileFilter(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+			{{Key: "$skip", Value: (page.Page - 1) * page.Limit}},
+			{{Key: "$limit", Value: page.Limit}},
+			{{Key: "$project", Value: bson.M{"versions": 0, "extractedText": 0}}},
+		}
+		pipeline = append(pipeline, fileOwnerStages...)
+
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		files := []adminFileItem{}
+		if err = cursor.All(ctx, &files); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, page.Result(files, total))
+	}
+}
+
+type takedownRequest struct {
+	Reason string `json:"reason" binding:"required,max=1000"`
+}
+
+// TakedownFile handler permanently removes any user's file. The reason is
+// recorded in the audit log and emailed to the owner.
+func (ac *AdminController) TakedownFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := ac.db
+
+		var req takedownRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		file, ok := lookupFile(ctx, ac.db, c, bson.M{})
+		if !ok {
+			return
+		}
+
+		failedBlobs, err := purgeFiles(ctx, ac.db, ac.cfg, bson.M{"_id": file.Id})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditFileDeleted, auditTargetFile, file.Id, gin.H{
+			"name":      file.Name,
+			"ownerId":   file.OwnerId,
+			"permanent": true,
+			"takedown":  true,
+			"reason":    req.Reason,
+		})
+		emitWebhookEvent(file.OwnerId, AuditFileDeleted, gin.H{"fileId": file.Id, "name": file.Name, "permanent": true, "takedown": true})
+
+		var owner models.User
+		if err := db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": file.OwnerId}).Decode(&owner); err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("loading owner of removed file %s failed: %v", file.Id.Hex(), err)
+			}
+		} else {
+			queueMail(owner.Email, mailFileRemoved, gin.H{"Name": owner.Name, "FileName": file.Name, "Reason": req.Reason})
+		}
+
+		if failedBlobs > 0 {
+			respondError(c, internalError("File removed but its stored content could not be deleted", nil).withDetails(gin.H{"fileId": file.Id.Hex()}))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "File removed successfully"})
+	}
+}
+
+// dailyUploads counts the files created on one UTC day.
+type dailyUploads struct {
+	Date  string `json:"date" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+	Bytes int64  `json:"bytes" bson:"bytes"`
+}
+
+// GetStats handler summarises the whole instance. Response:
+//
+//	{
+//	  "users": n,
+//	  "files": n,
+//	  "bytes": n,
+//	  "uploadsPerDay": [{"date": "2006-01-02", "count": n, "bytes": n}, ...]
+//	}
+//
+// files and bytes cover every file including versions and the trash, and
+// uploadsPerDay lists each of the last StatsDays days, oldest first.
+func (ac *AdminController) GetStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := ac.db
+
+		users, err := db.Collection(UserCollection).EstimatedDocumentCount(ctx)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		since := today.AddDate(0, 0, -(StatsDays - 1))
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$facet", Value: bson.M{
+				"totals": bson.A{
+					bson.D{{Key: "$group", Value: bson.M{"_id": nil, "count": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": storedBytesExpr}}}},
+				},
+				"days": bson.A{
+					bson.D{{Key: "$match", Value: bson.M{"createdAt": bson.M{"$gte": since}}}},
+					bson.D{{Key: "$group", Value: bson.M{
+						"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$createdAt", "timezone": "UTC"}},
+						"count": bson.M{"$sum": 1},
+						"bytes": bson.M{"$sum": "$size"},
+					}}},
+				},
+			}}},
+		}
+
+		cursor, err := db.Collection(FileCollection).Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var results []struct {
+			Totals []usageTotals  `bson:"totals"`
+			Days   []dailyUploads `bson:"days"`
+		}
+		if err := cursor.All(ctx, &results); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var totals usageTotals
+		byDay := map[string]dailyUploads{}
+		if len(results) > 0 {
+			if len(results[0].Totals) > 0 {
+				totals = results[0].Totals[0]
+			}
+			for _, day := range results[0].Days {
+				byDay[day.Date] = day
+			}
+		}
+
+		days := make([]dailyUploads, StatsDays)
+		for i := range days {
+			date := since.AddDate(0, 0, i).Format("2006-01-02")
+			day := byDay[date]
+			day.Date = date
+			days[i] = day
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"users":         users,
+			"files":         totals.Count,
+			"bytes":         totals.Bytes,
+			"uploadsPerDay": days,
+		})
+	}
+}
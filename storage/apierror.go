@@ -0,0 +1,185 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Error codes of APIError, stable for clients to switch on.
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeUnauthorized         = "unauthorized"
+	ErrCodeForbidden            = "forbidden"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeConflict             = "conflict"
+	ErrCodeGone                 = "gone"
+	ErrCodePayloadTooLarge      = "payload_too_large"
+	ErrCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrCodeUnprocessable        = "unprocessable"
+	ErrCodeRangeNotSatisfiable  = "range_not_satisfiable"
+	ErrCodeRateLimited          = "rate_limited"
+	ErrCodeTimeout              = "request_timeout"
+	ErrCodeInternal             = "internal"
+	ErrCodeNotImplemented       = "not_implemented"
+	ErrCodeUpstream             = "upstream_failed"
+	ErrCodeUnavailable          = "unavailable"
+)
+
+// APIError is the body of every error response written by respondError, as
+// {"error": {...}}. Message is meant for people, Code for programs; Details
+// carries structured extras such as the fields that failed validation.
+type APIError struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+
+	// cause is the underlying error, logged but never sent.
+	cause error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+func newAPIError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// withDetails returns a copy of e carrying details.
+func (e *APIError) withDetails(details any) *APIError {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// internalError is a 500 whose message, unlike the generic one, tells the
+// client what did and didn't happen. cause is only logged.
+func internalError(message string, cause error) *APIError {
+	return &APIError{Status: http.StatusInternalServerError, Code: ErrCodeInternal, Message: message, cause: cause}
+}
+
+func badRequest(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, message)
+}
+
+func unauthorized(message string) *APIError {
+	return newAPIError(http.StatusUnauthorized, ErrCodeUnauthorized, message)
+}
+
+func forbidden(message string) *APIError {
+	return newAPIError(http.StatusForbidden, ErrCodeForbidden, message)
+}
+
+func notFound(message string) *APIError {
+	return newAPIError(http.StatusNotFound, ErrCodeNotFound, message)
+}
+
+func conflict(message string) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeConflict, message)
+}
+
+func gone(message string) *APIError {
+	return newAPIError(http.StatusGone, ErrCodeGone, message)
+}
+
+func unavailable(message string) *APIError {
+	return newAPIError(http.StatusServiceUnavailable, ErrCodeUnavailable, message)
+}
+
+// invalidRequest reports a request body or parameter the handler could not
+// use. Binding validation failures list each failing field in Details; an
+// error that already is an *APIError is kept as it is.
+func invalidRequest(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return badRequest(err.Error())
+	}
+
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		fields = append(fields, FieldError{Field: fe.Field(), Message: validationMessage(fe)})
+	}
+	return badRequest("validation failed").withDetails(fields)
+}
+
+// orNotFound turns a "no documents" result into a 404 with message and
+// passes any other error through.
+func orNotFound(err error, message string) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return notFound(message)
+	}
+	return err
+}
+
+// orConflict turns a duplicate key error into a 409 with message and passes
+// any other error through.
+func orConflict(err error, message string) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return conflict(message)
+	}
+	return err
+}
+
+// toAPIError maps err to the response it should get. An *APIError anywhere
+// in the chain is used as it is; Mongo and context errors get their matching
+// status; anything else is a 500 whose message says nothing about the cause.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	var validationErrors validator.ValidationErrors
+	var tooLarge *UploadTooLargeError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &tooLarge):
+		return newAPIError(http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, tooLarge.Error()).withDetails(gin.H{"limit": tooLarge.Limit})
+	case errors.As(err, &validationErrors):
+		return invalidRequest(err)
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return notFound("resource not found")
+	case mongo.IsDuplicateKeyError(err):
+		return conflict("resource already exists")
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		return &APIError{Status: http.StatusGatewayTimeout, Code: ErrCodeTimeout, Message: "request timed out", cause: err}
+	default:
+		return internalError("internal server error", err)
+	}
+}
+
+// respondError aborts the request with the APIError for err, tagged with the
+// request ID. Server-side failures are logged with their cause, which the
+// client never sees.
+func respondError(c *gin.Context, err error) {
+	status, body := errorResponse(c, err)
+	c.AbortWithStatusJSON(status, body)
+}
+
+// errorResponse is the status and body respondError sends for err, for
+// writers that can't go through c.Writer, such as Timeout's.
+func errorResponse(c *gin.Context, err error) (int, gin.H) {
+	apiErr := *toAPIError(err)
+	apiErr.RequestID = c.GetString(requestIDKey)
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		cause := err
+		if apiErr.cause != nil {
+			cause = apiErr.cause
+		}
+		requestLog(c).Error("request failed", "route", c.FullPath(), "status", apiErr.Status, "message", apiErr.Message, "error", cause)
+	}
+	return apiErr.Status, gin.H{"error": apiErr}
+}
@@ -0,0 +1,189 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// errorBody is the decoded body of a response written by respondError.
+type errorBody struct {
+	Error struct {
+		Code      string         `json:"code"`
+		Message   string         `json:"message"`
+		Details   map[string]any `json:"details"`
+		RequestID string         `json:"requestId"`
+	} `json:"error"`
+}
+
+// decodeError decodes rec's body as an error response, failing t when it
+// isn't one.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errorBody {
+	t.Helper()
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding error body %q: %v", rec.Body.String(), err)
+	}
+	if body.Error.Code == "" || body.Error.Message == "" {
+		t.Fatalf("body %q is not an APIError", rec.Body.String())
+	}
+	return body
+}
+
+func TestToAPIError(t *testing.T) {
+	duplicate := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error collection: files index: name_1"}}}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"api error", conflict("name taken"), http.StatusConflict, ErrCodeConflict},
+		{"wrapped api error", fmt.Errorf("saving: %w", forbidden("no")), http.StatusForbidden, ErrCodeForbidden},
+		{"no documents", mongo.ErrNoDocuments, http.StatusNotFound, ErrCodeNotFound},
+		{"duplicate key", duplicate, http.StatusConflict, ErrCodeConflict},
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout, ErrCodeTimeout},
+		{"too large", &UploadTooLargeError{Limit: 10}, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge},
+		{"anything else", errors.New("connection refused"), http.StatusInternalServerError, ErrCodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toAPIError(tt.err)
+			if got.Status != tt.status || got.Code != tt.code {
+				t.Errorf("toAPIError(%v) = %d %s, want %d %s", tt.err, got.Status, got.Code, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestRespondErrorHidesCause(t *testing.T) {
+	for _, err := range []error{
+		errors.New("server selection error: context deadline exceeded, current topology: mongodb://db:27017"),
+		mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error collection: files"}}},
+		internalError("upload failed", errors.New("s3: AccessDenied")),
+	} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Set(requestIDKey, "req-1")
+
+		respondError(c, err)
+
+		if !c.IsAborted() {
+			t.Errorf("respondError(%v) did not abort the request", err)
+		}
+		body := decodeError(t, rec)
+		if body.Error.RequestID != "req-1" {
+			t.Errorf("requestId = %q, want req-1", body.Error.RequestID)
+		}
+		for _, leak := range []string{"mongodb://", "E11000", "AccessDenied", "topology"} {
+			if strings.Contains(rec.Body.String(), leak) {
+				t.Errorf("respondError(%v) leaked %q: %s", err, leak, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestRespondErrorDetails(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	respondError(c, unauthorized("password required").withDetails(gin.H{"passwordRequired": true}))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	body := decodeError(t, rec)
+	if body.Error.Code != ErrCodeUnauthorized || body.Error.Details["passwordRequired"] != true {
+		t.Errorf("body = %+v", body.Error)
+	}
+}
+
+func TestAuthRequiredRespondsWithAPIError(t *testing.T) {
+	router := gin.New()
+	router.GET("/", AuthRequired(nil, testConfig()), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	if body := decodeError(t, rec); body.Error.Code != ErrCodeUnauthorized {
+		t.Errorf("code = %q, want %q", body.Error.Code, ErrCodeUnauthorized)
+	}
+}
+
+func TestRequireRoleRespondsWithAPIError(t *testing.T) {
+	router := gin.New()
+	router.GET("/", RequireRole("admin"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+	if body := decodeError(t, rec); body.Error.Code != ErrCodeForbidden {
+		t.Errorf("code = %q, want %q", body.Error.Code, ErrCodeForbidden)
+	}
+}
+
+// TestNoHandWrittenErrorBodies fails for any gin.H with an "error" key
+// outside apierror.go, so every error response keeps the APIError shape and
+// none sends a raw error.
+func TestNoHandWrittenErrorBodies(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") || name == "apierror.go" {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			lit, ok := n.(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+			if sel, ok := lit.Type.(*ast.SelectorExpr); !ok || sel.Sel.Name != "H" {
+				return true
+			}
+			for _, elt := range lit.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if key, ok := kv.Key.(*ast.BasicLit); ok && key.Value == `"error"` {
+						t.Errorf("%s: error body written by hand; use respondError", fset.Position(kv.Pos()))
+					}
+				}
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var APIKeyCollection string = "apiKeys"
+
+// apiKeyHeader carries an API key in place of a bearer token.
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every key so leaked keys are easy to recognise.
+const apiKeyPrefix = "fsk_"
+
+// MaxAPIKeysPerUser caps how many keys one account may hold.
+const MaxAPIKeysPerUser = 20
+
+// apiKeyTouchInterval is how stale lastUsedAt may get before a request
+// refreshes it, so busy keys don't write on every call.
+const apiKeyTouchInterval = time.Minute
+
+// Scope resources. A key scoped to "files:read" may call the read routes of
+// the files resource and nothing else.
+const (
+	scopeFiles   = "files"
+	scopeShares  = "shares"
+	scopeAccount = "account"
+	scopeAdmin   = "admin"
+)
+
+// apiKeyScopes lists every scope a key can be given.
+var apiKeyScopes = []string{
+	"files:read", "files:write",
+	"shares:read", "shares:write",
+	"account:read", "account:write",
+	"admin:read", "admin:write",
+}
+
+// readRoutes are the non-GET routes that only read, and so need the read
+// scope of their resource.
+var readRoutes = map[string]bool{
+	"/files/download-zip": true,
+}
+
+type apiKeyTouch struct {
+	id primitive.ObjectID
+	at time.Time
+}
+
+var apiKeyTouches = make(chan apiKeyTouch, accessQueueSize)
+
+// authenticateAPIKey resolves raw to its owner and stores the same context
+// values as a bearer token would, plus the key's id and scopes.
+func authenticateAPIKey(c *gin.Context, db *mongo.Database, raw string) {
+	ctx := c.Request.Context()
+
+	hash := hashToken(raw)
+	var key models.APIKey
+	if err := db.Collection(APIKeyCollection).FindOne(ctx, bson.M{"keyHash": hash}).Decode(&key); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, unauthorized("invalid API key"))
+			return
+		}
+		respondError(c, err)
+		return
+	}
+
+	// The lookup used an index; compare again without leaking timing.
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hash)) != 1 {
+		respondError(c, unauthorized("invalid API key"))
+		return
+	}
+
+	now := time.Now()
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		respondError(c, unauthorized("API key has expired"))
+		return
+	}
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"role": 1, "status": 1, "deletedAt": 1})
+	if err := db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": key.UserId}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, unauthorized("invalid API key"))
+			return
+		}
+		respondError(c, err)
+		return
+	}
+
+	if user.IsDeleted() {
+		respondAccountDeleted(c)
+		return
+	}
+	if user.Status == models.UserStatusSuspended {
+		respondSuspended(c)
+		return
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		select {
+		case apiKeyTouches <- apiKeyTouch{id: key.Id, at: now}:
+		default:
+		}
+	}
+
+	c.Set("userID", key.UserId)
+	c.Set("role", user.Role)
+	c.Set("apiKeyID", key.Id)
+	c.Set("apiKeyScopes", key.Scopes)
+	c.Next()
+}
+
+// usingAPIKey reports whether the caller authenticated with an API key.
+func usingAPIKey(c *gin.Context) bool {
+	_, ok := c.Get("apiKeyID")
+	return ok
+}
+
+// RequireScope rejects API keys whose scopes don't cover resource: reads
+// need resource+":read", anything else resource+":write". Bearer tokens and
+// keys without scopes always pass. It must run after AuthRequired.
+func RequireScope(cfg *Config, resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get("apiKeyScopes")
+		scopes, _ := value.([]string)
+		if !ok || len(scopes) == 0 {
+			c.Next()
+			return
+		}
+
+		needed := resource + ":write"
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || readRoutes[cfg.routeTemplate(c)] {
+			needed = resource + ":read"
+		}
+
+		for _, scope := range scopes {
+			if scope == needed {
+				c.Next()
+				return
+			}
+		}
+		respondError(c, forbidden("API key lacks the "+needed+" scope"))
+	}
+}
+
+// StartAPIKeyTracker writes queued lastUsedAt updates until ctx is
+// cancelled.
+func StartAPIKeyTracker(ctx context.Context, db *mongo.Database) {
+	collection := db.Collection(APIKeyCollection)
+	startWorker(func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case touch := <-apiKeyTouches:
+				_, err := collection.UpdateOne(ctx, bson.M{"_id": touch.id}, bson.M{"$max": bson.M{"lastUsedAt": touch.at}})
+				if err != nil && ctx.Err() == nil {
+					log.Printf("recording use of API key %s failed: %v", touch.id.Hex(), err)
+				}
+			}
+		}
+	})
+}
+
+type createAPIKeyRequest struct {
+	Label     string     `json:"label" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"omitempty,dive,required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreateAPIKey handler issues a key for the caller. The key itself is only
+// in this response.
+func (uc *UserController) CreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(APIKeyCollection)
+
+		// A key must not be able to mint keys with more scopes than its own.
+		if usingAPIKey(c) {
+			respondError(c, forbidden("API keys cannot create API keys"))
+			return
+		}
+
+		var req createAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		scopes := []string{}
+		seen := map[string]bool{}
+		for _, scope := range req.Scopes {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if !containsString(apiKeyScopes, scope) {
+				respondError(c, badRequest("unknown scope "+scope).withDetails(gin.H{"allowedScopes": apiKeyScopes}))
+				return
+			}
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+
+		now := time.Now()
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+			respondError(c, badRequest("expiresAt must be in the future"))
+			return
+		}
+
+		userId := currentUserID(c)
+		count, err := collection.CountDocuments(ctx, bson.M{"userId": userId})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if count >= MaxAPIKeysPerUser {
+			respondError(c, conflict("too many API keys, revoke one first"))
+			return
+		}
+
+		token, err := randomToken(32)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		raw := apiKeyPrefix + token
+
+		key := models.APIKey{
+			Id:        primitive.NewObjectID(),
+			UserId:    userId,
+			Label:     req.Label,
+			Prefix:    raw[:len(apiKeyPrefix)+6],
+			KeyHash:   hashToken(raw),
+			Scopes:    scopes,
+			ExpiresAt: req.ExpiresAt,
+			CreatedAt: now,
+		}
+		if _, err := collection.InsertOne(ctx, key); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, userId, gin.H{"apiKeyCreated": key.Id, "scopes": scopes})
+		c.JSON(http.StatusCreated, gin.H{"key": raw, "apiKey": key})
+	}
+}
+
+// GetAPIKeys handler lists the caller's keys, newest first.
+func (uc *UserController) GetAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(APIKeyCollection)
+
+		opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+		cursor, err := collection.Find(ctx, bson.M{"userId": currentUserID(c)}, opts)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		keys := []models.APIKey{}
+		if err = cursor.All(ctx, &keys); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+// DeleteAPIKey handler revokes one of the caller's keys.
+func (uc *UserController) DeleteAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(APIKeyCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid API key ID"))
+			return
+		}
+
+		result, err := collection.DeleteOne(ctx, bson.M{"_id": objId, "userId": currentUserID(c)})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if result.DeletedCount == 0 {
+			respondError(c, notFound("API key not found"))
+			return
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, currentUserID(c), gin.H{"apiKeyRevoked": objId})
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+	}
+}
+
+// revokeUserAPIKeys deletes every API key belonging to userId.
+func revokeUserAPIKeys(ctx context.Context, db *mongo.Database, userId primitive.ObjectID) error {
+	_, err := db.Collection(APIKeyCollection).DeleteMany(ctx, bson.M{"userId": userId})
+	return err
+}
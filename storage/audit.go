@@ -0,0 +1,240 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var EventCollection string = "events"
+
+// auditBatchSize caps the events written by one InsertMany.
+const auditBatchSize = 100
+
+const (
+	AuditUserCreated     = "user.created"
+	AuditUserUpdated     = "user.updated"
+	AuditUserDeleted     = "user.deleted"
+	AuditUserSuspended   = "user.suspended"
+	AuditUserActivated   = "user.activated"
+	AuditUserRestored    = "user.restored"
+	AuditLoginSucceeded  = "auth.login"
+	AuditLoginFailed     = "auth.login_failed"
+	AuditLoginLocked     = "auth.login_locked"
+	AuditLockoutCleared  = "auth.lockout_cleared"
+	AuditFileUploaded    = "file.uploaded"
+	AuditFileDownloaded  = "file.downloaded"
+	AuditFileDeleted     = "file.deleted"
+	AuditFileQuarantined = "file.quarantined"
+	AuditShareCreated    = "share.created"
+	AuditShareRevoked    = "share.revoked"
+	AuditShareDownloaded = "share.downloaded"
+)
+
+const (
+	auditTargetUser  = "user"
+	auditTargetFile  = "file"
+	auditTargetShare = "share"
+)
+
+// auditEvents holds the events waiting to be written. StartAuditWriter
+// creates it with room for Config.AuditQueueSize; until then events are
+// dropped.
+var auditEvents chan models.Event
+
+// droppedAuditEvents counts events lost to a full queue since startup.
+var droppedAuditEvents atomic.Int64
+
+// audit records an action by the authenticated caller.
+func audit(c *gin.Context, action string, targetType string, targetId primitive.ObjectID, details gin.H) {
+	auditAs(c, currentUserID(c), action, targetType, targetId, details)
+}
+
+// auditAs records an action by actorId, which may be zero for anonymous
+// callers. It never blocks: when the queue is full the event is dropped.
+func auditAs(c *gin.Context, actorId primitive.ObjectID, action string, targetType string, targetId primitive.ObjectID, details gin.H) {
+	event := models.Event{
+		Id:         primitive.NewObjectID(),
+		Action:     action,
+		TargetType: targetType,
+		Details:    details,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		CreatedAt:  time.Now(),
+	}
+	if !actorId.IsZero() {
+		event.ActorId = &actorId
+	}
+	if !targetId.IsZero() {
+		event.TargetId = &targetId
+	}
+
+	select {
+	case auditEvents <- event:
+	default:
+		droppedAuditEvents.Add(1)
+	}
+}
+
+// StartAuditWriter writes queued events in batches until ctx is cancelled.
+func StartAuditWriter(ctx context.Context, db *mongo.Database, cfg *Config) {
+	collection := db.Collection(EventCollection)
+	events := make(chan models.Event, cfg.AuditQueueSize)
+	auditEvents = events
+
+	startWorker(func() {
+		for {
+			var event models.Event
+			select {
+			case <-ctx.Done():
+				return
+			case event = <-events:
+			}
+
+			batch := []interface{}{event}
+		drain:
+			for len(batch) < auditBatchSize {
+				select {
+				case event := <-events:
+					batch = append(batch, event)
+				default:
+					break drain
+				}
+			}
+
+			_, err := collection.InsertMany(ctx, batch)
+			if ctx.Err() != nil {
+				continue
+			}
+			recordJob("audit_write", err)
+			if err != nil {
+				droppedAuditEvents.Add(int64(len(batch)))
+				log.Printf("writing %d audit events failed: %v", len(batch), err)
+			}
+		}
+	})
+}
+
+// auditFilter builds an events query from the actor, action, targetType,
+// target, from and to query params.
+func auditFilter(c *gin.Context) (bson.M, error) {
+	filter := bson.M{}
+
+	for param, key := range map[string]string{"actor": "actorId", "target": "targetId"} {
+		if raw := c.Query(param); raw != "" {
+			id, err := primitive.ObjectIDFromHex(raw)
+			if err != nil {
+				return nil, errors.New("invalid " + param + " ID")
+			}
+			filter[key] = id
+		}
+	}
+
+	if action := c.Query("action"); action != "" {
+		filter["action"] = action
+	}
+	if targetType := c.Query("targetType"); targetType != "" {
+		filter["targetType"] = targetType
+	}
+
+	created := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if raw := c.Query(param); raw != "" {
+			value, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return nil, errors.New(param + " must be an RFC 3339 timestamp")
+			}
+			created[op] = value
+		}
+	}
+	if len(created) > 0 {
+		filter["createdAt"] = created
+	}
+
+	return filter, nil
+}
+
+// listEvents writes a page of the events matching filter, newest first.
+func listEvents(ctx context.Context, db *mongo.Database, c *gin.Context, filter bson.M) {
+	collection := db.Collection(EventCollection)
+
+	page, err := parsePagination(c)
+	if err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	cursor, err := collection.Find(ctx, filter, page.FindOptions().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page.Result(events, total))
+}
+
+// GetAuditLog handler lists audit events, filtered by actor, action,
+// targetType, target and a from/to time range.
+func (ac *AdminController) GetAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		filter, err := auditFilter(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		listEvents(ctx, ac.db, c, filter)
+	}
+}
+
+// GetAuditStats handler reports the audit queue depth and how many events
+// were dropped since startup.
+func (ac *AdminController) GetAuditStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"queued":   len(auditEvents),
+			"capacity": cap(auditEvents),
+			"dropped":  droppedAuditEvents.Load(),
+		})
+	}
+}
+
+// GetActivity handler lists the caller's own audit trail.
+func (uc *UserController) GetActivity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		filter, err := auditFilter(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+		filter["actorId"] = currentUserID(c)
+
+		listEvents(ctx, uc.db, c, filter)
+	}
+}
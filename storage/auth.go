@@ -0,0 +1,303 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrCodePasswordNotSet is the "code" of the 401 sent for a password login
+// to an account that only signs in with Google.
+const ErrCodePasswordNotSet = "password_not_set"
+
+type AuthController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewAuthController(db *mongo.Database, cfg *Config) *AuthController {
+	return &AuthController{db, cfg}
+}
+
+// SetupRouter function
+func (ac *AuthController) BasicRoute(router *gin.RouterGroup) {
+	limits := ac.cfg.RateLimits
+	authRouter := router.Group("/auth")
+	authRouter.POST("/login", RateLimit(ac.db, limits.Store, "login", limits.Login, byIP), ac.Login())
+	authRouter.POST("/2fa", RateLimit(ac.db, limits.Store, "login", limits.Login, byIP), ac.LoginTwoFactor())
+	authRouter.GET("/google/login", ac.GoogleLogin())
+	authRouter.GET("/google/callback", ac.GoogleCallback())
+	authRouter.POST("/refresh", ac.Refresh())
+	authRouter.GET("/verify", ac.VerifyEmail())
+	authRouter.POST("/verify/resend", AuthRequired(ac.db, ac.cfg), RequireScope(ac.cfg, scopeAccount), ac.ResendVerification())
+	authRouter.POST("/forgot-password", ac.ForgotPassword())
+	authRouter.POST("/reset-password", ac.ResetPassword())
+
+	sessionRouter := authRouter.Group("/sessions", AuthRequired(ac.db, ac.cfg), RequireScope(ac.cfg, scopeAccount))
+	sessionRouter.GET("/", ac.GetSessions())
+	sessionRouter.DELETE("/:id", ac.DeleteSession())
+}
+
+type loginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Login handler
+func (ac *AuthController) Login() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := ac.db.Collection(UserCollection)
+
+		var req loginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		email := normalizeEmail(req.Email)
+		keys := loginFailureKeys(email, c.ClientIP())
+		lockedFor, err := loginLockedFor(ctx, ac.db, keys)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if lockedFor > 0 {
+			respondLoginLocked(c, lockedFor)
+			return
+		}
+
+		var user models.User
+		err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+		if err != nil && err != mongo.ErrNoDocuments {
+			respondError(c, err)
+			return
+		}
+
+		// The password is checked first, even for accounts that can't sign
+		// in with one, so that the timing doesn't reveal which addresses are
+		// registered.
+		found := err == nil
+		hash := user.Password
+		if hash == "" {
+			hash = dummyPasswordHash()
+		}
+		matched := checkPassword(hash, req.Password)
+
+		// An account created through Google has no password to guess, so it
+		// is told how to sign in instead of being counted as a failure.
+		if found && user.Password == "" && len(user.Identities) > 0 {
+			respondError(c, newAPIError(http.StatusUnauthorized, ErrCodePasswordNotSet, "this account has no password, sign in with Google or set one with forgot-password"))
+			return
+		}
+
+		if !matched || !found || user.Password == "" {
+			auditAs(c, primitive.NilObjectID, AuditLoginFailed, auditTargetUser, user.Id, gin.H{"email": email})
+			if err := countLoginFailure(ctx, ac.db, ac.cfg.Lockout, c, email, user.Id); err != nil {
+				respondError(c, err)
+				return
+			}
+			respondError(c, unauthorized("invalid email or password"))
+			return
+		}
+
+		if user.MustResetPassword {
+			respondError(c, newAPIError(http.StatusForbidden, "password_reset_required", "set a password with the link from your invitation email, or request a new one with forgot-password"))
+			return
+		}
+
+		// Only the account's counter is reset; an IP guessing at many
+		// accounts must not be able to clear its own with one good login.
+		if _, err := clearLoginFailures(ctx, ac.db, keys[:1]); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		ac.startLogin(ctx, c, user, nil)
+	}
+}
+
+// startLogin continues a login once the first factor checked out: it turns
+// deleted and suspended accounts away, asks for the second factor when 2FA is on and
+// otherwise completes the login.
+func (ac *AuthController) startLogin(ctx context.Context, c *gin.Context, user models.User, details gin.H) {
+	if user.IsDeleted() {
+		respondAccountDeleted(c)
+		return
+	}
+	if user.Status == models.UserStatusSuspended {
+		respondSuspended(c)
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		challenge, expiresAt, err := issueTwoFactorChallenge(ac.cfg.JWTSecret, user)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"twoFactorRequired": true,
+			"challengeToken":    challenge,
+			"expiresIn":         int64(time.Until(expiresAt).Seconds()),
+		})
+		return
+	}
+
+	ac.completeLogin(ctx, c, user, details)
+}
+
+// completeLogin starts a session for user and writes the token response.
+func (ac *AuthController) completeLogin(ctx context.Context, c *gin.Context, user models.User, details gin.H) {
+	sessionId, refreshToken, err := createSession(ctx, ac.db, ac.cfg.RefreshTokenTTL, user.Id, c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	token, expiresAt, err := issueAccessToken(ac.cfg, user, sessionId)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	auditAs(c, user.Id, AuditLoginSucceeded, auditTargetUser, user.Id, details)
+	c.JSON(http.StatusOK, tokenResponse(token, expiresAt, refreshToken))
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// Refresh handler
+func (ac *AuthController) Refresh() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var req refreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		session, refreshToken, err := rotateSession(ctx, ac.db, ac.cfg.RefreshTokenTTL, req.RefreshToken)
+		if err == errInvalidRefreshToken || err == errRefreshTokenReused {
+			respondError(c, unauthorized(err.Error()))
+			return
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var user models.User
+		err = ac.db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": session.UserId}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			respondError(c, unauthorized(errInvalidRefreshToken.Error()))
+			return
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if user.IsDeleted() {
+			respondAccountDeleted(c)
+			return
+		}
+		if user.Status == models.UserStatusSuspended {
+			respondSuspended(c)
+			return
+		}
+
+		token, expiresAt, err := issueAccessToken(ac.cfg, user, session.Id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, tokenResponse(token, expiresAt, refreshToken))
+	}
+}
+
+// GetSessions handler
+func (ac *AuthController) GetSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := ac.db.Collection(SessionCollection)
+
+		userId := currentUserID(c)
+
+		filter := bson.M{
+			"userId":    userId,
+			"revokedAt": bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": time.Now()},
+		}
+		cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "lastUsedAt", Value: -1}}))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		sessions := []models.Session{}
+		if err = cursor.All(ctx, &sessions); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+// DeleteSession handler
+func (ac *AuthController) DeleteSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := ac.db.Collection(SessionCollection)
+
+		userId := currentUserID(c)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid session ID"))
+			return
+		}
+
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": objId, "userId": userId, "revokedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+		)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			respondError(c, notFound("Session not found"))
+			return
+		}
+		events.disconnect(userId, objId)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+	}
+}
+
+func tokenResponse(accessToken string, expiresAt time.Time, refreshToken string) gin.H {
+	return gin.H{
+		"accessToken":  accessToken,
+		"tokenType":    "Bearer",
+		"expiresIn":    int64(time.Until(expiresAt).Seconds()),
+		"refreshToken": refreshToken,
+	}
+}
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestLoginWithoutPassword(t *testing.T) {
+	mt := newMockDB(t)
+	user := models.User{Id: primitive.NewObjectID(), Email: "ada@example.com", Identities: []models.Identity{{Provider: models.IdentityProviderGoogle, Subject: "123"}}}
+
+	mt.Run("google account", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, LoginFailureCollection), found(mt, UserCollection, user))
+		router := gin.New()
+		router.POST("/auth/login", NewAuthController(mt.DB, testConfig()).Login())
+		rec := doRequest(router, http.MethodPost, "/auth/login", gin.H{"email": user.Email, "password": "correct horse battery"})
+
+		if rec.Code != http.StatusUnauthorized {
+			mt.Fatalf("status = %d, want 401", rec.Code)
+		}
+		if body := decodeError(mt.T, rec); body.Error.Code != ErrCodePasswordNotSet {
+			mt.Errorf("code = %q, want %q", body.Error.Code, ErrCodePasswordNotSet)
+		}
+		if n := len(mt.GetAllStartedEvents()); n != 2 {
+			mt.Errorf("sent %d commands, want the failure not counted", n)
+		}
+	})
+}
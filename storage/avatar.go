@@ -0,0 +1,340 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AvatarBucket is the GridFS bucket holding avatar images.
+var AvatarBucket string = "avatars"
+
+// AvatarFormField is the multipart field name carrying an avatar upload.
+const AvatarFormField = "avatar"
+
+// MaxAvatarBytes caps the size of an uploaded avatar.
+const MaxAvatarBytes = 2 << 20
+
+// AvatarSize is the edge in pixels avatars are cropped and scaled to.
+const AvatarSize = 256
+
+// avatarContentTypes are the sniffed types accepted as avatars.
+var avatarContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// avatarMeta is the GridFS metadata of an avatar.
+type avatarMeta struct {
+	UserId      primitive.ObjectID `bson:"userId"`
+	ContentType string             `bson:"contentType"`
+}
+
+type avatarDoc struct {
+	Id       primitive.ObjectID `bson:"_id"`
+	Length   int64              `bson:"length"`
+	Metadata avatarMeta         `bson:"metadata"`
+}
+
+func avatarBucket(db *mongo.Database) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(AvatarBucket))
+}
+
+// avatarURL is where the avatar avatarId of userId is served. The avatar id
+// in the query changes with every upload, so the URL can be cached for good.
+func (cfg *Config) avatarURL(userId primitive.ObjectID, avatarId primitive.ObjectID) string {
+	return cfg.apiURL("/users/" + userId.Hex() + "/avatar?v=" + avatarId.Hex())
+}
+
+// setAvatarURLs replaces the avatarId of each listed user with its
+// avatarUrl. When dropId is set the _id, only fetched to build the URL, is
+// removed as well.
+func setAvatarURLs(cfg *Config, users []bson.M, dropId bool) {
+	for _, user := range users {
+		avatarId, hasAvatar := user["avatarId"].(primitive.ObjectID)
+		userId, hasId := user["_id"].(primitive.ObjectID)
+		if hasAvatar && hasId {
+			user["avatarUrl"] = cfg.avatarURL(userId, avatarId)
+		}
+		delete(user, "avatarId")
+		if dropId {
+			delete(user, "_id")
+		}
+	}
+}
+
+// deleteAvatar removes one avatar blob, logging failures. A blob that is
+// already gone is not an error.
+func deleteAvatar(ctx context.Context, db *mongo.Database, avatarId primitive.ObjectID) {
+	bucket, err := avatarBucket(db)
+	if err == nil {
+		err = bucket.DeleteContext(ctx, avatarId)
+	}
+	if err != nil && err != gridfs.ErrFileNotFound {
+		log.Printf("deleting avatar %s failed: %v", avatarId.Hex(), err)
+	}
+}
+
+// deleteUserAvatars removes every avatar blob stored for userId.
+func deleteUserAvatars(ctx context.Context, db *mongo.Database, userId primitive.ObjectID) error {
+	bucket, err := avatarBucket(db)
+	if err != nil {
+		return err
+	}
+
+	var docs []avatarDoc
+	if err := findAll(ctx, bucket.GetFilesCollection(), bson.M{"metadata.userId": userId}, &docs, options.Find().SetProjection(bson.M{"_id": 1})); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := bucket.DeleteContext(ctx, doc.Id); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAvatarPart returns the content of the avatar part of a multipart
+// upload, or an *UploadTooLargeError past MaxAvatarBytes.
+func readAvatarPart(c *gin.Context) ([]byte, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("no " + AvatarFormField + " file uploaded")
+		}
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return nil, &UploadTooLargeError{Limit: MaxAvatarBytes}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != AvatarFormField || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		data, err := io.ReadAll(newLimitedReader(part, MaxAvatarBytes))
+		part.Close()
+		if errors.As(err, &maxBytes) {
+			return nil, &UploadTooLargeError{Limit: MaxAvatarBytes}
+		}
+		return data, err
+	}
+}
+
+// renderAvatar checks that data is an accepted image and crops and scales it
+// to AvatarSize square, refusing images of more than maxPixels. It returns
+// the encoded image and its content type.
+func renderAvatar(data []byte, maxPixels int64) ([]byte, string, error) {
+	if !avatarContentTypes[mediaType(http.DetectContentType(data))] {
+		return nil, "", errors.New("avatar must be a JPEG, PNG or WebP image")
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, "", errImageTooLarge
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, coverImage(img, AvatarSize, AvatarSize), format); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), encodedType(format), nil
+}
+
+// UploadAvatar handler sets the caller's avatar from the "avatar" part of a
+// multipart upload, replacing any previous one.
+func (uc *UserController) UploadAvatar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		if c.Request.ContentLength > MaxAvatarBytes+multipartSlack {
+			respondError(c, &UploadTooLargeError{Limit: MaxAvatarBytes})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxAvatarBytes+multipartSlack)
+
+		data, err := readAvatarPart(c)
+		var tooLarge *UploadTooLargeError
+		if errors.As(err, &tooLarge) {
+			respondError(c, tooLarge)
+			return
+		}
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		encoded, contentType, err := renderAvatar(data, uc.cfg.Images.MaxPixels)
+		if err == errImageTooLarge {
+			respondError(c, newAPIError(http.StatusUnprocessableEntity, ErrCodeUnprocessable, err.Error()).withDetails(gin.H{"maxPixels": uc.cfg.Images.MaxPixels}))
+			return
+		}
+		if err != nil {
+			respondError(c, newAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, err.Error()))
+			return
+		}
+
+		bucket, err := avatarBucket(uc.db)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		userId := currentUserID(c)
+		meta := avatarMeta{UserId: userId, ContentType: contentType}
+		avatarId, err := bucket.UploadFromStream(userId.Hex(), bytes.NewReader(encoded), options.GridFSUpload().SetMetadata(meta))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var previous struct {
+			AvatarId *primitive.ObjectID `bson:"avatarId"`
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before).SetProjection(bson.M{"avatarId": 1})
+		err = uc.db.Collection(UserCollection).
+			FindOneAndUpdate(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"avatarId": avatarId}}, opts).
+			Decode(&previous)
+		if err != nil {
+			deleteAvatar(ctx, uc.db, avatarId)
+			respondError(c, orNotFound(err, "User not found"))
+			return
+		}
+
+		if previous.AvatarId != nil {
+			deleteAvatar(ctx, uc.db, *previous.AvatarId)
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, userId, gin.H{"fields": []string{"avatar"}})
+		c.JSON(http.StatusOK, gin.H{"avatarUrl": uc.cfg.avatarURL(userId, avatarId)})
+	}
+}
+
+// GetAvatar handler serves a user's avatar. Users without one are redirected
+// to DefaultAvatarURL when it is set.
+func (uc *UserController) GetAvatar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		var user struct {
+			AvatarId *primitive.ObjectID `bson:"avatarId"`
+		}
+		opts := options.FindOne().SetProjection(bson.M{"avatarId": 1})
+		if err := uc.db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": objId}, opts).Decode(&user); err != nil {
+			respondError(c, orNotFound(err, "User not found"))
+			return
+		}
+
+		if user.AvatarId == nil {
+			if uc.cfg.DefaultAvatarURL != "" {
+				c.Redirect(http.StatusFound, uc.cfg.DefaultAvatarURL)
+				return
+			}
+			respondError(c, notFound("Avatar not found"))
+			return
+		}
+
+		// Each upload gets a new id, so a URL naming the current one never
+		// changes content. Plain URLs are revalidated with the ETag instead.
+		tag := `"` + user.AvatarId.Hex() + `"`
+		c.Header("ETag", tag)
+		if c.Query("v") == user.AvatarId.Hex() {
+			c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			c.Header("Cache-Control", "public, no-cache")
+		}
+		if c.GetHeader("If-None-Match") == tag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		bucket, err := avatarBucket(uc.db)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var doc avatarDoc
+		if err := bucket.GetFilesCollection().FindOne(ctx, bson.M{"_id": *user.AvatarId}).Decode(&doc); err != nil {
+			respondError(c, orNotFound(err, "Avatar not found"))
+			return
+		}
+
+		download, err := bucket.OpenDownloadStream(doc.Id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer download.Close()
+
+		c.Header("Content-Type", doc.Metadata.ContentType)
+		c.Header("Content-Length", strconv.FormatInt(doc.Length, 10))
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Status(http.StatusOK)
+		_, _ = io.Copy(c.Writer, download)
+	}
+}
+
+// DeleteAvatar handler removes the caller's avatar.
+func (uc *UserController) DeleteAvatar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		userId := currentUserID(c)
+
+		var previous struct {
+			AvatarId *primitive.ObjectID `bson:"avatarId"`
+		}
+		filter := bson.M{"_id": userId, "avatarId": bson.M{"$exists": true}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before).SetProjection(bson.M{"avatarId": 1})
+		err := uc.db.Collection(UserCollection).
+			FindOneAndUpdate(ctx, filter, bson.M{"$unset": bson.M{"avatarId": ""}}, opts).
+			Decode(&previous)
+		if err != nil {
+			respondError(c, orNotFound(err, "Avatar not found"))
+			return
+		}
+
+		if previous.AvatarId != nil {
+			deleteAvatar(ctx, uc.db, *previous.AvatarId)
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, userId, gin.H{"fields": []string{"avatar"}})
+		c.JSON(http.StatusOK, gin.H{"message": "Avatar removed successfully"})
+	}
+}
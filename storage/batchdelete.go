@@ -0,0 +1,193 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Statuses of the files of a batch delete.
+const (
+	batchDeleted   = "deleted"
+	batchNotFound  = "not_found"
+	batchForbidden = "forbidden"
+	batchFailed    = "failed"
+)
+
+type batchDeleteRequest struct {
+	FileIds []string `json:"fileIds" binding:"required,min=1,max=200,dive,len=24,hexadecimal"`
+}
+
+type batchDeleteResult struct {
+	FileId string `json:"fileId"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchDeleteResponse struct {
+	Deleted int                 `json:"deleted"`
+	Results []batchDeleteResult `json:"results"`
+}
+
+// BatchDeleteFiles handler deletes up to 200 files at once, as DeleteFile
+// does one: into the trash, or with ?permanent=true for good, trashed or
+// not. Each file is deleted or not on its own, and the result of each is
+// reported in request order.
+func (fc *FileController) BatchDeleteFiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		var req batchDeleteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+		permanent := c.Query("permanent") == "true"
+
+		objIds := make([]primitive.ObjectID, 0, len(req.FileIds))
+		seen := make(map[primitive.ObjectID]bool, len(req.FileIds))
+		for _, id := range req.FileIds {
+			objId, _ := primitive.ObjectIDFromHex(id)
+			if !seen[objId] {
+				seen[objId] = true
+				objIds = append(objIds, objId)
+			}
+		}
+
+		filter := bson.M{"_id": bson.M{"$in": objIds}}
+		if !permanent {
+			filter["deletedAt"] = notTrashed
+		}
+		var files []models.File
+		if err := findAll(ctx, db.Collection(FileCollection), filter, &files); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		byId := make(map[primitive.ObjectID]models.File, len(files))
+		for _, file := range files {
+			byId[file.Id] = file
+		}
+
+		status := make(map[primitive.ObjectID]batchDeleteResult, len(objIds))
+		var allowed []models.File
+		userId := currentUserID(c)
+		for _, objId := range objIds {
+			file, ok := byId[objId]
+			switch {
+			case !ok:
+				status[objId] = batchDeleteResult{Status: batchNotFound}
+			case file.OwnerId != userId && !isAdmin(c):
+				status[objId] = batchDeleteResult{Status: batchForbidden}
+			default:
+				allowed = append(allowed, file)
+			}
+		}
+
+		var deleted []models.File
+		if permanent {
+			deleted = fc.purgeBatch(ctx, allowed, status)
+		} else {
+			var err error
+			if deleted, err = fc.trashBatch(ctx, allowed, status); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+
+		for _, file := range deleted {
+			audit(c, AuditFileDeleted, auditTargetFile, file.Id, gin.H{"name": file.Name, "permanent": permanent, "batch": true})
+			emitWebhookEvent(file.OwnerId, AuditFileDeleted, gin.H{"fileId": file.Id, "name": file.Name, "permanent": permanent})
+		}
+
+		response := batchDeleteResponse{Deleted: len(deleted), Results: make([]batchDeleteResult, len(objIds))}
+		for i, objId := range objIds {
+			result := status[objId]
+			result.FileId = objId.Hex()
+			response.Results[i] = result
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// trashBatch moves files to the trash with one UpdateMany and returns those
+// it moved. They all get the same deletedAt, which tells them apart from
+// files trashed by another request in the meantime.
+func (fc *FileController) trashBatch(ctx context.Context, files []models.File, status map[primitive.ObjectID]batchDeleteResult) ([]models.File, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	collection := fc.db.Collection(FileCollection)
+
+	ids := make([]primitive.ObjectID, len(files))
+	for i, file := range files {
+		ids[i] = file.Id
+	}
+
+	// Mongo stores milliseconds, so deletedAt is matched as stored.
+	now := time.Now().Truncate(time.Millisecond)
+	filter := bson.M{"_id": bson.M{"$in": ids}, "deletedAt": notTrashed}
+	result, err := collection.UpdateMany(ctx, filter, trashUpdate(now))
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := map[primitive.ObjectID]bool{}
+	if result.ModifiedCount == int64(len(ids)) {
+		for _, id := range ids {
+			trashed[id] = true
+		}
+	} else {
+		moved, err := collection.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": ids}, "deletedAt": now})
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range moved {
+			if objId, ok := id.(primitive.ObjectID); ok {
+				trashed[objId] = true
+			}
+		}
+	}
+
+	var deleted []models.File
+	for _, file := range files {
+		if trashed[file.Id] {
+			status[file.Id] = batchDeleteResult{Status: batchDeleted}
+			deleted = append(deleted, file)
+		} else {
+			status[file.Id] = batchDeleteResult{Status: batchNotFound}
+		}
+	}
+	return deleted, nil
+}
+
+// purgeBatch permanently deletes files one at a time, each in its own
+// transaction, and returns those it deleted. Content that could not be
+// removed is logged by deleteBlobs for reconciliation.
+func (fc *FileController) purgeBatch(ctx context.Context, files []models.File, status map[primitive.ObjectID]batchDeleteResult) []models.File {
+	var deleted []models.File
+	for _, file := range files {
+		err := deleteFileRecords(ctx, fc.db, []models.File{file})
+		switch {
+		case err == mongo.ErrNoDocuments:
+			status[file.Id] = batchDeleteResult{Status: batchNotFound}
+		case err != nil:
+			Logger.Error("batch delete entry failed", "fileId", file.Id.Hex(), "error", err)
+			status[file.Id] = batchDeleteResult{Status: batchFailed, Error: toAPIError(err).Message}
+		default:
+			status[file.Id] = batchDeleteResult{Status: batchDeleted}
+			deleted = append(deleted, file)
+		}
+	}
+
+	purgeContent(ctx, fc.db, fc.cfg, deleted)
+	return deleted
+}
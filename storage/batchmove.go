@@ -0,0 +1,277 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errInvalidBatchOnConflict = errors.New("onConflict must be \"" + onConflictSkip + "\" or \"" + onConflictRename + "\"")
+
+type batchMoveRequest struct {
+	Ids []string `json:"ids" binding:"required,min=1,max=200,dive,len=24,hexadecimal"`
+	// TargetFolderId is the folder to move into; empty is the root.
+	TargetFolderId string `json:"targetFolderId" binding:"omitempty,len=24,hexadecimal"`
+}
+
+// batchMoveEntry reports one id of a batch move: its type and, once moved,
+// its name in the target folder, or why it wasn't moved.
+type batchMoveEntry struct {
+	Id     string `json:"id"`
+	Type   string `json:"type,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type batchMoveResponse struct {
+	Moved   []batchMoveEntry `json:"moved"`
+	Skipped []batchMoveEntry `json:"skipped"`
+	Failed  []batchMoveEntry `json:"failed"`
+}
+
+// batchMoves collects the writes of a batch move for one collection, with
+// the entry each write reports on.
+type batchMoves struct {
+	writes  []mongo.WriteModel
+	entries []batchMoveEntry
+}
+
+func (m *batchMoves) add(entry batchMoveEntry, id primitive.ObjectID, ownerId primitive.ObjectID, set bson.M) {
+	m.writes = append(m.writes, mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"_id": id, "ownerId": ownerId}).
+		SetUpdate(bson.M{"$set": set}))
+	m.entries = append(m.entries, entry)
+}
+
+// BatchMoveFiles handler moves up to 200 of the caller's files and folders
+// into one target folder. An entry whose name is taken in the target folder
+// is skipped, or with ?onConflict=rename moved under the first free " (n)"
+// name. A folder can't be moved into itself or below itself. Each entry is
+// moved or not on its own, and the response tells which.
+func (fc *FileController) BatchMoveFiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+		files := db.Collection(FileCollection)
+		folders := db.Collection(FolderCollection)
+
+		var req batchMoveRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		mode := c.DefaultQuery("onConflict", onConflictSkip)
+		if mode != onConflictSkip && mode != onConflictRename {
+			respondError(c, invalidRequest(errInvalidBatchOnConflict))
+			return
+		}
+
+		userId := currentUserID(c)
+		target, ok := resolveFolderParam(ctx, db, c, req.TargetFolderId, userId)
+		if !ok {
+			return
+		}
+
+		objIds := make([]primitive.ObjectID, 0, len(req.Ids))
+		seen := make(map[primitive.ObjectID]bool, len(req.Ids))
+		for _, id := range req.Ids {
+			objId, _ := primitive.ObjectIDFromHex(id)
+			if !seen[objId] {
+				seen[objId] = true
+				objIds = append(objIds, objId)
+			}
+		}
+
+		var fileDocs []models.File
+		if err := findAll(ctx, files, bson.M{"_id": bson.M{"$in": objIds}, "deletedAt": notTrashed, "expiresAt": notExpired()}, &fileDocs); err != nil {
+			respondError(c, err)
+			return
+		}
+		var folderDocs []models.Folder
+		if err := findAll(ctx, folders, bson.M{"_id": bson.M{"$in": objIds}}, &folderDocs); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		fileById := make(map[primitive.ObjectID]models.File, len(fileDocs))
+		for _, file := range fileDocs {
+			fileById[file.Id] = file
+		}
+		folderById := make(map[primitive.ObjectID]models.Folder, len(folderDocs))
+		for _, folder := range folderDocs {
+			folderById[folder.Id] = folder
+		}
+
+		response := batchMoveResponse{Moved: []batchMoveEntry{}, Skipped: []batchMoveEntry{}, Failed: []batchMoveEntry{}}
+		var folderMoves batchMoves
+		// Folder names claimed by earlier entries of the batch count as
+		// taken.
+		takenFolders := map[string]bool{}
+		now := time.Now()
+
+		for _, objId := range objIds {
+			entry := batchMoveEntry{Id: objId.Hex()}
+
+			if file, ok := fileById[objId]; ok {
+				entry.Type = "file"
+				switch {
+				case file.OwnerId != userId:
+					entry.Reason = "only the owner can move this file"
+					response.Failed = append(response.Failed, entry)
+				case sameFolder(file.FolderId, target):
+					entry.Reason = "already in the target folder"
+					response.Skipped = append(response.Skipped, entry)
+				default:
+					// The name index settles file names, so each file is
+					// moved as its name is claimed.
+					name, err := saveFileName(ctx, files, userId, target, file.Name, mode, nil, func(candidate string) error {
+						return moveFile(ctx, files, file.Id, userId, target, candidate)
+					})
+					if !recordMoveName(&response, &entry, name, err) {
+						continue
+					}
+					response.Moved = append(response.Moved, entry)
+				}
+				continue
+			}
+
+			folder, ok := folderById[objId]
+			if !ok {
+				entry.Reason = "not found"
+				response.Failed = append(response.Failed, entry)
+				continue
+			}
+
+			entry.Type = "folder"
+			if folder.OwnerId != userId {
+				entry.Reason = "not allowed to access this folder"
+				response.Failed = append(response.Failed, entry)
+				continue
+			}
+			if sameFolder(folder.ParentId, target) {
+				entry.Reason = "already in the target folder"
+				response.Skipped = append(response.Skipped, entry)
+				continue
+			}
+			if target != nil {
+				cycle, err := isSelfOrDescendant(ctx, folders, folder.Id, *target)
+				if err != nil {
+					respondError(c, err)
+					return
+				}
+				if cycle {
+					entry.Reason = errFolderCycle.Error()
+					response.Failed = append(response.Failed, entry)
+					continue
+				}
+			}
+
+			name, err := freeFolderName(ctx, folders, userId, target, folder.Name, mode, takenFolders)
+			if !recordMoveName(&response, &entry, name, err) {
+				continue
+			}
+			takenFolders[name] = true
+			folderMoves.add(entry, folder.Id, userId, bson.M{"parentId": target, "name": name, "updatedAt": now})
+		}
+
+		if err := applyBatchMoves(ctx, folders, folderMoves, &response); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// recordMoveName settles the name an entry gets in the target folder. It
+// reports false, having added the entry to the skipped or failed list, when
+// there is none.
+func recordMoveName(response *batchMoveResponse, entry *batchMoveEntry, name string, err error) bool {
+	switch {
+	case errors.Is(err, errNameConflict):
+		entry.Reason = moveNameTaken
+		response.Skipped = append(response.Skipped, *entry)
+		return false
+	case err != nil:
+		entry.Reason = batchMoveFailure(err)
+		response.Failed = append(response.Failed, *entry)
+		return false
+	}
+	entry.Name = name
+	return true
+}
+
+// moveFile moves the live file id of ownerId into folderId under name.
+func moveFile(ctx context.Context, files *mongo.Collection, id primitive.ObjectID, ownerId primitive.ObjectID, folderId *primitive.ObjectID, name string) error {
+	filter := bson.M{"_id": id, "ownerId": ownerId, "deletedAt": notTrashed}
+	result, err := files.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"folderId": folderId, "name": name}})
+	if err == nil && result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return err
+}
+
+// applyBatchMoves runs the writes of moves in one unordered BulkWrite, so one
+// failing write doesn't stop the others, and files each entry as moved or
+// failed.
+func applyBatchMoves(ctx context.Context, collection *mongo.Collection, moves batchMoves, response *batchMoveResponse) error {
+	if len(moves.writes) == 0 {
+		return nil
+	}
+
+	failed := map[int]string{}
+	_, err := collection.BulkWrite(ctx, moves.writes, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = batchMoveFailure(writeErr)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for i, entry := range moves.entries {
+		if reason, ok := failed[i]; ok {
+			entry.Name = ""
+			entry.Reason = reason
+			response.Failed = append(response.Failed, entry)
+			continue
+		}
+		response.Moved = append(response.Moved, entry)
+	}
+	return nil
+}
+
+const moveNameTaken = "an entry with this name already exists in the target folder"
+
+// batchMoveFailure is the reason given for an entry whose move failed with
+// err. Database errors are logged, not sent.
+func batchMoveFailure(err error) string {
+	if mongo.IsDuplicateKeyError(err) {
+		return moveNameTaken
+	}
+	apiErr := toAPIError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		Logger.Error("batch move entry failed", "error", err)
+	}
+	return apiErr.Message
+}
+
+// sameFolder reports whether two folder references, nil for the root, name
+// the same folder.
+func sameFolder(a *primitive.ObjectID, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// validationBody is a 400 from invalidRequest, whose details list the
+// fields that failed.
+type validationBody struct {
+	Error struct {
+		Code    string       `json:"code"`
+		Message string       `json:"message"`
+		Details []FieldError `json:"details"`
+	} `json:"error"`
+}
+
+func TestCreateUserValidation(t *testing.T) {
+	mt := newMockDB(t)
+	tests := []struct {
+		name string
+		body any
+		want []FieldError
+	}{
+		{"missing email", gin.H{"name": "Ada", "password": "correct horse"}, []FieldError{{"email", "is required"}}},
+		{"malformed email", gin.H{"name": "Ada", "email": "ada@", "password": "correct horse"}, []FieldError{{"email", "must be a valid email address"}}},
+		{"empty body", gin.H{}, []FieldError{{"name", "is required"}, {"email", "is required"}, {"password", "is required"}}},
+		{"short password", gin.H{"name": "Ada", "email": "ada@example.com", "password": "short"}, []FieldError{{"password", "must be at least 8 characters"}}},
+		{"unknown role", gin.H{"name": "Ada", "email": "ada@example.com", "password": "correct horse", "role": "root"}, []FieldError{{"role", "must be one of: user, admin"}}},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			rec := doRequest(userRouter(NewUserController(mt.DB, createUserConfig()), primitive.NilObjectID, ""), http.MethodPost, "/users/", tt.body)
+			if rec.Code != http.StatusBadRequest {
+				mt.Fatalf("status = %d, want 400", rec.Code)
+			}
+
+			var body validationBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				mt.Fatalf("decoding %s: %v", rec.Body.String(), err)
+			}
+			if body.Error.Code != ErrCodeInvalidRequest || len(body.Error.Details) != len(tt.want) {
+				mt.Fatalf("body = %s, want %v", rec.Body.String(), tt.want)
+			}
+			for i, want := range tt.want {
+				if body.Error.Details[i] != want {
+					mt.Errorf("details[%d] = %+v, want %+v", i, body.Error.Details[i], want)
+				}
+			}
+		})
+	}
+
+	mt.Run("valid", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection), mtest.CreateSuccessResponse())
+		rec := doRequest(userRouter(NewUserController(mt.DB, createUserConfig()), primitive.NilObjectID, ""), http.MethodPost, "/users/", gin.H{"name": "Ada", "email": "ada@example.com", "password": "correct horse"})
+		if rec.Code != http.StatusOK {
+			mt.Errorf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestDecodeStrictJSON(t *testing.T) {
+	type request struct {
+		Name string `json:"name" binding:"required"`
+	}
+	tests := map[string]bool{
+		`{"name":"Ada"}`:              true,
+		`{"name":"Ada","role":"x"}`:   false,
+		`{}`:                          false,
+		`{"name":"Ada"}{"name":"Bo"}`: false,
+		`not json`:                    false,
+	}
+	for raw, ok := range tests {
+		c, _ := gin.CreateTestContext(nil)
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", strings.NewReader(raw))
+		var req request
+		if err := decodeStrictJSON(c, &req); (err == nil) != ok {
+			t.Errorf("%s: err = %v, want ok %v", raw, err, ok)
+		}
+	}
+}
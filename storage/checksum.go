@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentSHA256Header carries the hex SHA-256 of a file's content, on
+// uploads as the client's expectation and on downloads as the stored value.
+const ContentSHA256Header = "X-Content-SHA256"
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// declaredChecksum reads the optional ContentSHA256Header of an upload,
+// writing a 400 response when it isn't a SHA-256 hex digest.
+func declaredChecksum(c *gin.Context) (string, bool) {
+	raw := c.GetHeader(ContentSHA256Header)
+	if raw == "" {
+		return "", true
+	}
+
+	digest := normalizeHex(raw)
+	if !sha256Hex.MatchString(digest) {
+		respondError(c, badRequest(ContentSHA256Header+" must be a hex encoded SHA-256 digest"))
+		return "", false
+	}
+	return digest, true
+}
+
+// respondChecksumMismatch writes the 422 response for an upload whose content
+// doesn't hash to the declared digest.
+func respondChecksumMismatch(c *gin.Context, declared string, actual string) {
+	err := newAPIError(http.StatusUnprocessableEntity, ErrCodeUnprocessable, "content does not match "+ContentSHA256Header)
+	respondError(c, err.withDetails(gin.H{"declared": declared, "actual": actual}))
+}
+
+// etag derives a strong ETag from a content checksum.
+func etag(checksum string) string {
+	return `"sha256-` + checksum + `"`
+}
+
+// VerifyFile handler re-reads a file's stored content and reports
+// whether it still hashes to the checksum in its metadata.
+func (fc *FileController) VerifyFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		download, err := openBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+		if err != nil {
+			if err == errBlobNotFound {
+				c.JSON(http.StatusOK, gin.H{"fileId": file.Id, "ok": false, "reason": "stored content is missing"})
+				return
+			}
+			respondError(c, err)
+			return
+		}
+		defer download.Close()
+
+		hasher := sha256.New()
+		size, err := io.Copy(hasher, download)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		actual := hex.EncodeToString(hasher.Sum(nil))
+
+		c.JSON(http.StatusOK, gin.H{
+			"fileId":       file.Id,
+			"ok":           actual == file.Checksum && size == file.Size,
+			"expected":     file.Checksum,
+			"actual":       actual,
+			"expectedSize": file.Size,
+			"actualSize":   size,
+		})
+	}
+}
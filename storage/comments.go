@@ -0,0 +1,328 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var CommentCollection string = "comments"
+
+// NotificationCommentMention is the notification a user gets when a comment
+// mentions them.
+const NotificationCommentMention = "comment.mention"
+
+// maxCommentMentions caps the mentions looked up in one comment; any after
+// that are left as plain text.
+const maxCommentMentions = 20
+
+// commentMention matches "@" followed by an email address, as in
+// "thanks @ana@example.com".
+var commentMention = regexp.MustCompile(`(?:^|[^\w@.])@([\w.%+-]+@[\w-]+(?:\.[\w-]+)+)`)
+
+type commentRequest struct {
+	Text string `json:"text" binding:"required,max=4000"`
+}
+
+// GetComments handler lists the comments on a file the caller can view,
+// oldest first.
+func (fc *FileController) GetComments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(CommentCollection)
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter := bson.M{"fileId": file.Id}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		comments := []models.Comment{}
+		opts := page.FindOptions().SetSort(bson.D{{Key: "_id", Value: 1}})
+		if err := findAll(ctx, collection, filter, &comments, opts); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, page.Result(comments, total))
+	}
+}
+
+// CreateComment handler adds the caller's comment to a file they can view
+// and notifies the users it mentions.
+func (fc *FileController) CreateComment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		file, ok := findFile(ctx, db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, db, c, file, accessViewer) {
+			return
+		}
+
+		var req commentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		mentions, err := resolveMentions(ctx, db, file, currentUserID(c), req.Text)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		comment := models.Comment{
+			Id:        primitive.NewObjectID(),
+			FileId:    file.Id,
+			AuthorId:  currentUserID(c),
+			Text:      req.Text,
+			Mentions:  mentions,
+			CreatedAt: time.Now(),
+		}
+
+		err = withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+			if _, err := db.Collection(CommentCollection).InsertOne(ctx, comment); err != nil {
+				return err
+			}
+			_, err := db.Collection(FileCollection).UpdateOne(ctx, bson.M{"_id": file.Id}, bson.M{"$inc": bson.M{"commentCount": 1}})
+			return err
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		notifyMentions(ctx, db, fc.cfg, file, &comment, mentions)
+
+		c.JSON(http.StatusCreated, comment)
+	}
+}
+
+// UpdateComment handler replaces the text of a comment. Only its author and
+// the file's owner may edit it; users newly mentioned are notified.
+func (fc *FileController) UpdateComment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		file, comment, ok := fc.findComment(ctx, c)
+		if !ok {
+			return
+		}
+
+		var req commentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		mentions, err := resolveMentions(ctx, db, file, comment.AuthorId, req.Text)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		set := bson.M{"text": req.Text, "updatedAt": time.Now()}
+		update := bson.M{"$set": set}
+		if len(mentions) > 0 {
+			set["mentions"] = mentions
+		} else {
+			update["$unset"] = bson.M{"mentions": ""}
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated models.Comment
+		if err := db.Collection(CommentCollection).FindOneAndUpdate(ctx, bson.M{"_id": comment.Id}, update, opts).Decode(&updated); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Comment not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		notified := make(map[primitive.ObjectID]bool, len(comment.Mentions))
+		for _, id := range comment.Mentions {
+			notified[id] = true
+		}
+		var added []primitive.ObjectID
+		for _, id := range mentions {
+			if !notified[id] {
+				added = append(added, id)
+			}
+		}
+		notifyMentions(ctx, db, fc.cfg, file, &updated, added)
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// DeleteComment handler removes a comment. Only its author and the file's
+// owner may delete it.
+func (fc *FileController) DeleteComment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		file, comment, ok := fc.findComment(ctx, c)
+		if !ok {
+			return
+		}
+
+		err := withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+			result, err := db.Collection(CommentCollection).DeleteOne(ctx, bson.M{"_id": comment.Id})
+			if err != nil {
+				return err
+			}
+			if result.DeletedCount == 0 {
+				return mongo.ErrNoDocuments
+			}
+			_, err = db.Collection(FileCollection).UpdateOne(ctx,
+				bson.M{"_id": file.Id, "commentCount": bson.M{"$gt": 0}},
+				bson.M{"$inc": bson.M{"commentCount": -1}},
+			)
+			return err
+		})
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Comment not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Comment deleted"})
+	}
+}
+
+// findComment loads the file and the comment named in the path, checking
+// that the caller can still view the file and either wrote the comment or
+// owns the file. It writes the error response when they can't.
+func (fc *FileController) findComment(ctx context.Context, c *gin.Context) (*models.File, *models.Comment, bool) {
+	file, ok := findFile(ctx, fc.db, c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	access, err := resolveFileAccess(ctx, fc.db, c, file)
+	if err != nil {
+		respondError(c, err)
+		return nil, nil, false
+	}
+	if access < accessViewer {
+		respondError(c, forbidden("not allowed to access this file"))
+		return nil, nil, false
+	}
+
+	objId, err := primitive.ObjectIDFromHex(c.Param("commentId"))
+	if err != nil {
+		respondError(c, badRequest("Invalid comment ID"))
+		return nil, nil, false
+	}
+
+	var comment models.Comment
+	if err := fc.db.Collection(CommentCollection).FindOne(ctx, bson.M{"_id": objId, "fileId": file.Id}).Decode(&comment); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, notFound("Comment not found"))
+			return nil, nil, false
+		}
+		respondError(c, err)
+		return nil, nil, false
+	}
+
+	if comment.AuthorId != currentUserID(c) && access < accessOwner {
+		respondError(c, forbidden("only the author or the file's owner can change this comment"))
+		return nil, nil, false
+	}
+	return file, &comment, true
+}
+
+// resolveMentions returns the users text mentions by email who can view
+// file, leaving out authorId. Addresses of unknown users or of users without
+// access are ignored, so mentions don't reveal who has an account.
+func resolveMentions(ctx context.Context, db *mongo.Database, file *models.File, authorId primitive.ObjectID, text string) ([]primitive.ObjectID, error) {
+	var emails []string
+	for _, match := range commentMention.FindAllStringSubmatch(text, -1) {
+		email := normalizeEmail(match[1])
+		if !containsString(emails, email) {
+			emails = append(emails, email)
+		}
+		if len(emails) == maxCommentMentions {
+			break
+		}
+	}
+	if len(emails) == 0 {
+		return nil, nil
+	}
+
+	userIds, err := db.Collection(UserCollection).Distinct(ctx, "_id", bson.M{"email": bson.M{"$in": emails}, "deletedAt": userNotDeleted})
+	if err != nil {
+		return nil, err
+	}
+	granted, err := fileGranteeIds(ctx, db, file.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	canView := map[primitive.ObjectID]bool{file.OwnerId: true}
+	for _, id := range granted {
+		if objId, ok := id.(primitive.ObjectID); ok {
+			canView[objId] = true
+		}
+	}
+
+	var mentions []primitive.ObjectID
+	for _, id := range userIds {
+		if objId, ok := id.(primitive.ObjectID); ok && objId != authorId && canView[objId] {
+			mentions = append(mentions, objId)
+		}
+	}
+	return mentions, nil
+}
+
+// notifyMentions tells each of userIds that comment mentions them.
+func notifyMentions(ctx context.Context, db *mongo.Database, cfg *Config, file *models.File, comment *models.Comment, userIds []primitive.ObjectID) {
+	for _, userId := range userIds {
+		notify(ctx, db, cfg, userId, NotificationCommentMention, gin.H{
+			"fileId":    file.Id,
+			"name":      file.Name,
+			"commentId": comment.Id,
+			"authorId":  comment.AuthorId,
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipPool reuses the gzip writers of one compression level.
+type gzipPool struct {
+	level   int
+	writers sync.Pool
+}
+
+func (p *gzipPool) get(w io.Writer) *gzip.Writer {
+	if gz, ok := p.writers.Get().(*gzip.Writer); ok {
+		gz.Reset(w)
+		return gz
+	}
+	gz, _ := gzip.NewWriterLevel(w, p.level)
+	return gz
+}
+
+// Gzip compresses JSON responses for clients that accept gzip, as
+// cfg.Gzip says. Anything else, in particular file contents that are
+// usually compressed already, passes straight through, as do the responses
+// of cfg.Gzip.ExcludedRoutes. A JSON body is held back until it reaches
+// cfg.Gzip.MinSize, the response is flushed or the handler returns, to
+// decide whether it is worth compressing. All responses of the routes it
+// covers carry Vary: Accept-Encoding.
+func Gzip(cfg *Config) gin.HandlerFunc {
+	settings := cfg.Gzip
+	pool := &gzipPool{level: settings.Level}
+	return func(c *gin.Context) {
+		if settings.Level == 0 || c.IsWebsocket() || containsString(settings.ExcludedRoutes, cfg.routeTemplate(c)) {
+			c.Next()
+			return
+		}
+		// Every response of a covered route depends on Accept-Encoding, even
+		// one that ends up uncompressed, or a cache could hand it to a client
+		// that asked for the other.
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: c.Writer, pool: pool, minSize: settings.MinSize}
+		c.Writer = gw
+		defer func() {
+			gw.finish()
+			c.Writer = gw.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter decides on the first bytes of the body whether to compress the
+// response, then either gzips or passes through everything written.
+type gzipWriter struct {
+	gin.ResponseWriter
+
+	pool    *gzipPool
+	minSize int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if !w.compressible() {
+			w.decide(false)
+		} else {
+			w.buf = append(w.buf, data...)
+			if len(w.buf) < w.minSize {
+				return len(data), nil
+			}
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+			return len(data), nil
+		}
+	}
+
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow sends the headers before any body, which leaves nothing to
+// compress.
+func (w *gzipWriter) WriteHeaderNow() {
+	if !w.decided {
+		w.decide(false)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Flush sends what has been written so far. A body still under minSize at
+// the first flush is streamed uncompressed.
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// compressible reports whether the response is JSON that has not been
+// encoded already.
+func (w *gzipWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	switch w.Status() {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// decide settles whether the response is compressed and writes out what was
+// held back.
+func (w *gzipWriter) decide(compress bool) error {
+	w.decided = true
+	if compress {
+		header := w.Header()
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = w.pool.get(w.ResponseWriter)
+	}
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	if w.gz != nil {
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// finish writes out a body that never reached minSize and completes the
+// gzip stream.
+func (w *gzipWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+		w.pool.writers.Put(w.gz)
+		w.gz = nil
+	}
+}
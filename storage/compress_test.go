@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipRouter serves a JSON body of size bytes at GET /files and, as an
+// excluded route, the same at GET /s/:token.
+func gzipRouter(size int) *gin.Engine {
+	cfg := testConfig()
+	handler := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": strings.Repeat("a", size)})
+	}
+	router := gin.New()
+	router.Use(Gzip(cfg))
+	router.GET(cfg.APIPrefix+"/files", handler)
+	router.GET(cfg.APIPrefix+"/s/:token", handler)
+	router.DELETE(cfg.APIPrefix+"/files", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return router
+}
+
+func gzipRequest(method, path, acceptEncoding string) *http.Request {
+	req := httptest.NewRequest(method, DefaultConfig().APIPrefix+path, nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	return req
+}
+
+func TestGzipCompressesLargeJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	gzipRouter(4096).ServeHTTP(rec, gzipRequest(http.MethodGet, "/files", "gzip, br"))
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil || len(body) < 4096 {
+		t.Errorf("decompressed %d bytes, %v", len(body), err)
+	}
+}
+
+func TestGzipAlwaysVaries(t *testing.T) {
+	tests := []struct {
+		name, method, acceptEncoding string
+		size                         int
+		compressed                   bool
+	}{
+		{"compressed", http.MethodGet, "gzip", 4096, true},
+		{"under the minimum", http.MethodGet, "gzip", 10, false},
+		{"no gzip", http.MethodGet, "br", 4096, false},
+		{"gzip refused", http.MethodGet, "gzip;q=0", 4096, false},
+		{"no content", http.MethodDelete, "gzip", 0, false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		gzipRouter(tt.size).ServeHTTP(rec, gzipRequest(tt.method, "/files", tt.acceptEncoding))
+
+		if got := rec.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %v, want Accept-Encoding once", tt.name, got)
+		}
+		if compressed := rec.Header().Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+			t.Errorf("%s: compressed = %v, want %v", tt.name, compressed, tt.compressed)
+		}
+	}
+}
+
+func TestGzipSkipsExcludedRoutes(t *testing.T) {
+	rec := httptest.NewRecorder()
+	gzipRouter(4096).ServeHTTP(rec, gzipRequest(http.MethodGet, "/s/abc", "gzip"))
+
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Vary") != "" {
+		t.Errorf("excluded route got Content-Encoding %q, Vary %q", rec.Header().Get("Content-Encoding"), rec.Header().Get("Vary"))
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                 false,
+		"gzip":             true,
+		"deflate, GZIP":    true,
+		"gzip;q=0":         false,
+		"gzip; q=0.5, br":  true,
+		"identity, br;q=1": false,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
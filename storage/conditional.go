@@ -0,0 +1,101 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validators identify one version of a response body for conditional
+// requests: a strong ETag and the time the content last changed.
+type validators struct {
+	etag     string
+	modified time.Time
+}
+
+// fileValidators returns the validators of file's current content.
+func fileValidators(file *models.File) validators {
+	v := validators{modified: file.CurrentVersion().UploadedAt}
+	if file.Checksum != "" {
+		v.etag = etag(file.Checksum)
+	}
+	return v
+}
+
+// setHeaders sends the validators as ETag and Last-Modified.
+func (v validators) setHeaders(c *gin.Context) {
+	if v.etag != "" {
+		c.Header("ETag", v.etag)
+	}
+	if !v.modified.IsZero() {
+		c.Header("Last-Modified", v.modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified evaluates If-None-Match, or when that is absent
+// If-Modified-Since, as RFC 7232 section 6 orders them.
+func (v validators) notModified(c *gin.Context) bool {
+	if header := c.GetHeader("If-None-Match"); header != "" {
+		return v.etag != "" && matchesETag(header, v.etag, false)
+	}
+	if header := c.GetHeader("If-Modified-Since"); header != "" && !v.modified.IsZero() {
+		since, err := http.ParseTime(header)
+		return err == nil && !v.modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// respondNotModified sends the validators and, when the client's copy is
+// still current, a 304 with no body. It reports whether it did.
+func (v validators) respondNotModified(c *gin.Context) bool {
+	v.setHeaders(c)
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+	if !v.notModified(c) {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// rangeApplies reports whether a Range header should be honoured given
+// If-Range (RFC 7233 section 3.2): only when the client's validator still
+// matches, comparing ETags strongly and dates exactly. Otherwise the whole
+// body is sent.
+func (v validators) rangeApplies(c *gin.Context) bool {
+	header := strings.TrimSpace(c.GetHeader("If-Range"))
+	if header == "" {
+		return true
+	}
+	if strings.HasPrefix(header, `"`) || strings.HasPrefix(header, "W/") {
+		return v.etag != "" && matchesETag(header, v.etag, true)
+	}
+
+	date, err := http.ParseTime(header)
+	return err == nil && !v.modified.IsZero() && v.modified.Truncate(time.Second).Equal(date)
+}
+
+// matchesETag reports whether a list of entity tags, or "*", matches tag.
+// Weak tags only match under weak comparison.
+func matchesETag(list string, tag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak, ok := strings.CutPrefix(candidate, "W/"); ok {
+			if strong {
+				continue
+			}
+			candidate = weak
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
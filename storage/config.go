@@ -0,0 +1,997 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of the server. LoadConfig reads it from the
+// environment; tests can start from DefaultConfig, point DatabaseName at a
+// throwaway database and hand it to SetupRouter.
+type Config struct {
+	// MongoURI is where Run connects; SetupRouter only uses DatabaseName.
+	MongoURI     string
+	DatabaseName string
+
+	// ListenAddr is the address Run serves on. On shutdown, in-flight
+	// requests get ShutdownGracePeriod to finish.
+	ListenAddr          string
+	ShutdownGracePeriod time.Duration
+
+	// RequestTimeout bounds API requests and StreamTimeout the upload and
+	// download routes; zero disables either.
+	RequestTimeout time.Duration
+	StreamTimeout  time.Duration
+
+	JWTSecret       []byte
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// DownloadURLTTL is how long the links from GET /files/:id/download-url
+	// stay valid.
+	DownloadURLTTL time.Duration
+
+	// DirectUploadTTL is how long a direct upload may take from its
+	// reservation to its confirmation, and how long its upload URLs stay
+	// valid.
+	DirectUploadTTL time.Duration
+
+	// NotificationTTL is how long notifications are kept, read or not.
+	NotificationTTL time.Duration
+
+	// PublicBaseURL is prefixed to links in responses and emails, e.g.
+	// "https://files.example.com".
+	PublicBaseURL    string
+	PasswordResetURL string
+
+	// APIPrefix is the path the API is mounted under. LegacyRoutes also
+	// serves it at the unprefixed paths of earlier releases, with a
+	// Deprecation header.
+	APIPrefix    string
+	LegacyRoutes bool
+
+	// MaxUploadSize caps a single upload; RoleUploadLimits overrides it per
+	// role where non-zero.
+	MaxUploadSize    int64
+	RoleUploadLimits map[string]int64
+
+	// UploadOnConflict is what uploads without ?onConflict= do when the
+	// name is taken: overwrite, rename or error.
+	UploadOnConflict string
+
+	// DownloadRate caps each user's download bandwidth in bytes per second;
+	// RoleDownloadRates overrides it per role where non-zero, and
+	// ShareDownloadRate caps each share link's anonymous downloads. Zero is
+	// unlimited.
+	DownloadRate      int64
+	RoleDownloadRates map[string]int64
+	ShareDownloadRate int64
+
+	// AllowedContentTypes, when non-empty, lists the only content types
+	// uploads may have, and BlockedContentTypes those they may not. Entries
+	// may end in "/*" to match a whole family.
+	AllowedContentTypes []string
+	BlockedContentTypes []string
+
+	// DedupEnabled stores identical uploads once.
+	DedupEnabled bool
+
+	// DefaultQuotaBytes is the storage quota of users without their own.
+	DefaultQuotaBytes int64
+
+	// FileVersionLimit is how many earlier versions are kept per file.
+	FileVersionLimit int
+
+	// UploadSessionTTL is how long an unfinished resumable upload is kept.
+	UploadSessionTTL time.Duration
+
+	// TrashRetention is how long trashed files are kept; the purge job runs
+	// every TrashPurgeInterval.
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	// UserDeletionGrace is how long a deleted account can still be restored.
+	UserDeletionGrace time.Duration
+
+	// VerificationTokenTTL and InvitationTTL are how long email verification
+	// links and the set-password links of imported users stay valid.
+	VerificationTokenTTL time.Duration
+	InvitationTTL        time.Duration
+
+	// ShareCodeLimit caps the active share links with a short code per user.
+	ShareCodeLimit int
+
+	// PreviewBytes is how much text GET /files/:id/preview returns, and
+	// ExtractedTextLimit how much is kept per file for search.
+	PreviewBytes       int
+	ExtractedTextLimit int
+
+	// DefaultAvatarURL is where requests for a missing avatar are
+	// redirected; they get a 404 while it is empty.
+	DefaultAvatarURL string
+
+	// TOTPIssuer names the service in authenticator apps.
+	TOTPIssuer string
+
+	// AuditQueueSize bounds the audit events waiting to be written.
+	AuditQueueSize int
+
+	SMTP           SMTPConfig
+	CORS           CORSConfig
+	Gzip           GzipConfig
+	CacheControl   CacheControlConfig
+	RateLimits     RateLimitConfig
+	Lockout        LockoutConfig
+	Google         GoogleConfig
+	Images         ImageConfig
+	Webhooks       WebhookConfig
+	Storage        StorageConfig
+	Scan           ScanConfig
+	ShareAnalytics ShareAnalyticsConfig
+
+	RequireEmailVerification bool
+	BootstrapFirstAdmin      bool
+
+	// MetricsEnabled serves Prometheus metrics on /metrics, to admins only
+	// unless MetricsPublic is set.
+	MetricsEnabled bool
+	MetricsPublic  bool
+
+	// APIDocsEnabled serves the OpenAPI spec on /openapi.json and Swagger UI
+	// on /docs, under APIPrefix.
+	APIDocsEnabled bool
+}
+
+// SMTPConfig configures outgoing mail. Mail is disabled, and only logged,
+// while Host is empty.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+
+	// MaxAttempts is how many times sending a message is tried.
+	MaxAttempts int
+}
+
+// CacheControlConfig holds the Cache-Control values of file content: Private
+// for the caller's own files and Shared for downloads through a public share
+// link. Links with a password or a download limit always get Private.
+type CacheControlConfig struct {
+	Private string
+	Shared  string
+}
+
+// RateLimitConfig holds the request rate limits. Store is "memory" for a
+// single instance or "mongo" to share the buckets between instances.
+type RateLimitConfig struct {
+	Store       string
+	Login       RateLimitRule
+	Unlock      RateLimitRule
+	Upload      RateLimitRule
+	Download    RateLimitRule
+	FileRequest RateLimitRule
+	ShareCode   RateLimitRule
+	UserSearch  RateLimitRule
+}
+
+// LockoutConfig locks an account, or the source IP, for Cooldown after
+// Threshold failed logins within Window.
+type LockoutConfig struct {
+	Threshold int
+	Window    time.Duration
+	Cooldown  time.Duration
+}
+
+// GoogleConfig is the Google OAuth client. Google login is off unless all
+// three are set; RedirectURL must point at /auth/google/callback under the
+// API prefix, e.g. https://files.example.com/api/v1/auth/google/callback.
+type GoogleConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// ImageConfig bounds image processing. MaxPixels caps the images decoded for
+// thumbnails and resizing, so a small compressed file can't expand into
+// gigabytes; MaxDimension caps the width and height of resized images, and
+// CacheBytes is the memory budget of their cache, zero disabling it.
+type ImageConfig struct {
+	MaxPixels    int64
+	MaxDimension int
+	CacheBytes   int64
+}
+
+// WebhookConfig tunes webhook deliveries. A delivery is tried MaxAttempts
+// times, each request bounded by Timeout; the first retry waits RetryBase and
+// each later one twice as long, up to RetryMax. A webhook is disabled after
+// DisableAfter failed deliveries in a row.
+type WebhookConfig struct {
+	MaxAttempts  int
+	DisableAfter int
+	RetryBase    time.Duration
+	RetryMax     time.Duration
+	Timeout      time.Duration
+}
+
+// StorageConfig selects where file content is stored: Backend "gridfs",
+// inside MongoDB, "local", in files below LocalDir, or "s3", in the bucket
+// S3 describes. Content keeps being read from the backend it was stored in
+// after Backend changes.
+type StorageConfig struct {
+	Backend    string
+	LocalDir   string
+	S3         S3Config
+	Encryption EncryptionConfig
+}
+
+// S3Config is a bucket on AWS S3 or an S3-compatible server such as MinIO.
+// Endpoint defaults to AWS in Region, e.g. "https://s3.eu-west-1.amazonaws.com";
+// most other servers also need PathStyle, which puts the bucket in the path
+// instead of the host name.
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
+// EncryptionConfig turns on encryption of new file content at rest. Keys
+// are the 32-byte master keys by name and KeyId the one new data keys are
+// wrapped with; a retired key stays listed until POST
+// /admin/encryption/rewrap has moved every data key off it. Wrapper, when
+// set, is used instead of Keys, for example to wrap data keys with a KMS.
+type EncryptionConfig struct {
+	KeyId   string
+	Keys    map[string][]byte
+	Wrapper KeyWrapper
+}
+
+// keyWrapper returns what wraps data keys, or nil when encryption is off.
+func (c EncryptionConfig) keyWrapper() KeyWrapper {
+	if c.Wrapper != nil {
+		return c.Wrapper
+	}
+	if len(c.Keys) == 0 {
+		return nil
+	}
+	return &masterKeyring{current: c.KeyId, keys: c.Keys}
+}
+
+// CORSConfig is the cross-origin policy. CORS is off while AllowedOrigins is
+// empty; "*" allows any origin.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	MaxAge           time.Duration
+	AllowCredentials bool
+}
+
+// GzipConfig tunes response compression. Level is a compress/gzip level, 0
+// turning compression off; ExcludedRoutes are route templates, such as
+// "/files/:id/download" relative to the API prefix, whose responses are
+// never compressed.
+type GzipConfig struct {
+	Level          int
+	MinSize        int
+	ExcludedRoutes []string
+}
+
+// ScanConfig configures virus scanning of uploads with clamd. Scanning is
+// off while ClamdAddr, a host:port, is empty. Workers bounds the files
+// scanned at once; AllowPendingDownloads lets files be downloaded before
+// their scan has cleared them.
+type ScanConfig struct {
+	ClamdAddr             string
+	Workers               int
+	AllowPendingDownloads bool
+}
+
+// ShareAnalyticsConfig configures the downloads share links record. StoreIPs
+// keeps a keyed hash of each visitor's truncated address, for visitor
+// estimates; GeoIPDatabase, the path of a MaxMind country or city database,
+// adds their country. Accesses are kept for Retention.
+type ShareAnalyticsConfig struct {
+	StoreIPs      bool
+	GeoIPDatabase string
+	Retention     time.Duration
+}
+
+// ConfigError lists every environment variable that is missing or could not
+// be parsed, so a misconfigured deployment can be fixed in one go.
+type ConfigError struct {
+	Missing []string
+	Invalid []string
+}
+
+func (e *ConfigError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing required environment variables: "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		parts = append(parts, "invalid settings: "+strings.Join(e.Invalid, "; "))
+	}
+	return "config: " + strings.Join(parts, "; ")
+}
+
+// envLoader reads variables for LoadConfig and collects every problem
+// instead of stopping at the first.
+type envLoader struct {
+	err ConfigError
+}
+
+func (l *envLoader) required(key string) string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		l.err.Missing = append(l.err.Missing, key)
+	}
+	return raw
+}
+
+func (l *envLoader) get(key string, def string) string {
+	if raw := os.Getenv(key); raw != "" {
+		return raw
+	}
+	return def
+}
+
+// getTypes reads a comma-separated list of content types. A variable that is
+// set but empty clears the list.
+func (l *envLoader) getTypes(key string, def []string) []string {
+	raw, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	list := []string{}
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getList reads a comma-separated list, dropping empty items.
+func (l *envLoader) getList(key string, def []string) []string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getKeys reads comma-separated name:base64 pairs.
+func (l *envLoader) getKeys(key string) map[string][]byte {
+	keys := map[string][]byte{}
+	for _, item := range l.getList(key, nil) {
+		name, encoded, ok := strings.Cut(item, ":")
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || name == "" || err != nil {
+			l.err.Invalid = append(l.err.Invalid, key+" must be a comma-separated list of name:base64-key pairs")
+			return nil
+		}
+		keys[name] = decoded
+	}
+	return keys
+}
+
+func (l *envLoader) getInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		l.err.Invalid = append(l.err.Invalid, key+" must be an integer")
+		return def
+	}
+	return n
+}
+
+func (l *envLoader) getInt64(key string, def int64) int64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		l.err.Invalid = append(l.err.Invalid, key+" must be an integer")
+		return def
+	}
+	return n
+}
+
+func (l *envLoader) getDuration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		l.err.Invalid = append(l.err.Invalid, key+` must be a duration such as "15m"`)
+		return def
+	}
+	return d
+}
+
+// getRateLimit reads a rate limit written "<requests>/<duration>", e.g.
+// "10/1m".
+func (l *envLoader) getRateLimit(key string, def RateLimitRule) RateLimitRule {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	count, per, ok := strings.Cut(raw, "/")
+	burst, countErr := strconv.Atoi(strings.TrimSpace(count))
+	d, perErr := time.ParseDuration(strings.TrimSpace(per))
+	if !ok || countErr != nil || perErr != nil {
+		l.err.Invalid = append(l.err.Invalid, key+` must be <requests>/<duration>, such as "10/1m"`)
+		return def
+	}
+	return RateLimitRule{Burst: burst, Per: d}
+}
+
+func (l *envLoader) getBool(key string, def bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		l.err.Invalid = append(l.err.Invalid, key+" must be true or false")
+		return def
+	}
+	return b
+}
+
+// DefaultConfig returns the defaults LoadConfig starts from. It has no
+// MongoURI or JWTSecret, which must always be set.
+func DefaultConfig() *Config {
+	return &Config{
+		DatabaseName:        "Go_With",
+		ListenAddr:          ":8080",
+		ShutdownGracePeriod: 30 * time.Second,
+		RequestTimeout:      10 * time.Second,
+		StreamTimeout:       time.Hour,
+		AccessTokenTTL:      15 * time.Minute,
+		RefreshTokenTTL:     30 * 24 * time.Hour,
+		DownloadURLTTL:      5 * time.Minute,
+		DirectUploadTTL:     time.Hour,
+		NotificationTTL:     30 * 24 * time.Hour,
+		APIPrefix:           "/api/v1",
+		LegacyRoutes:        true,
+		MaxUploadSize:       2 << 30,
+		RoleUploadLimits: map[string]int64{
+			models.RoleUser:  0,
+			models.RoleAdmin: 0,
+		},
+		UploadOnConflict: onConflictOverwrite,
+		RoleDownloadRates: map[string]int64{
+			models.RoleUser:  0,
+			models.RoleAdmin: 0,
+		},
+		BlockedContentTypes: []string{
+			"application/x-msdownload",
+			"application/x-executable",
+			"application/x-mach-binary",
+			"application/x-sh",
+			"application/x-bat",
+		},
+		DedupEnabled:         true,
+		DefaultQuotaBytes:    10 << 30,
+		FileVersionLimit:     10,
+		UploadSessionTTL:     24 * time.Hour,
+		TrashRetention:       30 * 24 * time.Hour,
+		TrashPurgeInterval:   time.Hour,
+		UserDeletionGrace:    30 * 24 * time.Hour,
+		VerificationTokenTTL: 48 * time.Hour,
+		InvitationTTL:        7 * 24 * time.Hour,
+		ShareCodeLimit:       20,
+		PreviewBytes:         64 << 10,
+		ExtractedTextLimit:   100 << 10,
+		TOTPIssuer:           "FileSharing",
+		AuditQueueSize:       4096,
+		SMTP: SMTPConfig{
+			Port:        587,
+			From:        "no-reply@localhost",
+			MaxAttempts: 3,
+		},
+		RateLimits: RateLimitConfig{
+			Store:       rateLimitStoreMemory,
+			Login:       RateLimitRule{Burst: 10, Per: time.Minute},
+			Unlock:      RateLimitRule{Burst: 10, Per: time.Minute},
+			Upload:      RateLimitRule{Burst: 60, Per: time.Minute},
+			Download:    RateLimitRule{Burst: 300, Per: time.Minute},
+			FileRequest: RateLimitRule{Burst: 30, Per: time.Minute},
+			ShareCode:   RateLimitRule{Burst: 10, Per: 5 * time.Minute},
+			UserSearch:  RateLimitRule{Burst: 30, Per: time.Minute},
+		},
+		Lockout: LockoutConfig{
+			Threshold: 5,
+			Window:    15 * time.Minute,
+			Cooldown:  15 * time.Minute,
+		},
+		Images: ImageConfig{
+			MaxPixels:    50_000_000,
+			MaxDimension: 4096,
+			CacheBytes:   64 << 20,
+		},
+		Webhooks: WebhookConfig{
+			MaxAttempts:  6,
+			DisableAfter: 5,
+			RetryBase:    30 * time.Second,
+			RetryMax:     time.Hour,
+			Timeout:      10 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{
+				"Authorization", "Content-Type", "Range", "If-Range", "If-None-Match", "If-Modified-Since",
+				apiKeyHeader, ContentSHA256Header, RequestIDHeader, "X-Share-Access",
+			},
+			ExposedHeaders: []string{
+				"Content-Disposition", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified",
+				ContentSHA256Header, RequestIDHeader, "Retry-After", "Deprecation", "Link",
+				"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+			},
+			MaxAge: 10 * time.Minute,
+		},
+		Storage: StorageConfig{
+			Backend:  StorageGridFS,
+			LocalDir: "data/files",
+			S3:       S3Config{Region: "us-east-1"},
+		},
+		Scan:           ScanConfig{Workers: 2},
+		ShareAnalytics: ShareAnalyticsConfig{StoreIPs: true, Retention: 90 * 24 * time.Hour},
+		Gzip: GzipConfig{
+			Level:   gzip.DefaultCompression,
+			MinSize: 1024,
+			ExcludedRoutes: []string{
+				"/files/:id/download", "/files/:id/versions/:n/download", "/files/:id/thumbnail",
+				"/files/:id/image", "/files/:id/preview", "/files/download-zip",
+				"/folders/:id/download", "/s/:token", "/users/:id/avatar", "/notifications/stream",
+				"/shares/:id/qr", "/s/:token/qr",
+			},
+		},
+		CacheControl: CacheControlConfig{
+			Private: "private, no-cache",
+			Shared:  "public, max-age=300",
+		},
+		RequireEmailVerification: true,
+	}
+}
+
+// LoadConfig reads the Config from the environment. MONGO_URI and JWT_SECRET
+// are required; everything else defaults to DefaultConfig. The returned
+// *ConfigError names every missing or malformed variable.
+func LoadConfig() (*Config, error) {
+	var l envLoader
+	def := DefaultConfig()
+
+	cfg := &Config{
+		MongoURI:            l.required("MONGO_URI"),
+		DatabaseName:        l.get("MONGO_DATABASE", def.DatabaseName),
+		ListenAddr:          l.get("LISTEN_ADDR", def.ListenAddr),
+		ShutdownGracePeriod: l.getDuration("SHUTDOWN_GRACE_PERIOD", def.ShutdownGracePeriod),
+		RequestTimeout:      l.getDuration("REQUEST_TIMEOUT", def.RequestTimeout),
+		StreamTimeout:       l.getDuration("STREAM_TIMEOUT", def.StreamTimeout),
+		JWTSecret:           []byte(l.required("JWT_SECRET")),
+		AccessTokenTTL:      l.getDuration("JWT_TTL", def.AccessTokenTTL),
+		RefreshTokenTTL:     l.getDuration("REFRESH_TOKEN_TTL", def.RefreshTokenTTL),
+		DownloadURLTTL:      l.getDuration("DOWNLOAD_URL_TTL", def.DownloadURLTTL),
+		DirectUploadTTL:     l.getDuration("DIRECT_UPLOAD_TTL", def.DirectUploadTTL),
+		NotificationTTL:     l.getDuration("NOTIFICATION_TTL", def.NotificationTTL),
+		PublicBaseURL:       l.get("PUBLIC_BASE_URL", def.PublicBaseURL),
+		APIPrefix:           l.get("API_PREFIX", def.APIPrefix),
+		LegacyRoutes:        l.getBool("LEGACY_ROUTES", def.LegacyRoutes),
+		MaxUploadSize:       l.getInt64("MAX_UPLOAD_SIZE", def.MaxUploadSize),
+		RoleUploadLimits: map[string]int64{
+			models.RoleUser:  l.getInt64("MAX_UPLOAD_SIZE_USER", 0),
+			models.RoleAdmin: l.getInt64("MAX_UPLOAD_SIZE_ADMIN", 0),
+		},
+		UploadOnConflict: l.get("UPLOAD_ON_CONFLICT", def.UploadOnConflict),
+		DownloadRate:     l.getInt64("DOWNLOAD_RATE", def.DownloadRate),
+		RoleDownloadRates: map[string]int64{
+			models.RoleUser:  l.getInt64("DOWNLOAD_RATE_USER", 0),
+			models.RoleAdmin: l.getInt64("DOWNLOAD_RATE_ADMIN", 0),
+		},
+		ShareDownloadRate:    l.getInt64("SHARE_DOWNLOAD_RATE", def.ShareDownloadRate),
+		AllowedContentTypes:  l.getTypes("ALLOWED_CONTENT_TYPES", def.AllowedContentTypes),
+		BlockedContentTypes:  l.getTypes("BLOCKED_CONTENT_TYPES", def.BlockedContentTypes),
+		DedupEnabled:         l.getBool("DEDUP_ENABLED", def.DedupEnabled),
+		DefaultQuotaBytes:    l.getInt64("DEFAULT_QUOTA_BYTES", def.DefaultQuotaBytes),
+		FileVersionLimit:     l.getInt("FILE_VERSION_LIMIT", def.FileVersionLimit),
+		UploadSessionTTL:     l.getDuration("UPLOAD_SESSION_TTL", def.UploadSessionTTL),
+		TrashRetention:       l.getDuration("TRASH_RETENTION", def.TrashRetention),
+		TrashPurgeInterval:   l.getDuration("TRASH_PURGE_INTERVAL", def.TrashPurgeInterval),
+		UserDeletionGrace:    l.getDuration("USER_DELETION_GRACE", def.UserDeletionGrace),
+		VerificationTokenTTL: l.getDuration("VERIFICATION_TOKEN_TTL", def.VerificationTokenTTL),
+		InvitationTTL:        l.getDuration("INVITATION_TTL", def.InvitationTTL),
+		ShareCodeLimit:       l.getInt("SHARE_CODE_LIMIT", def.ShareCodeLimit),
+		PreviewBytes:         l.getInt("PREVIEW_BYTES", def.PreviewBytes),
+		ExtractedTextLimit:   l.getInt("EXTRACTED_TEXT_LIMIT", def.ExtractedTextLimit),
+		DefaultAvatarURL:     l.get("DEFAULT_AVATAR_URL", def.DefaultAvatarURL),
+		TOTPIssuer:           l.get("TOTP_ISSUER", def.TOTPIssuer),
+		AuditQueueSize:       l.getInt("AUDIT_QUEUE_SIZE", def.AuditQueueSize),
+		SMTP: SMTPConfig{
+			Host:        l.get("SMTP_HOST", def.SMTP.Host),
+			Port:        l.getInt("SMTP_PORT", def.SMTP.Port),
+			Username:    l.get("SMTP_USERNAME", def.SMTP.Username),
+			Password:    l.get("SMTP_PASSWORD", def.SMTP.Password),
+			From:        l.get("MAIL_FROM", def.SMTP.From),
+			MaxAttempts: l.getInt("MAIL_MAX_ATTEMPTS", def.SMTP.MaxAttempts),
+		},
+		RateLimits: RateLimitConfig{
+			Store:       l.get("RATE_LIMIT_STORE", def.RateLimits.Store),
+			Login:       l.getRateLimit("RATE_LIMIT_LOGIN", def.RateLimits.Login),
+			Unlock:      l.getRateLimit("RATE_LIMIT_UNLOCK", def.RateLimits.Unlock),
+			Upload:      l.getRateLimit("RATE_LIMIT_UPLOAD", def.RateLimits.Upload),
+			Download:    l.getRateLimit("RATE_LIMIT_DOWNLOAD", def.RateLimits.Download),
+			FileRequest: l.getRateLimit("RATE_LIMIT_FILE_REQUEST", def.RateLimits.FileRequest),
+			ShareCode:   l.getRateLimit("RATE_LIMIT_SHARE_CODE", def.RateLimits.ShareCode),
+			UserSearch:  l.getRateLimit("RATE_LIMIT_USER_SEARCH", def.RateLimits.UserSearch),
+		},
+		Lockout: LockoutConfig{
+			Threshold: l.getInt("LOGIN_LOCKOUT_THRESHOLD", def.Lockout.Threshold),
+			Window:    l.getDuration("LOGIN_LOCKOUT_WINDOW", def.Lockout.Window),
+			Cooldown:  l.getDuration("LOGIN_LOCKOUT_COOLDOWN", def.Lockout.Cooldown),
+		},
+		Google: GoogleConfig{
+			ClientID:     l.get("GOOGLE_CLIENT_ID", def.Google.ClientID),
+			ClientSecret: l.get("GOOGLE_CLIENT_SECRET", def.Google.ClientSecret),
+			RedirectURL:  l.get("GOOGLE_REDIRECT_URL", def.Google.RedirectURL),
+		},
+		Images: ImageConfig{
+			MaxPixels:    l.getInt64("MAX_IMAGE_PIXELS", def.Images.MaxPixels),
+			MaxDimension: l.getInt("MAX_IMAGE_DIMENSION", def.Images.MaxDimension),
+			CacheBytes:   l.getInt64("IMAGE_CACHE_BYTES", def.Images.CacheBytes),
+		},
+		Webhooks: WebhookConfig{
+			MaxAttempts:  l.getInt("WEBHOOK_MAX_ATTEMPTS", def.Webhooks.MaxAttempts),
+			DisableAfter: l.getInt("WEBHOOK_DISABLE_AFTER", def.Webhooks.DisableAfter),
+			RetryBase:    l.getDuration("WEBHOOK_RETRY_BASE", def.Webhooks.RetryBase),
+			RetryMax:     l.getDuration("WEBHOOK_RETRY_MAX", def.Webhooks.RetryMax),
+			Timeout:      l.getDuration("WEBHOOK_TIMEOUT", def.Webhooks.Timeout),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   l.getList("CORS_ALLOWED_ORIGINS", def.CORS.AllowedOrigins),
+			AllowedMethods:   l.getList("CORS_ALLOWED_METHODS", def.CORS.AllowedMethods),
+			AllowedHeaders:   l.getList("CORS_ALLOWED_HEADERS", def.CORS.AllowedHeaders),
+			ExposedHeaders:   l.getList("CORS_EXPOSED_HEADERS", def.CORS.ExposedHeaders),
+			MaxAge:           l.getDuration("CORS_MAX_AGE", def.CORS.MaxAge),
+			AllowCredentials: l.getBool("CORS_ALLOW_CREDENTIALS", def.CORS.AllowCredentials),
+		},
+		Gzip: GzipConfig{
+			Level:          l.getInt("GZIP_LEVEL", def.Gzip.Level),
+			MinSize:        l.getInt("GZIP_MIN_SIZE", def.Gzip.MinSize),
+			ExcludedRoutes: l.getList("GZIP_EXCLUDED_ROUTES", def.Gzip.ExcludedRoutes),
+		},
+		CacheControl: CacheControlConfig{
+			Private: l.get("CACHE_CONTROL_PRIVATE", def.CacheControl.Private),
+			Shared:  l.get("CACHE_CONTROL_SHARED", def.CacheControl.Shared),
+		},
+		Storage: StorageConfig{
+			Backend:  l.get("STORAGE_BACKEND", def.Storage.Backend),
+			LocalDir: l.get("STORAGE_LOCAL_DIR", def.Storage.LocalDir),
+			S3: S3Config{
+				Endpoint:  l.get("S3_ENDPOINT", def.Storage.S3.Endpoint),
+				Bucket:    l.get("S3_BUCKET", def.Storage.S3.Bucket),
+				Region:    l.get("S3_REGION", def.Storage.S3.Region),
+				AccessKey: l.get("S3_ACCESS_KEY_ID", def.Storage.S3.AccessKey),
+				SecretKey: l.get("S3_SECRET_ACCESS_KEY", def.Storage.S3.SecretKey),
+				PathStyle: l.getBool("S3_PATH_STYLE", def.Storage.S3.PathStyle),
+			},
+			Encryption: EncryptionConfig{
+				KeyId: l.get("ENCRYPTION_KEY_ID", def.Storage.Encryption.KeyId),
+				Keys:  l.getKeys("ENCRYPTION_KEYS"),
+			},
+		},
+		Scan: ScanConfig{
+			ClamdAddr:             l.get("CLAMD_ADDR", def.Scan.ClamdAddr),
+			Workers:               l.getInt("SCAN_WORKERS", def.Scan.Workers),
+			AllowPendingDownloads: l.getBool("SCAN_ALLOW_PENDING_DOWNLOADS", def.Scan.AllowPendingDownloads),
+		},
+		ShareAnalytics: ShareAnalyticsConfig{
+			StoreIPs:      l.getBool("SHARE_ANALYTICS_STORE_IPS", def.ShareAnalytics.StoreIPs),
+			GeoIPDatabase: l.get("GEOIP_DATABASE", def.ShareAnalytics.GeoIPDatabase),
+			Retention:     l.getDuration("SHARE_ACCESS_TTL", def.ShareAnalytics.Retention),
+		},
+		RequireEmailVerification: l.getBool("REQUIRE_EMAIL_VERIFICATION", def.RequireEmailVerification),
+		BootstrapFirstAdmin:      l.getBool("BOOTSTRAP_FIRST_ADMIN", def.BootstrapFirstAdmin),
+		MetricsEnabled:           l.getBool("METRICS_ENABLED", def.MetricsEnabled),
+		MetricsPublic:            l.getBool("METRICS_PUBLIC", def.MetricsPublic),
+		APIDocsEnabled:           l.getBool("API_DOCS_ENABLED", def.APIDocsEnabled),
+	}
+	cfg.PasswordResetURL = l.get("PASSWORD_RESET_URL", def.PasswordResetURL)
+
+	if len(l.err.Missing) > 0 || len(l.err.Invalid) > 0 {
+		sort.Strings(l.err.Missing)
+		return nil, &l.err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that cfg can run the server. SetupRouter calls it, so
+// hand-built configs get the same checks as loaded ones.
+func (cfg *Config) Validate() error {
+	var err ConfigError
+	if cfg.DatabaseName == "" {
+		err.Missing = append(err.Missing, "MONGO_DATABASE")
+	}
+	if len(cfg.JWTSecret) == 0 {
+		err.Missing = append(err.Missing, "JWT_SECRET")
+	}
+	if cfg.ShutdownGracePeriod < 0 {
+		err.Invalid = append(err.Invalid, "SHUTDOWN_GRACE_PERIOD must not be negative")
+	}
+	if cfg.RequestTimeout < 0 {
+		err.Invalid = append(err.Invalid, "REQUEST_TIMEOUT must not be negative")
+	}
+	if cfg.StreamTimeout < 0 {
+		err.Invalid = append(err.Invalid, "STREAM_TIMEOUT must not be negative")
+	}
+	if cfg.AccessTokenTTL <= 0 {
+		err.Invalid = append(err.Invalid, "JWT_TTL must be positive")
+	}
+	if cfg.RefreshTokenTTL <= 0 {
+		err.Invalid = append(err.Invalid, "REFRESH_TOKEN_TTL must be positive")
+	}
+	if cfg.DownloadURLTTL < time.Second || cfg.DownloadURLTTL > maxDownloadURLTTL {
+		err.Invalid = append(err.Invalid, "DOWNLOAD_URL_TTL must be between 1s and 7 days")
+	}
+	if cfg.DirectUploadTTL < time.Minute || cfg.DirectUploadTTL > maxDownloadURLTTL {
+		err.Invalid = append(err.Invalid, "DIRECT_UPLOAD_TTL must be between 1m and 7 days")
+	}
+	if cfg.NotificationTTL < time.Hour {
+		err.Invalid = append(err.Invalid, "NOTIFICATION_TTL must be at least 1h")
+	}
+	if cfg.APIPrefix != "" && (!strings.HasPrefix(cfg.APIPrefix, "/") || strings.HasSuffix(cfg.APIPrefix, "/")) {
+		err.Invalid = append(err.Invalid, "API_PREFIX must start with / and not end with one")
+	}
+	if cfg.MaxUploadSize <= 0 {
+		err.Invalid = append(err.Invalid, "MAX_UPLOAD_SIZE must be positive")
+	}
+	for role, limit := range cfg.RoleUploadLimits {
+		if limit < 0 {
+			err.Invalid = append(err.Invalid, fmt.Sprintf("upload limit for role %s must not be negative", role))
+		}
+	}
+	switch cfg.UploadOnConflict {
+	case onConflictOverwrite, onConflictRename, onConflictError:
+	default:
+		err.Invalid = append(err.Invalid, "UPLOAD_ON_CONFLICT must be overwrite, rename or error")
+	}
+	if cfg.DownloadRate < 0 {
+		err.Invalid = append(err.Invalid, "DOWNLOAD_RATE must not be negative")
+	}
+	for role, rate := range cfg.RoleDownloadRates {
+		if rate < 0 {
+			err.Invalid = append(err.Invalid, fmt.Sprintf("download rate for role %s must not be negative", role))
+		}
+	}
+	if cfg.ShareDownloadRate < 0 {
+		err.Invalid = append(err.Invalid, "SHARE_DOWNLOAD_RATE must not be negative")
+	}
+	for key, types := range map[string][]string{"ALLOWED_CONTENT_TYPES": cfg.AllowedContentTypes, "BLOCKED_CONTENT_TYPES": cfg.BlockedContentTypes} {
+		for _, t := range types {
+			if t != "*" && (!strings.Contains(t, "/") || t != strings.ToLower(t)) {
+				err.Invalid = append(err.Invalid, fmt.Sprintf("%s entry %q must be a lower-case type/subtype, type/* or *", key, t))
+			}
+		}
+	}
+	if cfg.DefaultQuotaBytes < 0 {
+		err.Invalid = append(err.Invalid, "DEFAULT_QUOTA_BYTES must not be negative")
+	}
+	if cfg.FileVersionLimit < 0 {
+		err.Invalid = append(err.Invalid, "FILE_VERSION_LIMIT must not be negative")
+	}
+	if cfg.UploadSessionTTL < time.Minute {
+		err.Invalid = append(err.Invalid, "UPLOAD_SESSION_TTL must be at least 1m")
+	}
+	if cfg.TrashRetention < 0 {
+		err.Invalid = append(err.Invalid, "TRASH_RETENTION must not be negative")
+	}
+	if cfg.TrashPurgeInterval < time.Minute {
+		err.Invalid = append(err.Invalid, "TRASH_PURGE_INTERVAL must be at least 1m")
+	}
+	if cfg.UserDeletionGrace < 0 {
+		err.Invalid = append(err.Invalid, "USER_DELETION_GRACE must not be negative")
+	}
+	if cfg.VerificationTokenTTL < time.Minute {
+		err.Invalid = append(err.Invalid, "VERIFICATION_TOKEN_TTL must be at least 1m")
+	}
+	if cfg.InvitationTTL < time.Minute {
+		err.Invalid = append(err.Invalid, "INVITATION_TTL must be at least 1m")
+	}
+	if cfg.ShareCodeLimit < 0 {
+		err.Invalid = append(err.Invalid, "SHARE_CODE_LIMIT must not be negative")
+	}
+	if cfg.PreviewBytes <= 0 {
+		err.Invalid = append(err.Invalid, "PREVIEW_BYTES must be positive")
+	}
+	if cfg.ExtractedTextLimit < 0 {
+		err.Invalid = append(err.Invalid, "EXTRACTED_TEXT_LIMIT must not be negative")
+	}
+	if avatar := cfg.DefaultAvatarURL; avatar != "" && !strings.HasPrefix(avatar, "http://") && !strings.HasPrefix(avatar, "https://") && !strings.HasPrefix(avatar, "/") {
+		err.Invalid = append(err.Invalid, "DEFAULT_AVATAR_URL must be an http(s) URL or a path")
+	}
+	if cfg.TOTPIssuer == "" || strings.Contains(cfg.TOTPIssuer, ":") {
+		err.Invalid = append(err.Invalid, "TOTP_ISSUER must be set and must not contain a colon")
+	}
+	if cfg.AuditQueueSize < 1 {
+		err.Invalid = append(err.Invalid, "AUDIT_QUEUE_SIZE must be positive")
+	}
+	if cfg.SMTP.Host != "" {
+		if cfg.SMTP.Port < 1 || cfg.SMTP.Port > 65535 {
+			err.Invalid = append(err.Invalid, "SMTP_PORT must be between 1 and 65535")
+		}
+		if cfg.SMTP.From == "" {
+			err.Missing = append(err.Missing, "MAIL_FROM")
+		}
+	}
+	if cfg.SMTP.MaxAttempts < 1 {
+		err.Invalid = append(err.Invalid, "MAIL_MAX_ATTEMPTS must be positive")
+	}
+	if cfg.CacheControl.Private == "" {
+		err.Missing = append(err.Missing, "CACHE_CONTROL_PRIVATE")
+	}
+	if cfg.CacheControl.Shared == "" {
+		err.Missing = append(err.Missing, "CACHE_CONTROL_SHARED")
+	}
+	switch cfg.RateLimits.Store {
+	case rateLimitStoreMemory, rateLimitStoreMongo:
+	default:
+		err.Invalid = append(err.Invalid, fmt.Sprintf("RATE_LIMIT_STORE must be %q or %q", rateLimitStoreMemory, rateLimitStoreMongo))
+	}
+	limits := cfg.RateLimits
+	for key, limit := range map[string]RateLimitRule{
+		"RATE_LIMIT_LOGIN":        limits.Login,
+		"RATE_LIMIT_UNLOCK":       limits.Unlock,
+		"RATE_LIMIT_UPLOAD":       limits.Upload,
+		"RATE_LIMIT_DOWNLOAD":     limits.Download,
+		"RATE_LIMIT_FILE_REQUEST": limits.FileRequest,
+		"RATE_LIMIT_SHARE_CODE":   limits.ShareCode,
+		"RATE_LIMIT_USER_SEARCH":  limits.UserSearch,
+	} {
+		if limit.Burst < 0 || (limit.Burst > 0 && limit.Per <= 0) {
+			err.Invalid = append(err.Invalid, key+" must allow a non-negative number of requests per positive duration")
+		}
+	}
+	if cfg.Lockout.Threshold < 1 {
+		err.Invalid = append(err.Invalid, "LOGIN_LOCKOUT_THRESHOLD must be positive")
+	}
+	if cfg.Lockout.Window <= 0 || cfg.Lockout.Cooldown <= 0 {
+		err.Invalid = append(err.Invalid, "LOGIN_LOCKOUT_WINDOW and LOGIN_LOCKOUT_COOLDOWN must be positive")
+	}
+	if google := cfg.Google; google.ClientID != "" || google.ClientSecret != "" || google.RedirectURL != "" {
+		for key, value := range map[string]string{"GOOGLE_CLIENT_ID": google.ClientID, "GOOGLE_CLIENT_SECRET": google.ClientSecret, "GOOGLE_REDIRECT_URL": google.RedirectURL} {
+			if value == "" {
+				err.Missing = append(err.Missing, key)
+			}
+		}
+		if google.RedirectURL != "" && !strings.HasPrefix(google.RedirectURL, "http://") && !strings.HasPrefix(google.RedirectURL, "https://") {
+			err.Invalid = append(err.Invalid, "GOOGLE_REDIRECT_URL must start with http:// or https://")
+		}
+	}
+	if cfg.Images.MaxPixels <= 0 {
+		err.Invalid = append(err.Invalid, "MAX_IMAGE_PIXELS must be positive")
+	}
+	if cfg.Images.MaxDimension <= 0 {
+		err.Invalid = append(err.Invalid, "MAX_IMAGE_DIMENSION must be positive")
+	}
+	if cfg.Images.CacheBytes < 0 {
+		err.Invalid = append(err.Invalid, "IMAGE_CACHE_BYTES must not be negative")
+	}
+	if cfg.Webhooks.MaxAttempts < 1 {
+		err.Invalid = append(err.Invalid, "WEBHOOK_MAX_ATTEMPTS must be positive")
+	}
+	if cfg.Webhooks.DisableAfter < 1 {
+		err.Invalid = append(err.Invalid, "WEBHOOK_DISABLE_AFTER must be positive")
+	}
+	if cfg.Webhooks.RetryBase <= 0 || cfg.Webhooks.RetryMax < cfg.Webhooks.RetryBase {
+		err.Invalid = append(err.Invalid, "WEBHOOK_RETRY_BASE must be positive and at most WEBHOOK_RETRY_MAX")
+	}
+	if cfg.Webhooks.Timeout <= 0 {
+		err.Invalid = append(err.Invalid, "WEBHOOK_TIMEOUT must be positive")
+	}
+
+	for _, origin := range cfg.CORS.AllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			err.Invalid = append(err.Invalid, fmt.Sprintf("CORS origin %q must be * or start with http:// or https://", origin))
+		}
+	}
+	if cfg.Gzip.Level < gzip.HuffmanOnly || cfg.Gzip.Level > gzip.BestCompression {
+		err.Invalid = append(err.Invalid, "GZIP_LEVEL must be between -2 and 9")
+	}
+	if cfg.Gzip.MinSize < 0 {
+		err.Invalid = append(err.Invalid, "GZIP_MIN_SIZE must not be negative")
+	}
+	if cfg.CORS.MaxAge < 0 {
+		err.Invalid = append(err.Invalid, "CORS_MAX_AGE must not be negative")
+	}
+	switch cfg.Storage.Backend {
+	case StorageGridFS:
+	case StorageLocal:
+		if cfg.Storage.LocalDir == "" {
+			err.Missing = append(err.Missing, "STORAGE_LOCAL_DIR")
+		}
+	case StorageS3:
+		s3 := cfg.Storage.S3
+		for key, value := range map[string]string{"S3_BUCKET": s3.Bucket, "S3_REGION": s3.Region, "S3_ACCESS_KEY_ID": s3.AccessKey, "S3_SECRET_ACCESS_KEY": s3.SecretKey} {
+			if value == "" {
+				err.Missing = append(err.Missing, key)
+			}
+		}
+		if s3.Endpoint != "" && !strings.HasPrefix(s3.Endpoint, "http://") && !strings.HasPrefix(s3.Endpoint, "https://") {
+			err.Invalid = append(err.Invalid, "S3_ENDPOINT must start with http:// or https://")
+		}
+	default:
+		err.Invalid = append(err.Invalid, fmt.Sprintf("STORAGE_BACKEND must be %q, %q or %q", StorageGridFS, StorageLocal, StorageS3))
+	}
+	if encryption := cfg.Storage.Encryption; encryption.Wrapper == nil {
+		if len(encryption.Keys) > 0 && encryption.KeyId == "" {
+			err.Missing = append(err.Missing, "ENCRYPTION_KEY_ID")
+		}
+		if len(encryption.Keys) == 0 && encryption.KeyId != "" {
+			err.Missing = append(err.Missing, "ENCRYPTION_KEYS")
+		}
+		if _, ok := encryption.Keys[encryption.KeyId]; len(encryption.Keys) > 0 && encryption.KeyId != "" && !ok {
+			err.Invalid = append(err.Invalid, "ENCRYPTION_KEY_ID must name one of ENCRYPTION_KEYS")
+		}
+		for name, key := range encryption.Keys {
+			if len(key) != 32 {
+				err.Invalid = append(err.Invalid, fmt.Sprintf("encryption key %s must be 32 bytes", name))
+			}
+		}
+	}
+	if cfg.ShareAnalytics.Retention < time.Hour {
+		err.Invalid = append(err.Invalid, "SHARE_ACCESS_TTL must be at least 1h")
+	}
+	if cfg.Scan.ClamdAddr != "" {
+		if _, _, splitErr := net.SplitHostPort(cfg.Scan.ClamdAddr); splitErr != nil {
+			err.Invalid = append(err.Invalid, "CLAMD_ADDR must be host:port")
+		}
+		if cfg.Scan.Workers < 1 {
+			err.Invalid = append(err.Invalid, "SCAN_WORKERS must be positive")
+		}
+	}
+
+	if len(err.Missing) > 0 || len(err.Invalid) > 0 {
+		sort.Strings(err.Missing)
+		sort.Strings(err.Invalid)
+		return &err
+	}
+	return nil
+}
+
+// passwordResetURL is the page reset links point to, by default
+// /reset-password under PublicBaseURL.
+func (cfg *Config) passwordResetURL() string {
+	if cfg.PasswordResetURL == "" {
+		return cfg.PublicBaseURL + "/reset-password"
+	}
+	return cfg.PasswordResetURL
+}
@@ -0,0 +1,229 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testConfig is DefaultConfig with a JWT secret, which is all Validate
+// needs.
+func testConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.JWTSecret = []byte("test-secret")
+	return cfg
+}
+
+// configError returns the *ConfigError err holds, failing the test if there
+// is none.
+func configError(t *testing.T, err error) *ConfigError {
+	t.Helper()
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("err = %v, want a *ConfigError", err)
+	}
+	return cfgErr
+}
+
+// mentions reports whether one of the messages starts with key.
+func mentions(messages []string, key string) bool {
+	for _, message := range messages {
+		if strings.HasPrefix(message, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDefaultConfigValidates(t *testing.T) {
+	if err := testConfig().Validate(); err != nil {
+		t.Fatalf("Validate = %v", err)
+	}
+}
+
+func TestValidateRequiresSecret(t *testing.T) {
+	err := configError(t, DefaultConfig().Validate())
+	if !mentions(err.Missing, "JWT_SECRET") {
+		t.Errorf("missing = %v, want JWT_SECRET", err.Missing)
+	}
+}
+
+func TestValidateReportsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		key    string
+		change func(cfg *Config)
+	}{
+		{"BLOCKED_CONTENT_TYPES", func(cfg *Config) { cfg.BlockedContentTypes = []string{"Application/x-sh"} }},
+		{"ALLOWED_CONTENT_TYPES", func(cfg *Config) { cfg.AllowedContentTypes = []string{"images"} }},
+		{"DEFAULT_QUOTA_BYTES", func(cfg *Config) { cfg.DefaultQuotaBytes = -1 }},
+		{"FILE_VERSION_LIMIT", func(cfg *Config) { cfg.FileVersionLimit = -1 }},
+		{"UPLOAD_SESSION_TTL", func(cfg *Config) { cfg.UploadSessionTTL = time.Second }},
+		{"TRASH_PURGE_INTERVAL", func(cfg *Config) { cfg.TrashPurgeInterval = 0 }},
+		{"USER_DELETION_GRACE", func(cfg *Config) { cfg.UserDeletionGrace = -time.Hour }},
+		{"VERIFICATION_TOKEN_TTL", func(cfg *Config) { cfg.VerificationTokenTTL = 0 }},
+		{"INVITATION_TTL", func(cfg *Config) { cfg.InvitationTTL = 0 }},
+		{"SHARE_CODE_LIMIT", func(cfg *Config) { cfg.ShareCodeLimit = -1 }},
+		{"PREVIEW_BYTES", func(cfg *Config) { cfg.PreviewBytes = 0 }},
+		{"DEFAULT_AVATAR_URL", func(cfg *Config) { cfg.DefaultAvatarURL = "ftp://example.com/a.png" }},
+		{"TOTP_ISSUER", func(cfg *Config) { cfg.TOTPIssuer = "Files:Inc" }},
+		{"AUDIT_QUEUE_SIZE", func(cfg *Config) { cfg.AuditQueueSize = 0 }},
+		{"MAIL_MAX_ATTEMPTS", func(cfg *Config) { cfg.SMTP.MaxAttempts = 0 }},
+		{"RATE_LIMIT_STORE", func(cfg *Config) { cfg.RateLimits.Store = "redis" }},
+		{"RATE_LIMIT_LOGIN", func(cfg *Config) { cfg.RateLimits.Login = RateLimitRule{Burst: 10} }},
+		{"RATE_LIMIT_DOWNLOAD", func(cfg *Config) { cfg.RateLimits.Download.Burst = -1 }},
+		{"LOGIN_LOCKOUT_THRESHOLD", func(cfg *Config) { cfg.Lockout.Threshold = 0 }},
+		{"LOGIN_LOCKOUT_WINDOW", func(cfg *Config) { cfg.Lockout.Cooldown = 0 }},
+		{"GOOGLE_REDIRECT_URL", func(cfg *Config) {
+			cfg.Google = GoogleConfig{ClientID: "id", ClientSecret: "secret", RedirectURL: "example.com/callback"}
+		}},
+		{"MAX_IMAGE_PIXELS", func(cfg *Config) { cfg.Images.MaxPixels = 0 }},
+		{"MAX_IMAGE_DIMENSION", func(cfg *Config) { cfg.Images.MaxDimension = 0 }},
+		{"IMAGE_CACHE_BYTES", func(cfg *Config) { cfg.Images.CacheBytes = -1 }},
+		{"WEBHOOK_MAX_ATTEMPTS", func(cfg *Config) { cfg.Webhooks.MaxAttempts = 0 }},
+		{"WEBHOOK_RETRY_BASE", func(cfg *Config) { cfg.Webhooks.RetryBase = 2 * cfg.Webhooks.RetryMax }},
+		{"WEBHOOK_TIMEOUT", func(cfg *Config) { cfg.Webhooks.Timeout = 0 }},
+		{"SHARE_ACCESS_TTL", func(cfg *Config) { cfg.ShareAnalytics.Retention = time.Minute }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			cfg := testConfig()
+			tt.change(cfg)
+			err := configError(t, cfg.Validate())
+			if !mentions(err.Invalid, tt.key) {
+				t.Errorf("invalid = %v, want %s", err.Invalid, tt.key)
+			}
+		})
+	}
+}
+
+func TestValidateReportsMissingSettings(t *testing.T) {
+	cfg := testConfig()
+	cfg.CacheControl.Shared = ""
+	cfg.Google.ClientID = "id"
+
+	err := configError(t, cfg.Validate())
+	for _, key := range []string{"CACHE_CONTROL_SHARED", "GOOGLE_CLIENT_SECRET", "GOOGLE_REDIRECT_URL"} {
+		if !mentions(err.Missing, key) {
+			t.Errorf("missing = %v, want %s", err.Missing, key)
+		}
+	}
+}
+
+// setRequiredEnv sets the variables LoadConfig can't do without.
+func setRequiredEnv(t *testing.T) {
+	t.Setenv("MONGO_URI", "mongodb://localhost:27017")
+	t.Setenv("JWT_SECRET", "test-secret")
+}
+
+func TestLoadConfigReportsMalformedValues(t *testing.T) {
+	setRequiredEnv(t)
+	malformed := map[string]string{
+		"DEDUP_ENABLED":       "maybe",
+		"DEFAULT_QUOTA_BYTES": "10GB",
+		"FILE_VERSION_LIMIT":  "ten",
+		"TRASH_RETENTION":     "30 days",
+		"RATE_LIMIT_LOGIN":    "abc",
+		"RATE_LIMIT_UPLOAD":   "60",
+		"MAX_IMAGE_PIXELS":    "x",
+		"WEBHOOK_TIMEOUT":     "10",
+	}
+	for key, value := range malformed {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := LoadConfig()
+	if cfg != nil {
+		t.Fatal("LoadConfig returned a config despite malformed values")
+	}
+	cfgErr := configError(t, err)
+	for key := range malformed {
+		if !mentions(cfgErr.Invalid, key) {
+			t.Errorf("invalid = %v, want %s", cfgErr.Invalid, key)
+		}
+	}
+}
+
+func TestLoadConfigReadsSettings(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("RATE_LIMIT_LOGIN", "5/30s")
+	t.Setenv("BLOCKED_CONTENT_TYPES", "")
+	t.Setenv("ALLOWED_CONTENT_TYPES", "Image/*, application/pdf")
+	t.Setenv("DEDUP_ENABLED", "false")
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "3")
+	t.Setenv("WEBHOOK_RETRY_MAX", "2h")
+	t.Setenv("PUBLIC_BASE_URL", "https://files.example.com")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig = %v", err)
+	}
+	if cfg.RateLimits.Login != (RateLimitRule{Burst: 5, Per: 30 * time.Second}) {
+		t.Errorf("login limit = %+v", cfg.RateLimits.Login)
+	}
+	if cfg.BlockedContentTypes == nil || len(cfg.BlockedContentTypes) != 0 {
+		t.Errorf("an empty BLOCKED_CONTENT_TYPES should clear the list, got %v", cfg.BlockedContentTypes)
+	}
+	if got := strings.Join(cfg.AllowedContentTypes, ","); got != "image/*,application/pdf" {
+		t.Errorf("allowed types = %q", got)
+	}
+	if cfg.DedupEnabled || cfg.Lockout.Threshold != 3 || cfg.Webhooks.RetryMax != 2*time.Hour {
+		t.Errorf("dedup %v, lockout %d, retry max %v", cfg.DedupEnabled, cfg.Lockout.Threshold, cfg.Webhooks.RetryMax)
+	}
+	if got := cfg.passwordResetURL(); got != "https://files.example.com/reset-password" {
+		t.Errorf("password reset URL = %q", got)
+	}
+}
+
+func TestConfigErrorListsEverything(t *testing.T) {
+	err := &ConfigError{Missing: []string{"JWT_SECRET"}, Invalid: []string{"GZIP_LEVEL must be between -2 and 9"}}
+	msg := err.Error()
+	if !strings.Contains(msg, "JWT_SECRET") || !strings.Contains(msg, "GZIP_LEVEL") {
+		t.Errorf("Error() = %q, want both problems", msg)
+	}
+}
+
+func TestConfigsDoNotShareSettings(t *testing.T) {
+	a, b := testConfig(), testConfig()
+	b.JWTSecret = []byte("other-secret")
+	b.APIPrefix = "/api/v2"
+	b.MaxUploadSize = 1 << 20
+	b.RoleUploadLimits = map[string]int64{models.RoleAdmin: 1 << 30}
+
+	user, _ := gin.CreateTestContext(httptest.NewRecorder())
+	user.Set("role", models.RoleUser)
+	if a.uploadLimit(user) == b.uploadLimit(user) {
+		t.Error("both configs give users the same upload limit")
+	}
+	admin, _ := gin.CreateTestContext(httptest.NewRecorder())
+	admin.Set("role", models.RoleAdmin)
+	if got := b.uploadLimit(admin); got != 1<<30 {
+		t.Errorf("admin limit = %d, want the role override", got)
+	}
+	if a.apiURL("/s/x") == b.apiURL("/s/x") {
+		t.Errorf("both configs link to %s", a.apiURL("/s/x"))
+	}
+
+	// A token issued under one config is refused by a router built from the
+	// other.
+	token, _, err := issueAccessToken(a, models.User{Id: primitive.NewObjectID(), Role: models.RoleUser}, primitive.NewObjectID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/", AuthRequired(nil, b), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,165 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errCopyMismatch = errors.New("copied content does not match the source checksum")
+
+type copyFileRequest struct {
+	Name     string `json:"name" binding:"omitempty,max=255"`
+	FolderId string `json:"folderId" binding:"omitempty,len=24,hexadecimal"`
+}
+
+// CopyFile handler duplicates a file the caller can read into the caller's
+// own space, where it counts against the caller's quota.
+func (fc *FileController) CopyFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FileCollection)
+
+		source, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, source, accessViewer) {
+			return
+		}
+
+		mode, err := parseOnConflict(c, onConflictError)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		var req copyFileRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				respondBindingError(c, err)
+				return
+			}
+		}
+
+		userId := currentUserID(c)
+
+		name := source.Name
+		if req.Name != "" {
+			if name, err = sanitizeFileName(req.Name); err != nil {
+				respondError(c, invalidRequest(err))
+				return
+			}
+		}
+
+		// Copies of files shared with the caller land in the caller's root
+		// unless they pick one of their own folders.
+		folderId := source.FolderId
+		if req.FolderId != "" || source.OwnerId != userId {
+			if folderId, ok = resolveFolderParam(ctx, fc.db, c, req.FolderId, userId); !ok {
+				return
+			}
+		}
+
+		if !reserveQuota(ctx, fc.db, fc.cfg, c, userId, source.Size) {
+			return
+		}
+
+		file, err := copyContent(ctx, fc.db, fc.cfg, source, name)
+		if err != nil {
+			releaseQuota(ctx, fc.db, userId, source.Size)
+			respondStoreError(c, err)
+			return
+		}
+
+		file.OwnerId = userId
+		file.FolderId = folderId
+		file.ExtractedText = source.ExtractedText
+		// The same content gets the same verdict; one still pending is
+		// scanned for the copy too.
+		file.ScanResult = source.ScanResult
+
+		file.Live = true
+
+		_, err = saveFileName(ctx, collection, userId, folderId, name, mode, nil, func(candidate string) error {
+			file.Name = candidate
+			_, err := collection.InsertOne(ctx, file)
+			return err
+		})
+		if err != nil {
+			releaseQuota(ctx, fc.db, userId, source.Size)
+			deleteBlobs(ctx, fc.db, fc.cfg, []models.BlobRef{file.BlobRef})
+			respondError(c, orNameConflict(err))
+			return
+		}
+
+		enqueueContentJobs(file)
+
+		c.JSON(http.StatusCreated, gin.H{"id": file.Id.Hex(), "file": file})
+	}
+}
+
+// copyContent returns the metadata for a copy of source named name. With
+// deduplication on, the copy shares the source's blob; otherwise the backend
+// copies the content itself where it can, or it is streamed into
+// cfg.Storage.Backend, never held in memory as a whole.
+func copyContent(ctx context.Context, db *mongo.Database, cfg *Config, source *models.File, name string) (*models.File, error) {
+	if cfg.DedupEnabled && source.Checksum != "" {
+		blob, shared, err := retainBlob(ctx, db, source)
+		if err != nil {
+			return nil, err
+		}
+		if shared {
+			return &models.File{
+				Id:          primitive.NewObjectID(),
+				Name:        name,
+				Size:        source.Size,
+				ContentType: source.ContentType,
+				Checksum:    source.Checksum,
+				CreatedAt:   time.Now(),
+				BlobRef:     blob,
+			}, nil
+		}
+	}
+
+	blob, copied, err := copyBlob(ctx, db, cfg, source.BlobRef, source.Size)
+	if err != nil {
+		return nil, err
+	}
+	if copied {
+		return &models.File{
+			Id:          primitive.NewObjectID(),
+			Name:        name,
+			Size:        source.Size,
+			ContentType: source.ContentType,
+			Checksum:    source.Checksum,
+			CreatedAt:   time.Now(),
+			BlobRef:     blob,
+		}, nil
+	}
+
+	stream, err := openBlob(ctx, db, cfg, source.BlobRef)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	file, err := storeUpload(ctx, db, cfg, stream, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Checksum != source.Checksum && source.Checksum != "" {
+		discardBlob(ctx, db, cfg, file.BlobRef)
+		return nil, errCopyMismatch
+	}
+	return file, nil
+}
@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// corsConfig allows https://app.example.com, with credentials.
+func corsConfig() *Config {
+	cfg := testConfig()
+	cfg.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	cfg.CORS.AllowCredentials = true
+	cfg.CORS.MaxAge = 10 * time.Minute
+	return cfg
+}
+
+func preflight(path, origin string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	req.Header.Set("Access-Control-Request-Headers", "authorization, range")
+	return req
+}
+
+func TestCORSPreflight(t *testing.T) {
+	cfg := corsConfig()
+	router := newRouter(nil, cfg)
+
+	for _, path := range []string{"/files/abc/download", "/s/abcdefghijklmnop", "/users/me", "/no/such/route"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, preflight(cfg.APIPrefix+path, "https://app.example.com"))
+
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s: status = %d, want 204", path, rec.Code)
+		}
+		header := rec.Header()
+		if header.Get("Access-Control-Allow-Origin") != "https://app.example.com" || header.Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s: allow origin %q, credentials %q", path, header.Get("Access-Control-Allow-Origin"), header.Get("Access-Control-Allow-Credentials"))
+		}
+		if !strings.Contains(header.Get("Access-Control-Allow-Methods"), http.MethodDelete) || !strings.Contains(header.Get("Access-Control-Allow-Headers"), "Range") {
+			t.Errorf("%s: allow methods %q, headers %q", path, header.Get("Access-Control-Allow-Methods"), header.Get("Access-Control-Allow-Headers"))
+		}
+		if header.Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("%s: max age %q, want 600", path, header.Get("Access-Control-Max-Age"))
+		}
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	newRouter(nil, corsConfig()).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the request served", rec.Code)
+	}
+	header := rec.Header()
+	if header.Get("Access-Control-Allow-Origin") != "https://app.example.com" || header.Get("Vary") != "Origin" {
+		t.Errorf("allow origin %q, Vary %q", header.Get("Access-Control-Allow-Origin"), header.Get("Vary"))
+	}
+	for _, exposed := range []string{"Content-Disposition", RequestIDHeader} {
+		if !strings.Contains(header.Get("Access-Control-Expose-Headers"), exposed) {
+			t.Errorf("exposed headers %q lack %s", header.Get("Access-Control-Expose-Headers"), exposed)
+		}
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	cfg := corsConfig()
+	router := newRouter(nil, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want the request served without CORS headers", rec.Code)
+	}
+	for name := range rec.Header() {
+		if strings.HasPrefix(name, "Access-Control-") {
+			t.Errorf("disallowed origin got %s", name)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, preflight(cfg.APIPrefix+"/files/abc/download", "https://evil.example.com"))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight got %d, allow origin %q; want 204 without CORS headers", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	cors := corsConfig().CORS
+	cors.AllowedOrigins = []string{"*"}
+	tests := []struct {
+		credentials bool
+		want        string
+	}{
+		{false, "*"},
+		// Browsers refuse * with credentials, so the origin is echoed.
+		{true, "https://any.example.com"},
+	}
+	for _, tt := range tests {
+		cors.AllowCredentials = tt.credentials
+		cfg := testConfig()
+		cfg.CORS = cors
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.Header.Set("Origin", "https://any.example.com")
+		rec := httptest.NewRecorder()
+		newRouter(nil, cfg).ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("credentials %v: allow origin %q, want %q", tt.credentials, got, tt.want)
+		}
+	}
+}
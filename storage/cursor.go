@@ -0,0 +1,208 @@
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errStaleCursor   = errors.New("cursor was issued for a different sort order")
+	errCursorAndPage = errors.New("cursor and page cannot be combined")
+)
+
+// pageCursor marks where a listing left off: the sort it was issued for and
+// the sort key values of the last item returned, in sort order.
+type pageCursor struct {
+	Sort   string `bson:"s"`
+	Values bson.A `bson:"v"`
+}
+
+// CursorPage is a page of a listing that supports ?cursor=. Without a cursor
+// it is an offset page like Pagination; with one it continues after the
+// item the cursor names with a range query on the sort keys, so documents
+// inserted or removed between requests don't shift the page.
+type CursorPage struct {
+	Pagination
+	secret []byte
+	sort   bson.D
+	after  bson.M
+}
+
+// parseCursorPage reads ?limit= and either ?page= or ?cursor= for a listing
+// ordered by sortDoc, with cursors signed by secret. A cursor issued for
+// another sort order is refused.
+func parseCursorPage(c *gin.Context, secret []byte, sortDoc bson.D) (CursorPage, error) {
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return CursorPage{}, err
+	}
+	p := CursorPage{Pagination: pagination, secret: secret, sort: sortDoc}
+
+	token := c.Query("cursor")
+	if token == "" {
+		return p, nil
+	}
+	if c.Query("page") != "" {
+		return p, errCursorAndPage
+	}
+
+	cursor, err := decodeCursor(p.secret, token)
+	if err != nil {
+		return p, err
+	}
+	if cursor.Sort != sortFingerprint(sortDoc) || len(cursor.Values) != len(sortDoc) {
+		return p, errStaleCursor
+	}
+	p.Page = 0
+	p.after = afterFilter(sortDoc, cursor.Values)
+	return p, nil
+}
+
+// Filter adds the cursor's range condition, if any, to filter.
+func (p CursorPage) Filter(filter bson.M) bson.M {
+	if p.after == nil {
+		return filter
+	}
+	and, _ := filter["$and"].(bson.A)
+	filter["$and"] = append(and, p.after)
+	return filter
+}
+
+// FindOptions sorts and limits the query, fetching one item more than the
+// page holds to learn whether there is a next page.
+func (p CursorPage) FindOptions() *options.FindOptions {
+	opts := options.Find().SetSort(p.sort).SetLimit(p.Limit + 1)
+	if p.after == nil {
+		opts.SetSkip((p.Page - 1) * p.Limit)
+	}
+	return opts
+}
+
+// Stages are the aggregation equivalent of Filter and FindOptions, for
+// pipelines whose sort keys are computed in an earlier stage.
+func (p CursorPage) Stages() []bson.D {
+	var stages []bson.D
+	if p.after != nil {
+		stages = append(stages, bson.D{{Key: "$match", Value: p.after}})
+	}
+	stages = append(stages, bson.D{{Key: "$sort", Value: p.sort}})
+	if p.after == nil && p.Page > 1 {
+		stages = append(stages, bson.D{{Key: "$skip", Value: (p.Page - 1) * p.Limit}})
+	}
+	return append(stages, bson.D{{Key: "$limit", Value: p.Limit + 1}})
+}
+
+// cursorResult trims the extra item FindOptions asked for and wraps items
+// into a PageResult, with a nextCursor when there are more.
+func cursorResult[T any](p CursorPage, items []T, total int64) (PageResult, error) {
+	more := int64(len(items)) > p.Limit
+	if more {
+		items = items[:p.Limit]
+	}
+
+	result := p.Result(items, total)
+	if more {
+		next, err := encodeCursor(p.secret, p.sort, items[len(items)-1])
+		if err != nil {
+			return result, err
+		}
+		result.NextCursor = next
+	}
+	return result, nil
+}
+
+// afterFilter matches the documents that sort after values: those greater
+// on the first key, or equal on it and greater on the next, and so on.
+func afterFilter(sortDoc bson.D, values bson.A) bson.M {
+	or := make(bson.A, 0, len(sortDoc))
+	for i, key := range sortDoc {
+		cond := bson.M{}
+		for j := 0; j < i; j++ {
+			cond[sortDoc[j].Key] = values[j]
+		}
+		op := "$gt"
+		if direction, _ := key.Value.(int); direction < 0 {
+			op = "$lt"
+		}
+		cond[key.Key] = bson.M{op: values[i]}
+		or = append(or, cond)
+	}
+	return bson.M{"$or": or}
+}
+
+// sortFingerprint names a sort document, e.g. "createdAt:-1,_id:-1".
+func sortFingerprint(sortDoc bson.D) string {
+	parts := make([]string, len(sortDoc))
+	for i, key := range sortDoc {
+		direction, _ := key.Value.(int)
+		parts[i] = key.Key + ":" + strconv.Itoa(direction)
+	}
+	return strings.Join(parts, ",")
+}
+
+// encodeCursor builds the cursor continuing after last, an item as it is
+// stored, so its sort keys are read by their bson names.
+func encodeCursor(secret []byte, sortDoc bson.D, last any) (string, error) {
+	raw, err := bson.Marshal(last)
+	if err != nil {
+		return "", err
+	}
+
+	cursor := pageCursor{Sort: sortFingerprint(sortDoc), Values: make(bson.A, len(sortDoc))}
+	for i, key := range sortDoc {
+		value, err := bson.Raw(raw).LookupErr(key.Key)
+		if err != nil {
+			return "", err
+		}
+		cursor.Values[i] = value
+	}
+
+	data, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(secret, data)), nil
+}
+
+// decodeCursor checks the signature of a cursor and decodes it. Cursors are
+// signed so clients can't turn them into queries of their own.
+func decodeCursor(secret []byte, token string) (pageCursor, error) {
+	var cursor pageCursor
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return cursor, errInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, errInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, cursorMAC(secret, data)) {
+		return cursor, errInvalidCursor
+	}
+
+	if err := bson.Unmarshal(data, &cursor); err != nil {
+		return cursor, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// cursorMAC signs cursor data with a key derived from secret.
+func cursorMAC(secret []byte, data []byte) []byte {
+	key := hmac.New(sha256.New, secret)
+	key.Write([]byte("page cursor"))
+
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,201 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BlobCollection holds the reference counts of deduplicated content.
+var BlobCollection string = "blobs"
+
+// blobClaimRetries bounds the retries when a blob record with the same
+// content is being created or removed concurrently.
+const blobClaimRetries = 3
+
+// dedupeUpload points a freshly stored file at an existing blob with the same
+// SHA-256 and size, discarding its own copy, or registers its blob for reuse.
+// The content is always written first: trusting a client declared hash would
+// let anyone who knows a hash claim content they don't have.
+func dedupeUpload(ctx context.Context, db *mongo.Database, cfg *Config, file *models.File) error {
+	if !cfg.DedupEnabled {
+		return nil
+	}
+
+	existing, err := claimBlob(ctx, db, file.Checksum, file.Size, file.BlobRef)
+	if err != nil {
+		return err
+	}
+	if existing.BlobId != file.BlobId {
+		discardBlob(ctx, db, cfg, file.BlobRef)
+		file.BlobRef = existing
+	}
+	return nil
+}
+
+// claimBlob takes a reference on the blob holding sha256/size content, or
+// registers fallback, which must already be stored, as that blob. It returns
+// the blob to reference, which may be kept by another storage backend. When
+// the record can't be settled the fallback stays unregistered and is deleted
+// outright once unreferenced.
+func claimBlob(ctx context.Context, db *mongo.Database, sha256 string, size int64, fallback models.BlobRef) (models.BlobRef, error) {
+	collection := db.Collection(BlobCollection)
+
+	// Records at zero are being removed and must not be revived.
+	filter := bson.M{"sha256": sha256, "size": size, "refCount": bson.M{"$gt": 0}}
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"_id": 1, "storageBackend": 1, "storageKey": 1, "encrypted": 1})
+
+	for attempt := 0; attempt < blobClaimRetries; attempt++ {
+		var blob models.Blob
+		err := collection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"refCount": 1}}, opts).Decode(&blob)
+		if err == nil {
+			return blob.Ref(), nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return models.BlobRef{}, err
+		}
+
+		_, err = collection.InsertOne(ctx, models.Blob{
+			Id:             fallback.BlobId,
+			StorageBackend: fallback.StorageBackend,
+			StorageKey:     fallback.StorageKey,
+			Encrypted:      fallback.Encrypted,
+			SHA256:         sha256,
+			Size:           size,
+			RefCount:       1,
+			CreatedAt:      time.Now(),
+		})
+		if err == nil {
+			return fallback, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return models.BlobRef{}, err
+		}
+	}
+	return fallback, nil
+}
+
+// retainBlob takes one more reference on the blob behind file, registering
+// blobs stored before deduplication as they are first shared. It reports
+// false when the blob can't be shared and must be copied instead.
+func retainBlob(ctx context.Context, db *mongo.Database, file *models.File) (models.BlobRef, bool, error) {
+	collection := db.Collection(BlobCollection)
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": file.BlobId, "refCount": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"refCount": 1}})
+	if err != nil {
+		return models.BlobRef{}, false, err
+	}
+	if result.MatchedCount == 1 {
+		return file.BlobRef, true, nil
+	}
+
+	// An unregistered blob has exactly one reference: file itself.
+	_, err = collection.InsertOne(ctx, models.Blob{
+		Id:             file.BlobId,
+		StorageBackend: file.StorageBackend,
+		StorageKey:     file.StorageKey,
+		Encrypted:      file.Encrypted,
+		SHA256:         file.Checksum,
+		Size:           file.Size,
+		RefCount:       2,
+		CreatedAt:      time.Now(),
+	})
+	if err == nil {
+		return file.BlobRef, true, nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		// Either the same content is registered under another blob, which
+		// can be shared instead, or the record is mid-removal.
+		var other models.Blob
+		filter := bson.M{"sha256": file.Checksum, "size": file.Size, "refCount": bson.M{"$gt": 0}}
+		err = collection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"refCount": 1}}).Decode(&other)
+		if err == nil {
+			return other.Ref(), true, nil
+		}
+		if err == mongo.ErrNoDocuments {
+			return models.BlobRef{}, false, nil
+		}
+	}
+	return models.BlobRef{}, false, err
+}
+
+// releaseBlob drops one reference to stored content and reports whether the
+// content itself should now be deleted. Only the caller that takes the count
+// to zero gets true.
+func releaseBlob(ctx context.Context, db *mongo.Database, id primitive.ObjectID) (bool, error) {
+	collection := db.Collection(BlobCollection)
+
+	var blob models.Blob
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"refCount": -1}}, opts).Decode(&blob)
+	if err == mongo.ErrNoDocuments {
+		// Not deduplicated, so the caller held the only reference.
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if blob.RefCount > 0 {
+		return false, nil
+	}
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id, "refCount": bson.M{"$lte": 0}})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount == 1, nil
+}
+
+// GetDedupStats handler reports how much storage deduplication saves.
+func (ac *AdminController) GetDedupStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := ac.db.Collection(BlobCollection)
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"refCount": bson.M{"$gt": 0}}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":         nil,
+				"blobs":       bson.M{"$sum": 1},
+				"references":  bson.M{"$sum": "$refCount"},
+				"storedBytes": bson.M{"$sum": "$size"},
+				"savedBytes":  bson.M{"$sum": bson.M{"$multiply": bson.A{"$size", bson.M{"$subtract": bson.A{"$refCount", 1}}}}},
+			}}},
+		}
+
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var results []struct {
+			Blobs       int64 `json:"blobs" bson:"blobs"`
+			References  int64 `json:"references" bson:"references"`
+			StoredBytes int64 `json:"storedBytes" bson:"storedBytes"`
+			SavedBytes  int64 `json:"savedBytes" bson:"savedBytes"`
+		}
+		if err := cursor.All(ctx, &results); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		stats := gin.H{"enabled": ac.cfg.DedupEnabled, "blobs": 0, "references": 0, "storedBytes": 0, "savedBytes": 0}
+		if len(results) > 0 {
+			stats["blobs"] = results[0].Blobs
+			stats["references"] = results[0].References
+			stats["storedBytes"] = results[0].StoredBytes
+			stats["savedBytes"] = results[0].SavedBytes
+		}
+
+		c.JSON(http.StatusOK, stats)
+	}
+}
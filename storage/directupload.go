@@ -0,0 +1,479 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var DirectUploadCollection string = "directUploads"
+
+const (
+	// s3MaxPutSize is the largest object a single PUT can store; bigger
+	// direct uploads are multipart.
+	s3MaxPutSize = 5 << 30
+	// s3MaxObjectSize is the largest object S3 can store.
+	s3MaxObjectSize = 5 << 40
+	// s3MaxParts is the most parts a multipart upload can have.
+	s3MaxParts = 10000
+	// directUploadPartSize is the smallest part of a multipart direct
+	// upload, keeping the number of part URLs handed out low.
+	directUploadPartSize = 64 << 20
+	// directUploadConfirmWindow is how long a confirmation may take before
+	// the sweeper treats the upload as abandoned; longer than the default
+	// Config.StreamTimeout.
+	directUploadConfirmWindow = 2 * time.Hour
+)
+
+var errDirectUploadUnsupported = errors.New("direct uploads need the " + StorageS3 + " storage backend without content encryption; use POST /files/ instead")
+
+type createDirectUploadRequest struct {
+	Name     string `json:"name" binding:"required,max=255"`
+	Size     int64  `json:"size" binding:"min=0"`
+	SHA256   string `json:"sha256" binding:"required,len=64,hexadecimal"`
+	FolderId string `json:"folderId" binding:"omitempty,len=24,hexadecimal"`
+}
+
+type confirmDirectUploadRequest struct {
+	// Parts lists the uploaded parts of a multipart upload, with the ETag
+	// S3 returned for each.
+	Parts []s3Part `json:"parts" binding:"max=10000,dive"`
+}
+
+// uploadPartURL is where one part of a multipart direct upload is PUT.
+type uploadPartURL struct {
+	PartNumber int    `json:"partNumber"`
+	URL        string `json:"url"`
+}
+
+// directUploadResponse tells the client where to upload the content: with
+// one PUT to URL carrying Headers, or for a multipart upload each PartSize
+// slice to the URL of its part.
+type directUploadResponse struct {
+	Id        primitive.ObjectID `json:"id"`
+	Method    string             `json:"method"`
+	URL       string             `json:"url,omitempty"`
+	Headers   map[string]string  `json:"headers,omitempty"`
+	PartSize  int64              `json:"partSize,omitempty"`
+	Parts     []uploadPartURL    `json:"parts,omitempty"`
+	ExpiresAt time.Time          `json:"expiresAt"`
+}
+
+// directStorage returns the s3 backend, or nil when direct uploads are not
+// possible. Content uploaded straight to S3 can't be encrypted on the way.
+func directStorage(db *mongo.Database, cfg *Config) (*s3Storage, error) {
+	if cfg.Storage.Backend != StorageS3 || cfg.Storage.Encryption.keyWrapper() != nil {
+		return nil, nil
+	}
+	storage, err := storageBackend(db, cfg, StorageS3)
+	if err != nil {
+		return nil, err
+	}
+	s3, _ := storage.(*s3Storage)
+	return s3, nil
+}
+
+// CreateDirectUpload handler reserves a file whose content the client then
+// uploads straight to S3 with the presigned URLs in the response, and
+// confirms with ConfirmDirectUpload. A single PUT must carry the
+// x-amz-checksum-sha256 header it was signed with, so S3 itself rejects
+// content that doesn't match the declared SHA-256. Other storage backends,
+// and deployments encrypting content, answer 501.
+func (fc *FileController) CreateDirectUpload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(DirectUploadCollection)
+
+		s3, err := directStorage(fc.db, fc.cfg)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if s3 == nil {
+			respondError(c, newAPIError(http.StatusNotImplemented, ErrCodeNotImplemented, errDirectUploadUnsupported.Error()))
+			return
+		}
+
+		var req createDirectUploadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		limit := fc.cfg.uploadLimit(c)
+		if limit > s3MaxObjectSize {
+			limit = s3MaxObjectSize
+		}
+		if req.Size > limit {
+			respondUploadTooLarge(c, limit)
+			return
+		}
+
+		userId := currentUserID(c)
+		if !checkQuota(ctx, fc.db, fc.cfg, c, userId, req.Size) {
+			return
+		}
+
+		folderId, ok := resolveFolderParam(ctx, fc.db, c, req.FolderId, userId)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		blobId := primitive.NewObjectID()
+		upload := models.DirectUpload{
+			Id:        primitive.NewObjectID(),
+			OwnerId:   userId,
+			FolderId:  folderId,
+			Name:      req.Name,
+			Size:      req.Size,
+			SHA256:    normalizeHex(req.SHA256),
+			Status:    models.DirectUploadPending,
+			CreatedAt: now,
+			ExpiresAt: now.Add(fc.cfg.DirectUploadTTL),
+			BlobRef:   models.BlobRef{BlobId: blobId, StorageBackend: StorageS3, StorageKey: blobId.Hex()},
+		}
+		response := directUploadResponse{Id: upload.Id, Method: http.MethodPut, ExpiresAt: upload.ExpiresAt}
+
+		if req.Size <= s3MaxPutSize {
+			digest, _ := hex.DecodeString(upload.SHA256)
+			header := map[string]string{"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(digest)}
+			response.URL, err = s3.presign(http.MethodPut, upload.StorageKey, nil, header, fc.cfg.DirectUploadTTL)
+			response.Headers = header
+		} else {
+			upload.PartSize = max(directUploadPartSize, (req.Size+s3MaxParts-1)/s3MaxParts)
+			upload.MultipartId, err = s3.createMultipart(ctx, upload.StorageKey)
+			if err == nil {
+				response.PartSize = upload.PartSize
+				response.Parts, err = presignUploadParts(s3, &upload, fc.cfg.DirectUploadTTL)
+			}
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if _, err := collection.InsertOne(ctx, upload); err != nil {
+			if upload.MultipartId != "" {
+				s3.abortMultipart(ctx, upload.StorageKey, upload.MultipartId)
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// presignUploadParts returns an upload URL for each PartSize slice of the
+// multipart upload.
+func presignUploadParts(s3 *s3Storage, upload *models.DirectUpload, ttl time.Duration) ([]uploadPartURL, error) {
+	count := int((upload.Size + upload.PartSize - 1) / upload.PartSize)
+	parts := make([]uploadPartURL, 0, count)
+	for number := 1; number <= count; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {upload.MultipartId}}
+		signed, err := s3.presign(http.MethodPut, upload.StorageKey, query, nil, ttl)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, uploadPartURL{PartNumber: number, URL: signed})
+	}
+	return parts, nil
+}
+
+// ConfirmDirectUpload handler turns a direct upload into a file once its
+// content is in S3: it completes a multipart upload with the parts the
+// client lists, checks the object has the reserved size and SHA-256 and an
+// allowed content type, and takes the quota. Content failing a check is
+// deleted together with the reservation. As with other uploads, a live file
+// of the same name in the folder gets the content as a new version.
+func (fc *FileController) ConfirmDirectUpload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		var req confirmDirectUploadRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				respondBindingError(c, err)
+				return
+			}
+		}
+
+		mode, err := parseUploadOnConflict(c, fc.cfg.UploadOnConflict)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		upload, ok := fc.claimDirectUpload(ctx, c)
+		if !ok {
+			return
+		}
+
+		s3, err := directStorage(db, fc.cfg)
+		if err == nil && s3 == nil {
+			err = errDirectUploadUnsupported
+		}
+		if err != nil {
+			releaseDirectUpload(ctx, db, upload)
+			respondError(c, err)
+			return
+		}
+
+		if upload.MultipartId != "" {
+			if len(req.Parts) == 0 {
+				releaseDirectUpload(ctx, db, upload)
+				respondError(c, badRequest("parts is required to complete a multipart upload"))
+				return
+			}
+			err := s3.completeMultipart(ctx, upload.StorageKey, upload.MultipartId, req.Parts)
+			var s3Err *s3Error
+			if errors.As(err, &s3Err) && s3Err.Status < 500 && !isS3NotFound(err) {
+				releaseDirectUpload(ctx, db, upload)
+				respondError(c, badRequest("the listed parts could not be assembled: "+s3Err.Code))
+				return
+			}
+			// NoSuchUpload follows a completion that succeeded before.
+			if err != nil && !isS3NotFound(err) {
+				releaseDirectUpload(ctx, db, upload)
+				respondError(c, err)
+				return
+			}
+		}
+
+		size, etag, err := s3.stat(ctx, upload.StorageKey)
+		if err == errBlobNotFound {
+			releaseDirectUpload(ctx, db, upload)
+			respondError(c, conflict("the content has not been uploaded yet"))
+			return
+		}
+		if err != nil {
+			releaseDirectUpload(ctx, db, upload)
+			respondError(c, err)
+			return
+		}
+		if size != upload.Size {
+			dropDirectUpload(ctx, db, s3, upload)
+			respondError(c, newAPIError(http.StatusUnprocessableEntity, ErrCodeUnprocessable, "uploaded content does not match the declared size").withDetails(gin.H{"expected": upload.Size, "actual": size}))
+			return
+		}
+
+		contentType, checksum, err := inspectDirectUpload(ctx, fc.cfg, s3, upload)
+		if err != nil {
+			dropDirectUpload(ctx, db, s3, upload)
+			respondStoreError(c, err)
+			return
+		}
+		if checksum != upload.SHA256 {
+			dropDirectUpload(ctx, db, s3, upload)
+			respondChecksumMismatch(c, upload.SHA256, checksum)
+			return
+		}
+
+		if !reserveQuota(ctx, db, fc.cfg, c, upload.OwnerId, size) {
+			dropDirectUpload(ctx, db, s3, upload)
+			return
+		}
+
+		file := &models.File{
+			Id:          upload.Id,
+			OwnerId:     upload.OwnerId,
+			FolderId:    upload.FolderId,
+			Name:        upload.Name,
+			Size:        size,
+			ContentType: contentType,
+			Checksum:    checksum,
+			CreatedAt:   time.Now(),
+			BlobRef:     upload.BlobRef,
+			ScanResult:  newScanResult(fc.cfg.Scan),
+		}
+
+		if err := dedupeUpload(ctx, db, fc.cfg, file); err != nil {
+			releaseQuota(ctx, db, upload.OwnerId, size)
+			dropDirectUpload(ctx, db, s3, upload)
+			respondError(c, err)
+			return
+		}
+
+		saved, created, err := saveUploadedFile(ctx, db, fc.cfg, file, mode)
+		if err != nil {
+			releaseQuota(ctx, db, upload.OwnerId, size)
+			deleteBlobs(ctx, db, fc.cfg, []models.BlobRef{file.BlobRef})
+			_, _ = db.Collection(DirectUploadCollection).DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": upload.Id})
+			respondSaveError(c, err)
+			return
+		}
+
+		_, _ = db.Collection(DirectUploadCollection).DeleteOne(ctx, bson.M{"_id": upload.Id})
+		uploadedBytes.add(float64(size))
+
+		enqueueContentJobs(saved)
+		audit(c, AuditFileUploaded, auditTargetFile, saved.Id, gin.H{"name": saved.Name, "size": saved.Size, "version": saved.CurrentVersion().N, "direct": true, "etag": etag})
+		emitWebhookEvent(saved.OwnerId, AuditFileUploaded, fileEventData(saved))
+
+		if !created {
+			c.JSON(http.StatusOK, saved)
+			return
+		}
+		c.JSON(http.StatusCreated, saved)
+	}
+}
+
+// claimDirectUpload marks the caller's pending direct upload named by the
+// :id path param as being confirmed, so concurrent confirmations can't both
+// turn it into a file.
+func (fc *FileController) claimDirectUpload(ctx context.Context, c *gin.Context) (*models.DirectUpload, bool) {
+	collection := fc.db.Collection(DirectUploadCollection)
+
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("Invalid upload ID"))
+		return nil, false
+	}
+
+	now := time.Now()
+	filter := bson.M{"_id": objId, "ownerId": currentUserID(c), "status": models.DirectUploadPending, "expiresAt": bson.M{"$gt": now}}
+	// The confirmation gets its own deadline, so the sweeper leaves the
+	// upload alone however little of its TTL was left.
+	update := bson.M{"$set": bson.M{"status": models.DirectUploadConfirming, "expiresAt": now.Add(directUploadConfirmWindow)}}
+
+	var upload models.DirectUpload
+	if err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&upload); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, notFound("Upload not found"))
+			return nil, false
+		}
+		respondError(c, err)
+		return nil, false
+	}
+
+	return &upload, true
+}
+
+// releaseDirectUpload puts a direct upload whose confirmation failed for a
+// reason the client can fix back to pending, until its original expiry.
+func releaseDirectUpload(ctx context.Context, db *mongo.Database, upload *models.DirectUpload) {
+	update := bson.M{"$set": bson.M{"status": models.DirectUploadPending, "expiresAt": upload.ExpiresAt}}
+	if _, err := db.Collection(DirectUploadCollection).UpdateOne(context.WithoutCancel(ctx), bson.M{"_id": upload.Id}, update); err != nil {
+		log.Printf("releasing direct upload %s failed: %v", upload.Id.Hex(), err)
+	}
+}
+
+// dropDirectUpload deletes the content of a direct upload, and any parts of
+// an unfinished multipart upload, together with its reservation. Neither is
+// cancelled with the request.
+func dropDirectUpload(ctx context.Context, db *mongo.Database, s3 *s3Storage, upload *models.DirectUpload) {
+	ctx = context.WithoutCancel(ctx)
+	if upload.MultipartId != "" {
+		s3.abortMultipart(ctx, upload.StorageKey, upload.MultipartId)
+	}
+	if err := s3.Delete(ctx, upload.StorageKey); err != nil {
+		log.Printf("deleting direct upload %s content failed: %v", upload.Id.Hex(), err)
+		return
+	}
+	if _, err := db.Collection(DirectUploadCollection).DeleteOne(ctx, bson.M{"_id": upload.Id}); err != nil {
+		log.Printf("deleting direct upload %s failed: %v", upload.Id.Hex(), err)
+	}
+}
+
+// inspectDirectUpload detects the content type of an uploaded object from
+// its first bytes and returns it with the object's SHA-256. A single PUT was
+// checked against the declared SHA-256 by S3; the content of a multipart
+// upload, whose parts S3 only checks one by one, is read in full to hash it.
+func inspectDirectUpload(ctx context.Context, cfg *Config, s3 *s3Storage, upload *models.DirectUpload) (string, string, error) {
+	var head []byte
+	checksum := upload.SHA256
+
+	if upload.MultipartId == "" {
+		if upload.Size > 0 {
+			download, err := s3.GetRange(ctx, upload.StorageKey, 0, min(upload.Size, sniffLen))
+			if err != nil {
+				return "", "", err
+			}
+			head, err = io.ReadAll(download)
+			download.Close()
+			if err != nil {
+				return "", "", err
+			}
+		}
+	} else {
+		download, err := s3.Get(ctx, upload.StorageKey)
+		if err != nil {
+			return "", "", err
+		}
+		defer download.Close()
+
+		buf := make([]byte, sniffLen)
+		n, err := io.ReadFull(download, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", "", err
+		}
+		head = buf[:n]
+
+		hasher := sha256.New()
+		hasher.Write(head)
+		if _, err := io.Copy(hasher, download); err != nil {
+			return "", "", err
+		}
+		checksum = hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	contentType := detectContentType(head, upload.Name)
+	if err := cfg.checkContentType(contentType); err != nil {
+		return "", "", err
+	}
+	return contentType, checksum, nil
+}
+
+// expireDirectUploads removes the direct uploads past their expiry, which
+// were never confirmed or whose confirmation was abandoned, together with
+// their content. It returns how many it removed.
+func expireDirectUploads(ctx context.Context, db *mongo.Database, cfg *Config) (int, error) {
+	collection := db.Collection(DirectUploadCollection)
+
+	now := time.Now()
+	var expired []models.DirectUpload
+	opts := options.Find().SetSort(bson.D{{Key: "expiresAt", Value: 1}}).SetLimit(TrashPurgeBatch)
+	if err := findAll(ctx, collection, bson.M{"expiresAt": bson.M{"$lt": now}}, &expired, opts); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, upload := range expired {
+		storage, err := storageBackend(db, cfg, upload.StorageBackend)
+		if err != nil {
+			return removed, err
+		}
+		if s3, ok := storage.(*s3Storage); ok && upload.MultipartId != "" {
+			s3.abortMultipart(ctx, upload.StorageKey, upload.MultipartId)
+		}
+		if err := storage.Delete(ctx, upload.StorageKey); err != nil {
+			return removed, err
+		}
+
+		result, err := collection.DeleteOne(ctx, bson.M{"_id": upload.Id})
+		if err != nil {
+			return removed, err
+		}
+		removed += int(result.DeletedCount)
+	}
+	return removed, nil
+}
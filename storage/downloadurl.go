@@ -0,0 +1,121 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxDownloadURLTTL is the longest validity S3 accepts for a presigned URL.
+const maxDownloadURLTTL = 7 * 24 * time.Hour
+
+// DownloadClaims is the payload of a download token, which lets whoever
+// holds it download one file as the user it was issued to.
+type DownloadClaims struct {
+	FileId string `json:"fid"`
+	Role   string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// GetDownloadURL handler checks the caller may download a file, as the
+// download route does, and returns a short-lived link to its content instead
+// of the content itself. Content kept in S3 is linked to directly with a
+// presigned URL, so the bytes don't pass through the server; anything else
+// gets a link to the download route carrying a download token. The download
+// is recorded when the link is issued.
+func (fc *FileController) GetDownloadURL() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		if !scanAllowsDownload(c, fc.cfg.Scan, file.ScanResult) {
+			return
+		}
+
+		ttl := fc.cfg.DownloadURLTTL
+		expiresAt := time.Now().Add(ttl)
+		link, direct, err := presignBlob(fc.db, fc.cfg, file.BlobRef, contentDisposition("attachment", file.Name), ttl)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if !direct {
+			token, err := issueDownloadToken(c, fc.cfg.JWTSecret, file, expiresAt)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			link = fc.cfg.apiURL("/files/" + file.Id.Hex() + "/download?token=" + token)
+		}
+
+		recordAccess(c, file)
+		audit(c, AuditFileDownloaded, auditTargetFile, file.Id, gin.H{"direct": direct})
+
+		c.JSON(http.StatusOK, gin.H{
+			"url":       link,
+			"direct":    direct,
+			"expiresIn": int64(ttl.Seconds()),
+		})
+	}
+}
+
+func issueDownloadToken(c *gin.Context, secret []byte, file *models.File, expiresAt time.Time) (string, error) {
+	claims := DownloadClaims{
+		FileId: file.Id.Hex(),
+		Role:   c.GetString("role"),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   currentUserID(c).Hex(),
+			Audience:  jwt.ClaimStrings{downloadAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	return signToken(secret, claims)
+}
+
+// DownloadTokenAuth authenticates a download by the ?token= of a link from
+// GET /files/:id/download-url, for the file it was issued for, and leaves
+// requests without one to AuthRequired. The permission checks of the
+// download still apply to the user the token was issued to.
+func DownloadTokenAuth(db *mongo.Database, cfg *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.Query("token")
+		if raw == "" {
+			c.Next()
+			return
+		}
+
+		claims := &DownloadClaims{}
+		if err := parseToken(cfg.JWTSecret, raw, claims, downloadAudience); err != nil || claims.FileId != c.Param("id") {
+			respondError(c, unauthorized("invalid or expired download link"))
+			return
+		}
+		userId, err := primitive.ObjectIDFromHex(claims.Subject)
+		if err != nil {
+			respondError(c, unauthorized("invalid token subject"))
+			return
+		}
+
+		if !requireActiveAccount(c, db, userId) {
+			return
+		}
+
+		c.Set("userID", userId)
+		c.Set("role", claims.Role)
+		c.Set("downloadToken", true)
+		c.Next()
+	}
+}
@@ -0,0 +1,431 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BlobKeyCollection holds the data keys of encrypted content.
+var BlobKeyCollection string = "blobKeys"
+
+// KeyWrapper protects data keys with a master key, such as one kept in a
+// KMS. KeyId names the master key Wrap uses; Unwrap must also accept the
+// ones used before it, until every data key has been rewrapped.
+type KeyWrapper interface {
+	KeyId() string
+	Wrap(ctx context.Context, dataKey []byte) (wrapped []byte, err error)
+	Unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error)
+}
+
+const (
+	// encryptionScheme is AES-256-GCM over chunks of encryptionChunkSize
+	// plaintext bytes, so a range is read by decrypting only its chunks.
+	encryptionScheme    = "aes-256-gcm-chunked"
+	encryptionChunkSize = 64 << 10
+	// keyRewrapLock names the lease that keeps rewrap runs on one instance.
+	keyRewrapLock = "key-rewrap"
+)
+
+var errBlobKeyNotFound = errors.New("encryption key of stored content not found")
+var errContentKeysMissing = errors.New("stored content is encrypted but no encryption keys are configured")
+var errBlobTampered = errors.New("stored content failed authentication")
+var errRewrapRunning = errors.New("a key rewrap is already running")
+var errEncryptionDisabled = errors.New("content encryption is not enabled")
+
+// contentKeyError is what a client is told when the data key of encrypted
+// content can't be had or used. cause, which may name master keys or come
+// from a KMS, is only logged.
+func contentKeyError(cause error) *APIError {
+	return internalError("stored content could not be decrypted", cause)
+}
+
+// masterKeyring wraps data keys with AES-256-GCM under master keys from the
+// configuration, the one named current for new data keys.
+type masterKeyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (k *masterKeyring) KeyId() string {
+	return k.current
+}
+
+// Wrap seals dataKey under the current master key, prefixed with the nonce.
+func (k *masterKeyring) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(k.keys[k.current])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(k.current)), nil
+}
+
+func (k *masterKeyring) Unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	master, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("master key %q is not configured", keyId)
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errBlobTampered
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyId))
+	if err != nil {
+		return nil, errBlobTampered
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the nonce of chunk n: the blob's random prefix followed by n.
+func chunkNonce(prefix []byte, n uint64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+// sealingReader encrypts what it reads from src chunk by chunk under a fresh
+// data key.
+type sealingReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	key     models.BlobKey
+	chunk   uint64
+	plain   []byte
+	sealed  []byte
+	pending []byte
+	err     error
+}
+
+// newSealingReader generates a data key for new content read from src and
+// wraps it with keys.
+func newSealingReader(ctx context.Context, keys KeyWrapper, src io.Reader) (*sealingReader, error) {
+	dataKey := make([]byte, 32)
+	prefix := make([]byte, 4)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, internalError("new content could not be encrypted", fmt.Errorf("wrapping data key: %w", err))
+	}
+
+	return &sealingReader{
+		src:  src,
+		aead: aead,
+		key: models.BlobKey{
+			Scheme:      encryptionScheme,
+			KeyId:       keys.KeyId(),
+			WrappedKey:  wrapped,
+			NoncePrefix: prefix,
+			ChunkSize:   encryptionChunkSize,
+		},
+		plain:  make([]byte, encryptionChunkSize),
+		sealed: make([]byte, 0, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *sealingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := io.ReadFull(r.src, r.plain)
+		if n > 0 {
+			r.pending = r.aead.Seal(r.sealed[:0], chunkNonce(r.key.NoncePrefix, r.chunk), r.plain[:n], nil)
+			r.chunk++
+			r.key.Size += int64(n)
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		r.err = err
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// openingReader decrypts the chunks read from src, starting at chunk, and
+// returns remaining plaintext bytes after dropping the first skip.
+type openingReader struct {
+	src       io.ReadCloser
+	aead      cipher.AEAD
+	key       *models.BlobKey
+	chunk     uint64
+	skip      int
+	remaining int64
+	buf       []byte
+	pending   []byte
+}
+
+func (r *openingReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	for len(r.pending) == 0 {
+		plainLen := r.key.Size - int64(r.chunk)*int64(r.key.ChunkSize)
+		if plainLen > int64(r.key.ChunkSize) {
+			plainLen = int64(r.key.ChunkSize)
+		}
+		sealed := r.buf[:int(plainLen)+r.aead.Overhead()]
+		if _, err := io.ReadFull(r.src, sealed); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		plain, err := r.aead.Open(sealed[:0], chunkNonce(r.key.NoncePrefix, r.chunk), sealed, nil)
+		if err != nil {
+			return 0, errBlobTampered
+		}
+		r.chunk++
+		r.pending = plain[r.skip:]
+		r.skip = 0
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func (r *openingReader) Close() error {
+	return r.src.Close()
+}
+
+// openSealedBlob opens length plaintext bytes of the encrypted content ref
+// points at, from offset start, reading only the chunks holding them. A
+// negative length reads to the end. Its data key is unwrapped with keys.
+func openSealedBlob(ctx context.Context, db *mongo.Database, keys KeyWrapper, storage Storage, ref models.BlobRef, start int64, length int64) (io.ReadCloser, error) {
+	if keys == nil {
+		return nil, contentKeyError(errContentKeysMissing)
+	}
+
+	var key models.BlobKey
+	if err := db.Collection(BlobKeyCollection).FindOne(ctx, bson.M{"_id": ref.BlobId}).Decode(&key); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, contentKeyError(errBlobKeyNotFound)
+		}
+		return nil, err
+	}
+	if key.Scheme != encryptionScheme || key.ChunkSize <= 0 {
+		return nil, contentKeyError(fmt.Errorf("unsupported encryption scheme %q", key.Scheme))
+	}
+	dataKey, err := keys.Unwrap(ctx, key.KeyId, key.WrappedKey)
+	if err != nil {
+		return nil, contentKeyError(fmt.Errorf("unwrapping data key: %w", err))
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, contentKeyError(err)
+	}
+	return openChunks(ctx, storage, blobKey(ref), &key, aead, start, length)
+}
+
+// openChunks reads the chunks of the content under storageKey that hold the
+// wanted plaintext range.
+func openChunks(ctx context.Context, storage Storage, storageKey string, key *models.BlobKey, aead cipher.AEAD, start int64, length int64) (io.ReadCloser, error) {
+	if length < 0 || start+length > key.Size {
+		length = key.Size - start
+	}
+	if start >= key.Size || length <= 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	chunkSize := int64(key.ChunkSize)
+	sealedChunk := chunkSize + int64(aead.Overhead())
+	first := start / chunkSize
+	last := (start + length - 1) / chunkSize
+	sealedSize := key.Size + ((key.Size+chunkSize-1)/chunkSize)*int64(aead.Overhead())
+	sealedStart := first * sealedChunk
+	sealedEnd := (last + 1) * sealedChunk
+	if sealedEnd > sealedSize {
+		sealedEnd = sealedSize
+	}
+
+	var src io.ReadCloser
+	var err error
+	if ranged, ok := storage.(rangeReader); ok && (sealedStart > 0 || sealedEnd < sealedSize) {
+		src, err = ranged.GetRange(ctx, storageKey, sealedStart, sealedEnd-sealedStart)
+	} else {
+		src, err = storage.Get(ctx, storageKey)
+		if err == nil {
+			if err = skipBytes(src, sealedStart); err != nil {
+				src.Close()
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &openingReader{
+		src:       src,
+		aead:      aead,
+		key:       key,
+		chunk:     uint64(first),
+		skip:      int(start - first*chunkSize),
+		remaining: length,
+		buf:       make([]byte, sealedChunk),
+	}, nil
+}
+
+// saveBlobKey records the data key of content stored under id.
+func saveBlobKey(ctx context.Context, db *mongo.Database, key models.BlobKey) error {
+	key.CreatedAt = time.Now()
+	_, err := db.Collection(BlobKeyCollection).InsertOne(ctx, key)
+	return err
+}
+
+// copyBlobKey records the data key of from for a byte-for-byte copy of its
+// content stored as to.
+func copyBlobKey(ctx context.Context, db *mongo.Database, from models.BlobRef, to models.BlobRef) error {
+	var key models.BlobKey
+	if err := db.Collection(BlobKeyCollection).FindOne(ctx, bson.M{"_id": from.BlobId}).Decode(&key); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errBlobKeyNotFound
+		}
+		return err
+	}
+	key.Id = to.BlobId
+	return saveBlobKey(ctx, db, key)
+}
+
+// rewrapSummary reports what a rewrap run did.
+type rewrapSummary struct {
+	KeyId     string `json:"keyId"`
+	Rewrapped int    `json:"rewrapped"`
+	Failed    int    `json:"failed"`
+}
+
+// rewrapBlobKeys wraps every data key under a master key other than the
+// current one of keys with the current one, in batches, while holding the
+// rewrap lock. Content is not re-encrypted. Keys that can't be unwrapped are
+// counted and left as they are.
+func rewrapBlobKeys(ctx context.Context, db *mongo.Database, keys KeyWrapper) (rewrapSummary, error) {
+	summary := rewrapSummary{KeyId: keys.KeyId()}
+
+	acquired, err := acquireLock(ctx, db, keyRewrapLock, time.Hour)
+	if err != nil {
+		return summary, err
+	}
+	if !acquired {
+		return summary, errRewrapRunning
+	}
+	defer func() {
+		if err := releaseLock(context.Background(), db, keyRewrapLock); err != nil {
+			log.Printf("releasing key rewrap lock failed: %v", err)
+		}
+	}()
+
+	collection := db.Collection(BlobKeyCollection)
+	filter := bson.M{"keyId": bson.M{"$ne": summary.KeyId}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(TrashPurgeBatch)
+
+	for ctx.Err() == nil {
+		var batch []models.BlobKey
+		if err := findAll(ctx, collection, filter, &batch, opts); err != nil {
+			return summary, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, key := range batch {
+			dataKey, err := keys.Unwrap(ctx, key.KeyId, key.WrappedKey)
+			if err == nil {
+				var wrapped []byte
+				if wrapped, err = keys.Wrap(ctx, dataKey); err == nil {
+					now := time.Now()
+					update := bson.M{"$set": bson.M{"keyId": summary.KeyId, "wrappedKey": wrapped, "rewrappedAt": now}}
+					_, err = collection.UpdateOne(ctx, bson.M{"_id": key.Id, "keyId": key.KeyId}, update)
+				}
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return summary, ctx.Err()
+				}
+				log.Printf("rewrapping data key of blob %s failed: %v", key.Id.Hex(), err)
+				summary.Failed++
+				continue
+			}
+			summary.Rewrapped++
+		}
+
+		// Keys that failed stay behind, so the next batch starts after them.
+		filter["_id"] = bson.M{"$gt": batch[len(batch)-1].Id}
+		if len(batch) < TrashPurgeBatch {
+			break
+		}
+	}
+
+	log.Printf("key rewrap: rewrapped %d data keys under %s, %d failed", summary.Rewrapped, summary.KeyId, summary.Failed)
+	return summary, ctx.Err()
+}
+
+// RewrapKeys handler wraps the data keys of encrypted content with the
+// current master key, so older master keys can be retired.
+func (ac *AdminController) RewrapKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		keys := ac.cfg.Storage.Encryption.keyWrapper()
+		if keys == nil {
+			respondError(c, conflict(errEncryptionDisabled.Error()))
+			return
+		}
+
+		summary, err := rewrapBlobKeys(ctx, ac.db, keys)
+		if err == errRewrapRunning {
+			respondError(c, conflict(err.Error()))
+			return
+		}
+		if err != nil {
+			respondError(c, internalError("rewrap stopped, the summary lists the keys rewrapped so far", err).withDetails(gin.H{"summary": summary}))
+			return
+		}
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,51 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenSealedBlobWithoutKeysIsGeneric(t *testing.T) {
+	_, err := openSealedBlob(context.Background(), nil, nil, nil, models.BlobRef{}, 0, -1)
+	apiErr := toAPIError(err)
+	if apiErr.Status != http.StatusInternalServerError || apiErr.Message != "stored content could not be decrypted" {
+		t.Errorf("got %d %q, want the generic decrypt error", apiErr.Status, apiErr.Message)
+	}
+	if !errors.Is(err, errContentKeysMissing) {
+		t.Errorf("the cause %v should still be logged", err)
+	}
+}
+
+func TestNewSealingReaderHidesWrapFailure(t *testing.T) {
+	keys := &masterKeyring{current: "missing", keys: map[string][]byte{}}
+	_, err := newSealingReader(context.Background(), keys, strings.NewReader("content"))
+	if err == nil {
+		t.Fatal("wrapping with a missing master key succeeded")
+	}
+	if msg := toAPIError(err).Message; strings.Contains(msg, "missing") || strings.Contains(msg, "key") {
+		t.Errorf("message %q reveals the cause", msg)
+	}
+}
+
+func TestMasterKeyringUnwrapDetectsTampering(t *testing.T) {
+	keyring := &masterKeyring{current: "k1", keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	dataKey := bytes.Repeat([]byte{7}, 32)
+
+	wrapped, err := keyring.Wrap(context.Background(), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := keyring.Unwrap(context.Background(), "k1", wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("Unwrap = %x, %v", got, err)
+	}
+
+	wrapped[len(wrapped)-1] ^= 1
+	if _, err := keyring.Unwrap(context.Background(), "k1", wrapped); err != errBlobTampered {
+		t.Errorf("Unwrap of a tampered key = %v, want errBlobTampered", err)
+	}
+}
@@ -0,0 +1,424 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Event types pushed to clients connected to GET /ws. File scans are also
+// kept as notifications, see notify.
+const (
+	EventUploadProgress = "upload.progress"
+	EventFileScanned    = "file.scanned"
+	EventThumbnailReady = "thumbnail.ready"
+	EventShareAccessed  = "share.accessed"
+	EventZipProgress    = "zip.progress"
+)
+
+const (
+	// eventBuffer is how many events a connection may fall behind by before
+	// it is dropped, so a slow client never holds up whoever publishes.
+	eventBuffer = 64
+	// eventWriteTimeout bounds sending one message to a client.
+	eventWriteTimeout = 10 * time.Second
+	// eventPingInterval is how often idle connections are pinged; one that
+	// hasn't answered within eventPongTimeout is closed.
+	eventPingInterval = 30 * time.Second
+	eventPongTimeout  = eventPingInterval + 10*time.Second
+	// eventAuthTimeout is how long a client connecting without ?token= has
+	// to send it as its first message.
+	eventAuthTimeout = 10 * time.Second
+	// eventReadLimit caps the messages clients send, which are only the
+	// token and pongs.
+	eventReadLimit = 4096
+	// eventRecheckInterval is how often a connection checks that its account
+	// and session are still usable, catching suspensions and logouts made
+	// on other instances.
+	eventRecheckInterval = time.Minute
+
+	// closeUnauthorized is the close code for a connection that didn't
+	// authenticate, or whose token expired or whose session or account
+	// ended, in the range RFC 6455 leaves to applications.
+	closeUnauthorized = 4401
+)
+
+var errEventAuth = errors.New("send {\"token\": \"<access token>\"} as the first message or pass ?token=")
+var errShuttingDown = errors.New("server is shutting down")
+
+// event is the JSON message pushed to clients. Notifications carry the id
+// they are stored under; other events are not kept and have none.
+type event struct {
+	Id   string    `json:"id,omitempty"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data gin.H     `json:"data"`
+}
+
+// eventMessage is an event ready to send.
+type eventMessage struct {
+	id        string
+	eventType string
+	json      []byte
+}
+
+// eventSub is one client connection subscribed to a user's events, a
+// WebSocket or an event stream, opened with a token for sessionId. Events
+// queue in send for the connection's writer; done is closed to make it hang
+// up.
+type eventSub struct {
+	userId            primitive.ObjectID
+	sessionId         primitive.ObjectID
+	notificationsOnly bool
+	send              chan eventMessage
+	done              chan struct{}
+	once              sync.Once
+	reason            int
+}
+
+// close makes the writer hang up with the given WebSocket close code. Only
+// the first call counts.
+func (s *eventSub) close(code int) {
+	s.once.Do(func() {
+		s.reason = code
+		close(s.done)
+	})
+}
+
+// eventHub tracks the connections of each user on this instance. Events only
+// reach clients connected to the instance that publishes them.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[primitive.ObjectID]map[*eventSub]bool
+	closed bool
+}
+
+var events = &eventHub{subs: map[primitive.ObjectID]map[*eventSub]bool{}}
+
+// publishEvent pushes an event to every connection of userId without
+// waiting for any of them. Connections too far behind are dropped.
+func publishEvent(userId primitive.ObjectID, eventType string, data gin.H) {
+	events.publish(userId, event{Type: eventType, Time: time.Now(), Data: data})
+}
+
+func (h *eventHub) publish(userId primitive.ObjectID, e event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.subs[userId]
+	if len(subs) == 0 {
+		return
+	}
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	message := eventMessage{id: e.Id, eventType: e.Type, json: encoded}
+
+	for sub := range subs {
+		if sub.notificationsOnly && e.Id == "" {
+			continue
+		}
+		select {
+		case sub.send <- message:
+		default:
+			delete(subs, sub)
+			sub.close(websocket.ClosePolicyViolation)
+		}
+	}
+	if len(subs) == 0 {
+		delete(h.subs, userId)
+	}
+}
+
+// subscribe adds a connection for userId opened with sessionId, zero for API
+// keys, or returns nil once the hub has shut down.
+func (h *eventHub) subscribe(userId, sessionId primitive.ObjectID, notificationsOnly bool) *eventSub {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+	sub := &eventSub{
+		userId:            userId,
+		sessionId:         sessionId,
+		notificationsOnly: notificationsOnly,
+		send:              make(chan eventMessage, eventBuffer),
+		done:              make(chan struct{}),
+	}
+	if h.subs[userId] == nil {
+		h.subs[userId] = map[*eventSub]bool{}
+	}
+	h.subs[userId][sub] = true
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if subs := h.subs[sub.userId]; subs[sub] {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(h.subs, sub.userId)
+		}
+	}
+}
+
+// disconnect hangs up on the connections of userId opened with sessionId,
+// or on all of them when sessionId is zero, once the session or the account
+// can no longer be used.
+func (h *eventHub) disconnect(userId, sessionId primitive.ObjectID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.subs[userId]
+	for sub := range subs {
+		if sessionId.IsZero() || sub.sessionId == sessionId {
+			delete(subs, sub)
+			sub.close(closeUnauthorized)
+		}
+	}
+	if len(subs) == 0 {
+		delete(h.subs, userId)
+	}
+}
+
+// shutdown hangs up on every client, telling them to reconnect elsewhere,
+// and refuses new connections.
+func (h *eventHub) shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			sub.close(websocket.CloseGoingAway)
+		}
+	}
+	h.subs = map[primitive.ObjectID]map[*eventSub]bool{}
+}
+
+// writeEvents sends sub's events and pings over ws until sub is closed, then
+// hangs up.
+func writeEvents(sub *eventSub, ws *websocket.Conn) {
+	ticker := time.NewTicker(eventPingInterval)
+	defer ticker.Stop()
+	defer ws.Close()
+
+	for {
+		select {
+		case message := <-sub.send:
+			ws.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			if err := ws.WriteMessage(websocket.TextMessage, message.json); err != nil {
+				sub.close(websocket.CloseAbnormalClosure)
+				return
+			}
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout)); err != nil {
+				sub.close(websocket.CloseAbnormalClosure)
+				return
+			}
+		case <-sub.done:
+			if sub.reason != websocket.CloseAbnormalClosure {
+				closing := websocket.FormatCloseMessage(sub.reason, "")
+				_ = ws.WriteControl(websocket.CloseMessage, closing, time.Now().Add(eventWriteTimeout))
+			}
+			return
+		}
+	}
+}
+
+// watchSession hangs up on sub when the token it was opened with expires at
+// expiresAt, or when a check every eventRecheckInterval finds its account
+// suspended or deleted or its session ended. Failed checks keep the
+// connection; the next one tries again.
+func watchSession(db *mongo.Database, sub *eventSub, expiresAt time.Time) {
+	expiry := time.NewTimer(time.Until(expiresAt))
+	defer expiry.Stop()
+	recheck := time.NewTicker(eventRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-expiry.C:
+			sub.close(closeUnauthorized)
+			return
+		case <-recheck.C:
+			switch checkEventSub(db, sub) {
+			case errAccountDeleted, errAccountSuspended, errSessionEnded:
+				sub.close(closeUnauthorized)
+				return
+			}
+		}
+	}
+}
+
+// checkEventSub fails like checkActiveAccount and checkSession once sub's
+// account or session can no longer be used.
+func checkEventSub(db *mongo.Database, sub *eventSub) error {
+	ctx, cancel := context.WithTimeout(context.Background(), eventWriteTimeout)
+	defer cancel()
+
+	if err := checkActiveAccount(ctx, db, sub.userId); err != nil {
+		return err
+	}
+	if sub.sessionId.IsZero() {
+		return nil
+	}
+	return checkSession(ctx, db, sub.sessionId)
+}
+
+// readUntilClosed reads from ws until the client goes away, answering pings
+// and keeping the read deadline moving with each pong. Clients have nothing
+// else to say.
+func readUntilClosed(sub *eventSub, ws *websocket.Conn) {
+	ws.SetReadDeadline(time.Now().Add(eventPongTimeout))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(eventPongTimeout))
+	})
+	for {
+		if _, _, err := ws.NextReader(); err != nil {
+			sub.close(websocket.CloseAbnormalClosure)
+			return
+		}
+	}
+}
+
+// newEventUpgrader accepts connections from the API's own host and from the
+// origins cors allows; clients other than browsers send no Origin.
+func newEventUpgrader(cors CORSConfig) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || cors.allows(origin) {
+				return true
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
+	}
+}
+
+type EventController struct {
+	db       *mongo.Database
+	cfg      *Config
+	upgrader *websocket.Upgrader
+}
+
+func NewEventController(db *mongo.Database, cfg *Config) *EventController {
+	return &EventController{db: db, cfg: cfg, upgrader: newEventUpgrader(cfg.CORS)}
+}
+
+// SetupRouter function
+func (ec *EventController) BasicRoute(router *gin.RouterGroup) {
+	router.GET("/ws", ec.Connect())
+}
+
+// Connect handler upgrades to a WebSocket that pushes the caller's events
+// as JSON until either side hangs up. Browsers can't set headers on it, so
+// the access token is passed as ?token= or in the first message. The
+// connection is closed with closeUnauthorized when that token expires or its
+// session or account ends; clients reconnect with a fresh token.
+func (ec *EventController) Connect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var token eventToken
+		if raw := c.Query("token"); raw != "" {
+			parsed, err := parseEventToken(ec.cfg.JWTSecret, raw)
+			if err != nil {
+				respondError(c, unauthorized(err.Error()))
+				return
+			}
+			if !requireActiveAccount(c, ec.db, parsed.userId) {
+				return
+			}
+			token = parsed
+		}
+
+		// Upgrade answers failed handshakes itself.
+		ws, err := ec.upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		ws.SetReadLimit(eventReadLimit)
+
+		if token.userId.IsZero() {
+			if token, err = authenticateEventConn(ctx, ec.db, ec.cfg.JWTSecret, ws); err != nil {
+				closing := websocket.FormatCloseMessage(closeUnauthorized, err.Error())
+				_ = ws.WriteControl(websocket.CloseMessage, closing, time.Now().Add(eventWriteTimeout))
+				ws.Close()
+				return
+			}
+		}
+
+		sub := events.subscribe(token.userId, token.sessionId, false)
+		if sub == nil {
+			closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, errShuttingDown.Error())
+			_ = ws.WriteControl(websocket.CloseMessage, closing, time.Now().Add(eventWriteTimeout))
+			ws.Close()
+			return
+		}
+		defer events.unsubscribe(sub)
+
+		go writeEvents(sub, ws)
+		go watchSession(ec.db, sub, token.expiresAt)
+		readUntilClosed(sub, ws)
+	}
+}
+
+// authenticateEventConn reads the access token from the first message of a
+// connection opened without one.
+func authenticateEventConn(ctx context.Context, db *mongo.Database, secret []byte, ws *websocket.Conn) (eventToken, error) {
+	ws.SetReadDeadline(time.Now().Add(eventAuthTimeout))
+	var hello struct {
+		Token string `json:"token"`
+	}
+	if err := ws.ReadJSON(&hello); err != nil || hello.Token == "" {
+		return eventToken{}, errEventAuth
+	}
+
+	token, err := parseEventToken(secret, hello.Token)
+	if err != nil {
+		return eventToken{}, err
+	}
+	if err := checkActiveAccount(ctx, db, token.userId); err != nil {
+		return eventToken{}, err
+	}
+	return token, nil
+}
+
+// eventToken is what a connection needs to know of the access token it was
+// opened with.
+type eventToken struct {
+	userId    primitive.ObjectID
+	sessionId primitive.ObjectID
+	expiresAt time.Time
+}
+
+// parseEventToken returns the user, session and expiry of an access token.
+func parseEventToken(secret []byte, raw string) (eventToken, error) {
+	claims, err := parseAccessToken(secret, raw)
+	if err != nil {
+		return eventToken{}, errors.New("invalid or expired token")
+	}
+	userId, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return eventToken{}, errors.New("invalid token subject")
+	}
+	return eventToken{userId: userId, sessionId: claims.session(), expiresAt: claims.ExpiresAt.Time}, nil
+}
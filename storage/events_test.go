@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestHub() *eventHub {
+	return &eventHub{subs: map[primitive.ObjectID]map[*eventSub]bool{}}
+}
+
+func isClosed(sub *eventSub) bool {
+	select {
+	case <-sub.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestEventHubDisconnectSession(t *testing.T) {
+	hub := newTestHub()
+	userId, other := primitive.NewObjectID(), primitive.NewObjectID()
+	loggedOut, kept := primitive.NewObjectID(), primitive.NewObjectID()
+
+	a := hub.subscribe(userId, loggedOut, false)
+	b := hub.subscribe(userId, kept, false)
+	c := hub.subscribe(other, loggedOut, false)
+
+	hub.disconnect(userId, loggedOut)
+
+	if !isClosed(a) || a.reason != closeUnauthorized {
+		t.Errorf("connection of the ended session: closed %v, reason %d", isClosed(a), a.reason)
+	}
+	if isClosed(b) || isClosed(c) {
+		t.Error("connections of other sessions or users were closed")
+	}
+	if hub.subs[userId][a] {
+		t.Error("closed connection is still subscribed")
+	}
+}
+
+func TestEventHubDisconnectUser(t *testing.T) {
+	hub := newTestHub()
+	userId := primitive.NewObjectID()
+	a := hub.subscribe(userId, primitive.NewObjectID(), false)
+	b := hub.subscribe(userId, primitive.NilObjectID, true)
+
+	hub.disconnect(userId, primitive.NilObjectID)
+
+	if !isClosed(a) || !isClosed(b) {
+		t.Error("suspending an account should close all of its connections")
+	}
+	if _, ok := hub.subs[userId]; ok {
+		t.Error("user still has subscriptions")
+	}
+}
+
+func TestWatchSessionClosesOnExpiry(t *testing.T) {
+	sub := newTestHub().subscribe(primitive.NewObjectID(), primitive.NilObjectID, false)
+
+	done := make(chan struct{})
+	go func() {
+		watchSession(nil, sub, time.Now().Add(10*time.Millisecond))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connection outlived its token")
+	}
+	if sub.reason != closeUnauthorized {
+		t.Errorf("reason = %d, want %d", sub.reason, closeUnauthorized)
+	}
+}
+
+func TestConnectRejectsBadTokenWithAPIError(t *testing.T) {
+	router := gin.New()
+	NewEventController(nil, testConfig()).BasicRoute(router.Group(""))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws?token=not-a-token", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	if body := decodeError(t, rec); body.Error.Code != ErrCodeUnauthorized {
+		t.Errorf("code = %q, want %q", body.Error.Code, ErrCodeUnauthorized)
+	}
+}
@@ -0,0 +1,210 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportBatchSize is how many documents an export fetches from Mongo, and
+// writes out, at a time.
+const ExportBatchSize = 1000
+
+var userExportHeader = []string{
+	"id", "name", "email", "role", "status", "emailVerified", "twoFactorEnabled", "quotaBytes", "usedBytes", "createdAt",
+}
+
+var fileExportHeader = []string{
+	"id", "name", "ownerId", "ownerEmail", "folderId", "contentType", "size", "version", "checksum", "tags", "createdAt", "updatedAt", "deletedAt",
+}
+
+// exportFilename names an export after what it holds and today's UTC date.
+func exportFilename(kind string) string {
+	return kind + "-" + time.Now().UTC().Format("2006-01-02") + ".csv"
+}
+
+// formatExportTime renders t for a CSV cell; a nil time is an empty cell.
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// streamCSV writes header and then one record per document of cursor
+// straight to the response. Records are flushed to the client whenever the
+// cursor's current batch is used up, so no more than one batch is held in
+// memory. Once the response has started, errors can only be logged.
+func streamCSV(ctx context.Context, c *gin.Context, cursor *mongo.Cursor, filename string, header []string, record func(*mongo.Cursor) ([]string, error)) {
+	defer cursor.Close(ctx)
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", contentDisposition("attachment", filename))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	flush := func() bool {
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			requestLog(c).Error("export failed", "filename", filename, "error", err)
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	if err := writer.Write(header); err != nil {
+		requestLog(c).Error("export failed", "filename", filename, "error", err)
+		return
+	}
+
+	for cursor.Next(ctx) {
+		row, err := record(cursor)
+		if err != nil {
+			requestLog(c).Error("export failed", "filename", filename, "error", err)
+			return
+		}
+		if err := writer.Write(row); err != nil {
+			requestLog(c).Error("export failed", "filename", filename, "error", err)
+			return
+		}
+		if cursor.RemainingBatchLength() == 0 && !flush() {
+			return
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		requestLog(c).Error("export failed", "filename", filename, "error", err)
+	}
+	flush()
+}
+
+// ExportUsers handler downloads every account matching the GetUsers filters
+// as CSV, oldest first.
+func (ac *AdminController) ExportUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := ac.db.Collection(UserCollection)
+
+		filter, err := userListFilter(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetBatchSize(ExportBatchSize).
+			SetProjection(bson.M{
+				"name": 1, "email": 1, "role": 1, "status": 1, "emailVerified": 1,
+				"twoFactorEnabled": 1, "quotaBytes": 1, "usedBytes": 1, "createdAt": 1, "deletedAt": 1,
+			})
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		streamCSV(ctx, c, cursor, exportFilename("users"), userExportHeader, func(cursor *mongo.Cursor) ([]string, error) {
+			var user models.User
+			if err := cursor.Decode(&user); err != nil {
+				return nil, err
+			}
+
+			status := user.Status
+			if user.IsDeleted() {
+				status = "deleted"
+			} else if status == "" {
+				status = models.UserStatusActive
+			}
+			quota := ""
+			if user.QuotaBytes != nil {
+				quota = strconv.FormatInt(*user.QuotaBytes, 10)
+			}
+
+			return []string{
+				user.Id.Hex(),
+				user.Name,
+				user.Email,
+				user.Role,
+				status,
+				strconv.FormatBool(user.IsVerified()),
+				strconv.FormatBool(user.TwoFactorEnabled),
+				quota,
+				strconv.FormatInt(user.UsedBytes, 10),
+				formatExportTime(&user.CreatedAt),
+			}, nil
+		})
+	}
+}
+
+// ExportFiles handler downloads the metadata of every file matching the
+// GetAllFiles filters as CSV, oldest first. Tags are joined with ";".
+func (ac *AdminController) ExportFiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := ac.db.Collection(FileCollection)
+
+		filter, err := adminFileFilter(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+			{{Key: "$project", Value: bson.M{"versions": 0, "extractedText": 0}}},
+		}
+		pipeline = append(pipeline, fileOwnerStages...)
+
+		cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(ExportBatchSize))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		streamCSV(ctx, c, cursor, exportFilename("files"), fileExportHeader, func(cursor *mongo.Cursor) ([]string, error) {
+			var file adminFileItem
+			if err := cursor.Decode(&file); err != nil {
+				return nil, err
+			}
+
+			ownerEmail := ""
+			if file.Owner != nil {
+				ownerEmail = file.Owner.Email
+			}
+			folderId := ""
+			if file.FolderId != nil {
+				folderId = file.FolderId.Hex()
+			}
+
+			return []string{
+				file.Id.Hex(),
+				file.Name,
+				file.OwnerId.Hex(),
+				ownerEmail,
+				folderId,
+				file.ContentType,
+				strconv.FormatInt(file.Size, 10),
+				strconv.Itoa(file.CurrentVersion().N),
+				file.Checksum,
+				strings.Join(file.Tags, ";"),
+				formatExportTime(&file.CreatedAt),
+				formatExportTime(file.UpdatedAt),
+				formatExportTime(file.DeletedAt),
+			}, nil
+		})
+	}
+}
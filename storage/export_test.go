@@ -0,0 +1,153 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// flushRecorder notes how much of the body had been written at each flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushedAt = append(r.flushedAt, r.Body.Len())
+	r.ResponseRecorder.Flush()
+}
+
+func exportRouter(ac *AdminController) *gin.Engine {
+	router := gin.New()
+	router.GET("/admin/users/export", ac.ExportUsers())
+	router.GET("/admin/files/export", ac.ExportFiles())
+	return router
+}
+
+// batches answers a find of collection with docs, batchSize at a time, the
+// way a server cursor hands them out.
+func batches(collection string, docs []bson.D, batchSize int) []bson.D {
+	responses := []bson.D{}
+	for start := 0; start < len(docs); start += batchSize {
+		end := min(start+batchSize, len(docs))
+		cursorId, batch := int64(1), mtest.NextBatch
+		if end == len(docs) {
+			cursorId = 0
+		}
+		if start == 0 {
+			batch = mtest.FirstBatch
+		}
+		responses = append(responses, mtest.CreateCursorResponse(cursorId, "test."+collection, batch, docs[start:end]...))
+	}
+	return responses
+}
+
+func readCSV(t testing.TB, body []byte) [][]string {
+	t.Helper()
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	return records
+}
+
+func TestExportUsersStreamsBatches(t *testing.T) {
+	mt := newMockDB(t)
+	const total = 30*ExportBatchSize + 17
+
+	mt.Run("tens of thousands", func(mt *mtest.T) {
+		created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		docs := make([]bson.D, total)
+		for i := range docs {
+			docs[i] = bsonDoc(mt, models.User{Id: primitive.NewObjectID(), Name: "user " + strconv.Itoa(i), Email: "u" + strconv.Itoa(i) + "@example.com", Role: models.RoleUser, CreatedAt: created})
+		}
+		mt.AddMockResponses(batches(UserCollection, docs, ExportBatchSize)...)
+
+		rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		exportRouter(NewAdminController(mt.DB, testConfig())).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/users/export?role=user", nil))
+
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if want := contentDisposition("attachment", exportFilename("users")); rec.Header().Get("Content-Disposition") != want {
+			mt.Errorf("Content-Disposition = %q, want %q", rec.Header().Get("Content-Disposition"), want)
+		}
+		records := readCSV(mt, rec.Body.Bytes())
+		if len(records) != total+1 || records[total][1] != "user "+strconv.Itoa(total-1) {
+			mt.Fatalf("got %d records ending in %v, want the header and %d users in order", len(records), records[len(records)-1], total)
+		}
+
+		// Each batch is written out before the next is fetched.
+		if len(rec.flushedAt) < total/ExportBatchSize {
+			mt.Fatalf("flushed %d times for %d batches", len(rec.flushedAt), total/ExportBatchSize+1)
+		}
+		body, previous := rec.Body.Bytes(), 0
+		for _, at := range rec.flushedAt {
+			if rows := bytes.Count(body[previous:at], []byte("\n")); rows > ExportBatchSize+1 {
+				mt.Fatalf("%d rows were held back before a flush, want at most a batch", rows)
+			}
+			previous = at
+		}
+
+		cmd := sentCommand(mt, "find")
+		if cmd.Lookup("batchSize").Int32() != ExportBatchSize || cmd.Lookup("filter", "role").StringValue() != models.RoleUser {
+			mt.Errorf("find = %s, want batches of %d filtered by role", cmd, ExportBatchSize)
+		}
+	})
+
+	mt.Run("invalid filter", func(mt *mtest.T) {
+		rec := httptest.NewRecorder()
+		exportRouter(NewAdminController(mt.DB, testConfig())).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/users/export?role=owner", nil))
+		if rec.Code != http.StatusBadRequest {
+			mt.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestExportEscapesFields(t *testing.T) {
+	mt := newMockDB(t)
+	awkward := "Lovelace, \"Ada\"\nCountess"
+
+	mt.Run("users", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, models.User{Id: primitive.NewObjectID(), Name: awkward, Email: "ada@example.com", Role: models.RoleUser}))
+		rec := httptest.NewRecorder()
+		exportRouter(NewAdminController(mt.DB, testConfig())).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/users/export", nil))
+
+		records := readCSV(mt, rec.Body.Bytes())
+		if len(records) != 2 || records[1][1] != awkward {
+			mt.Errorf("records = %q, want the name back unchanged", records)
+		}
+	})
+
+	mt.Run("files", func(mt *mtest.T) {
+		owner := primitive.NewObjectID()
+		file := bsonDoc(mt, models.File{Id: primitive.NewObjectID(), OwnerId: owner, Name: awkward, Size: 42, ContentType: "text/plain", Tags: []string{"a,b", "c"}})
+		file = append(file, bson.E{Key: "owner", Value: bson.M{"_id": owner, "email": "ada@example.com"}})
+		mt.AddMockResponses(found(mt, FileCollection, file))
+		rec := httptest.NewRecorder()
+		exportRouter(NewAdminController(mt.DB, testConfig())).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/files/export?owner="+owner.Hex(), nil))
+
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		records := readCSV(mt, rec.Body.Bytes())
+		if len(records) != 2 || records[1][1] != awkward || records[1][3] != "ada@example.com" || records[1][9] != "a,b;c" {
+			mt.Errorf("records = %q, want the file with its owner and tags", records)
+		}
+
+		stages, _ := sentCommand(mt, "aggregate").Lookup("pipeline").Array().Values()
+		if matched, _ := stages[0].Document().Lookup("$match", "ownerId").ObjectIDOK(); matched != owner {
+			mt.Errorf("pipeline starts with %s, want the owner filter", stages[0])
+		}
+	})
+}
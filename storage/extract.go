@@ -0,0 +1,204 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxExtractSource bounds how much of a document is read to extract text, so
+// a huge file can't tie the worker up.
+const maxExtractSource = 32 << 20
+
+var errNoExtractor = errors.New("no text extractor for this file type")
+
+// officeDocuments maps zipped XML document types to the part holding their
+// body text.
+var officeDocuments = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": "word/document.xml",
+	"application/vnd.oasis.opendocument.text":                                 "content.xml",
+}
+
+type extractJob struct {
+	fileId primitive.ObjectID
+	blob   models.BlobRef
+	kind   string
+}
+
+var extractJobs = make(chan extractJob, thumbnailQueueSize)
+
+// enqueueContentJobs queues the background work that follows new content:
+// thumbnails for images, text extraction for searchable types and the virus
+// scan.
+func enqueueContentJobs(file *models.File) {
+	enqueueThumbnails(file)
+	enqueueTextExtraction(file)
+	enqueueScan(file)
+}
+
+// extractKind reports how text is extracted from file: "text", an office
+// document part name, or "" when it isn't searchable by content.
+func extractKind(file *models.File) string {
+	media := mediaType(file.ContentType)
+	if part, ok := officeDocuments[media]; ok {
+		return part
+	}
+	if strings.HasPrefix(media, "text/") || textContentTypes[media] || codeLanguages[strings.ToLower(path.Ext(file.Name))] != "" {
+		return "text"
+	}
+	return ""
+}
+
+// enqueueTextExtraction queues text extraction for files that don't have
+// extracted text yet.
+func enqueueTextExtraction(file *models.File) {
+	kind := extractKind(file)
+	if kind == "" || file.ExtractedText != "" {
+		return
+	}
+
+	select {
+	case extractJobs <- extractJob{fileId: file.Id, blob: file.BlobRef, kind: kind}:
+	default:
+		log.Printf("text extraction queue full, skipping file %s", file.Id.Hex())
+	}
+}
+
+// StartTextExtractor extracts queued files until ctx is cancelled.
+func StartTextExtractor(ctx context.Context, db *mongo.Database, cfg *Config) {
+	startWorker(func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case job := <-extractJobs:
+				err := extractText(ctx, db, cfg, job)
+				if ctx.Err() != nil {
+					continue
+				}
+				recordJob("text_extraction", err)
+				if err != nil {
+					log.Printf("text extraction for file %s failed: %v", job.fileId.Hex(), err)
+				}
+			}
+		}
+	})
+}
+
+// extractText stores up to limit bytes of the text of a job's content on its
+// file. The update is conditional on the BlobId so a job for replaced content
+// changes nothing.
+func extractText(ctx context.Context, db *mongo.Database, cfg *Config, job extractJob) error {
+	download, err := openBlob(ctx, db, cfg, job.blob)
+	if err != nil {
+		return err
+	}
+	defer download.Close()
+
+	var text string
+	if job.kind == "text" {
+		text, err = readPlainText(download, cfg.ExtractedTextLimit)
+	} else {
+		text, err = readOfficeText(download, job.kind, cfg.ExtractedTextLimit)
+	}
+	if err != nil {
+		return err
+	}
+
+	collection := db.Collection(FileCollection)
+	_, err = collection.UpdateOne(ctx,
+		bson.M{"_id": job.fileId, "gridfsId": job.blob.BlobId},
+		bson.M{"$set": bson.M{"extractedText": text}},
+	)
+	return err
+}
+
+// readPlainText reads up to limit bytes of text as UTF-8.
+func readPlainText(r io.Reader, limit int) (string, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return "", err
+	}
+	truncated := len(raw) > limit
+	if truncated {
+		raw = raw[:limit]
+	}
+
+	name, enc := detectTextEncoding(raw, "", truncated)
+	if enc == nil {
+		return "", errNoExtractor
+	}
+	text, err := decodePreview(raw, name, enc, truncated)
+	if err != nil {
+		return "", err
+	}
+	return capText(string(text), limit), nil
+}
+
+// readOfficeText collects the character data of part inside a zipped XML
+// document, with paragraphs separated by newlines, up to limit bytes.
+func readOfficeText(r io.Reader, part string, limit int) (string, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, maxExtractSource+1))
+	if err != nil {
+		return "", err
+	}
+	if len(raw) > maxExtractSource {
+		return "", errors.New("document too large to extract")
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return "", err
+	}
+	entry, err := archive.Open(part)
+	if err != nil {
+		return "", err
+	}
+	defer entry.Close()
+
+	var out strings.Builder
+	decoder := xml.NewDecoder(io.LimitReader(entry, maxExtractSource))
+	for out.Len() < limit {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			out.Write(t)
+		case xml.EndElement:
+			// w:p in .docx, text:p and text:h in .odt.
+			if t.Name.Local == "p" || t.Name.Local == "h" {
+				out.WriteByte('\n')
+			}
+		}
+	}
+	return capText(out.String(), limit), nil
+}
+
+// capText cuts text to limit bytes on a character boundary.
+func capText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	text = text[:limit]
+	for len(text) > 0 && !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
@@ -0,0 +1,930 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FileBucket is the GridFS bucket of the gridfs storage backend.
+var FileBucket string = "fs"
+
+// UploadFormField is the multipart field name carrying the file.
+const UploadFormField = "file"
+
+var errNoFilePart = errors.New("multipart form has no \"" + UploadFormField + "\" part")
+
+type FileController struct {
+	db  *mongo.Database
+	cfg *Config
+
+	// images caches resized images within cfg.Images.CacheBytes.
+	images *lruCache
+}
+
+func NewFileController(db *mongo.Database, cfg *Config) *FileController {
+	return &FileController{db: db, cfg: cfg, images: newLRUCache(cfg.Images.CacheBytes)}
+}
+
+// SetupRouter function
+func (fc *FileController) BasicRoute(router *gin.RouterGroup) {
+	limits := fc.cfg.RateLimits
+	fileRouter := router.Group("/files", AuthRequired(fc.db, fc.cfg), RequireScope(fc.cfg, scopeFiles))
+	fileRouter.GET("/", fc.GetFiles())
+	uploadRate := RateLimit(fc.db, limits.Store, "upload", limits.Upload, byUser)
+	downloadRate := RateLimit(fc.db, limits.Store, "download", limits.Download, byUser)
+
+	fileRouter.POST("/", uploadRate, RequireVerified(fc.db), fc.UploadFile())
+	fileRouter.GET("/shared-with-me", fc.GetSharedWithMe())
+	fileRouter.GET("/trash", fc.GetTrash())
+	fileRouter.GET("/search", fc.SearchFiles())
+	fileRouter.GET("/starred", fc.GetStarred())
+	fileRouter.GET("/recent", fc.GetRecent())
+	fileRouter.POST("/download-zip", downloadRate, fc.DownloadZip())
+	fileRouter.POST("/batch-delete", fc.BatchDeleteFiles())
+	fileRouter.POST("/batch-move", fc.BatchMoveFiles())
+	fileRouter.GET("/:id", fc.GetFile())
+	fileRouter.PATCH("/:id", fc.UpdateFile())
+	fileRouter.GET("/:id/download-url", fc.GetDownloadURL())
+	fileRouter.POST("/:id/copy", fc.CopyFile())
+	fileRouter.GET("/:id/verify", fc.VerifyFile())
+	fileRouter.GET("/:id/thumbnail", fc.GetThumbnail())
+	fileRouter.GET("/:id/image", fc.GetResizedImage())
+	fileRouter.GET("/:id/preview", fc.GetPreview())
+	fileRouter.DELETE("/:id", fc.DeleteFile())
+	fileRouter.POST("/:id/restore", fc.RestoreFile())
+
+	fileRouter.GET("/:id/versions", fc.GetVersions())
+	fileRouter.GET("/:id/versions/:n/download", downloadRate, fc.DownloadVersion())
+	fileRouter.POST("/:id/versions/:n/restore", fc.RestoreVersion())
+
+	fileRouter.POST("/:id/star", fc.StarFile())
+	fileRouter.DELETE("/:id/star", fc.UnstarFile())
+	fileRouter.POST("/:id/tags", fc.AddTags())
+	fileRouter.DELETE("/:id/tags/:tag", fc.RemoveTag())
+
+	fileRouter.GET("/:id/comments", fc.GetComments())
+	fileRouter.POST("/:id/comments", fc.CreateComment())
+	fileRouter.PATCH("/:id/comments/:commentId", fc.UpdateComment())
+	fileRouter.DELETE("/:id/comments/:commentId", fc.DeleteComment())
+
+	fileRouter.GET("/:id/permissions", fc.GetPermissions())
+	fileRouter.POST("/:id/permissions", fc.GrantPermission())
+	fileRouter.DELETE("/:id/permissions/:userId", fc.RevokePermission())
+
+	fileRouter.POST("/upload-url", uploadRate, RequireVerified(fc.db), fc.CreateDirectUpload())
+	fileRouter.POST("/:id/confirm", uploadRate, RequireVerified(fc.db), fc.ConfirmDirectUpload())
+
+	fileRouter.POST("/uploads", uploadRate, RequireVerified(fc.db), fc.CreateUpload())
+	fileRouter.GET("/uploads/:id", fc.GetUpload())
+	fileRouter.PUT("/uploads/:id/chunks/:n", uploadRate, fc.PutUploadChunk())
+	fileRouter.POST("/uploads/:id/complete", uploadRate, RequireVerified(fc.db), fc.CompleteUpload())
+
+	// Links from GET /files/:id/download-url authenticate with ?token= instead
+	// of a bearer token or API key.
+	router.GET("/files/:id/download", DownloadTokenAuth(fc.db, fc.cfg), AuthRequired(fc.db, fc.cfg), RequireScope(fc.cfg, scopeFiles), downloadRate, fc.DownloadFile())
+
+	tagRouter := router.Group("/tags", AuthRequired(fc.db, fc.cfg), RequireScope(fc.cfg, scopeFiles))
+	tagRouter.GET("/", fc.GetTags())
+}
+
+// fileBucket opens the GridFS bucket of the gridfs storage backend.
+func fileBucket(db *mongo.Database) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(FileBucket))
+}
+
+// fileListItem is a file as returned by list endpoints.
+type fileListItem struct {
+	models.File `bson:",inline"`
+	Shared      bool `json:"shared" bson:"-"`
+	Starred     bool `json:"starred" bson:"-"`
+}
+
+// GetFiles handler
+func (fc *FileController) GetFiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FileCollection)
+
+		sortDoc, err := parseSortOptions(c, fileSortFields, "createdAt", "desc")
+		if err != nil {
+			respondError(c, invalidRequest(err).withDetails(gin.H{"allowedSortFields": allowedFieldNames(fileSortFields)}))
+			return
+		}
+
+		page, err := parseCursorPage(c, fc.cfg.JWTSecret, sortDoc)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter, err := fileListFilter(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		// The total counts the whole listing, not what is left after the
+		// cursor.
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		cursor, err := collection.Find(ctx, page.Filter(filter), page.FindOptions())
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		files := []fileListItem{}
+		if err = cursor.All(ctx, &files); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		result, err := cursorResult(page, files, total)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		files = result.Items.([]fileListItem)
+
+		now := time.Now()
+		for i := range files {
+			files[i].ExpiresIn = expiresIn(files[i].ExpiresAt, now)
+		}
+
+		if err := fc.markShared(ctx, files); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if err := fc.markStarred(ctx, c, files); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// fileListFilter builds the GetFiles query from the caller and the optional
+// name, contentType, minSize/maxSize, from/to, tag/tagMode and (admin only)
+// owner params.
+func fileListFilter(c *gin.Context) (bson.M, error) {
+	filter := bson.M{"ownerId": currentUserID(c), "deletedAt": notTrashed, "expiresAt": notExpired()}
+
+	if owner := c.Query("owner"); owner != "" {
+		if !isAdmin(c) {
+			return nil, errors.New("only admins can filter by owner")
+		}
+		ownerId, err := primitive.ObjectIDFromHex(owner)
+		if err != nil {
+			return nil, errors.New("invalid owner ID")
+		}
+		filter["ownerId"] = ownerId
+	}
+
+	if name := c.Query("name"); name != "" {
+		filter["name"] = bson.M{"$regex": regexp.QuoteMeta(name), "$options": "i"}
+	}
+
+	if contentType := c.Query("contentType"); contentType != "" {
+		filter["contentType"] = contentType
+	}
+
+	if err := rangeFilters(c, filter); err != nil {
+		return nil, err
+	}
+
+	if err := tagFilter(c, filter); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// rangeFilters adds the ?minSize=/?maxSize= and ?from=/?to= (RFC 3339
+// creation time) conditions to filter.
+func rangeFilters(c *gin.Context, filter bson.M) error {
+	size := bson.M{}
+	for param, op := range map[string]string{"minSize": "$gte", "maxSize": "$lte"} {
+		if raw := c.Query(param); raw != "" {
+			value, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || value < 0 {
+				return fmt.Errorf("%s must be a non-negative integer", param)
+			}
+			size[op] = value
+		}
+	}
+	if len(size) > 0 {
+		filter["size"] = size
+	}
+
+	return createdAtFilter(c, filter)
+}
+
+// createdAtFilter adds the ?from=/?to= (RFC 3339 creation time) conditions
+// to filter.
+func createdAtFilter(c *gin.Context, filter bson.M) error {
+	created := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if raw := c.Query(param); raw != "" {
+			value, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			created[op] = value
+		}
+	}
+	if len(created) > 0 {
+		filter["createdAt"] = created
+	}
+	return nil
+}
+
+// markShared sets Shared on every file that has at least one share link.
+func (fc *FileController) markShared(ctx context.Context, files []fileListItem) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(files))
+	for i, file := range files {
+		ids[i] = file.Id
+	}
+
+	shares := fc.db.Collection(ShareCollection)
+	sharedIds, err := shares.Distinct(ctx, "fileId", bson.M{"fileId": bson.M{"$in": ids}, "revokedAt": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	shared := make(map[primitive.ObjectID]bool, len(sharedIds))
+	for _, id := range sharedIds {
+		if objId, ok := id.(primitive.ObjectID); ok {
+			shared[objId] = true
+		}
+	}
+	for i := range files {
+		files[i].Shared = shared[files[i].Id]
+	}
+	return nil
+}
+
+// UploadFile handler. ?onConflict= says what happens when the name is
+// taken, as saveUploadedFile describes. With ?expiresAt= or ?ttl= a new file
+// expires then; a new version of an existing file keeps that file's expiry.
+func (fc *FileController) UploadFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		limit := fc.cfg.uploadLimit(c)
+		if c.Request.ContentLength > limit+multipartSlack {
+			respondUploadTooLarge(c, limit)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartSlack)
+
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		userId := currentUserID(c)
+		folderParam := c.Query("folderId")
+		expiresAtParam, ttlParam := c.Query("expiresAt"), c.Query("ttl")
+
+		declared, ok := declaredChecksum(c)
+		if !ok {
+			return
+		}
+
+		mode, err := parseUploadOnConflict(c, fc.cfg.UploadOnConflict)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		if c.Request.ContentLength > 0 && !checkQuota(ctx, fc.db, fc.cfg, c, userId, c.Request.ContentLength) {
+			return
+		}
+
+		var file *models.File
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			var maxBytes *http.MaxBytesError
+			if errors.As(err, &maxBytes) {
+				respondUploadTooLarge(c, limit)
+				return
+			}
+			if err != nil {
+				respondError(c, invalidRequest(err))
+				return
+			}
+
+			// Form fields must precede the file part to take effect, since
+			// the file is streamed as soon as it is reached.
+			if name := part.FormName(); (name == "folderId" || name == "expiresAt" || name == "ttl") && part.FileName() == "" {
+				value, err := io.ReadAll(io.LimitReader(part, 64))
+				part.Close()
+				if err != nil {
+					respondError(c, invalidRequest(err))
+					return
+				}
+				switch name {
+				case "folderId":
+					folderParam = strings.TrimSpace(string(value))
+				case "expiresAt":
+					expiresAtParam = strings.TrimSpace(string(value))
+				case "ttl":
+					ttlParam = strings.TrimSpace(string(value))
+				}
+				continue
+			}
+
+			if part.FormName() != UploadFormField || part.FileName() == "" {
+				part.Close()
+				continue
+			}
+
+			expiresAt, err := parseFileExpiry(expiresAtParam, ttlParam, time.Now())
+			if err != nil {
+				part.Close()
+				respondError(c, invalidRequest(err))
+				return
+			}
+
+			folderId, ok := resolveFolderParam(ctx, fc.db, c, folderParam, userId)
+			if !ok {
+				part.Close()
+				return
+			}
+
+			file, err = storeUpload(ctx, fc.db, fc.cfg, newLimitedReader(part, limit), part.FileName())
+			part.Close()
+			if err != nil {
+				respondStoreError(c, err)
+				return
+			}
+			file.FolderId = folderId
+			file.ExpiresAt = expiresAt
+			break
+		}
+
+		if file == nil {
+			respondError(c, invalidRequest(errNoFilePart))
+			return
+		}
+
+		if declared != "" && declared != file.Checksum {
+			discardBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+			respondChecksumMismatch(c, declared, file.Checksum)
+			return
+		}
+
+		file.OwnerId = userId
+
+		// The declared length only covers the request as a whole, so the
+		// quota is settled against the stored size.
+		if !reserveQuota(ctx, fc.db, fc.cfg, c, userId, file.Size) {
+			discardBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+			return
+		}
+
+		if err := dedupeUpload(ctx, fc.db, fc.cfg, file); err != nil {
+			releaseQuota(ctx, fc.db, userId, file.Size)
+			discardBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+			respondError(c, err)
+			return
+		}
+
+		saved, created, err := saveUploadedFile(ctx, fc.db, fc.cfg, file, mode)
+		if err != nil {
+			releaseQuota(ctx, fc.db, userId, file.Size)
+			deleteBlobs(ctx, fc.db, fc.cfg, []models.BlobRef{file.BlobRef})
+			respondSaveError(c, err)
+			return
+		}
+
+		enqueueContentJobs(saved)
+		audit(c, AuditFileUploaded, auditTargetFile, saved.Id, gin.H{"name": saved.Name, "size": saved.Size, "version": saved.CurrentVersion().N})
+		emitWebhookEvent(saved.OwnerId, AuditFileUploaded, fileEventData(saved))
+
+		saved.ExpiresIn = expiresIn(saved.ExpiresAt, time.Now())
+		if !created {
+			c.JSON(http.StatusOK, saved)
+			return
+		}
+		c.JSON(http.StatusCreated, saved)
+	}
+}
+
+// discardBlob deletes content that was stored for a request that then
+// failed. It is not cancelled with the request, so the blob is not orphaned
+// when the failure is the client going away.
+func discardBlob(ctx context.Context, db *mongo.Database, cfg *Config, ref models.BlobRef) {
+	_ = removeBlob(context.WithoutCancel(ctx), db, cfg, ref)
+}
+
+// storeUpload streams r into cfg.Storage.Backend while computing its size and
+// SHA-256, and returns the metadata document for it. The content type is
+// detected from the first bytes and checked before anything is written,
+// failing with an *UnsupportedTypeError if it is refused. Nothing is kept if
+// the copy fails part-way.
+func storeUpload(ctx context.Context, db *mongo.Database, cfg *Config, r io.Reader, filename string) (*models.File, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	contentType := detectContentType(head, filename)
+	if err := cfg.checkContentType(contentType); err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	counter := &byteCounter{}
+	ref, err := putBlob(ctx, db, cfg, io.TeeReader(io.MultiReader(bytes.NewReader(head), r), io.MultiWriter(hasher, counter)), filename)
+	if err != nil {
+		return nil, err
+	}
+	uploadedBytes.add(float64(counter.n))
+
+	return &models.File{
+		Id:          primitive.NewObjectID(),
+		Name:        filename,
+		Size:        counter.n,
+		ContentType: contentType,
+		Checksum:    hex.EncodeToString(hasher.Sum(nil)),
+		CreatedAt:   time.Now(),
+		BlobRef:     ref,
+		ScanResult:  newScanResult(cfg.Scan),
+	}, nil
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (b *byteCounter) Write(p []byte) (int, error) {
+	b.n += int64(len(p))
+	return len(p), nil
+}
+
+// GetFile handler
+func (fc *FileController) GetFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		recordAccess(c, file)
+		file.ExpiresIn = expiresIn(file.ExpiresAt, time.Now())
+		c.JSON(http.StatusOK, file)
+	}
+}
+
+type updateFileRequest struct {
+	Name         *string    `json:"name" binding:"omitempty,max=255"`
+	FolderId     *string    `json:"folderId" binding:"omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt" binding:"omitempty"`
+	TTL          string     `json:"ttl" binding:"omitempty"`
+	NeverExpires bool       `json:"neverExpires"`
+}
+
+// UpdateFile handler renames and/or moves a file, or changes when it
+// expires. Only the metadata changes; the stored content is left as is.
+func (fc *FileController) UpdateFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FileCollection)
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		mode, err := parseOnConflict(c, onConflictError)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		var req updateFileRequest
+		if err := decodeStrictJSON(c, &req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		expiry := shareSettings{ExpiresAt: req.ExpiresAt, TTL: req.TTL, NeverExpires: req.NeverExpires}
+		expiresAt, expiryChanged, err := expiry.expiry(time.Now())
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		if req.Name == nil && req.FolderId == nil && !expiryChanged {
+			respondError(c, badRequest("no updatable fields provided"))
+			return
+		}
+
+		// Editors may rename; moving between folders and changing the expiry
+		// is up to the owner.
+		need := accessEditor
+		if req.FolderId != nil || expiryChanged {
+			need = accessOwner
+		}
+		if !authorizeFileAccess(ctx, fc.db, c, file, need) {
+			return
+		}
+
+		name := file.Name
+		if req.Name != nil {
+			if name, err = sanitizeFileName(*req.Name); err != nil {
+				respondError(c, invalidRequest(err))
+				return
+			}
+		}
+
+		folderId := file.FolderId
+		if req.FolderId != nil {
+			if folderId, ok = resolveFolderParam(ctx, fc.db, c, *req.FolderId, file.OwnerId); !ok {
+				return
+			}
+		}
+
+		set := bson.M{"folderId": folderId}
+		update := bson.M{"$set": set}
+		switch {
+		case expiryChanged && expiresAt == nil:
+			update["$unset"] = bson.M{"expiresAt": ""}
+		case expiryChanged:
+			set["expiresAt"] = *expiresAt
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		// A file that expired since it was read can no longer be kept.
+		filter := bson.M{"_id": file.Id, "expiresAt": notExpired()}
+		var updated models.File
+		_, err = saveFileName(ctx, collection, file.OwnerId, folderId, name, mode, nil, func(candidate string) error {
+			set["name"] = candidate
+			return collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+		})
+		if err != nil {
+			respondError(c, orNotFound(orNameConflict(err), "File not found"))
+			return
+		}
+
+		updated.ExpiresIn = expiresIn(updated.ExpiresAt, time.Now())
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// DownloadFile handler. Downloads through a link from GetDownloadURL were
+// recorded when the link was issued.
+func (fc *FileController) DownloadFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		if !scanAllowsDownload(c, fc.cfg.Scan, file.ScanResult) {
+			return
+		}
+
+		if !c.GetBool("downloadToken") {
+			recordAccess(c, file)
+			audit(c, AuditFileDownloaded, auditTargetFile, file.Id, nil)
+		}
+		streamFile(c, fc.db, fc.cfg, file, "attachment")
+	}
+}
+
+// DeleteFile handler moves a file to the trash, or with ?permanent=true
+// removes it, trashed or not, together with its stored content.
+func (fc *FileController) DeleteFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		permanent := c.Query("permanent") == "true"
+
+		var file *models.File
+		var ok bool
+		if permanent {
+			file, ok = lookupFile(ctx, fc.db, c, bson.M{})
+		} else {
+			file, ok = findFile(ctx, fc.db, c)
+		}
+		if !ok {
+			return
+		}
+
+		if !authorizeFileOwner(c, file) {
+			return
+		}
+
+		if !permanent {
+			fc.trashFile(ctx, c, file)
+			return
+		}
+
+		if err := deleteFileRecords(ctx, fc.db, []models.File{*file}); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("File not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditFileDeleted, auditTargetFile, file.Id, gin.H{"name": file.Name, "permanent": true})
+		emitWebhookEvent(file.OwnerId, AuditFileDeleted, gin.H{"fileId": file.Id, "name": file.Name, "permanent": true})
+
+		if failed := purgeContent(ctx, fc.db, fc.cfg, []models.File{*file}); len(failed) > 0 {
+			respondError(c, internalError("File deleted but its stored content could not be removed", nil).withDetails(gin.H{"fileId": file.Id.Hex(), "gridfsIds": failed}))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+	}
+}
+
+// deleteBlobs drops one reference to each of refs, removing the content
+// nothing references anymore, and returns the BlobIds that could not be
+// released. Failures are logged with the id so they can be reconciled.
+func deleteBlobs(ctx context.Context, db *mongo.Database, cfg *Config, refs []models.BlobRef) []primitive.ObjectID {
+	var failed []primitive.ObjectID
+	for _, ref := range refs {
+		unreferenced, err := releaseBlob(ctx, db, ref.BlobId)
+		if err != nil {
+			log.Printf("releasing blob %s failed: %v", ref.BlobId.Hex(), err)
+			failed = append(failed, ref.BlobId)
+			continue
+		}
+		if !unreferenced {
+			continue
+		}
+
+		if err := removeBlob(ctx, db, cfg, ref); err != nil {
+			log.Printf("storage cleanup of blob %s failed: %v", ref.BlobId.Hex(), err)
+			failed = append(failed, ref.BlobId)
+		}
+	}
+	return failed
+}
+
+// purgeFiles hard-deletes the files matching filter together with their
+// shares, permissions and stored content. It returns how many blobs could not
+// be removed; those are logged by deleteBlobs for reconciliation.
+func purgeFiles(ctx context.Context, db *mongo.Database, cfg *Config, filter bson.M) (int, error) {
+	files, err := findFilesToPurge(ctx, db, filter)
+	if err != nil || len(files) == 0 {
+		return 0, err
+	}
+
+	if err := deleteFileRecords(ctx, db, files); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(purgeContent(ctx, db, cfg, files)), nil
+}
+
+// findFilesToPurge loads the fields of the files matching filter that
+// deleteFileRecords and purgeContent need.
+func findFilesToPurge(ctx context.Context, db *mongo.Database, filter bson.M) ([]models.File, error) {
+	var files []models.File
+	projection := options.Find().SetProjection(bson.M{"_id": 1, "ownerId": 1, "gridfsId": 1, "storageBackend": 1, "storageKey": 1, "encrypted": 1, "size": 1,
+		"versions.gridfsId": 1, "versions.storageBackend": 1, "versions.storageKey": 1, "versions.encrypted": 1, "versions.size": 1})
+	if err := findAll(ctx, db.Collection(FileCollection), filter, &files, projection); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// deleteFileRecords removes the metadata of files along with their shares,
+// permissions, stars, comments and access history, and gives back the quota
+// they held, in one transaction. It returns mongo.ErrNoDocuments when none of
+// the files were left to delete. The documents that point at a file go first,
+// so without transactions a failure leaves the file to be purged again.
+func deleteFileRecords(ctx context.Context, db *mongo.Database, files []models.File) error {
+	fileIds := make([]primitive.ObjectID, len(files))
+	freed := map[primitive.ObjectID]int64{}
+	for i, file := range files {
+		fileIds[i] = file.Id
+		freed[file.OwnerId] += file.StoredBytes()
+	}
+	byFile := bson.M{"fileId": bson.M{"$in": fileIds}}
+
+	return withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+		if _, err := db.Collection(PermissionCollection).DeleteMany(ctx, byFile); err != nil {
+			return err
+		}
+		if _, err := db.Collection(ShareCollection).DeleteMany(ctx, byFile); err != nil {
+			return err
+		}
+		if _, err := db.Collection(StarCollection).DeleteMany(ctx, bson.M{"targetId": bson.M{"$in": fileIds}}); err != nil {
+			return err
+		}
+		if _, err := db.Collection(FileAccessCollection).DeleteMany(ctx, byFile); err != nil {
+			return err
+		}
+		if _, err := db.Collection(CommentCollection).DeleteMany(ctx, byFile); err != nil {
+			return err
+		}
+
+		result, err := db.Collection(FileCollection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": fileIds}})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+
+		for ownerId, n := range freed {
+			if err := deductQuota(ctx, db, ownerId, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// purgeContent removes the thumbnails and stored content of files once
+// deleteFileRecords has committed, returning the blobs that could not be
+// removed.
+func purgeContent(ctx context.Context, db *mongo.Database, cfg *Config, files []models.File) []primitive.ObjectID {
+	fileIds := make([]primitive.ObjectID, len(files))
+	var blobs []models.BlobRef
+	for i, file := range files {
+		fileIds[i] = file.Id
+		blobs = append(blobs, file.Blobs()...)
+	}
+
+	purgeThumbnails(ctx, db, fileIds)
+	return deleteBlobs(ctx, db, cfg, blobs)
+}
+
+// findFile loads the metadata document named by the :id path param, writing
+// a 400 or 404 response when it can't. Trashed files count as not found.
+func findFile(ctx context.Context, db *mongo.Database, c *gin.Context) (*models.File, bool) {
+	return lookupFile(ctx, db, c, bson.M{"deletedAt": notTrashed, "expiresAt": notExpired()})
+}
+
+// lookupFile is findFile with the extra conditions in filter instead of the
+// trash check.
+func lookupFile(ctx context.Context, db *mongo.Database, c *gin.Context, filter bson.M) (*models.File, bool) {
+	collection := db.Collection(FileCollection)
+
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("Invalid file ID"))
+		return nil, false
+	}
+	filter["_id"] = objId
+
+	var file models.File
+	if err := collection.FindOne(ctx, filter).Decode(&file); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, notFound("File not found"))
+			return nil, false
+		}
+		respondError(c, err)
+		return nil, false
+	}
+
+	return &file, true
+}
+
+// authorizeFileOwner reports whether the caller may modify or delete file,
+// writing a 403 response when it may not.
+func authorizeFileOwner(c *gin.Context, file *models.File) bool {
+	if file.OwnerId == currentUserID(c) || isAdmin(c) {
+		return true
+	}
+
+	respondError(c, forbidden("only the owner can modify this file"))
+	return false
+}
+
+// streamFile writes the stored content of file to the response without
+// buffering it, honoring conditional requests and a single-range Range
+// header subject to If-Range. The download stream is closed even if the
+// client goes away mid-transfer. disposition is "attachment" for downloads
+// or "inline" for previews. Cache-Control is cfg.CacheControl.Private
+// unless the caller has set it.
+func streamFile(c *gin.Context, db *mongo.Database, cfg *Config, file *models.File, disposition string) {
+	if c.Writer.Header().Get("Cache-Control") == "" {
+		c.Header("Cache-Control", cfg.CacheControl.Private)
+	}
+	validators := fileValidators(file)
+	if validators.respondNotModified(c) {
+		return
+	}
+
+	rng, partial, err := requestedRange(c, validators, file.Size)
+	if err != nil {
+		c.Header("Content-Range", "bytes */"+strconv.FormatInt(file.Size, 10))
+		respondError(c, newAPIError(http.StatusRequestedRangeNotSatisfiable, ErrCodeRangeNotSatisfiable, err.Error()))
+		return
+	}
+	status := http.StatusOK
+	if partial {
+		status = http.StatusPartialContent
+	}
+
+	var download io.ReadCloser
+	if status == http.StatusPartialContent {
+		download, err = openBlobRange(c.Request.Context(), db, cfg, file.BlobRef, rng.start, rng.length)
+	} else {
+		download, err = openBlob(c.Request.Context(), db, cfg, file.BlobRef)
+	}
+	if err != nil {
+		if err == errBlobNotFound {
+			respondError(c, notFound("File not found"))
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	defer download.Close()
+
+	c.Header("Accept-Ranges", "bytes")
+	if file.Checksum != "" {
+		c.Header(ContentSHA256Header, file.Checksum)
+	}
+	c.Header("Content-Type", file.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Length", strconv.FormatInt(rng.length, 10))
+	c.Header("Content-Disposition", contentDisposition(disposition, file.Name))
+	if status == http.StatusPartialContent {
+		c.Header("Content-Range", "bytes "+strconv.FormatInt(rng.start, 10)+"-"+strconv.FormatInt(rng.end(), 10)+"/"+strconv.FormatInt(file.Size, 10))
+	}
+	w, done := throttleDownload(c, db, cfg)
+	defer done()
+	c.Status(status)
+
+	sent, _ := io.CopyN(w, download, rng.length)
+	downloadedBytes.add(float64(sent))
+}
+
+// contentDisposition formats a Content-Disposition header, using the RFC 2231
+// filename* form for names that aren't plain ASCII.
+func contentDisposition(disposition string, filename string) string {
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); value != "" {
+		return value
+	}
+	return disposition
+}
+
+// normalizeHex lowercases a hex digest so it compares equal to ours.
+func normalizeHex(digest string) string {
+	return strings.ToLower(strings.TrimSpace(digest))
+}
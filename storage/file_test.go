@@ -0,0 +1,55 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUpdateFileReturnsUpdatedFile(t *testing.T) {
+	mt := newMockDB(t)
+	ownerId := primitive.NewObjectID()
+	file := models.File{Id: primitive.NewObjectID(), OwnerId: ownerId, Name: "draft.txt", Size: 5}
+	rename := func(mt *mtest.T) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.PATCH("/files/:id", asUser(ownerId, models.RoleUser), NewFileController(mt.DB, testConfig()).UpdateFile())
+		return doRequest(router, http.MethodPatch, "/files/"+file.Id.Hex(), gin.H{"name": "final.txt"})
+	}
+
+	mt.Run("renamed", func(mt *mtest.T) {
+		renamed := file
+		renamed.Name = "final.txt"
+		mt.AddMockResponses(found(mt, FileCollection, file), mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bsonDoc(mt, renamed)}))
+		rec := rename(mt)
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		command := sentCommand(mt, "findAndModify")
+		if !command.Lookup("new").Boolean() || command.Lookup("update", "$set", "name").StringValue() != "final.txt" {
+			mt.Errorf("findAndModify = %s, want the new name set and the document after it returned", command)
+		}
+		body := decodeJSON(mt, rec)
+		if body["name"] != "final.txt" || body["id"] != file.Id.Hex() || body["size"] != float64(5) {
+			mt.Errorf("body = %v, want the renamed file", body)
+		}
+	})
+
+	mt.Run("gone", func(mt *mtest.T) {
+		// The file expired or was deleted between the read and the update.
+		mt.AddMockResponses(found(mt, FileCollection, file), mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+		rec := rename(mt)
+		if rec.Code != http.StatusNotFound {
+			mt.Fatalf("status = %d, want 404: %s", rec.Code, rec.Body.String())
+		}
+		if got := decodeError(mt.T, rec).Error.Message; got != "File not found" {
+			mt.Errorf("message = %q", got)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notExpired matches files without an expiry or whose expiry is still to
+// come when used as the "expiresAt" condition of a query. Expired files are
+// hidden from the moment they expire, before expireFiles gets to them.
+func notExpired() bson.M {
+	return bson.M{"$not": bson.M{"$lte": time.Now()}}
+}
+
+// parseFileExpiry resolves an expiry given as expiresAt, an RFC 3339
+// timestamp, or ttl, a duration such as "24h", the same way share links do.
+// It returns nil when neither is given.
+func parseFileExpiry(expiresAt, ttl string, now time.Time) (*time.Time, error) {
+	settings := shareSettings{TTL: ttl}
+	if expiresAt != "" {
+		at, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, errors.New("expiresAt must be an RFC 3339 timestamp")
+		}
+		settings.ExpiresAt = &at
+	}
+	at, _, err := settings.expiry(now)
+	return at, err
+}
+
+// expiresIn is the number of whole seconds left until expiresAt, or nil
+// when there is no expiry.
+func expiresIn(expiresAt *time.Time, now time.Time) *int64 {
+	if expiresAt == nil {
+		return nil
+	}
+	left := int64(expiresAt.Sub(now) / time.Second)
+	if left < 0 {
+		left = 0
+	}
+	return &left
+}
+
+// releaseExpiredName frees name in folderId of ownerId from files that have
+// expired but are still waiting for expireFiles, by clearing their live
+// flag. It reports whether there were any.
+func releaseExpiredName(ctx context.Context, files *mongo.Collection, ownerId primitive.ObjectID, folderId *primitive.ObjectID, name string) (bool, error) {
+	filter := bson.M{"ownerId": ownerId, "folderId": folderId, "name": name, "live": true, "expiresAt": bson.M{"$lte": time.Now()}}
+	result, err := files.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"live": ""}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// expireFiles hard-deletes the files whose expiry has passed, trashed or
+// not, along with their content and shares, in batches.
+func expireFiles(ctx context.Context, db *mongo.Database, cfg *Config) (purgeSummary, error) {
+	var summary purgeSummary
+
+	collection := db.Collection(FileCollection)
+	expired := bson.M{"expiresAt": bson.M{"$lte": time.Now()}}
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "size": 1}).
+		SetSort(bson.D{{Key: "expiresAt", Value: 1}}).
+		SetLimit(TrashPurgeBatch)
+
+	for ctx.Err() == nil {
+		var batch []models.File
+		if err := findAll(ctx, collection, expired, &batch, opts); err != nil {
+			return summary, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		ids := make([]primitive.ObjectID, len(batch))
+		var bytes int64
+		for i, file := range batch {
+			ids[i] = file.Id
+			bytes += file.Size
+		}
+
+		// Re-check expiresAt so a file whose expiry was extended since the
+		// find is kept.
+		filter := bson.M{"_id": bson.M{"$in": ids}, "expiresAt": expired["expiresAt"]}
+		failed, err := purgeFiles(ctx, db, cfg, filter)
+		if err != nil {
+			return summary, err
+		}
+
+		summary.Files += len(batch)
+		summary.BytesFreed += bytes
+		summary.FailedBlobs += failed
+
+		if len(batch) < TrashPurgeBatch {
+			break
+		}
+	}
+
+	if summary.Files > 0 {
+		log.Printf("file expiry: removed %d files, freed %d bytes, %d blobs left behind", summary.Files, summary.BytesFreed, summary.FailedBlobs)
+	}
+	return summary, ctx.Err()
+}
@@ -0,0 +1,579 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var FileRequestCollection string = "fileRequests"
+
+// NotificationFileRequestReceived is the notification the owner of a file
+// request gets for each file uploaded through it.
+const NotificationFileRequestReceived = "filerequest.received"
+
+// fileRequestPasswordFailures limits wrong password attempts per request
+// token.
+var fileRequestPasswordFailures = newFailureLimiter(5, 15*time.Minute)
+
+type FileRequestController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewFileRequestController(db *mongo.Database, cfg *Config) *FileRequestController {
+	return &FileRequestController{db, cfg}
+}
+
+// SetupRouter function
+func (rc *FileRequestController) BasicRoute(router *gin.RouterGroup) {
+	limits := rc.cfg.RateLimits
+	requestRouter := router.Group("/filerequests", AuthRequired(rc.db, rc.cfg), RequireScope(rc.cfg, scopeShares))
+	requestRouter.GET("/", rc.GetFileRequests())
+	requestRouter.POST("/", RequireVerified(rc.db), rc.CreateFileRequest())
+	requestRouter.DELETE("/:id", rc.CloseFileRequest())
+
+	publicRouter := router.Group("/r")
+	publicRouter.GET("/:token", RateLimit(rc.db, limits.Store, "download", limits.Download, byIP), rc.GetFileRequest())
+	publicRouter.POST("/:token/upload",
+		RateLimit(rc.db, limits.Store, "upload", limits.Upload, byIP),
+		RateLimit(rc.db, limits.Store, "filerequest", limits.FileRequest, byRequestToken),
+		rc.UploadToFileRequest(),
+	)
+}
+
+// fileRequestSettings are accepted when creating a file request. ExpiresAt
+// and TTL are mutually exclusive; without either the request stays open
+// until it is closed or full.
+type fileRequestSettings struct {
+	Title     string     `json:"title" binding:"required,max=200"`
+	Message   string     `json:"message" binding:"max=2000"`
+	FolderId  string     `json:"folderId"`
+	MaxFiles  *int       `json:"maxFiles" binding:"omitempty,min=1"`
+	MaxBytes  *int64     `json:"maxBytes" binding:"omitempty,min=1"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	TTL       string     `json:"ttl"`
+	Password  *string    `json:"password" binding:"omitempty,min=4,max=128"`
+}
+
+// fileRequestResponse is a file request as returned to its owner.
+type fileRequestResponse struct {
+	models.FileRequest `bson:",inline"`
+	URL                string `json:"url" bson:"-"`
+	Expired            bool   `json:"expired" bson:"-"`
+	Protected          bool   `json:"protected" bson:"-"`
+}
+
+func newFileRequestResponse(cfg *Config, request models.FileRequest) fileRequestResponse {
+	return fileRequestResponse{
+		FileRequest: request,
+		URL:         cfg.apiURL("/r/" + request.Token),
+		Expired:     request.Expired(time.Now()),
+		Protected:   request.PasswordHash != "",
+	}
+}
+
+// publicFileRequest is what GET /r/:token shows uploaders. The remaining
+// counts are only set when the request has the matching limit.
+type publicFileRequest struct {
+	Title            string     `json:"title"`
+	Message          string     `json:"message,omitempty"`
+	OwnerName        string     `json:"ownerName"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	RemainingFiles   *int       `json:"remainingFiles,omitempty"`
+	RemainingBytes   *int64     `json:"remainingBytes,omitempty"`
+	MaxFileSize      int64      `json:"maxFileSize"`
+	PasswordRequired bool       `json:"passwordRequired"`
+}
+
+// fileRequestUploader is how an uploader describes themselves, sent as the
+// "name" and "email" form fields.
+type fileRequestUploader struct {
+	Name  string `json:"name" binding:"max=200"`
+	Email string `json:"email" binding:"omitempty,email"`
+}
+
+// fileRequestReceipt is returned to an uploader for a received file. It
+// leaves out where the file went in the owner's storage.
+type fileRequestReceipt struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// CreateFileRequest handler creates a link through which anyone can upload
+// files into one of the caller's folders.
+func (rc *FileRequestController) CreateFileRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := rc.db.Collection(FileRequestCollection)
+		userId := currentUserID(c)
+
+		var req fileRequestSettings
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		now := time.Now()
+		expiresAt, _, err := shareSettings{ExpiresAt: req.ExpiresAt, TTL: req.TTL}.expiry(now)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		passwordHash, _, err := shareSettings{Password: req.Password}.passwordHash()
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		folderId, ok := resolveFolderParam(ctx, rc.db, c, strings.TrimSpace(req.FolderId), userId)
+		if !ok {
+			return
+		}
+
+		token, err := randomToken(32)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		request := models.FileRequest{
+			Id:           primitive.NewObjectID(),
+			OwnerId:      userId,
+			FolderId:     folderId,
+			Token:        token,
+			Title:        strings.TrimSpace(req.Title),
+			Message:      strings.TrimSpace(req.Message),
+			MaxFiles:     req.MaxFiles,
+			MaxBytes:     req.MaxBytes,
+			ExpiresAt:    expiresAt,
+			PasswordHash: passwordHash,
+			CreatedAt:    now,
+		}
+
+		if _, err := collection.InsertOne(ctx, request); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, newFileRequestResponse(rc.cfg, request))
+	}
+}
+
+// GetFileRequests handler lists the caller's file requests, newest first,
+// or only the open ones with ?open=true.
+func (rc *FileRequestController) GetFileRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := rc.db.Collection(FileRequestCollection)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter := bson.M{"ownerId": currentUserID(c)}
+		if c.Query("open") == "true" {
+			filter["closedAt"] = bson.M{"$exists": false}
+			filter["$or"] = bson.A{bson.M{"expiresAt": bson.M{"$exists": false}}, bson.M{"expiresAt": bson.M{"$gt": time.Now()}}}
+		}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var requests []models.FileRequest
+		opts := page.FindOptions().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+		if err := findAll(ctx, collection, filter, &requests, opts); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		items := make([]fileRequestResponse, 0, len(requests))
+		for _, request := range requests {
+			items = append(items, newFileRequestResponse(rc.cfg, request))
+		}
+
+		c.JSON(http.StatusOK, page.Result(items, total))
+	}
+}
+
+// CloseFileRequest handler stops a file request from taking more files.
+// The files already received stay where they are.
+func (rc *FileRequestController) CloseFileRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := rc.db.Collection(FileRequestCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid file request ID"))
+			return
+		}
+
+		filter := bson.M{"_id": objId, "closedAt": bson.M{"$exists": false}}
+		if !isAdmin(c) {
+			filter["ownerId"] = currentUserID(c)
+		}
+
+		result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"closedAt": time.Now()}})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if result.MatchedCount == 0 {
+			respondError(c, notFound("File request not found"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "File request closed"})
+	}
+}
+
+// GetFileRequest handler shows an uploader what a file request asks for and
+// how much it still takes.
+func (rc *FileRequestController) GetFileRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		request, owner, ok := lookupFileRequest(ctx, rc.db, c)
+		if !ok {
+			return
+		}
+
+		details := publicFileRequest{
+			Title:            request.Title,
+			Message:          request.Message,
+			OwnerName:        owner.Name,
+			ExpiresAt:        request.ExpiresAt,
+			MaxFileSize:      rc.fileRequestUploadLimit(request, owner),
+			PasswordRequired: request.PasswordHash != "",
+		}
+		if request.MaxFiles != nil {
+			remaining := *request.MaxFiles - request.FilesReceived
+			details.RemainingFiles = &remaining
+		}
+		if request.MaxBytes != nil {
+			remaining := *request.MaxBytes - request.BytesReceived
+			details.RemainingBytes = &remaining
+		}
+
+		c.JSON(http.StatusOK, details)
+	}
+}
+
+// UploadToFileRequest handler stores one file sent through a file request
+// in the owner's folder, charged to their quota, and notifies them. The
+// multipart form takes optional "name", "email" and "password" fields, which
+// must precede the file part. A name already taken in the folder gets a
+// " (n)" suffix, so uploads never replace the owner's files.
+func (rc *FileRequestController) UploadToFileRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := rc.db
+
+		token := c.Param("token")
+		if blocked, retryAfter := fileRequestPasswordFailures.Blocked(token); blocked {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			respondError(c, newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "too many wrong passwords, try again later"))
+			return
+		}
+
+		request, owner, ok := lookupFileRequest(ctx, db, c)
+		if !ok {
+			return
+		}
+
+		limit := rc.fileRequestUploadLimit(request, owner)
+		if c.Request.ContentLength > limit+multipartSlack {
+			respondUploadTooLarge(c, limit)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartSlack)
+
+		// The owner's usage isn't shown to uploaders.
+		if c.Request.ContentLength > 0 && owner.UsedBytes+c.Request.ContentLength > rc.cfg.quotaLimit(owner) {
+			respondRequesterStorageFull(c)
+			return
+		}
+
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		var uploader fileRequestUploader
+		var password string
+		var file *models.File
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			var maxBytes *http.MaxBytesError
+			if errors.As(err, &maxBytes) {
+				respondUploadTooLarge(c, limit)
+				return
+			}
+			if err != nil {
+				respondError(c, invalidRequest(err))
+				return
+			}
+
+			if part.FileName() == "" {
+				var field *string
+				switch part.FormName() {
+				case "name":
+					field = &uploader.Name
+				case "email":
+					field = &uploader.Email
+				case "password":
+					field = &password
+				}
+				if field != nil {
+					value, err := io.ReadAll(io.LimitReader(part, 1024))
+					if err != nil {
+						part.Close()
+						respondError(c, invalidRequest(err))
+						return
+					}
+					*field = strings.TrimSpace(string(value))
+				}
+				part.Close()
+				continue
+			}
+
+			if part.FormName() != UploadFormField {
+				part.Close()
+				continue
+			}
+
+			uploader.Email = normalizeEmail(uploader.Email)
+			if err := binding.Validator.ValidateStruct(&uploader); err != nil {
+				part.Close()
+				respondBindingError(c, err)
+				return
+			}
+
+			if request.PasswordHash != "" {
+				if password == "" {
+					part.Close()
+					respondError(c, unauthorized("password required").withDetails(gin.H{"passwordRequired": true}))
+					return
+				}
+				if !checkPassword(request.PasswordHash, password) {
+					part.Close()
+					fileRequestPasswordFailures.Fail(token)
+					respondError(c, unauthorized("wrong password").withDetails(gin.H{"passwordRequired": true}))
+					return
+				}
+				fileRequestPasswordFailures.Reset(token)
+			}
+
+			name, err := sanitizeFileName(part.FileName())
+			if err != nil {
+				part.Close()
+				respondError(c, invalidRequest(err))
+				return
+			}
+
+			file, err = storeUpload(ctx, db, rc.cfg, newLimitedReader(part, limit), name)
+			part.Close()
+			if err != nil {
+				respondStoreError(c, err)
+				return
+			}
+			break
+		}
+
+		if file == nil {
+			respondError(c, invalidRequest(errNoFilePart))
+			return
+		}
+
+		file.OwnerId = request.OwnerId
+		file.FolderId = request.FolderId
+		file.Uploader = &models.FileUploader{RequestId: request.Id, Name: uploader.Name, Email: uploader.Email}
+
+		saved, ok := rc.saveReceivedFile(ctx, c, request, file)
+		if !ok {
+			return
+		}
+
+		enqueueContentJobs(saved)
+		auditAs(c, primitive.NilObjectID, AuditFileUploaded, auditTargetFile, saved.Id, gin.H{"name": saved.Name, "size": saved.Size, "version": 1, "fileRequestId": request.Id})
+		emitWebhookEvent(saved.OwnerId, AuditFileUploaded, fileEventData(saved))
+		notify(ctx, db, rc.cfg, saved.OwnerId, NotificationFileRequestReceived, gin.H{
+			"requestId":     request.Id,
+			"title":         request.Title,
+			"fileId":        saved.Id,
+			"name":          saved.Name,
+			"size":          saved.Size,
+			"uploaderName":  uploader.Name,
+			"uploaderEmail": uploader.Email,
+		})
+
+		c.JSON(http.StatusCreated, fileRequestReceipt{Name: saved.Name, Size: saved.Size, Checksum: saved.Checksum})
+	}
+}
+
+// saveReceivedFile takes a slot of request and the owner's quota for file,
+// whose content is stored, and inserts it under a free name. Whatever was
+// taken is given back, and the content discarded, when it fails; the error
+// response is written then.
+func (rc *FileRequestController) saveReceivedFile(ctx context.Context, c *gin.Context, request *models.FileRequest, file *models.File) (*models.File, bool) {
+	db := rc.db
+
+	claimed, err := claimFileRequestSlot(ctx, db, request.Id, file.Size)
+	if err != nil {
+		discardBlob(ctx, db, rc.cfg, file.BlobRef)
+		respondError(c, err)
+		return nil, false
+	}
+	if !claimed {
+		discardBlob(ctx, db, rc.cfg, file.BlobRef)
+		respondError(c, gone("file request is full"))
+		return nil, false
+	}
+
+	reserved, err := claimQuota(ctx, db, rc.cfg.DefaultQuotaBytes, file.OwnerId, file.Size)
+	if err != nil || !reserved {
+		releaseFileRequestSlot(ctx, db, request.Id, file.Size)
+		discardBlob(ctx, db, rc.cfg, file.BlobRef)
+		if err != nil {
+			respondError(c, err)
+		} else {
+			respondRequesterStorageFull(c)
+		}
+		return nil, false
+	}
+
+	fail := func(err error) (*models.File, bool) {
+		releaseQuota(ctx, db, file.OwnerId, file.Size)
+		releaseFileRequestSlot(ctx, db, request.Id, file.Size)
+		respondError(c, err)
+		return nil, false
+	}
+
+	if err := dedupeUpload(ctx, db, rc.cfg, file); err != nil {
+		discardBlob(ctx, db, rc.cfg, file.BlobRef)
+		return fail(err)
+	}
+
+	saved, _, err := saveUploadedFile(ctx, db, rc.cfg, file, onConflictRename)
+	if err != nil {
+		deleteBlobs(ctx, db, rc.cfg, []models.BlobRef{file.BlobRef})
+		return fail(err)
+	}
+	return saved, true
+}
+
+// lookupFileRequest loads the open file request named by the token in the
+// path and its owner, writing a 404 when there is none or the owner's
+// account can't take files, and a 410 when it expired or is full.
+func lookupFileRequest(ctx context.Context, db *mongo.Database, c *gin.Context) (*models.FileRequest, *models.User, bool) {
+	var request models.FileRequest
+	filter := bson.M{"token": c.Param("token"), "closedAt": bson.M{"$exists": false}}
+	if err := db.Collection(FileRequestCollection).FindOne(ctx, filter).Decode(&request); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, notFound("File request not found"))
+			return nil, nil, false
+		}
+		respondError(c, err)
+		return nil, nil, false
+	}
+
+	owner, err := loadQuotaUser(ctx, db, request.OwnerId)
+	if err == mongo.ErrNoDocuments || (err == nil && (owner.IsDeleted() || owner.Status == models.UserStatusSuspended)) {
+		respondError(c, notFound("File request not found"))
+		return nil, nil, false
+	}
+	if err != nil {
+		respondError(c, err)
+		return nil, nil, false
+	}
+
+	if request.Expired(time.Now()) {
+		respondError(c, gone("file request has expired"))
+		return nil, nil, false
+	}
+	if request.Full() {
+		respondError(c, gone("file request is full"))
+		return nil, nil, false
+	}
+	return &request, owner, true
+}
+
+// fileRequestUploadLimit is the largest file request takes: the owner's
+// upload limit, or less when the request has fewer bytes left.
+func (rc *FileRequestController) fileRequestUploadLimit(request *models.FileRequest, owner *models.User) int64 {
+	limit := rc.cfg.roleUploadLimit(owner.Role)
+	if request.MaxBytes != nil {
+		if remaining := *request.MaxBytes - request.BytesReceived; remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+// claimFileRequestSlot atomically counts a file of size bytes against the
+// request if it still has room for it, so concurrent uploads can't take
+// more than its limits allow.
+func claimFileRequestSlot(ctx context.Context, db *mongo.Database, requestId primitive.ObjectID, size int64) (bool, error) {
+	filter := bson.M{
+		"_id":      requestId,
+		"closedAt": bson.M{"$exists": false},
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"maxFiles": bson.M{"$exists": false}},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$filesReceived", "$maxFiles"}}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"maxBytes": bson.M{"$exists": false}},
+				bson.M{"$expr": bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$bytesReceived", size}}, "$maxBytes"}}},
+			}},
+		},
+	}
+	update := bson.M{"$inc": bson.M{"filesReceived": 1, "bytesReceived": size}}
+
+	err := db.Collection(FileRequestCollection).FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// releaseFileRequestSlot gives back a slot taken for an upload that then
+// failed. Like releaseQuota it isn't cancelled with the request.
+func releaseFileRequestSlot(ctx context.Context, db *mongo.Database, requestId primitive.ObjectID, size int64) {
+	update := bson.M{"$inc": bson.M{"filesReceived": -1, "bytesReceived": -size}}
+	_, _ = db.Collection(FileRequestCollection).UpdateOne(context.WithoutCancel(ctx), bson.M{"_id": requestId}, update)
+}
+
+// respondRequesterStorageFull writes the 413 for an upload that doesn't fit
+// in the requester's quota, without revealing their usage.
+func respondRequesterStorageFull(c *gin.Context) {
+	respondError(c, newAPIError(http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "the requester has no storage left for this upload"))
+}
@@ -0,0 +1,404 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var FolderCollection string = "folders"
+
+var errFolderCycle = errors.New("a folder can't be moved into itself or one of its descendants")
+var errInvalidName = errors.New("name must not be empty or contain path separators")
+
+type FolderController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewFolderController(db *mongo.Database, cfg *Config) *FolderController {
+	return &FolderController{db, cfg}
+}
+
+// SetupRouter function
+func (fc *FolderController) BasicRoute(router *gin.RouterGroup) {
+	limits := fc.cfg.RateLimits
+	folderRouter := router.Group("/folders", AuthRequired(fc.db, fc.cfg), RequireScope(fc.cfg, scopeFiles))
+	folderRouter.GET("/", fc.GetFolder())
+	folderRouter.POST("/", fc.CreateFolder())
+	folderRouter.GET("/:id", fc.GetFolder())
+	folderRouter.GET("/:id/download", RateLimit(fc.db, limits.Store, "download", limits.Download, byUser), fc.DownloadFolder())
+	folderRouter.PATCH("/:id", fc.UpdateFolder())
+	folderRouter.DELETE("/:id", fc.DeleteFolder())
+	folderRouter.POST("/:id/star", fc.StarFolder())
+	folderRouter.DELETE("/:id/star", fc.UnstarFolder())
+}
+
+type createFolderRequest struct {
+	Name     string `json:"name" binding:"required,max=255"`
+	ParentId string `json:"parentId" binding:"omitempty,len=24,hexadecimal"`
+}
+
+// CreateFolder handler
+func (fc *FolderController) CreateFolder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FolderCollection)
+
+		var req createFolderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		name, err := cleanName(req.Name)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		userId := currentUserID(c)
+		parentId, ok := resolveFolderParam(ctx, fc.db, c, req.ParentId, userId)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		folder := models.Folder{
+			Id:        primitive.NewObjectID(),
+			OwnerId:   userId,
+			Name:      name,
+			ParentId:  parentId,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		if _, err := collection.InsertOne(ctx, folder); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, folder)
+	}
+}
+
+// GetFolder handler
+func (fc *FolderController) GetFolder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		var folder *models.Folder
+		ownerId := currentUserID(c)
+		if c.Param("id") != "" {
+			var ok bool
+			folder, ok = findFolder(ctx, fc.db, c)
+			if !ok {
+				return
+			}
+			if !authorizeFolder(c, folder) {
+				return
+			}
+			ownerId = folder.OwnerId
+		}
+
+		filter := bson.M{"ownerId": ownerId, "parentId": nil}
+		fileFilter := bson.M{"ownerId": ownerId, "folderId": nil, "deletedAt": notTrashed, "expiresAt": notExpired()}
+		if folder != nil {
+			filter["parentId"] = folder.Id
+			fileFilter["folderId"] = folder.Id
+		}
+		byName := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+
+		folders := []models.Folder{}
+		if err := findAll(ctx, db.Collection(FolderCollection), filter, &folders, byName); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		files := []models.File{}
+		if err := findAll(ctx, db.Collection(FileCollection), fileFilter, &files, byName); err != nil {
+			respondError(c, err)
+			return
+		}
+		now := time.Now()
+		for i := range files {
+			files[i].ExpiresIn = expiresIn(files[i].ExpiresAt, now)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"folder": folder, "folders": folders, "files": files})
+	}
+}
+
+type updateFolderRequest struct {
+	Name     *string `json:"name" binding:"omitempty,max=255"`
+	ParentId *string `json:"parentId" binding:"omitempty"`
+}
+
+// UpdateFolder handler
+func (fc *FolderController) UpdateFolder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FolderCollection)
+
+		folder, ok := findFolder(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFolder(c, folder) {
+			return
+		}
+
+		var req updateFolderRequest
+		if err := decodeStrictJSON(c, &req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		set := bson.M{}
+
+		if req.Name != nil {
+			name, err := cleanName(*req.Name)
+			if err != nil {
+				respondError(c, invalidRequest(err))
+				return
+			}
+			set["name"] = name
+		}
+
+		if req.ParentId != nil {
+			parentId, ok := resolveFolderParam(ctx, fc.db, c, *req.ParentId, folder.OwnerId)
+			if !ok {
+				return
+			}
+
+			if parentId != nil {
+				cycle, err := isSelfOrDescendant(ctx, collection, folder.Id, *parentId)
+				if err != nil {
+					respondError(c, err)
+					return
+				}
+				if cycle {
+					respondError(c, invalidRequest(errFolderCycle))
+					return
+				}
+			}
+			set["parentId"] = parentId
+		}
+
+		if len(set) == 0 {
+			respondError(c, badRequest("no updatable fields provided"))
+			return
+		}
+		set["updatedAt"] = time.Now()
+
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated models.Folder
+		if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": folder.Id}, bson.M{"$set": set}, opts).Decode(&updated); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Folder not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// DeleteFolder handler
+func (fc *FolderController) DeleteFolder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		folder, ok := findFolder(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFolder(c, folder) {
+			return
+		}
+
+		ids, err := descendantFolderIds(ctx, db.Collection(FolderCollection), folder.Id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		fileFilter := bson.M{"folderId": bson.M{"$in": ids}, "deletedAt": notTrashed, "expiresAt": notExpired()}
+
+		if c.Query("recursive") != "true" {
+			count, err := db.Collection(FileCollection).CountDocuments(ctx, fileFilter)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			if count > 0 || len(ids) > 1 {
+				respondError(c, conflict("folder is not empty, pass ?recursive=true to delete its contents"))
+				return
+			}
+		}
+
+		// Files inside go to the trash; restoring them after their folder
+		// is gone puts them back at the root.
+		trashed, err := db.Collection(FileCollection).UpdateMany(ctx, fileFilter, trashUpdate(time.Now()))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if _, err := db.Collection(FolderCollection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			respondError(c, err)
+			return
+		}
+		_, _ = db.Collection(StarCollection).DeleteMany(ctx, bson.M{"targetId": bson.M{"$in": ids}})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Folder deleted successfully", "trashedFiles": trashed.ModifiedCount})
+	}
+}
+
+// findFolder loads the folder named by the :id path param, writing a 400 or
+// 404 response when it can't.
+func findFolder(ctx context.Context, db *mongo.Database, c *gin.Context) (*models.Folder, bool) {
+	collection := db.Collection(FolderCollection)
+
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("Invalid folder ID"))
+		return nil, false
+	}
+
+	var folder models.Folder
+	if err := collection.FindOne(ctx, bson.M{"_id": objId}).Decode(&folder); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, notFound("Folder not found"))
+			return nil, false
+		}
+		respondError(c, err)
+		return nil, false
+	}
+
+	return &folder, true
+}
+
+// authorizeFolder reports whether the caller owns folder (or is an admin),
+// writing a 403 response when it doesn't.
+func authorizeFolder(c *gin.Context, folder *models.Folder) bool {
+	if folder.OwnerId == currentUserID(c) || isAdmin(c) {
+		return true
+	}
+
+	respondError(c, forbidden("not allowed to access this folder"))
+	return false
+}
+
+// resolveFolderParam checks that a client supplied folder id names a folder
+// owned by ownerId. An empty id means the root and resolves to nil.
+func resolveFolderParam(ctx context.Context, db *mongo.Database, c *gin.Context, raw string, ownerId primitive.ObjectID) (*primitive.ObjectID, bool) {
+	if raw == "" {
+		return nil, true
+	}
+
+	objId, err := primitive.ObjectIDFromHex(raw)
+	if err != nil {
+		respondError(c, badRequest("Invalid folder ID"))
+		return nil, false
+	}
+
+	collection := db.Collection(FolderCollection)
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": objId, "ownerId": ownerId})
+	if err != nil {
+		respondError(c, err)
+		return nil, false
+	}
+	if count == 0 {
+		respondError(c, notFound("Folder not found"))
+		return nil, false
+	}
+
+	return &objId, true
+}
+
+// isSelfOrDescendant walks up from candidate to the root and reports whether
+// it passes through folderId.
+func isSelfOrDescendant(ctx context.Context, folders *mongo.Collection, folderId primitive.ObjectID, candidate primitive.ObjectID) (bool, error) {
+	current := &candidate
+	for current != nil {
+		if *current == folderId {
+			return true, nil
+		}
+
+		var folder models.Folder
+		err := folders.FindOne(ctx, bson.M{"_id": *current}, options.FindOne().SetProjection(bson.M{"parentId": 1})).Decode(&folder)
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		current = folder.ParentId
+	}
+	return false, nil
+}
+
+// descendantFolderIds returns folderId and the ids of every folder below it.
+func descendantFolderIds(ctx context.Context, folders *mongo.Collection, folderId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ids := []primitive.ObjectID{folderId}
+	frontier := []primitive.ObjectID{folderId}
+
+	for len(frontier) > 0 {
+		cursor, err := folders.Find(ctx, bson.M{"parentId": bson.M{"$in": frontier}}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+
+		var children []models.Folder
+		if err := cursor.All(ctx, &children); err != nil {
+			return nil, err
+		}
+
+		frontier = frontier[:0]
+		for _, child := range children {
+			ids = append(ids, child.Id)
+			frontier = append(frontier, child.Id)
+		}
+	}
+	return ids, nil
+}
+
+// cleanName trims a file or folder name and rejects empty names and names
+// containing path separators.
+func cleanName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return "", errInvalidName
+	}
+	return name, nil
+}
+
+// findAll runs a Find and decodes every result into results.
+func findAll(ctx context.Context, collection *mongo.Collection, filter interface{}, results interface{}, opts ...*options.FindOptions) error {
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
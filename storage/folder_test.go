@@ -0,0 +1,53 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUpdateFolderReturnsUpdatedFolder(t *testing.T) {
+	mt := newMockDB(t)
+	ownerId := primitive.NewObjectID()
+	folder := models.Folder{Id: primitive.NewObjectID(), OwnerId: ownerId, Name: "Drafts"}
+	rename := func(mt *mtest.T) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.PATCH("/folders/:id", asUser(ownerId, models.RoleUser), NewFolderController(mt.DB, testConfig()).UpdateFolder())
+		return doRequest(router, http.MethodPatch, "/folders/"+folder.Id.Hex(), gin.H{"name": "Final"})
+	}
+
+	mt.Run("renamed", func(mt *mtest.T) {
+		renamed := folder
+		renamed.Name = "Final"
+		mt.AddMockResponses(found(mt, FolderCollection, folder), mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bsonDoc(mt, renamed)}))
+		rec := rename(mt)
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		if !sentCommand(mt, "findAndModify").Lookup("new").Boolean() {
+			mt.Error("the update does not ask for the document after it")
+		}
+		body := decodeJSON(mt, rec)
+		if body["name"] != "Final" || body["id"] != folder.Id.Hex() {
+			mt.Errorf("body = %v, want the renamed folder", body)
+		}
+	})
+
+	mt.Run("gone", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, FolderCollection, folder), mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+		rec := rename(mt)
+		if rec.Code != http.StatusNotFound {
+			mt.Fatalf("status = %d, want 404: %s", rec.Code, rec.Body.String())
+		}
+		if got := decodeError(mt.T, rec).Error.Message; got != "Folder not found" {
+			mt.Errorf("message = %q", got)
+		}
+	})
+}
@@ -0,0 +1,442 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var GroupCollection string = "groups"
+
+// MaxGroupMembers caps the members of one group, its owner included.
+const MaxGroupMembers = 500
+
+// NotificationGroupAdded is the notification a user gets when they are
+// added to a group.
+const NotificationGroupAdded = "group.added"
+
+var errGroupFull = fmt.Errorf("a group can have at most %d members", MaxGroupMembers)
+
+type GroupController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewGroupController(db *mongo.Database, cfg *Config) *GroupController {
+	return &GroupController{db, cfg}
+}
+
+// SetupRouter function
+func (gc *GroupController) BasicRoute(router *gin.RouterGroup) {
+	groupRouter := router.Group("/groups", AuthRequired(gc.db, gc.cfg), RequireScope(gc.cfg, scopeShares))
+	groupRouter.GET("/", gc.GetGroups())
+	groupRouter.POST("/", gc.CreateGroup())
+	groupRouter.GET("/:id", gc.GetGroup())
+	groupRouter.PATCH("/:id", gc.UpdateGroup())
+	groupRouter.DELETE("/:id", gc.DeleteGroup())
+	groupRouter.POST("/:id/members", gc.AddMember())
+	groupRouter.DELETE("/:id/members/:userId", gc.RemoveMember())
+}
+
+type groupRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+type addMemberRequest struct {
+	UserId string `json:"userId" binding:"required_without=Email,omitempty,len=24,hexadecimal"`
+	Email  string `json:"email" binding:"required_without=UserId,omitempty,email"`
+	Role   string `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+// CreateGroup handler creates a group with the caller as its owner and only
+// member.
+func (gc *GroupController) CreateGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := gc.db.Collection(GroupCollection)
+
+		var req groupRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		now := time.Now()
+		group := models.Group{
+			Id:        primitive.NewObjectID(),
+			Name:      req.Name,
+			OwnerId:   currentUserID(c),
+			Members:   []models.GroupMember{{UserId: currentUserID(c), Role: models.GroupRoleAdmin, AddedAt: now}},
+			CreatedAt: now,
+		}
+		if _, err := collection.InsertOne(ctx, group); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, group)
+	}
+}
+
+// GetGroups handler lists the groups the caller is a member of, by name.
+func (gc *GroupController) GetGroups() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := gc.db.Collection(GroupCollection)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter := bson.M{"members.userId": currentUserID(c)}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		groups := []models.Group{}
+		opts := page.FindOptions().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+		if err := findAll(ctx, collection, filter, &groups, opts); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, page.Result(groups, total))
+	}
+}
+
+// GetGroup handler
+func (gc *GroupController) GetGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		group, ok := gc.findGroup(ctx, c, false)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, group)
+	}
+}
+
+// UpdateGroup handler renames a group. Only its admins may.
+func (gc *GroupController) UpdateGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := gc.db.Collection(GroupCollection)
+
+		group, ok := gc.findGroup(ctx, c, true)
+		if !ok {
+			return
+		}
+
+		var req groupRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		update := bson.M{"$set": bson.M{"name": req.Name, "updatedAt": time.Now()}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated models.Group
+		if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": group.Id}, update, opts).Decode(&updated); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Group not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// DeleteGroup handler deletes a group along with everything shared with it.
+// Only its owner may.
+func (gc *GroupController) DeleteGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		group, ok := gc.findGroup(ctx, c, true)
+		if !ok {
+			return
+		}
+
+		if group.OwnerId != currentUserID(c) && !isAdmin(c) {
+			respondError(c, forbidden("only the owner can delete a group"))
+			return
+		}
+
+		if err := deleteGroups(ctx, gc.db, []primitive.ObjectID{group.Id}); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
+	}
+}
+
+// AddMember handler adds a user to a group, or changes the role of one
+// already in it. Only the group's admins may. A user added to the group
+// gets access to everything shared with it straight away.
+func (gc *GroupController) AddMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := gc.db
+		collection := db.Collection(GroupCollection)
+
+		group, ok := gc.findGroup(ctx, c, true)
+		if !ok {
+			return
+		}
+
+		var req addMemberRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+		role := req.Role
+		if role == "" {
+			role = models.GroupRoleMember
+		}
+
+		filter := bson.M{"email": normalizeEmail(req.Email), "deletedAt": userNotDeleted}
+		if req.UserId != "" {
+			objId, _ := primitive.ObjectIDFromHex(req.UserId)
+			filter = bson.M{"_id": objId, "deletedAt": userNotDeleted}
+		}
+
+		var user models.User
+		if err := db.Collection(UserCollection).FindOne(ctx, filter).Decode(&user); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("User not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		var update bson.M
+		added := group.Member(user.Id) == nil
+		if added {
+			// The size check runs in the filter so concurrent adds can't push
+			// a group past the limit.
+			filter = bson.M{
+				"_id":            group.Id,
+				"members.userId": bson.M{"$ne": user.Id},
+				"$expr":          bson.M{"$lt": bson.A{bson.M{"$size": "$members"}, MaxGroupMembers}},
+			}
+			member := models.GroupMember{UserId: user.Id, Role: role, AddedAt: time.Now()}
+			update = bson.M{"$push": bson.M{"members": member}}
+		} else {
+			if user.Id == group.OwnerId && role != models.GroupRoleAdmin {
+				respondError(c, badRequest("the owner is always an admin"))
+				return
+			}
+			filter = bson.M{"_id": group.Id, "members.userId": user.Id}
+			update = bson.M{"$set": bson.M{"members.$.role": role}}
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated models.Group
+		if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+			if err == mongo.ErrNoDocuments {
+				if added {
+					respondError(c, conflict(errGroupFull.Error()))
+					return
+				}
+				respondError(c, notFound("Member not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		if added {
+			notify(ctx, db, gc.cfg, user.Id, NotificationGroupAdded, gin.H{"groupId": group.Id, "name": group.Name, "addedBy": currentUserID(c)})
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// RemoveMember handler takes a user out of a group, and with it their access
+// to what is shared with the group. Admins may remove anyone but the owner;
+// members may always leave.
+func (gc *GroupController) RemoveMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := gc.db.Collection(GroupCollection)
+
+		userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		group, ok := gc.findGroup(ctx, c, userId != currentUserID(c))
+		if !ok {
+			return
+		}
+
+		if userId == group.OwnerId {
+			respondError(c, badRequest("the owner can't leave the group; delete it instead"))
+			return
+		}
+
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": group.Id, "members.userId": userId},
+			bson.M{"$pull": bson.M{"members": bson.M{"userId": userId}}, "$set": bson.M{"updatedAt": time.Now()}},
+		)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			respondError(c, notFound("Member not found"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+	}
+}
+
+// findGroup loads the group named in the path for a caller who is one of
+// its members, or one of its admins when asAdmin is set. Admins of the
+// service get through either way. It writes the error response when the
+// caller doesn't.
+func (gc *GroupController) findGroup(ctx context.Context, c *gin.Context, asAdmin bool) (*models.Group, bool) {
+	collection := gc.db.Collection(GroupCollection)
+
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("Invalid group ID"))
+		return nil, false
+	}
+
+	var group models.Group
+	if err := collection.FindOne(ctx, bson.M{"_id": objId}).Decode(&group); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, notFound("Group not found"))
+			return nil, false
+		}
+		respondError(c, err)
+		return nil, false
+	}
+
+	if isAdmin(c) {
+		return &group, true
+	}
+	member := group.Member(currentUserID(c))
+	if member == nil {
+		respondError(c, forbidden("not a member of this group"))
+		return nil, false
+	}
+	if asAdmin && member.Role != models.GroupRoleAdmin {
+		respondError(c, forbidden("only group admins can manage the group"))
+		return nil, false
+	}
+	return &group, true
+}
+
+// grantFilter matches the permissions that give userId access to a file:
+// those granted to them and those granted to a group they are a member of.
+// Memberships are looked up on every call, so a user removed from a group
+// loses what was shared with it at once.
+func grantFilter(ctx context.Context, db *mongo.Database, userId primitive.ObjectID) (bson.M, error) {
+	groupIds, err := db.Collection(GroupCollection).Distinct(ctx, "_id", bson.M{"members.userId": userId})
+	if err != nil {
+		return nil, err
+	}
+	if len(groupIds) == 0 {
+		return bson.M{"userId": userId}, nil
+	}
+	return bson.M{"$or": bson.A{bson.M{"userId": userId}, bson.M{"groupId": bson.M{"$in": groupIds}}}}, nil
+}
+
+// grantedFileIds returns the ids of the files userId has been granted
+// access to, directly or through a group.
+func grantedFileIds(ctx context.Context, db *mongo.Database, userId primitive.ObjectID) ([]interface{}, error) {
+	filter, err := grantFilter(ctx, db, userId)
+	if err != nil {
+		return nil, err
+	}
+	return db.Collection(PermissionCollection).Distinct(ctx, "fileId", filter)
+}
+
+// fileGranteeIds returns the ids of the users granted access to fileId,
+// directly or through a group.
+func fileGranteeIds(ctx context.Context, db *mongo.Database, fileId primitive.ObjectID) ([]interface{}, error) {
+	permissions := db.Collection(PermissionCollection)
+
+	userIds, err := permissions.Distinct(ctx, "userId", bson.M{"fileId": fileId, "userId": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	groupIds, err := permissions.Distinct(ctx, "groupId", bson.M{"fileId": fileId, "groupId": bson.M{"$exists": true}})
+	if err != nil || len(groupIds) == 0 {
+		return userIds, err
+	}
+
+	members, err := db.Collection(GroupCollection).Distinct(ctx, "members.userId", bson.M{"_id": bson.M{"$in": groupIds}})
+	if err != nil {
+		return nil, err
+	}
+	return append(userIds, members...), nil
+}
+
+// deleteGroups removes groups and the permissions granted to them.
+func deleteGroups(ctx context.Context, db *mongo.Database, groupIds []primitive.ObjectID) error {
+	return withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+		if _, err := db.Collection(PermissionCollection).DeleteMany(ctx, bson.M{"groupId": bson.M{"$in": groupIds}}); err != nil {
+			return err
+		}
+		_, err := db.Collection(GroupCollection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": groupIds}})
+		return err
+	})
+}
+
+// removeUserFromGroups deletes the groups userId owns and takes them out of
+// the others.
+func removeUserFromGroups(ctx context.Context, db *mongo.Database, userId primitive.ObjectID) error {
+	collection := db.Collection(GroupCollection)
+
+	owned, err := collection.Distinct(ctx, "_id", bson.M{"ownerId": userId})
+	if err != nil {
+		return err
+	}
+	if len(owned) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(owned))
+		for _, id := range owned {
+			if objId, ok := id.(primitive.ObjectID); ok {
+				ids = append(ids, objId)
+			}
+		}
+		if err := deleteGroups(ctx, db, ids); err != nil {
+			return err
+		}
+	}
+
+	_, err = collection.UpdateMany(ctx, bson.M{"members.userId": userId}, bson.M{"$pull": bson.M{"members": bson.M{"userId": userId}}})
+	return err
+}
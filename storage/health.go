@@ -0,0 +1,150 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ReadinessTimeout bounds each readiness check against Mongo.
+const ReadinessTimeout = 2 * time.Second
+
+// ReadinessCacheTTL is how long a readiness result is reused, so a storm of
+// probes costs Mongo one round of checks.
+const ReadinessCacheTTL = 2 * time.Second
+
+// probePaths are polled constantly, so they are not logged when they succeed
+// and stay open
+// while draining for the orchestrator and scrapers to watch the shutdown.
+var probePaths = []string{"/healthz", "/readyz", "/metrics"}
+
+type HealthController struct {
+	db  *mongo.Database
+	cfg *Config
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	ready     bool
+	checks    map[string]string
+}
+
+func NewHealthController(db *mongo.Database, cfg *Config) *HealthController {
+	return &HealthController{db: db, cfg: cfg}
+}
+
+// SetupRouter function
+func (hc *HealthController) BasicRoute(router *gin.RouterGroup) {
+	router.GET("/healthz", hc.Healthz())
+	router.GET("/readyz", hc.Readyz())
+}
+
+// Healthz handler answers 200 while the process is up.
+func (hc *HealthController) Healthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// Readyz handler answers 200 when Mongo responds, every required index
+// exists and the file bucket is reachable, along with the storage backend
+// new content goes to, and 503 naming the failed checks otherwise. Results are cached for ReadinessCacheTTL.
+func (hc *HealthController) Readyz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		if draining.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": gin.H{"server": "shutting down"}})
+			return
+		}
+
+		ready, checks := hc.readiness(ctx)
+		if !ready {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+	}
+}
+
+// readiness returns the cached result, running the checks again once it is
+// older than ReadinessCacheTTL. Concurrent probes wait for one run.
+func (hc *HealthController) readiness(ctx context.Context) (bool, map[string]string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if time.Since(hc.checkedAt) < ReadinessCacheTTL {
+		return hc.ready, hc.checks
+	}
+
+	// The result is shared with other probes, so one that hangs up must not
+	// fail the checks for all of them.
+	checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ReadinessTimeout)
+	defer cancel()
+
+	checks := map[string]string{
+		"mongo":   checkResult(hc.db.RunCommand(checkCtx, bson.D{{Key: "ping", Value: 1}}).Err()),
+		"indexes": "skipped",
+		"gridfs":  "skipped",
+	}
+	if checks["mongo"] == "ok" {
+		checks["indexes"] = checkIndexes(checkCtx, hc.db, hc.cfg)
+		_, err := hc.db.Collection(FileBucket + ".files").EstimatedDocumentCount(checkCtx)
+		checks["gridfs"] = checkResult(err)
+	}
+	if storage, err := storageBackend(hc.db, hc.cfg, hc.cfg.Storage.Backend); err != nil {
+		checks["storage"] = checkResult(err)
+	} else if checker, ok := storage.(storageChecker); ok {
+		checks["storage"] = checkResult(checker.Check(checkCtx))
+	}
+
+	ready := true
+	for _, result := range checks {
+		if result != "ok" {
+			ready = false
+		}
+	}
+	// Only the change to failing is logged, not every failed probe.
+	if !ready && (hc.ready || hc.checkedAt.IsZero()) {
+		log.Printf("readiness check failed: %v", checks)
+	}
+
+	hc.checkedAt, hc.ready, hc.checks = time.Now(), ready, checks
+	return ready, checks
+}
+
+func checkResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// checkIndexes reports the required indexes that are missing.
+func checkIndexes(ctx context.Context, db *mongo.Database, cfg *Config) string {
+	var missing []string
+	for collection, models := range requiredIndexes(cfg) {
+		present, err := indexNames(ctx, db, collection)
+		if err != nil {
+			return err.Error()
+		}
+		for _, model := range models {
+			if name := *model.Options.Name; !present[name] {
+				missing = append(missing, collection+"."+name)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "missing " + strings.Join(missing, ", ")
+	}
+	return "ok"
+}
@@ -0,0 +1,116 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var errImageTooLarge = errors.New("image dimensions exceed the allowed pixel count")
+
+// imageContentTypes are the stored types that can be decoded as images.
+var imageContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// isImageType reports whether content of contentType can be decoded.
+func isImageType(contentType string) bool {
+	return imageContentTypes[mediaType(contentType)]
+}
+
+// loadImage decodes the content ref points at after checking that it has at
+// most maxPixels. It returns the decoder's format name, e.g. "png".
+func loadImage(ctx context.Context, db *mongo.Database, cfg *Config, ref models.BlobRef, maxPixels int64) (image.Image, string, error) {
+	header, err := openBlob(ctx, db, cfg, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	config, _, err := image.DecodeConfig(header)
+	header.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, "", errImageTooLarge
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	download, err := openBlob(ctx, db, cfg, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	defer download.Close()
+
+	return image.Decode(download)
+}
+
+// fitWithin scales img down, keeping its aspect ratio, so that it fits in a
+// w by h box. Images already small enough are returned as is.
+func fitWithin(img image.Image, w int, h int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= w && bounds.Dy() <= h {
+		return img
+	}
+
+	scale := min(float64(w)/float64(bounds.Dx()), float64(h)/float64(bounds.Dy()))
+	return scaleImage(img, max(1, int(float64(bounds.Dx())*scale)), max(1, int(float64(bounds.Dy())*scale)))
+}
+
+// coverImage scales img to fill a w by h box, keeping its aspect ratio, and
+// crops the overflow evenly from both sides.
+func coverImage(img image.Image, w int, h int) image.Image {
+	bounds := img.Bounds()
+	crop := bounds
+	if bounds.Dx()*h > bounds.Dy()*w {
+		cw := bounds.Dy() * w / h
+		crop.Min.X += (bounds.Dx() - cw) / 2
+		crop.Max.X = crop.Min.X + cw
+	} else {
+		ch := bounds.Dx() * h / w
+		crop.Min.Y += (bounds.Dy() - ch) / 2
+		crop.Max.Y = crop.Min.Y + ch
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Over, nil)
+	return dst
+}
+
+// scaleImage resamples img to exactly w by h.
+func scaleImage(img image.Image, w int, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return dst
+}
+
+// encodedType is the content type encodeImage writes for a source format.
+// PNG and GIF sources stay lossless to keep their transparency.
+func encodedType(format string) string {
+	if format == "png" || format == "gif" {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// encodeImage writes img to w as encodedType(format).
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	if encodedType(format) == "image/png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
+}
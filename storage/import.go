@@ -0,0 +1,467 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ImportBatchSize is how many users ImportUsers inserts per InsertMany.
+const ImportBatchSize = 500
+
+// Statuses of one row in an import report.
+const (
+	importCreated = "created"
+	importSkipped = "skipped"
+	importError   = "error"
+)
+
+// importRow is one user to import. Role defaults to user.
+type importRow struct {
+	Name       string `json:"name" binding:"required,max=100"`
+	Email      string `json:"email" binding:"required,email"`
+	Role       string `json:"role" binding:"omitempty,oneof=user admin"`
+	QuotaBytes *int64 `json:"quotaBytes" binding:"omitempty,min=0"`
+}
+
+// importResult reports what happened to one row. Row counts from 1 and
+// excludes the CSV header.
+type importResult struct {
+	Row    int                 `json:"row"`
+	Email  string              `json:"email,omitempty"`
+	Status string              `json:"status"`
+	Reason string              `json:"reason,omitempty"`
+	Id     *primitive.ObjectID `json:"id,omitempty"`
+}
+
+// importSource yields rows one at a time and io.EOF after the last. A
+// rowError is reported against its row; any other error ends the import.
+type importSource interface {
+	Next() (importRow, error)
+}
+
+type rowError struct {
+	reason string
+}
+
+func (e rowError) Error() string {
+	return e.reason
+}
+
+// jsonImportSource reads the elements of a JSON array one by one.
+type jsonImportSource struct {
+	decoder *json.Decoder
+}
+
+func newJSONImportSource(r io.Reader) (*jsonImportSource, error) {
+	decoder := json.NewDecoder(r)
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("request body must be a JSON array")
+	}
+	return &jsonImportSource{decoder}, nil
+}
+
+func (s *jsonImportSource) Next() (importRow, error) {
+	var row importRow
+	if !s.decoder.More() {
+		return row, io.EOF
+	}
+
+	// Decoding into RawMessage first keeps the stream usable after an
+	// element of the wrong shape.
+	var raw json.RawMessage
+	if err := s.decoder.Decode(&raw); err != nil {
+		return row, err
+	}
+	if err := json.Unmarshal(raw, &row); err != nil {
+		return row, rowError{"invalid row: " + err.Error()}
+	}
+	return row, nil
+}
+
+// csvImportSource reads CSV records with a header naming the columns. name
+// and email are required; role and quota (or quotaBytes) are optional.
+type csvImportSource struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVImportSource(r io.Reader) (*csvImportSource, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if name == "quotabytes" {
+			name = "quota"
+		}
+		if _, ok := columns[name]; !ok {
+			columns[name] = i
+		}
+	}
+	for _, required := range []string{"name", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header must have a %q column", required)
+		}
+	}
+	return &csvImportSource{reader, columns}, nil
+}
+
+func (s *csvImportSource) Next() (importRow, error) {
+	var row importRow
+	record, err := s.reader.Read()
+	if err != nil {
+		return row, err
+	}
+
+	field := func(name string) string {
+		i, ok := s.columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	row.Name = field("name")
+	row.Email = field("email")
+	row.Role = strings.ToLower(field("role"))
+	if quota := field("quota"); quota != "" {
+		quotaBytes, err := strconv.ParseInt(quota, 10, 64)
+		if err != nil {
+			return row, rowError{"quota must be a whole number of bytes"}
+		}
+		row.QuotaBytes = &quotaBytes
+	}
+	return row, nil
+}
+
+// openImportSource picks the reader for the request body: a JSON array, a
+// text/csv body or a CSV uploaded as the "file" part of a multipart form.
+func openImportSource(c *gin.Context) (importSource, error) {
+	switch c.ContentType() {
+	case binding.MIMEJSON:
+		return newJSONImportSource(c.Request.Body)
+	case "text/csv":
+		return newCSVImportSource(c.Request.Body)
+	case binding.MIMEMultipartPOSTForm:
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil, errors.New("no file uploaded")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if part.FormName() == "file" {
+				return newCSVImportSource(part)
+			}
+			part.Close()
+		}
+	default:
+		return nil, errUnsupportedImportType
+	}
+}
+
+var errUnsupportedImportType = errors.New("send a JSON array, a text/csv body or a multipart form with a CSV file")
+
+// userImport collects rows into batches and records the outcome of each.
+type userImport struct {
+	ctx      context.Context
+	db       *mongo.Database
+	cfg      *Config
+	c        *gin.Context
+	invite   bool
+	verified bool
+
+	seen    map[string]bool
+	batch   []interface{}
+	pending []int
+	results []importResult
+	counts  map[string]int
+}
+
+// add validates row and queues it for the next batch.
+func (imp *userImport) add(number int, row importRow, err error) error {
+	row.Email = normalizeEmail(row.Email)
+	row.Name = strings.TrimSpace(row.Name)
+	result := importResult{Row: number, Email: row.Email}
+
+	if err == nil {
+		err = binding.Validator.ValidateStruct(&row)
+	}
+	if err != nil {
+		result.Status = importError
+		result.Reason = rowErrorReason(err)
+		imp.record(result)
+		return nil
+	}
+
+	if imp.seen[row.Email] {
+		result.Status = importSkipped
+		result.Reason = "duplicate email in import"
+		imp.record(result)
+		return nil
+	}
+	imp.seen[row.Email] = true
+
+	role := row.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+	verified := imp.verified
+	user := models.User{
+		Id:                primitive.NewObjectID(),
+		Name:              row.Name,
+		Email:             row.Email,
+		Role:              role,
+		Status:            models.UserStatusActive,
+		QuotaBytes:        row.QuotaBytes,
+		EmailVerified:     &verified,
+		MustResetPassword: true,
+		Live:              true,
+		CreatedAt:         time.Now(),
+	}
+
+	imp.pending = append(imp.pending, len(imp.results))
+	imp.batch = append(imp.batch, user)
+	imp.results = append(imp.results, result)
+
+	if len(imp.batch) >= ImportBatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+func (imp *userImport) record(result importResult) {
+	imp.results = append(imp.results, result)
+	imp.counts[result.Status]++
+}
+
+// fail reports err against the rows at pending.
+func (imp *userImport) fail(pending []int, err error) {
+	for _, i := range pending {
+		imp.results[i].Status = importError
+		imp.results[i].Reason = err.Error()
+		imp.counts[importError]++
+	}
+}
+
+// skipPendingDeletions skips the rows of batch whose email belongs to a
+// deleted account that can still be restored, which the email index no
+// longer rejects, and returns the rest.
+func (imp *userImport) skipPendingDeletions(collection *mongo.Collection, batch []interface{}, pending []int) ([]interface{}, []int, error) {
+	emails := make([]string, len(batch))
+	for n, user := range batch {
+		emails[n] = user.(models.User).Email
+	}
+	taken, err := collection.Distinct(imp.ctx, "email", bson.M{"email": bson.M{"$in": emails}, "deletedAt": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, pending, err
+	}
+	deleted := map[string]bool{}
+	for _, email := range taken {
+		if email, ok := email.(string); ok {
+			deleted[email] = true
+		}
+	}
+
+	var keptBatch []interface{}
+	var keptPending []int
+	for n, user := range batch {
+		if deleted[emails[n]] {
+			result := &imp.results[pending[n]]
+			result.Status = importSkipped
+			result.Reason = "an account with this email was deleted and can still be restored"
+			imp.counts[importSkipped]++
+			continue
+		}
+		keptBatch = append(keptBatch, user)
+		keptPending = append(keptPending, pending[n])
+	}
+	return keptBatch, keptPending, nil
+}
+
+// flush inserts the queued users. Rows whose email is held by an account
+// waiting to be purged or rejected by the unique email index are skipped;
+// other write errors are reported against their row.
+func (imp *userImport) flush() error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	batch, pending := imp.batch, imp.pending
+	imp.batch, imp.pending = nil, nil
+
+	collection := imp.db.Collection(UserCollection)
+	batch, pending, err := imp.skipPendingDeletions(collection, batch, pending)
+	if err != nil {
+		imp.fail(pending, err)
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	_, err = collection.InsertMany(imp.ctx, batch, options.InsertMany().SetOrdered(false))
+
+	failed := map[int]mongo.BulkWriteError{}
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			imp.fail(pending, err)
+			return err
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = writeErr
+		}
+	}
+
+	for n, i := range pending {
+		result := &imp.results[i]
+		if writeErr, ok := failed[n]; ok {
+			if writeErr.Code == 11000 {
+				result.Status = importSkipped
+				result.Reason = "email already registered"
+			} else {
+				result.Status = importError
+				result.Reason = writeErr.Message
+			}
+			imp.counts[result.Status]++
+			continue
+		}
+
+		user := batch[n].(models.User)
+		result.Status = importCreated
+		result.Id = &user.Id
+		imp.counts[importCreated]++
+
+		audit(imp.c, AuditUserCreated, auditTargetUser, user.Id, gin.H{"role": user.Role, "import": true})
+		if imp.invite {
+			if err := sendPasswordReset(imp.ctx, imp.db, imp.cfg, &user, imp.cfg.InvitationTTL, mailInvitation); err != nil {
+				log.Printf("sending invitation to user %s failed: %v", user.Id.Hex(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (imp *userImport) report() gin.H {
+	return gin.H{
+		"created": imp.counts[importCreated],
+		"skipped": imp.counts[importSkipped],
+		"failed":  imp.counts[importError],
+		"results": imp.results,
+	}
+}
+
+// rowErrorReason turns a row's decode or validation error into one line.
+func rowErrorReason(err error) string {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err.Error()
+	}
+	reasons := make([]string, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		reasons = append(reasons, fe.Field()+" "+validationMessage(fe))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// ImportUsers handler creates accounts in bulk from a JSON array of
+// {name, email, role, quotaBytes} objects or a CSV with a header row, sent
+// as the body or as the "file" part of a multipart form. The body is read
+// as a stream and inserted in batches of ImportBatchSize.
+//
+// Imported accounts have no password and must set one through the reset
+// flow; ?invite=true emails each of them a link to do so. The response
+// reports every row as created, skipped or error with a reason.
+func (ac *AdminController) ImportUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		source, err := openImportSource(c)
+		if err == errUnsupportedImportType {
+			respondError(c, newAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, err.Error()))
+			return
+		}
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		imp := &userImport{
+			ctx:      ctx,
+			db:       ac.db,
+			cfg:      ac.cfg,
+			c:        c,
+			invite:   c.Query("invite") == "true",
+			verified: !ac.cfg.RequireEmailVerification,
+			seen:     map[string]bool{},
+			results:  []importResult{},
+			counts:   map[string]int{},
+		}
+
+		for number := 1; ; number++ {
+			row, err := source.Next()
+			if err == io.EOF {
+				break
+			}
+			var invalid rowError
+			if err != nil && !errors.As(err, &invalid) {
+				// Rows read so far are still imported so the report
+				// matches what is in the database.
+				if err := imp.flush(); err != nil {
+					respondError(c, internalError("import stopped, the report lists the rows imported so far", err).withDetails(gin.H{"report": imp.report()}))
+					return
+				}
+				respondError(c, badRequest(fmt.Sprintf("row %d: %v", number, err)).withDetails(gin.H{"report": imp.report()}))
+				return
+			}
+			if err := imp.add(number, row, err); err != nil {
+				respondError(c, internalError("import stopped, the report lists the rows imported so far", err).withDetails(gin.H{"report": imp.report()}))
+				return
+			}
+		}
+
+		if err := imp.flush(); err != nil {
+			respondError(c, internalError("import stopped, the report lists the rows imported so far", err).withDetails(gin.H{"report": imp.report()}))
+			return
+		}
+
+		c.JSON(http.StatusOK, imp.report())
+	}
+}
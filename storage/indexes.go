@@ -0,0 +1,486 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the handlers rely on and drops the ones
+// they replaced. It is safe to call on every startup; existing indexes with
+// the same spec are left alone. It logs which indexes it created and dropped
+// and how many were already there.
+func EnsureIndexes(ctx context.Context, db *mongo.Database, cfg *Config) error {
+	var created, dropped, present []string
+	for collection, specs := range requiredIndexes(cfg) {
+		existing, err := indexNames(ctx, db, collection)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, specs); err != nil {
+			return err
+		}
+
+		for _, model := range specs {
+			name := collection + "." + *model.Options.Name
+			if existing[*model.Options.Name] {
+				present = append(present, name)
+			} else {
+				created = append(created, name)
+			}
+		}
+
+		// Replacements are created first, so what they enforce never lapses.
+		for _, name := range obsoleteIndexes[collection] {
+			if !existing[name] {
+				continue
+			}
+			if _, err := db.Collection(collection).Indexes().DropOne(ctx, name); err != nil {
+				return err
+			}
+			dropped = append(dropped, collection+"."+name)
+		}
+	}
+
+	sort.Strings(created)
+	Logger.Info("indexes ensured", "created", created, "dropped", dropped, "present", len(present))
+	return nil
+}
+
+// markLiveFiles sets the live flag of files stored before it existed that
+// are neither trashed nor expired, so the name index covers them. Where
+// several in a folder share a name, the oldest keeps it and the others are
+// renamed to the first free " (n)" variant. Files saved since have the flag,
+// so once it has run there is nothing left to do.
+func markLiveFiles(ctx context.Context, db *mongo.Database) error {
+	files := db.Collection(FileCollection)
+	filter := bson.M{"live": bson.M{"$exists": false}, "deletedAt": notTrashed, "expiresAt": notExpired()}
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "ownerId": 1, "folderId": 1, "name": 1}).
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(TrashPurgeBatch)
+
+	var marked, renamed int
+	for {
+		var batch []models.File
+		if err := findAll(ctx, files, filter, &batch, opts); err != nil {
+			return err
+		}
+
+		for _, file := range batch {
+			name, err := saveFileName(ctx, files, file.OwnerId, file.FolderId, file.Name, onConflictRename, nil, func(candidate string) error {
+				_, err := files.UpdateOne(ctx, bson.M{"_id": file.Id}, bson.M{"$set": bson.M{"name": candidate, "live": true}})
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			marked++
+			if name != file.Name {
+				renamed++
+				Logger.Info("renamed file with a duplicate name", "fileId", file.Id.Hex(), "from", file.Name, "to", name)
+			}
+		}
+
+		if len(batch) < TrashPurgeBatch {
+			break
+		}
+	}
+
+	if marked > 0 {
+		Logger.Info("live files marked", "marked", marked, "renamed", renamed)
+	}
+	return nil
+}
+
+// markLiveUsers sets the live flag of accounts created before it existed
+// that are not deleted, so the email index covers them. It runs before
+// EnsureIndexes drops the index that kept every email unique, so no two of
+// them share one and their emails stay unique throughout.
+func markLiveUsers(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{"live": bson.M{"$exists": false}, "deletedAt": userNotDeleted}
+	result, err := db.Collection(UserCollection).UpdateMany(ctx, filter, bson.M{"$set": bson.M{"live": true}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		Logger.Info("live users marked", "marked", result.ModifiedCount)
+	}
+	return nil
+}
+
+// obsoleteIndexes are indexes earlier versions created that are in the way
+// of the current ones, by collection name.
+var obsoleteIndexes = map[string][]string{
+	// Allowed a single grant without a userId per file, so only one group.
+	PermissionCollection: {"fileId_userId_unique"},
+	// Allowed two live files of the same name in a folder, the second unless
+	// they had an expiry.
+	FileCollection: {"ownerId_folderId_name", "ownerId_folderId_name_unique"},
+	// Kept the email of deleted accounts until they were purged.
+	UserCollection: {"email_unique"},
+}
+
+// indexNames returns the names of the indexes of collection. listIndexes
+// answers in one batch for collections this size, so no cursor is kept.
+func indexNames(ctx context.Context, db *mongo.Database, collection string) (map[string]bool, error) {
+	var result struct {
+		Cursor struct {
+			FirstBatch []struct {
+				Name string `bson:"name"`
+			} `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+	err := db.RunCommand(ctx, bson.D{{Key: "listIndexes", Value: collection}}).Decode(&result)
+	if err != nil {
+		// A collection that was never written to has no indexes yet.
+		if cmdErr, ok := err.(mongo.CommandError); !ok || cmdErr.Name != "NamespaceNotFound" {
+			return nil, err
+		}
+	}
+
+	names := map[string]bool{}
+	for _, index := range result.Cursor.FirstBatch {
+		names[index.Name] = true
+	}
+	return names, nil
+}
+
+// requiredIndexes lists the indexes of each collection by collection name,
+// with the retention periods of cfg.
+func requiredIndexes(cfg *Config) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		UserCollection: {
+			// Only live accounts hold their email: deleting one clears its
+			// live flag, so the address can be registered again once
+			// respondPendingDeletion lets it.
+			{
+				Keys: bson.D{{Key: "email", Value: 1}, {Key: "live", Value: 1}},
+				Options: options.Index().SetName("email_live_unique").SetUnique(true).
+					SetPartialFilterExpression(bson.M{"live": true}),
+			},
+			{
+				Keys:    bson.D{{Key: "name", Value: 1}},
+				Options: options.Index().SetName("name"),
+			},
+			{
+				Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+				Options: options.Index().SetName("identities_unique").SetUnique(true).
+					SetPartialFilterExpression(bson.M{"identities": bson.M{"$exists": true}}),
+			},
+		},
+		FileCollection: {
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("ownerId_createdAt"),
+			},
+			// Only live files hold their name: trashing a file clears its
+			// live flag, and so does the first save that wants the name of
+			// an expired file still waiting for the sweep.
+			{
+				Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "folderId", Value: 1}, {Key: "name", Value: 1}, {Key: "live", Value: 1}},
+				Options: options.Index().SetName("ownerId_folderId_name_live_unique").SetUnique(true).
+					SetPartialFilterExpression(bson.M{"live": true}),
+			},
+			{
+				Keys:    bson.D{{Key: "folderId", Value: 1}},
+				Options: options.Index().SetName("folderId"),
+			},
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "deletedAt", Value: -1}},
+				Options: options.Index().SetName("ownerId_deletedAt"),
+			},
+			{
+				Keys:    bson.D{{Key: "deletedAt", Value: 1}},
+				Options: options.Index().SetName("deletedAt").SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt").SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "tags", Value: 1}},
+				Options: options.Index().SetName("ownerId_tags"),
+			},
+			{
+				Keys: bson.D{{Key: "name", Value: "text"}, {Key: "tags", Value: "text"}, {Key: "extractedText", Value: "text"}},
+				Options: options.Index().SetName("name_tags_extractedText_text").
+					SetWeights(bson.M{"name": 10, "tags": 5, "extractedText": 1}),
+			},
+			{
+				Keys:    bson.D{{Key: "scanStatus", Value: 1}, {Key: "createdAt", Value: 1}},
+				Options: options.Index().SetName("scanStatus_createdAt").SetSparse(true),
+			},
+		},
+		FolderCollection: {
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "parentId", Value: 1}, {Key: "name", Value: 1}},
+				Options: options.Index().SetName("ownerId_parentId_name"),
+			},
+			{
+				Keys:    bson.D{{Key: "parentId", Value: 1}},
+				Options: options.Index().SetName("parentId"),
+			},
+		},
+		BlobCollection: {
+			{
+				Keys:    bson.D{{Key: "sha256", Value: 1}, {Key: "size", Value: 1}},
+				Options: options.Index().SetName("sha256_size_unique").SetUnique(true),
+			},
+		},
+		BlobKeyCollection: {
+			{
+				Keys:    bson.D{{Key: "keyId", Value: 1}},
+				Options: options.Index().SetName("keyId"),
+			},
+		},
+		ThumbnailBucket + ".files": {
+			{
+				Keys:    bson.D{{Key: "metadata.fileId", Value: 1}, {Key: "metadata.size", Value: 1}, {Key: "metadata.sourceId", Value: 1}},
+				Options: options.Index().SetName("fileId_size_sourceId"),
+			},
+		},
+		CommentCollection: {
+			{
+				Keys:    bson.D{{Key: "fileId", Value: 1}, {Key: "_id", Value: 1}},
+				Options: options.Index().SetName("fileId_id"),
+			},
+		},
+		NotificationCollection: {
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "_id", Value: 1}},
+				Options: options.Index().SetName("userId_id"),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		StarCollection: {
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "targetId", Value: 1}},
+				Options: options.Index().SetName("userId_targetId_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "targetId", Value: 1}},
+				Options: options.Index().SetName("targetId"),
+			},
+		},
+		FileAccessCollection: {
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "fileId", Value: 1}},
+				Options: options.Index().SetName("userId_fileId_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "lastAccessedAt", Value: -1}},
+				Options: options.Index().SetName("userId_lastAccessedAt"),
+			},
+		},
+		EventCollection: {
+			{
+				Keys:    bson.D{{Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("createdAt"),
+			},
+			{
+				Keys:    bson.D{{Key: "actorId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("actorId_createdAt"),
+			},
+			{
+				Keys:    bson.D{{Key: "targetId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("targetId_createdAt"),
+			},
+		},
+		WebhookCollection: {
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "events", Value: 1}},
+				Options: options.Index().SetName("ownerId_events"),
+			},
+		},
+		WebhookDeliveryCollection: {
+			{
+				Keys:    bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
+				Options: options.Index().SetName("status_nextAttemptAt"),
+			},
+			{
+				Keys:    bson.D{{Key: "webhookId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("webhookId_createdAt"),
+			},
+		},
+		VerificationCollection: {
+			{
+				Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+				Options: options.Index().SetName("tokenHash_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}},
+				Options: options.Index().SetName("userId"),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(int32(verificationExpiryGrace.Seconds())),
+			},
+		},
+		LoginFailureCollection: {
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		RateLimitCollection: {
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		APIKeyCollection: {
+			{
+				Keys:    bson.D{{Key: "keyHash", Value: 1}},
+				Options: options.Index().SetName("keyHash_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("userId_createdAt"),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		PasswordResetCollection: {
+			{
+				Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+				Options: options.Index().SetName("tokenHash_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}},
+				Options: options.Index().SetName("userId"),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		ShareCollection: {
+			{
+				Keys:    bson.D{{Key: "token", Value: 1}},
+				Options: options.Index().SetName("token_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "code", Value: 1}},
+				Options: options.Index().SetName("code_unique").SetUnique(true).SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{Key: "fileId", Value: 1}},
+				Options: options.Index().SetName("fileId"),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(int32(ShareExpiryGrace.Seconds())),
+			},
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("ownerId_createdAt"),
+			},
+		},
+		FileRequestCollection: {
+			{
+				Keys:    bson.D{{Key: "token", Value: 1}},
+				Options: options.Index().SetName("token_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("ownerId_createdAt"),
+			},
+		},
+		ShareAccessCollection: {
+			{
+				Keys:    bson.D{{Key: "shareId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("shareId_createdAt"),
+			},
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}},
+				Options: options.Index().SetName("ownerId"),
+			},
+			{
+				Keys:    bson.D{{Key: "createdAt", Value: 1}},
+				Options: options.Index().SetName("createdAt_ttl").SetExpireAfterSeconds(int32(cfg.ShareAnalytics.Retention.Seconds())),
+			},
+		},
+		PermissionCollection: {
+			{
+				// Grants to groups have no userId, and grants to users no
+				// groupId, so each file has one grant per user or group.
+				Keys:    bson.D{{Key: "fileId", Value: 1}, {Key: "userId", Value: 1}, {Key: "groupId", Value: 1}},
+				Options: options.Index().SetName("fileId_userId_groupId_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+				Options: options.Index().SetName("userId_createdAt"),
+			},
+			{
+				Keys:    bson.D{{Key: "groupId", Value: 1}},
+				Options: options.Index().SetName("groupId").SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}},
+				Options: options.Index().SetName("ownerId"),
+			},
+		},
+		GroupCollection: {
+			{
+				Keys:    bson.D{{Key: "members.userId", Value: 1}, {Key: "name", Value: 1}},
+				Options: options.Index().SetName("members_userId_name"),
+			},
+			{
+				Keys:    bson.D{{Key: "ownerId", Value: 1}},
+				Options: options.Index().SetName("ownerId"),
+			},
+		},
+		UploadSessionCollection: {
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		// Expired direct uploads are removed by expireDirectUploads, which
+		// deletes their content too, so the index has no TTL.
+		DirectUploadCollection: {
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt"),
+			},
+		},
+		UploadChunkCollection: {
+			{
+				Keys:    bson.D{{Key: "uploadId", Value: 1}, {Key: "n", Value: 1}},
+				Options: options.Index().SetName("uploadId_n_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		SessionCollection: {
+			{
+				Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+				Options: options.Index().SetName("tokenHash"),
+			},
+			{
+				Keys:    bson.D{{Key: "previousHashes", Value: 1}},
+				Options: options.Index().SetName("previousHashes"),
+			},
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}},
+				Options: options.Index().SetName("userId"),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+	}
+}
@@ -0,0 +1,106 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errNoJWTSecret = errors.New("JWT secret is not configured")
+var errMissingBearer = errors.New("missing bearer token")
+
+// Token audiences keep each kind of signed token from being accepted in
+// place of another.
+const (
+	accessAudience    = "access"
+	shareAudience     = "share"
+	twoFactorAudience = "2fa"
+	downloadAudience  = "download"
+)
+
+// AccessClaims is the payload carried by access tokens. SessionId is the
+// session the token was issued for, so connections it opened can be hung up
+// when that session ends.
+type AccessClaims struct {
+	Role      string `json:"role"`
+	SessionId string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// issueAccessToken signs a token for user and the session sessionId that
+// expires after cfg.AccessTokenTTL.
+func issueAccessToken(cfg *Config, user models.User, sessionId primitive.ObjectID) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(cfg.AccessTokenTTL)
+	claims := AccessClaims{
+		Role:      user.Role,
+		SessionId: sessionId.Hex(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.Id.Hex(),
+			Audience:  jwt.ClaimStrings{accessAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := signToken(cfg.JWTSecret, claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// session returns the id of the session the token was issued for, or the
+// zero id for a token that names none.
+func (claims *AccessClaims) session() primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(claims.SessionId)
+	return id
+}
+
+// parseAccessToken checks the signature and expiry of a token and returns its
+// claims.
+func parseAccessToken(secret []byte, raw string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	if err := parseToken(secret, raw, claims, accessAudience); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// signToken signs claims with secret, Config.JWTSecret, using HS256.
+func signToken(secret []byte, claims jwt.Claims) (string, error) {
+	if len(secret) == 0 {
+		return "", errNoJWTSecret
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// parseToken verifies raw into claims with secret, requiring an expiry and
+// the given audience.
+func parseToken(secret []byte, raw string, claims jwt.Claims, audience string) error {
+	if len(secret) == 0 {
+		return errNoJWTSecret
+	}
+
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithAudience(audience))
+	return err
+}
+
+// bearerClaims validates the Authorization: Bearer header and returns the
+// claims of the token.
+func bearerClaims(c *gin.Context, secret []byte) (*AccessClaims, error) {
+	header := c.GetHeader("Authorization")
+	raw, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || raw == "" {
+		return nil, errMissingBearer
+	}
+
+	return parseAccessToken(secret, raw)
+}
@@ -0,0 +1,74 @@
+package routes
+
+import (
+	"sync"
+	"time"
+)
+
+// failureLimiter counts failed attempts per key within a sliding window and
+// reports when a key has used up its allowance.
+type failureLimiter struct {
+	mu      sync.Mutex
+	max     int
+	window  time.Duration
+	entries map[string]*failureEntry
+}
+
+type failureEntry struct {
+	count int
+	first time.Time
+}
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{max: max, window: window, entries: map[string]*failureEntry{}}
+}
+
+// Blocked reports whether key has reached the failure limit, and if so how
+// long until it may try again.
+func (l *failureLimiter) Blocked(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok {
+		return false, 0
+	}
+
+	elapsed := time.Since(entry.first)
+	if elapsed >= l.window {
+		delete(l.entries, key)
+		return false, 0
+	}
+	return entry.count >= l.max, l.window - elapsed
+}
+
+// Fail records a failed attempt for key.
+func (l *failureLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := l.entries[key]
+	if !ok || now.Sub(entry.first) >= l.window {
+		l.entries[key] = &failureEntry{count: 1, first: now}
+		l.sweep(now)
+		return
+	}
+	entry.count++
+}
+
+// Reset forgets the failures recorded for key.
+func (l *failureLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// sweep drops expired entries so the map doesn't grow without bound.
+func (l *failureLimiter) sweep(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.first) >= l.window {
+			delete(l.entries, key)
+		}
+	}
+}
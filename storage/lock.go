@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LockCollection holds the leases background jobs take so a job runs on one
+// server instance at a time.
+var LockCollection string = "locks"
+
+// instanceId identifies this process as a lock holder.
+var instanceId = primitive.NewObjectID().Hex()
+
+// acquireLock takes the named lease for ttl if it is free or has lapsed. It
+// reports false, without an error, when another holder has it.
+func acquireLock(ctx context.Context, db *mongo.Database, name string, ttl time.Duration) (bool, error) {
+	collection := db.Collection(LockCollection)
+
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"holder": instanceId},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"holder": instanceId, "acquiredAt": now, "expiresAt": now.Add(ttl)}}
+
+	// A held lock doesn't match the filter, so the upsert collides with the
+	// existing _id instead of taking it over.
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseLock gives up the named lease if this instance still holds it.
+func releaseLock(ctx context.Context, db *mongo.Database, name string) error {
+	collection := db.Collection(LockCollection)
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": name, "holder": instanceId})
+	return err
+}
@@ -0,0 +1,155 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var LoginFailureCollection string = "loginFailures"
+
+// loginFailureKeys are the counters a login attempt counts against. The
+// email is used whether or not an account has it, so lockouts reveal
+// nothing about which addresses are registered.
+func loginFailureKeys(email string, ip string) []string {
+	return []string{"email:" + email, "ip:" + ip}
+}
+
+// loginLockedFor reports how long the longest active lockout among keys
+// still lasts, or zero when none is active.
+func loginLockedFor(ctx context.Context, db *mongo.Database, keys []string) (time.Duration, error) {
+	collection := db.Collection(LoginFailureCollection)
+
+	now := time.Now()
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": keys}, "lockedUntil": bson.M{"$gt": now}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var counters []struct {
+		LockedUntil time.Time `bson:"lockedUntil"`
+	}
+	if err := cursor.All(ctx, &counters); err != nil {
+		return 0, err
+	}
+
+	var longest time.Duration
+	for _, counter := range counters {
+		if d := counter.LockedUntil.Sub(now); d > longest {
+			longest = d
+		}
+	}
+	return longest, nil
+}
+
+// recordLoginFailure counts a failed login against key and returns whether
+// this failure started a lockout under policy. Counting restarts once the
+// window since the first failure has passed, and after every lockout.
+func recordLoginFailure(ctx context.Context, db *mongo.Database, policy LockoutConfig, key string) (bool, error) {
+	collection := db.Collection(LoginFailureCollection)
+
+	now := time.Now()
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"stale": bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$firstAt", time.Time{}}}, now.Add(-policy.Window)}}}}},
+		{{Key: "$set", Value: bson.M{
+			"count":   bson.M{"$cond": bson.A{"$stale", 1, bson.M{"$add": bson.A{"$count", 1}}}},
+			"firstAt": bson.M{"$cond": bson.A{"$stale", now, "$firstAt"}},
+		}}},
+		{{Key: "$set", Value: bson.M{"justLocked": bson.M{"$and": bson.A{
+			bson.M{"$gte": bson.A{"$count", policy.Threshold}},
+			bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$lockedUntil", time.Time{}}}, now}},
+		}}}}},
+		{{Key: "$set", Value: bson.M{
+			"lockedUntil": bson.M{"$cond": bson.A{"$justLocked", now.Add(policy.Cooldown), "$lockedUntil"}},
+			"count":       bson.M{"$cond": bson.A{"$justLocked", 0, "$count"}},
+		}}},
+		{{Key: "$set", Value: bson.M{"expiresAt": bson.M{"$max": bson.A{
+			bson.M{"$add": bson.A{"$firstAt", policy.Window.Milliseconds()}},
+			bson.M{"$ifNull": bson.A{"$lockedUntil", now}},
+		}}}}},
+		{{Key: "$unset", Value: "stale"}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		JustLocked bool `bson:"justLocked"`
+	}
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&counter)
+	if mongo.IsDuplicateKeyError(err) {
+		err = collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&counter)
+	}
+	return counter.JustLocked, err
+}
+
+// clearLoginFailures forgets the failures counted against keys.
+func clearLoginFailures(ctx context.Context, db *mongo.Database, keys []string) (int64, error) {
+	collection := db.Collection(LoginFailureCollection)
+	result, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// respondLoginLocked writes the 429 for a locked out login. It reads the
+// same for existing and unknown accounts.
+func respondLoginLocked(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	respondError(c, newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "too many failed login attempts, try again later"))
+}
+
+// countLoginFailure records a failed login for email from the caller's IP
+// and audits any lockout it starts.
+func countLoginFailure(ctx context.Context, db *mongo.Database, policy LockoutConfig, c *gin.Context, email string, userId primitive.ObjectID) error {
+	for _, key := range loginFailureKeys(email, c.ClientIP()) {
+		locked, err := recordLoginFailure(ctx, db, policy, key)
+		if err != nil {
+			return err
+		}
+		if locked {
+			auditAs(c, primitive.NilObjectID, AuditLoginLocked, auditTargetUser, userId, gin.H{
+				"key":         key,
+				"email":       email,
+				"lockedUntil": time.Now().Add(policy.Cooldown),
+			})
+		}
+	}
+	return nil
+}
+
+// ClearLockout handler lifts the login lockout of ?email= and/or ?ip=.
+func (ac *AdminController) ClearLockout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var keys []string
+		email := normalizeEmail(c.Query("email"))
+		if email != "" {
+			keys = append(keys, "email:"+email)
+		}
+		if ip := c.Query("ip"); ip != "" {
+			keys = append(keys, "ip:"+ip)
+		}
+		if len(keys) == 0 {
+			respondError(c, badRequest("email or ip is required"))
+			return
+		}
+
+		cleared, err := clearLoginFailures(ctx, ac.db, keys)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditLockoutCleared, auditTargetUser, primitive.NilObjectID, gin.H{"keys": keys})
+		c.JSON(http.StatusOK, gin.H{"message": "Lockout cleared", "cleared": cleared})
+	}
+}
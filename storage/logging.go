@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID in and out. An incoming value is
+// kept so one ID follows a request through proxies and retries.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds an incoming request ID; longer or non-printable
+// values are replaced.
+const maxRequestIDLength = 128
+
+// Logger writes the structured JSON log. Run also installs it as the default
+// for the log package, so the rest of the code logs through it too.
+var Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// requestIDKey and requestLoggerKey hold the request ID and the
+// request-scoped logger in the gin context.
+const (
+	requestIDKey     = "requestId"
+	requestLoggerKey = "logger"
+)
+
+// RequestLogger assigns every request an ID, echoes it in X-Request-ID and
+// logs one line per request once it has been handled. Only the route
+// template is logged, never the raw path, query or body, so share tokens,
+// passwords and other secrets in them never reach the log. Successful probes
+// are not logged.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Set(requestIDKey, id)
+		c.Set(requestLoggerKey, Logger.With("requestId", id))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < http.StatusBadRequest && containsString(probePaths, c.Request.URL.Path) {
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", route,
+			"status", status,
+			"latencyMs", time.Since(start).Milliseconds(),
+			"bytes", max(c.Writer.Size(), 0),
+			"clientIp", c.ClientIP(),
+		}
+		if userId := currentUserID(c); !userId.IsZero() {
+			attrs = append(attrs, "userId", userId.Hex())
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		requestLog(c).Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// requestLog returns the logger for the request, which tags every line with
+// its request ID. Outside RequestLogger it is the plain Logger.
+func requestLog(c *gin.Context) *slog.Logger {
+	if logger, ok := c.Get(requestLoggerKey); ok {
+		return logger.(*slog.Logger)
+	}
+	return Logger
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
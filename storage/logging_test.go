@@ -0,0 +1,136 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// captureLogs sends Logger, and the log package, to a buffer for the rest
+// of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	savedLogger, savedOutput := Logger, log.Writer()
+	Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		Logger = savedLogger
+		log.SetOutput(savedOutput)
+	})
+	return &buf
+}
+
+// requestLines returns the "request" lines of logs, decoded.
+func requestLines(t testing.TB, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, raw := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var line map[string]any
+		if json.Unmarshal([]byte(raw), &line) == nil && line["msg"] == "request" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestRequestLoggerIDs(t *testing.T) {
+	logs := captureLogs(t)
+	router := gin.New()
+	router.Use(RequestLogger())
+	router.GET("/files/:id", func(c *gin.Context) {
+		requestLog(c).Info("deep in the file code")
+		c.String(http.StatusOK, "hello")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/files/abc?token=secret", nil)
+	req.Header.Set(RequestIDHeader, "trace-1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Header().Get(RequestIDHeader) != "trace-1" {
+		t.Errorf("%s = %q, want the incoming ID echoed", RequestIDHeader, rec.Header().Get(RequestIDHeader))
+	}
+
+	var handlerLine map[string]any
+	json.Unmarshal([]byte(strings.SplitN(logs.String(), "\n", 2)[0]), &handlerLine)
+	if handlerLine["requestId"] != "trace-1" {
+		t.Errorf("handler logged %v, want it tagged with the request ID", handlerLine)
+	}
+	lines := requestLines(t, logs)
+	if len(lines) != 1 {
+		t.Fatalf("logged %d request lines, want 1: %s", len(lines), logs)
+	}
+	line := lines[0]
+	if line["route"] != "/files/:id" || line["status"] != float64(200) || line["bytes"] != float64(5) || line["requestId"] != "trace-1" {
+		t.Errorf("request line = %v", line)
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Error("the query string reached the log")
+	}
+
+	// A missing or unusable ID is replaced.
+	req = httptest.NewRequest(http.MethodGet, "/files/abc", nil)
+	req.Header.Set(RequestIDHeader, "has spaces")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if id := rec.Header().Get(RequestIDHeader); id == "has spaces" || !validRequestID(id) {
+		t.Errorf("%s = %q, want a generated ID", RequestIDHeader, id)
+	}
+}
+
+func TestLoginNeverLogsPassword(t *testing.T) {
+	mt := newMockDB(t)
+	const password = "correct horse battery"
+	hash, err := hashPassword("another password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Id: primitive.NewObjectID(), Email: "ada@example.com", Password: hash, Role: models.RoleUser}
+	login := func(mt *mtest.T) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(RequestLogger())
+		router.POST("/auth/login", NewAuthController(mt.DB, testConfig()).Login())
+		return doRequest(router, http.MethodPost, "/auth/login", gin.H{"email": user.Email, "password": password})
+	}
+	counted := mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.M{"_id": "key", "justLocked": false}})
+
+	mt.Run("wrong password", func(mt *mtest.T) {
+		logs := captureLogs(mt.T)
+		mt.AddMockResponses(found(mt, LoginFailureCollection), found(mt, UserCollection, user), counted, counted)
+		if rec := login(mt); rec.Code != http.StatusUnauthorized {
+			mt.Fatalf("status = %d, want 401: %s", rec.Code, rec.Body.String())
+		}
+
+		if lines := requestLines(mt, logs); len(lines) != 1 || lines[0]["route"] != "/auth/login" {
+			mt.Fatalf("request lines = %v, want the login's", lines)
+		}
+		if strings.Contains(logs.String(), password) {
+			mt.Errorf("password in the log: %s", logs)
+		}
+	})
+
+	mt.Run("database error", func(mt *mtest.T) {
+		logs := captureLogs(mt.T)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "unavailable"}))
+		if rec := login(mt); rec.Code != http.StatusInternalServerError {
+			mt.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body.String())
+		}
+
+		if !strings.Contains(logs.String(), "unavailable") {
+			mt.Errorf("the error was not logged: %s", logs)
+		}
+		if strings.Contains(logs.String(), password) {
+			mt.Errorf("password in the log: %s", logs)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mailRetryBase is the wait before the first retry; it doubles each time.
+const mailRetryBase = 5 * time.Second
+
+const (
+	mailFileShared    = "fileShared"
+	mailLinkShared    = "linkShared"
+	mailVerifyEmail   = "verifyEmail"
+	mailPasswordReset = "passwordReset"
+	mailFileRemoved   = "fileRemoved"
+	mailQuarantined   = "fileQuarantined"
+	mailInvitation    = "invitation"
+)
+
+type mailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// mailTemplates are the messages the mailer sends. The share templates are
+// rendered with a shareEmail.
+var mailTemplates = map[string]mailTemplate{
+	mailPasswordReset: {
+		subject: template.Must(template.New("subject").Parse(`Reset your password`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+
+Someone asked to reset the password of your account. Choose a new one here:
+
+{{.Link}}
+
+The link can be used once and expires on {{.ExpiresAt.Format "2 Jan 2006 15:04 MST"}}. If you didn't ask for this, ignore this email; your password stays the same.
+`)),
+	},
+	mailVerifyEmail: {
+		subject: template.Must(template.New("subject").Parse(`Verify your email address`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+
+Confirm your email address to start uploading and sharing files:
+
+{{.Link}}
+
+The link expires on {{.ExpiresAt.Format "2 Jan 2006 15:04 MST"}}. If you didn't create an account, ignore this email.
+`)),
+	},
+	mailFileShared: {
+		subject: template.Must(template.New("subject").Parse(`{{.SharerName}} shared "{{.FileName}}" with you`)),
+		body: template.Must(template.New("body").Parse(`Hi {{if .RecipientName}}{{.RecipientName}}{{else}}there{{end}},
+
+{{.SharerName}} gave you {{.Role}} access to "{{.FileName}}".
+
+Open it here: {{.Link}}
+`)),
+	},
+	mailInvitation: {
+		subject: template.Must(template.New("subject").Parse(`Your account is ready`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+
+An account has been created for you. Choose a password to start using it:
+
+{{.Link}}
+
+The link can be used once and expires on {{.ExpiresAt.Format "2 Jan 2006 15:04 MST"}}.
+`)),
+	},
+	mailFileRemoved: {
+		subject: template.Must(template.New("subject").Parse(`"{{.FileName}}" was removed from your account`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+
+An administrator removed your file "{{.FileName}}". Its share links no longer work.
+
+Reason: {{.Reason}}
+`)),
+	},
+	mailQuarantined: {
+		subject: template.Must(template.New("subject").Parse(`"{{.FileName}}" was quarantined`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+
+The virus scanner found {{.Signature}} in your file "{{.FileName}}". The file can no longer be downloaded or shared, and its share links no longer work.
+`)),
+	},
+	mailLinkShared: {
+		subject: template.Must(template.New("subject").Parse(`{{.SharerName}} sent you "{{.FileName}}"`)),
+		body: template.Must(template.New("body").Parse(`Hi {{if .RecipientName}}{{.RecipientName}}{{else}}there{{end}},
+
+{{.SharerName}} sent you a link to "{{.FileName}}".
+
+Download it here: {{.Link}}
+{{- if .ExpiresAt}}
+The link expires on {{.ExpiresAt.Format "2 Jan 2006 15:04 MST"}}.{{end}}
+{{- if .Protected}}
+The link is password protected; ask {{.SharerName}} for the password.{{end}}
+`)),
+	},
+}
+
+// shareEmail is a queued share notification. SharerName and RecipientName
+// are filled in by the mail worker.
+type shareEmail struct {
+	Template      string
+	SharerId      primitive.ObjectID
+	To            string
+	FileName      string
+	Link          string
+	Role          string
+	ExpiresAt     *time.Time
+	Protected     bool
+	SharerName    string
+	RecipientName string
+}
+
+// mailJob is a rendered-on-send message waiting in the mail queue.
+type mailJob struct {
+	to       string
+	template string
+	data     interface{}
+	attempts int
+}
+
+var shareEmails = make(chan shareEmail, thumbnailQueueSize)
+var mailJobs = make(chan mailJob, thumbnailQueueSize)
+
+// queueShareEmail hands a share notification to the mail worker without
+// waiting.
+func queueShareEmail(email shareEmail) {
+	select {
+	case shareEmails <- email:
+	default:
+		log.Printf("mail queue full, dropping %s notification", email.Template)
+	}
+}
+
+// queueMail hands a message to the mail worker without waiting.
+func queueMail(to string, name string, data interface{}) {
+	enqueueMailJob(mailJob{to: to, template: name, data: data})
+}
+
+func enqueueMailJob(job mailJob) {
+	select {
+	case mailJobs <- job:
+	default:
+		log.Printf("mail queue full, dropping %s mail", job.template)
+	}
+}
+
+// StartMailer sends queued mail through cfg.SMTP until ctx is cancelled.
+func StartMailer(ctx context.Context, db *mongo.Database, cfg *Config) {
+	startWorker(func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case email := <-shareEmails:
+				prepareShareEmail(ctx, db, email)
+			case job := <-mailJobs:
+				sendMailJob(job, cfg.SMTP)
+			}
+		}
+	})
+}
+
+// prepareShareEmail fills in the names of a share notification and queues
+// it, skipping recipients who turned share emails off.
+func prepareShareEmail(ctx context.Context, db *mongo.Database, email shareEmail) {
+	users := db.Collection(UserCollection)
+	projection := options.FindOne().SetProjection(bson.M{"name": 1, "disableShareEmails": 1})
+
+	var recipient models.User
+	err := users.FindOne(ctx, bson.M{"email": normalizeEmail(email.To)}, projection).Decode(&recipient)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("loading mail recipient failed: %v", err)
+		return
+	}
+	if recipient.DisableShareEmails {
+		return
+	}
+	email.RecipientName = recipient.Name
+
+	var sharer models.User
+	if err := users.FindOne(ctx, bson.M{"_id": email.SharerId}, projection).Decode(&sharer); err != nil {
+		log.Printf("loading sharer %s for mail failed: %v", email.SharerId.Hex(), err)
+		return
+	}
+	email.SharerName = sharer.Name
+
+	queueMail(email.To, email.Template, email)
+}
+
+// sendMailJob sends job through server, retrying failures later with a
+// growing delay.
+func sendMailJob(job mailJob, server SMTPConfig) {
+	err := sendTemplate(server, job.to, job.template, job.data)
+	if err == nil {
+		return
+	}
+
+	job.attempts++
+	if job.attempts >= server.MaxAttempts {
+		log.Printf("sending %s mail failed after %d attempts: %v", job.template, job.attempts, err)
+		return
+	}
+	log.Printf("sending %s mail failed, retrying: %v", job.template, err)
+	time.AfterFunc(mailRetryBase<<(job.attempts-1), func() { enqueueMailJob(job) })
+}
+
+// sendTemplate renders the named template with data and sends it to to.
+func sendTemplate(server SMTPConfig, to string, name string, data interface{}) error {
+	tmpl := mailTemplates[name]
+
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return err
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return err
+	}
+	return sendMail(server, to, subject.String(), body.String())
+}
+
+// sendMail sends a plain text message through server. Mail is only logged
+// while server has no Host.
+func sendMail(server SMTPConfig, to string, subject string, body string) error {
+	to = headerSafe(to)
+	if server.Host == "" {
+		log.Printf("SMTP_HOST not set, not sending %q to %s", subject, to)
+		return nil
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", headerSafe(server.From))
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerSafe(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if server.Username != "" {
+		auth = smtp.PlainAuth("", server.Username, server.Password, server.Host)
+	}
+	addr := net.JoinHostPort(server.Host, strconv.Itoa(server.Port))
+	return smtp.SendMail(addr, auth, server.From, []string{to}, msg.Bytes())
+}
+
+// headerSafe strips line breaks so values can't inject extra headers.
+func headerSafe(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
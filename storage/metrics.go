@@ -0,0 +1,302 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// metricsContentType is the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are the histogram bounds, in seconds, for request and
+// GridFS durations.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metric is one family in the exposition.
+type metric interface {
+	write(ctx context.Context, w io.Writer)
+}
+
+var registeredMetrics []metric
+
+// labelSep joins label values into a series key.
+const labelSep = "\xff"
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name string, help string, labels ...string) *counterVec {
+	m := &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	registeredMetrics = append(registeredMetrics, m)
+	return m
+}
+
+func (m *counterVec) add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSep)
+	m.mu.Lock()
+	m.values[key] += v
+	m.mu.Unlock()
+}
+
+func (m *counterVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *counterVec) write(ctx context.Context, w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, m.name, m.help, "counter")
+	if len(m.labels) == 0 {
+		// An unlabelled counter is reported even before it first moves.
+		fmt.Fprintf(w, "%s %s\n", m.name, formatMetricValue(m.values[""]))
+		return
+	}
+	for _, key := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, key, "", ""), formatMetricValue(m.values[key]))
+	}
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	m := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	registeredMetrics = append(registeredMetrics, m)
+	return m
+}
+
+func (m *histogramVec) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSep)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	for i, bound := range m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// since observes the seconds elapsed since start.
+func (m *histogramVec) since(start time.Time, labelValues ...string) {
+	m.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (m *histogramVec) write(ctx context.Context, w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, m.name, m.help, "histogram")
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, key, "le", formatMetricValue(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, key, "", ""), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, key, "", ""), s.count)
+	}
+}
+
+// gaugeFunc is a gauge whose value is read when /metrics is scraped.
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func(ctx context.Context) (float64, error)
+}
+
+func newGaugeFunc(name string, help string, value func(ctx context.Context) (float64, error)) *gaugeFunc {
+	m := &gaugeFunc{name: name, help: help, value: value}
+	registeredMetrics = append(registeredMetrics, m)
+	return m
+}
+
+func (m *gaugeFunc) write(ctx context.Context, w io.Writer) {
+	if m.value == nil {
+		return
+	}
+	v, err := m.value(ctx)
+	if err != nil {
+		log.Printf("metric %s: %v", m.name, err)
+		return
+	}
+	writeMetricHeader(w, m.name, m.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", m.name, formatMetricValue(v))
+}
+
+func writeMetricHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatLabels(names []string, key string, extraName string, extraValue string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, labelSep) {
+			pairs = append(pairs, names[i]+"="+strconv.Quote(value))
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+"="+strconv.Quote(extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	httpRequests = newCounter("filesharing_http_requests_total",
+		"HTTP requests handled, by method, route template and status.", "method", "route", "status")
+	httpRequestDuration = newHistogram("filesharing_http_request_duration_seconds",
+		"Time to handle an HTTP request, by method, route template and status.", latencyBuckets, "method", "route", "status")
+	uploadedBytes = newCounter("filesharing_uploaded_bytes_total",
+		"File content bytes stored by uploads.")
+	downloadedBytes = newCounter("filesharing_downloaded_bytes_total",
+		"File content bytes sent by downloads, previews and archives.")
+	gridfsDuration = newHistogram("filesharing_gridfs_operation_duration_seconds",
+		"Time taken by GridFS operations, by operation.", latencyBuckets, "operation")
+	s3Duration = newHistogram("filesharing_s3_operation_duration_seconds",
+		"Time taken by S3 operations, by operation.", latencyBuckets, "operation")
+	jobRuns = newCounter("filesharing_job_runs_total",
+		"Background job runs, by job.", "job")
+	jobFailures = newCounter("filesharing_job_failures_total",
+		"Background job runs that failed, by job.", "job")
+	activeUploadSessions = newGaugeFunc("filesharing_active_upload_sessions",
+		"Resumable uploads started and not yet completed or expired.", nil)
+	scanBacklog = newGaugeFunc("filesharing_scan_backlog",
+		"Files waiting for a virus scan.", nil)
+)
+
+// recordJob counts one run of a background job, and its failure if err is
+// set.
+func recordJob(job string, err error) {
+	jobRuns.inc(job)
+	if err != nil {
+		jobFailures.inc(job)
+	}
+}
+
+// Metrics counts and times every request by its route template, e.g.
+// /files/:id, so the label set stays bounded however many ids are requested.
+// Requests that match no route are labelled "unmatched".
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.inc(c.Request.Method, route, status)
+		httpRequestDuration.since(start, c.Request.Method, route, status)
+	}
+}
+
+type MetricsController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewMetricsController(db *mongo.Database, cfg *Config) *MetricsController {
+	return &MetricsController{db, cfg}
+}
+
+// SetupRouter function
+func (mc *MetricsController) BasicRoute(router *gin.RouterGroup) {
+	activeUploadSessions.value = func(ctx context.Context) (float64, error) {
+		countCtx, cancel := context.WithTimeout(ctx, ReadinessTimeout)
+		defer cancel()
+		n, err := mc.db.Collection(UploadSessionCollection).CountDocuments(countCtx, bson.M{"expiresAt": bson.M{"$gt": time.Now()}})
+		return float64(n), err
+	}
+	scanBacklog.value = func(ctx context.Context) (float64, error) {
+		countCtx, cancel := context.WithTimeout(ctx, ReadinessTimeout)
+		defer cancel()
+		n, err := mc.db.Collection(FileCollection).CountDocuments(countCtx, bson.M{"scanStatus": models.ScanScanning})
+		return float64(n), err
+	}
+
+	if mc.cfg.MetricsPublic {
+		router.GET("/metrics", mc.GetMetrics())
+		return
+	}
+	router.GET("/metrics", AuthRequired(mc.db, mc.cfg), RequireScope(mc.cfg, scopeAdmin), RequireRole(models.RoleAdmin), mc.GetMetrics())
+}
+
+// GetMetrics handler writes every metric in the Prometheus text format.
+func (mc *MetricsController) GetMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", metricsContentType)
+		c.Status(http.StatusOK)
+		for _, m := range registeredMetrics {
+			m.write(c.Request.Context(), c.Writer)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuthRequired rejects requests without a valid bearer token or API key and
+// stores the caller's id in the context under "userID".
+func AuthRequired(db *mongo.Database, cfg *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Set by DownloadTokenAuth on the download route.
+		if c.GetBool("downloadToken") {
+			c.Next()
+			return
+		}
+
+		if key := c.GetHeader(apiKeyHeader); key != "" {
+			authenticateAPIKey(c, db, key)
+			return
+		}
+
+		claims, err := bearerClaims(c, cfg.JWTSecret)
+		if err != nil {
+			respondError(c, unauthorized(err.Error()))
+			return
+		}
+
+		userId, err := primitive.ObjectIDFromHex(claims.Subject)
+		if err != nil {
+			respondError(c, unauthorized("invalid token subject"))
+			return
+		}
+
+		if !requireActiveAccount(c, db, userId) {
+			return
+		}
+
+		c.Set("userID", userId)
+		c.Set("role", claims.Role)
+		c.Set("sessionID", claims.session())
+		c.Next()
+	}
+}
+
+// RequireRole rejects callers whose token does not carry the given role. It
+// must run after AuthRequired.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != role {
+			respondError(c, forbidden("insufficient permissions"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// currentUserID returns the id stored by AuthRequired.
+func currentUserID(c *gin.Context) primitive.ObjectID {
+	userId, _ := c.Get("userID")
+	id, _ := userId.(primitive.ObjectID)
+	return id
+}
+
+// currentSessionID returns the session the caller's access token was issued
+// for, or the zero id for API keys and tokens that name none.
+func currentSessionID(c *gin.Context) primitive.ObjectID {
+	sessionId, _ := c.Get("sessionID")
+	id, _ := sessionId.(primitive.ObjectID)
+	return id
+}
+
+// isAdmin reports whether the authenticated caller has the admin role.
+func isAdmin(c *gin.Context) bool {
+	return c.GetString("role") == models.RoleAdmin
+}
+
+// authorizeUser reports whether the caller may act on the user with the given
+// id, writing a 403 response when it may not. Admins may act on anyone.
+func authorizeUser(c *gin.Context, id primitive.ObjectID) bool {
+	if currentUserID(c) == id || isAdmin(c) {
+		return true
+	}
+
+	respondError(c, forbidden("not allowed to access this user"))
+	return false
+}
+
+// OptionalAuth behaves like AuthRequired when a bearer token or API key is
+// present and lets anonymous requests through otherwise.
+func OptionalAuth(db *mongo.Database, cfg *Config) gin.HandlerFunc {
+	authRequired := AuthRequired(db, cfg)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" && c.GetHeader(apiKeyHeader) == "" {
+			c.Next()
+			return
+		}
+
+		authRequired(c)
+	}
+}
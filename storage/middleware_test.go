@@ -0,0 +1,136 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// authRouter answers GET / behind AuthRequired with the caller it found.
+func authRouter(mt *mtest.T, cfg *Config) *gin.Engine {
+	router := gin.New()
+	router.GET("/", AuthRequired(mt.DB, cfg), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"userId": currentUserID(c), "role": c.GetString("role")})
+	})
+	return router
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestAuthRequired(t *testing.T) {
+	mt := newMockDB(t)
+	cfg := testConfig()
+	user := models.User{Id: primitive.NewObjectID(), Role: models.RoleAdmin}
+	valid, _, err := issueAccessToken(cfg, user, primitive.NewObjectID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := signToken(cfg.JWTSecret, AccessClaims{
+		Role: user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.Id.Hex(),
+			Audience:  jwt.ClaimStrings{accessAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := valid[:len(valid)-2] + "xx"
+	if tampered == valid {
+		tampered = valid[:len(valid)-2] + "yy"
+	}
+
+	for name, token := range map[string]string{"missing header": "", "expired": expired, "tampered signature": tampered, "not a JWT": "abc"} {
+		mt.Run(name, func(mt *mtest.T) {
+			rec := httptest.NewRecorder()
+			authRouter(mt, cfg).ServeHTTP(rec, bearerRequest(token))
+
+			if rec.Code != http.StatusUnauthorized {
+				mt.Fatalf("status = %d, want 401", rec.Code)
+			}
+			if body := decodeError(mt.T, rec); body.Error.Code != ErrCodeUnauthorized {
+				mt.Errorf("code = %q, want %q", body.Error.Code, ErrCodeUnauthorized)
+			}
+			if len(mt.GetAllStartedEvents()) != 0 {
+				mt.Error("an invalid token got as far as the database")
+			}
+		})
+	}
+
+	mt.Run("valid", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, bson.M{"_id": user.Id, "status": models.UserStatusActive}))
+		rec := httptest.NewRecorder()
+		authRouter(mt, cfg).ServeHTTP(rec, bearerRequest(valid))
+
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if body := decodeJSON(mt, rec); body["userId"] != user.Id.Hex() || body["role"] != models.RoleAdmin {
+			mt.Errorf("caller = %v, want the token's user and role", body)
+		}
+	})
+
+	mt.Run("suspended", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, bson.M{"_id": user.Id, "status": models.UserStatusSuspended}))
+		rec := httptest.NewRecorder()
+		authRouter(mt, cfg).ServeHTTP(rec, bearerRequest(valid))
+
+		if rec.Code != http.StatusForbidden {
+			mt.Errorf("status = %d, want 403", rec.Code)
+		}
+	})
+
+	mt.Run("deleted", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection))
+		rec := httptest.NewRecorder()
+		authRouter(mt, cfg).ServeHTTP(rec, bearerRequest(valid))
+
+		if rec.Code != http.StatusUnauthorized {
+			mt.Errorf("status = %d, want 401", rec.Code)
+		}
+	})
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	self, other := primitive.NewObjectID(), primitive.NewObjectID()
+	tests := []struct {
+		name   string
+		caller primitive.ObjectID
+		role   string
+		want   bool
+	}{
+		{"self", self, models.RoleUser, true},
+		{"someone else", other, models.RoleUser, false},
+		{"admin", other, models.RoleAdmin, true},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPatch, "/", nil)
+		c.Set("userID", tt.caller)
+		c.Set("role", tt.role)
+
+		if got := authorizeUser(c, self); got != tt.want {
+			t.Errorf("%s: authorizeUser = %v, want %v", tt.name, got, tt.want)
+		}
+		if !tt.want && rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403", tt.name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newMockDB returns an mtest.T whose subtests get a mt.DB that answers each
+// command, in order, with the next response queued by mt.AddMockResponses.
+// Nothing is stored: a test queues what the server would have said.
+func newMockDB(t *testing.T) *mtest.T {
+	return mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+}
+
+// found is the server's answer to a find, or a FindOne, matching docs in
+// collection.
+func found(t testing.TB, collection string, docs ...any) bson.D {
+	t.Helper()
+	batch := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		batch = append(batch, bsonDoc(t, doc))
+	}
+	return mtest.CreateCursorResponse(0, "test."+collection, mtest.FirstBatch, batch...)
+}
+
+// bsonDoc converts v to the document Mongo would hold for it.
+func bsonDoc(t testing.TB, v any) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// duplicateKey is the server's answer to a write that breaks a unique index.
+func duplicateKey() bson.D {
+	return mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "E11000 duplicate key error"})
+}
+
+// sentCommand returns the first command named name that mt's client sent.
+func sentCommand(mt *mtest.T, name string) bson.Raw {
+	mt.Helper()
+	for _, event := range mt.GetAllStartedEvents() {
+		if event.CommandName == name {
+			return event.Command
+		}
+	}
+	mt.Fatalf("no %s command was sent", name)
+	return nil
+}
+
+// asUser stands in for AuthRequired, authenticating every request as userId
+// with role.
+func asUser(userId primitive.ObjectID, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("userID", userId)
+		c.Set("role", role)
+	}
+}
+
+// doRequest sends a request with body, JSON-encoded unless nil, to router and
+// returns the recorded response.
+func doRequest(router http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeJSON decodes rec's body into a map.
+func decodeJSON(t testing.TB, rec *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return body
+}
+
+// Wire protocol opcodes spoken by stallingMongo.
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+// stallingMongo is a MongoDB server that completes the handshake and answers
+// every command with ok, except the one named stall: that it never answers,
+// as a server stuck on a slow query, and sends it on stalled instead.
+type stallingMongo struct {
+	stall   string
+	stalled chan bson.Raw
+	addr    string
+}
+
+func newStallingMongo(t *testing.T, stall string) *stallingMongo {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &stallingMongo{stall: stall, stalled: make(chan bson.Raw, 1), addr: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// client connects to s, disconnecting when the test ends.
+func (s *stallingMongo) client(t *testing.T) *mongo.Client {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://"+s.addr).SetDirect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		client.Disconnect(ctx)
+	})
+	return client
+}
+
+func (s *stallingMongo) serve(conn net.Conn) {
+	defer conn.Close()
+	hello, _ := bson.Marshal(bson.D{
+		{Key: "ismaster", Value: true}, {Key: "isWritablePrimary", Value: true}, {Key: "helloOk", Value: true},
+		{Key: "minWireVersion", Value: 0}, {Key: "maxWireVersion", Value: 17},
+		{Key: "maxBsonObjectSize", Value: 16 << 20}, {Key: "maxMessageSizeBytes", Value: 48000000}, {Key: "maxWriteBatchSize", Value: 100000},
+		{Key: "ok", Value: 1},
+	})
+	ok, _ := bson.Marshal(bson.D{{Key: "ok", Value: 1}})
+
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header)-16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		requestId, opCode := binary.LittleEndian.Uint32(header[4:]), binary.LittleEndian.Uint32(header[12:])
+
+		var command bson.Raw
+		switch opCode {
+		case opQuery:
+			// flags, the collection name and the skip and limit come first.
+			name := bytes.IndexByte(body[4:], 0)
+			command = bson.Raw(body[4+name+1+8:])
+		case opMsg:
+			// flags, then a body section of kind 0.
+			command = bson.Raw(body[5:])
+		default:
+			return
+		}
+		elem, err := command.IndexErr(0)
+		if err != nil {
+			return
+		}
+
+		reply := ok
+		switch name := elem.Key(); name {
+		case "hello", "isMaster", "ismaster":
+			reply = hello
+		case s.stall:
+			s.stalled <- command
+			continue
+		}
+
+		var out []byte
+		if opCode == opQuery {
+			out = binary.LittleEndian.AppendUint32(out, 0) // flags
+			out = binary.LittleEndian.AppendUint64(out, 0) // cursor id
+			out = binary.LittleEndian.AppendUint32(out, 0) // starting from
+			out = binary.LittleEndian.AppendUint32(out, 1) // documents returned
+			out = append(out, reply...)
+			opCode = opReply
+		} else {
+			out = binary.LittleEndian.AppendUint32(out, 0) // flags
+			out = append(out, 0)                           // body section
+			out = append(out, reply...)
+		}
+		msg := binary.LittleEndian.AppendUint32(nil, uint32(16+len(out)))
+		msg = binary.LittleEndian.AppendUint32(msg, requestId+1<<16)
+		msg = binary.LittleEndian.AppendUint32(msg, requestId)
+		msg = binary.LittleEndian.AppendUint32(msg, opCode)
+		if _, err := conn.Write(append(msg, out...)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	onConflictError  = "error"
+	onConflictRename = "rename"
+	// onConflictSkip leaves an entry where it is; batch moves only.
+	onConflictSkip = "skip"
+	// onConflictOverwrite makes an upload a new version of the file whose
+	// name it takes; uploads only.
+	onConflictOverwrite = "overwrite"
+)
+
+// maxNameSuffix bounds the " (n)" suffixes tried before giving up on finding a
+// free name.
+const maxNameSuffix = 1000
+
+var errNameConflict = errors.New("a file with this name already exists in the target folder")
+var errInvalidOnConflict = errors.New("onConflict must be \"" + onConflictError + "\" or \"" + onConflictRename + "\"")
+var errInvalidUploadOnConflict = errors.New("onConflict must be \"" + onConflictRename + "\", \"" + onConflictOverwrite + "\" or \"" + onConflictError + "\"")
+
+// NameConflictError is errNameConflict for an upload that was refused the
+// name of ExistingId.
+type NameConflictError struct {
+	ExistingId primitive.ObjectID
+}
+
+func (e *NameConflictError) Error() string {
+	return errNameConflict.Error()
+}
+
+func (e *NameConflictError) Unwrap() error {
+	return errNameConflict
+}
+
+// parseOnConflict reads ?onConflict=, defaulting to def.
+func parseOnConflict(c *gin.Context, def string) (string, error) {
+	switch mode := c.DefaultQuery("onConflict", def); mode {
+	case onConflictError, onConflictRename:
+		return mode, nil
+	default:
+		return "", errInvalidOnConflict
+	}
+}
+
+// parseUploadOnConflict reads the ?onConflict= of an upload, defaulting to
+// def, Config.UploadOnConflict.
+func parseUploadOnConflict(c *gin.Context, def string) (string, error) {
+	switch mode := c.DefaultQuery("onConflict", def); mode {
+	case onConflictRename, onConflictOverwrite, onConflictError:
+		return mode, nil
+	default:
+		return "", errInvalidUploadOnConflict
+	}
+}
+
+// respondSaveError writes the response for a saveUploadedFile failure: 409
+// naming the file in the way for a name conflict, 500 otherwise.
+func respondSaveError(c *gin.Context, err error) {
+	var nameConflict *NameConflictError
+	if errors.As(err, &nameConflict) {
+		respondError(c, conflict(err.Error()).withDetails(gin.H{"existingId": nameConflict.ExistingId}))
+		return
+	}
+	respondError(c, orNameConflict(err))
+}
+
+// orNameConflict turns errNameConflict into a 409 and passes any other error
+// through.
+func orNameConflict(err error) error {
+	if errors.Is(err, errNameConflict) {
+		return conflict(err.Error())
+	}
+	return err
+}
+
+// sanitizeFileName reduces a client supplied file name to its last path
+// segment and trims it, rejecting names that end up empty.
+func sanitizeFileName(name string) (string, error) {
+	if i := strings.LastIndexAny(name, "/\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	return cleanName(name)
+}
+
+// suffixedName inserts " (n)" before the extension of name, so "report.pdf"
+// becomes "report (1).pdf". Dotfiles such as ".env" are suffixed at the end.
+func suffixedName(name string, n int) string {
+	ext := path.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// freeName saves an entry under name or, with onConflictRename, under the
+// first of its " (n)" variants that isn't taken, and returns the name used.
+// save writes the entry under the name it is passed and fails with a
+// duplicate key error, or errNameConflict, when that name is taken; with a
+// unique index behind it, concurrent saves of one name can't both succeed.
+// Names in taken are skipped. Any other error from save is returned as is.
+func freeName(name string, mode string, taken map[string]bool, save func(name string) error) (string, error) {
+	candidate := name
+	for n := 1; n <= maxNameSuffix; n++ {
+		if !taken[candidate] {
+			err := save(candidate)
+			if err == nil {
+				return candidate, nil
+			}
+			if !mongo.IsDuplicateKeyError(err) && !errors.Is(err, errNameConflict) {
+				return "", err
+			}
+		}
+		if mode != onConflictRename {
+			return "", errNameConflict
+		}
+		candidate = suffixedName(name, n)
+	}
+	return "", errNameConflict
+}
+
+// saveFileName runs save, which writes a file of ownerId into folderId under
+// the name it is passed, through freeName. A name held only by a file that
+// has expired but that expireFiles hasn't removed yet is released and tried
+// again, since that file is hidden already.
+func saveFileName(ctx context.Context, files *mongo.Collection, ownerId primitive.ObjectID, folderId *primitive.ObjectID, name string, mode string, taken map[string]bool, save func(name string) error) (string, error) {
+	return freeName(name, mode, taken, func(candidate string) error {
+		err := save(candidate)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		released, releaseErr := releaseExpiredName(ctx, files, ownerId, folderId, candidate)
+		if releaseErr != nil {
+			return releaseErr
+		}
+		if !released {
+			return err
+		}
+		return save(candidate)
+	})
+}
+
+// freeFolderName is freeName for folders of ownerId in parentId, which have
+// no unique index on their names: a name counts as taken while another
+// folder there has it.
+func freeFolderName(ctx context.Context, folders *mongo.Collection, ownerId primitive.ObjectID, parentId *primitive.ObjectID, name string, mode string, taken map[string]bool) (string, error) {
+	return freeName(name, mode, taken, func(candidate string) error {
+		count, err := folders.CountDocuments(ctx, bson.M{"ownerId": ownerId, "parentId": parentId, "name": candidate})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errNameConflict
+		}
+		return nil
+	})
+}
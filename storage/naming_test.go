@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// uniqueNames stands in for a unique name index: saving a name that is
+// already there fails with a duplicate key error.
+type uniqueNames struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (u *uniqueNames) save(name string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.names[name] {
+		return mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	}
+	u.names[name] = true
+	return nil
+}
+
+func TestFreeName(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  string
+		held  []string
+		taken map[string]bool
+		want  string
+		err   error
+	}{
+		{"free", onConflictError, nil, nil, "report.pdf", nil},
+		{"held", onConflictError, []string{"report.pdf"}, nil, "", errNameConflict},
+		{"held, skip", onConflictSkip, []string{"report.pdf"}, nil, "", errNameConflict},
+		{"rename", onConflictRename, []string{"report.pdf", "report (1).pdf"}, nil, "report (2).pdf", nil},
+		{"taken in batch", onConflictRename, nil, map[string]bool{"report.pdf": true}, "report (1).pdf", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := &uniqueNames{names: map[string]bool{}}
+			for _, name := range tt.held {
+				index.names[name] = true
+			}
+			got, err := freeName("report.pdf", tt.mode, tt.taken, index.save)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("freeName = %q, %v; want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestFreeNamePassesOtherErrors(t *testing.T) {
+	failure := errors.New("connection reset")
+	calls := 0
+	_, err := freeName("a.txt", onConflictRename, nil, func(string) error {
+		calls++
+		return failure
+	})
+	if err != failure || calls != 1 {
+		t.Errorf("got %v after %d saves, want the save error after one", err, calls)
+	}
+}
+
+func TestFreeNameConcurrentRenames(t *testing.T) {
+	index := &uniqueNames{names: map[string]bool{}}
+	const uploads = 20
+
+	var wg sync.WaitGroup
+	names := make([]string, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name, err := freeName("report.pdf", onConflictRename, nil, index.save)
+			if err != nil {
+				t.Error(err)
+			}
+			names[i] = name
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			t.Fatalf("two uploads were given %q", name)
+		}
+		seen[name] = true
+	}
+	for n := 1; n < uploads; n++ {
+		if !seen[fmt.Sprintf("report (%d).pdf", n)] {
+			t.Errorf("suffix %d was skipped: %v", n, names)
+		}
+	}
+}
+
+func TestSuffixedName(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":     "report (3).pdf",
+		"archive.tar.gz": "archive.tar (3).gz",
+		".env":           ".env (3)",
+		"README":         "README (3)",
+	}
+	for name, want := range tests {
+		if got := suffixedName(name, 3); got != want {
+			t.Errorf("suffixedName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRespondSaveError(t *testing.T) {
+	existing := primitive.NewObjectID()
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&NameConflictError{ExistingId: existing}, http.StatusConflict, ErrCodeConflict},
+		{errNameConflict, http.StatusConflict, ErrCodeConflict},
+		{errors.New("insert failed"), http.StatusInternalServerError, ErrCodeInternal},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/files", nil)
+
+		respondSaveError(c, tt.err)
+
+		if rec.Code != tt.status {
+			t.Errorf("%v: status = %d, want %d", tt.err, rec.Code, tt.status)
+			continue
+		}
+		body := decodeError(t, rec)
+		if body.Error.Code != tt.code {
+			t.Errorf("%v: code = %q, want %q", tt.err, body.Error.Code, tt.code)
+		}
+		if _, ok := tt.err.(*NameConflictError); ok && body.Error.Details["existingId"] != existing.Hex() {
+			t.Errorf("details = %v, want existingId %s", body.Error.Details, existing.Hex())
+		}
+	}
+}
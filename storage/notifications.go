@@ -0,0 +1,244 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var NotificationCollection string = "notifications"
+
+// NotificationFileShared is the notification a user gets when a file is
+// shared with them. A file's scan verdict is sent as EventFileScanned.
+const NotificationFileShared = "file.shared"
+
+const (
+	// notificationHeartbeat is how often an idle stream gets a comment, so
+	// proxies don't time it out and dead clients are noticed.
+	notificationHeartbeat = 15 * time.Second
+	// notificationRetry is how long clients wait before reconnecting.
+	notificationRetry = 3 * time.Second
+	// notificationBackfillLimit caps the notifications replayed to a client
+	// resuming a stream; it can list the rest with GET /notifications.
+	notificationBackfillLimit = 500
+)
+
+// notify stores a notification for userId and pushes it to their streams
+// and WebSockets. A notification that can't be stored is logged rather than
+// failing whatever caused it. It is kept for cfg.NotificationTTL.
+func notify(ctx context.Context, db *mongo.Database, cfg *Config, userId primitive.ObjectID, notificationType string, data gin.H) {
+	now := time.Now()
+	notification := models.Notification{
+		Id:        primitive.NewObjectID(),
+		UserId:    userId,
+		Type:      notificationType,
+		Data:      data,
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.NotificationTTL),
+	}
+	if _, err := db.Collection(NotificationCollection).InsertOne(ctx, notification); err != nil {
+		log.Printf("storing %s notification for user %s failed: %v", notificationType, userId.Hex(), err)
+		return
+	}
+
+	events.publish(userId, notificationEvent(notification))
+}
+
+func notificationEvent(notification models.Notification) event {
+	return event{Id: notification.Id.Hex(), Type: notification.Type, Time: notification.CreatedAt, Data: notification.Data}
+}
+
+type NotificationController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewNotificationController(db *mongo.Database, cfg *Config) *NotificationController {
+	return &NotificationController{db, cfg}
+}
+
+// SetupRouter function
+func (nc *NotificationController) BasicRoute(router *gin.RouterGroup) {
+	notificationRouter := router.Group("/notifications", AuthRequired(nc.db, nc.cfg), RequireScope(nc.cfg, scopeAccount))
+	notificationRouter.GET("/", nc.GetNotifications())
+	notificationRouter.GET("/stream", nc.StreamNotifications())
+	notificationRouter.POST("/:id/read", nc.MarkRead())
+}
+
+// GetNotifications handler lists the caller's notifications, newest first,
+// or only the unread ones with ?unread=true.
+func (nc *NotificationController) GetNotifications() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := nc.db.Collection(NotificationCollection)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter := bson.M{"userId": currentUserID(c)}
+		if c.Query("unread") == "true" {
+			filter["readAt"] = bson.M{"$exists": false}
+		}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		notifications := []models.Notification{}
+		opts := page.FindOptions().SetSort(bson.D{{Key: "_id", Value: -1}})
+		if err := findAll(ctx, collection, filter, &notifications, opts); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, page.Result(notifications, total))
+	}
+}
+
+// MarkRead handler marks one of the caller's notifications read. Marking it
+// again keeps the time it was first read.
+func (nc *NotificationController) MarkRead() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := nc.db.Collection(NotificationCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid notification ID"))
+			return
+		}
+
+		update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"readAt": bson.M{"$ifNull": bson.A{"$readAt", time.Now()}}}}}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var notification models.Notification
+		if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": objId, "userId": currentUserID(c)}, update, opts).Decode(&notification); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Notification not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+// StreamNotifications handler sends the caller's notifications as
+// Server-Sent Events while the client stays connected, each with its id.
+// A client reconnecting with Last-Event-ID, or ?lastEventId=, first gets
+// what it missed. Streams are cut at Config.StreamTimeout like other long
+// responses; clients reconnect and resume.
+func (nc *NotificationController) StreamNotifications() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := nc.db.Collection(NotificationCollection)
+		userId := currentUserID(c)
+
+		var lastId primitive.ObjectID
+		raw := c.GetHeader("Last-Event-ID")
+		if raw == "" {
+			raw = c.Query("lastEventId")
+		}
+		if raw != "" {
+			id, err := primitive.ObjectIDFromHex(raw)
+			if err != nil {
+				respondError(c, badRequest("Invalid Last-Event-ID"))
+				return
+			}
+			lastId = id
+		}
+
+		// Subscribing before looking up the backlog means nothing stored in
+		// between is missed; whatever arrives both ways is sent once.
+		sub := events.subscribe(userId, currentSessionID(c), true)
+		if sub == nil {
+			respondError(c, unavailable(errShuttingDown.Error()))
+			return
+		}
+		defer events.unsubscribe(sub)
+
+		backlog := []models.Notification{}
+		if !lastId.IsZero() {
+			opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(notificationBackfillLimit)
+			if err := findAll(ctx, collection, bson.M{"userId": userId, "_id": bson.M{"$gt": lastId}}, &backlog, opts); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		w := c.Writer
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", notificationRetry.Milliseconds()); err != nil {
+			return
+		}
+		for _, notification := range backlog {
+			encoded, err := json.Marshal(notificationEvent(notification))
+			if err != nil {
+				continue
+			}
+			if err := writeServerSentEvent(w, eventMessage{id: notification.Id.Hex(), eventType: notification.Type, json: encoded}); err != nil {
+				return
+			}
+			lastId = notification.Id
+		}
+		w.Flush()
+
+		heartbeat := time.NewTicker(notificationHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.done:
+				return
+			case message := <-sub.send:
+				id, _ := primitive.ObjectIDFromHex(message.id)
+				if bytes.Compare(id[:], lastId[:]) <= 0 {
+					continue
+				}
+				err = writeServerSentEvent(w, message)
+				lastId = id
+			case <-heartbeat.C:
+				_, err = fmt.Fprint(w, ": ping\n\n")
+			}
+			if err != nil {
+				return
+			}
+			w.Flush()
+		}
+	}
+}
+
+// writeServerSentEvent writes message in the text/event-stream format. The
+// JSON encoding has no newlines, so it fits on one data line.
+func writeServerSentEvent(w gin.ResponseWriter, message eventMessage) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", message.id, message.eventType, message.json)
+	return err
+}
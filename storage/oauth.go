@@ -0,0 +1,325 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	googleAuthURL     = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL    = "https://oauth2.googleapis.com/token"
+	googleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+)
+
+// oauthStateCookie carries the state parameter from the redirect to the
+// callback, so a callback the browser didn't start is rejected.
+const oauthStateCookie = "oauth_state"
+
+const oauthStateTTL = 10 * time.Minute
+
+var googleClient = &http.Client{Timeout: 10 * time.Second}
+
+func (g GoogleConfig) configured() bool {
+	return g.ClientID != "" && g.ClientSecret != "" && g.RedirectURL != ""
+}
+
+// cookiePath scopes the state cookie to the directory of the callback, which
+// is where the browser has to send it back.
+func (g GoogleConfig) cookiePath() string {
+	redirect, err := url.Parse(g.RedirectURL)
+	if err != nil || redirect.Path == "" {
+		return "/"
+	}
+	return path.Dir(redirect.Path)
+}
+
+// googleProfile is the part of the OpenID userinfo response we use.
+type googleProfile struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// GoogleLogin handler redirects to Google's consent screen.
+func (ac *AuthController) GoogleLogin() gin.HandlerFunc {
+	google := ac.cfg.Google
+	return func(c *gin.Context) {
+		if !google.configured() {
+			respondError(c, unavailable("Google login is not configured"))
+			return
+		}
+
+		state, err := randomToken(32)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(oauthStateCookie, state, int(oauthStateTTL.Seconds()), google.cookiePath(), "", secureCookies(c, ac.cfg), true)
+
+		query := url.Values{
+			"client_id":     {google.ClientID},
+			"redirect_uri":  {google.RedirectURL},
+			"response_type": {"code"},
+			"scope":         {"openid email profile"},
+			"state":         {state},
+			"prompt":        {"select_account"},
+		}
+		c.Redirect(http.StatusFound, googleAuthURL+"?"+query.Encode())
+	}
+}
+
+// GoogleCallback handler finishes a Google login. The Google account is
+// matched to a user by its id, then by email; if neither exists a new,
+// verified user is created. The response is the same as Login's.
+func (ac *AuthController) GoogleCallback() gin.HandlerFunc {
+	google := ac.cfg.Google
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		if !google.configured() {
+			respondError(c, unavailable("Google login is not configured"))
+			return
+		}
+
+		expected, _ := c.Cookie(oauthStateCookie)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(oauthStateCookie, "", -1, google.cookiePath(), "", secureCookies(c, ac.cfg), true)
+
+		state := c.Query("state")
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(state)) != 1 {
+			respondError(c, badRequest("invalid OAuth state"))
+			return
+		}
+
+		if reason := c.Query("error"); reason != "" {
+			respondError(c, badRequest("Google sign-in failed: "+reason))
+			return
+		}
+
+		code := c.Query("code")
+		if code == "" {
+			respondError(c, badRequest("code is required"))
+			return
+		}
+
+		profile, err := fetchGoogleProfile(c.Request.Context(), google, code)
+		if err != nil {
+			respondError(c, &APIError{Status: http.StatusBadGateway, Code: ErrCodeUpstream, Message: "could not load the Google profile", cause: err})
+			return
+		}
+
+		if !profile.EmailVerified || profile.Email == "" {
+			respondError(c, forbidden("the Google account has no verified email address"))
+			return
+		}
+
+		user, created, err := ac.googleUser(ctx, profile)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				respondError(c, conflict("email already registered"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		if created {
+			auditAs(c, user.Id, AuditUserCreated, auditTargetUser, user.Id, gin.H{"role": user.Role, "provider": models.IdentityProviderGoogle})
+		}
+		ac.startLogin(ctx, c, *user, gin.H{"provider": models.IdentityProviderGoogle})
+	}
+}
+
+// fetchGoogleProfile trades an authorization code for an access token and
+// reads the user's profile with it.
+func fetchGoogleProfile(ctx context.Context, google GoogleConfig, code string) (*googleProfile, error) {
+	form := url.Values{
+		"client_id":     {google.ClientID},
+		"client_secret": {google.ClientSecret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {google.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doGoogleRequest(req, &token); err != nil {
+		return nil, fmt.Errorf("exchanging Google code: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("exchanging Google code: no access token returned")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, googleUserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	var profile googleProfile
+	if err := doGoogleRequest(req, &profile); err != nil {
+		return nil, fmt.Errorf("loading Google profile: %w", err)
+	}
+	if profile.Subject == "" {
+		return nil, errors.New("loading Google profile: no account id returned")
+	}
+	return &profile, nil
+}
+
+func doGoogleRequest(req *http.Request, out interface{}) error {
+	resp, err := googleClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Google responded %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// googleUser finds or creates the user for a Google profile. An existing
+// account with the same email is linked to it by linkGoogleIdentity.
+func (ac *AuthController) googleUser(ctx context.Context, profile *googleProfile) (*models.User, bool, error) {
+	collection := ac.db.Collection(UserCollection)
+
+	var user models.User
+	linked := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": models.IdentityProviderGoogle, "subject": profile.Subject}}}
+	err := collection.FindOne(ctx, linked).Decode(&user)
+	if err == nil {
+		return &user, false, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, false, err
+	}
+
+	email := normalizeEmail(profile.Email)
+	identity := models.Identity{
+		Provider: models.IdentityProviderGoogle,
+		Subject:  profile.Subject,
+		Email:    email,
+		LinkedAt: time.Now(),
+	}
+
+	err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		linked, err := ac.linkGoogleIdentity(ctx, user, identity)
+		return linked, false, err
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, false, err
+	}
+
+	name := strings.TrimSpace(profile.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+
+	verified := true
+	user = models.User{
+		Id:            primitive.NewObjectID(),
+		Name:          name,
+		Email:         email,
+		Role:          models.RoleUser,
+		Status:        models.UserStatusActive,
+		EmailVerified: &verified,
+		Identities:    []models.Identity{identity},
+		Live:          true,
+		CreatedAt:     time.Now(),
+	}
+	if _, err := collection.InsertOne(ctx, user); err != nil {
+		return nil, false, err
+	}
+	return &user, true, nil
+}
+
+// linkGoogleIdentity links identity to user, the existing account with the
+// same email. An account whose address was never verified may have been
+// registered ahead of its owner by someone else, so before it is marked
+// verified its password and two-factor setup are dropped and its sessions
+// and API keys revoked, leaving Google as the only way in.
+func (ac *AuthController) linkGoogleIdentity(ctx context.Context, user models.User, identity models.Identity) (*models.User, error) {
+	collection := ac.db.Collection(UserCollection)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var linked models.User
+	if user.IsVerified() {
+		update := bson.M{"$push": bson.M{"identities": identity}}
+		if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": user.Id}, update, opts).Decode(&linked); err != nil {
+			return nil, err
+		}
+		return &linked, nil
+	}
+
+	err := withTransaction(ctx, ac.db.Client(), func(ctx context.Context) error {
+		update := bson.M{
+			"$push": bson.M{"identities": identity},
+			"$unset": bson.M{
+				"password":          "",
+				"mustResetPassword": "",
+				"twoFactorEnabled":  "",
+				"totpSecret":        "",
+				"totpPendingSecret": "",
+				"totpLastStep":      "",
+				"recoveryCodes":     "",
+			},
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": user.Id}, update); err != nil {
+			return err
+		}
+
+		if err := revokeUserSessions(ctx, ac.db, user.Id); err != nil {
+			return err
+		}
+		if err := revokeUserAPIKeys(ctx, ac.db, user.Id); err != nil {
+			return err
+		}
+
+		verified := bson.M{"$set": bson.M{"emailVerified": true}}
+		return collection.FindOneAndUpdate(ctx, bson.M{"_id": user.Id}, verified, opts).Decode(&linked)
+	})
+	if err != nil {
+		return nil, err
+	}
+	events.disconnect(user.Id, primitive.NilObjectID)
+	return &linked, nil
+}
+
+// secureCookies reports whether cookies should be marked Secure: when the
+// request came in over TLS or the public URL is https.
+func secureCookies(c *gin.Context, cfg *Config) bool {
+	return c.Request.TLS != nil || strings.HasPrefix(cfg.PublicBaseURL, "https://")
+}
@@ -0,0 +1,662 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// APIVersion is the version reported in the OpenAPI info block.
+const APIVersion = "1.0.0"
+
+// apiOperation documents one route, by its path relative to APIPrefix
+// unless unversioned. body and response are zero values of the types they
+// are sent as; the schemas are derived from their json and binding tags, so
+// the spec follows the request structs the handlers bind.
+type apiOperation struct {
+	method      string
+	path        string
+	tag         string
+	summary     string
+	public      bool
+	unversioned bool
+	query       []apiParam
+
+	body      any
+	multipart string // form field of an uploaded file, if any
+	rawBody   bool
+
+	status   int
+	response any
+	page     any    // item type of a PageResult response
+	content  string // content type of a non-JSON response
+}
+
+type apiParam struct {
+	name        string
+	kind        string
+	description string
+}
+
+// Documentation-only shapes of responses the handlers build as gin.H.
+type (
+	apiMessage struct {
+		Message string `json:"message"`
+	}
+	apiErrorResponse struct {
+		Error APIError `json:"error"`
+	}
+	apiTokens struct {
+		AccessToken  string `json:"accessToken"`
+		TokenType    string `json:"tokenType"`
+		ExpiresIn    int64  `json:"expiresIn"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	apiLoginResult struct {
+		apiTokens
+		TwoFactorRequired bool   `json:"twoFactorRequired,omitempty"`
+		ChallengeToken    string `json:"challengeToken,omitempty"`
+	}
+	apiDownloadURL struct {
+		URL       string `json:"url"`
+		Direct    bool   `json:"direct"`
+		ExpiresIn int64  `json:"expiresIn"`
+	}
+	apiCreatedUser struct {
+		InsertedID primitive.ObjectID `json:"insertedID"`
+		Message    string             `json:"message"`
+	}
+	apiDeletedUser struct {
+		Message string    `json:"message"`
+		PurgeAt time.Time `json:"purgeAt,omitempty"`
+	}
+	apiFolderListing struct {
+		Folder  *models.Folder  `json:"folder"`
+		Folders []models.Folder `json:"folders"`
+		Files   []models.File   `json:"files"`
+	}
+	apiCreatedUpload struct {
+		UploadId   primitive.ObjectID `json:"uploadId"`
+		ChunkCount int                `json:"chunkCount"`
+		ExpiresAt  time.Time          `json:"expiresAt"`
+	}
+	apiUploadStatus struct {
+		Upload  models.UploadSession `json:"upload"`
+		Missing []int                `json:"missing"`
+	}
+	apiCopiedFile struct {
+		Id   string      `json:"id"`
+		File models.File `json:"file"`
+	}
+	apiCreatedAPIKey struct {
+		Key    string        `json:"key"`
+		APIKey models.APIKey `json:"apiKey"`
+	}
+	apiCreatedWebhook struct {
+		Webhook models.Webhook `json:"webhook"`
+		Secret  string         `json:"secret"`
+	}
+	apiTwoFactorSetup struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	apiRecoveryCodes struct {
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	apiShareAccess struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresIn   int64  `json:"expiresIn"`
+		URL         string `json:"url"`
+	}
+	apiObject map[string]any
+)
+
+func queryParam(name string, kind string, description string) apiParam {
+	return apiParam{name: name, kind: kind, description: description}
+}
+
+// params joins groups of parameters into one list.
+func params(groups ...[]apiParam) []apiParam {
+	var all []apiParam
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+var (
+	pageParams = []apiParam{
+		queryParam("page", "integer", "Page number, from 1."),
+		queryParam("limit", "integer", "Items per page."),
+	}
+	sortParams = []apiParam{
+		queryParam("sort", "string", "Field to sort by."),
+		queryParam("order", "string", "asc or desc."),
+	}
+	createdParams = []apiParam{
+		queryParam("from", "string", "Only items created at or after this RFC 3339 time."),
+		queryParam("to", "string", "Only items created at or before this RFC 3339 time."),
+	}
+	sizeParams = []apiParam{
+		queryParam("minSize", "integer", "Minimum size in bytes."),
+		queryParam("maxSize", "integer", "Maximum size in bytes."),
+	}
+	fileListParams = params(pageParams, []apiParam{cursorParam}, sortParams, createdParams, sizeParams, []apiParam{
+		queryParam("name", "string", "Only files whose name contains this."),
+		queryParam("contentType", "string", "Only files of this content type."),
+		queryParam("tag", "string", "Only files with these tags; repeat for several."),
+		queryParam("tagMode", "string", "all (default) or any of the given tags."),
+		queryParam("owner", "string", "Only files of this owner (admin)."),
+	})
+	uploadParams = []apiParam{
+		queryParam("folderId", "string", "Folder to upload into."),
+		queryParam("expiresAt", "string", "RFC 3339 time after which the file is deleted."),
+		queryParam("ttl", "string", "Delete the file after this duration, such as \"24h\"."),
+		uploadConflictParam,
+	}
+	qrParams = []apiParam{
+		queryParam("size", "integer", "Width and height in pixels, 64 to 1024; 256 by default."),
+		queryParam("level", "string", "Error correction level: L, M (default), Q or H."),
+	}
+	cursorParam         = queryParam("cursor", "string", "nextCursor of the previous page, instead of page.")
+	conflictParam       = queryParam("onConflict", "string", "What to do when the name is taken: error or rename.")
+	uploadConflictParam = queryParam("onConflict", "string", "What to do when the name is taken: overwrite (make a new version), rename or error; the server's default when left out.")
+)
+
+// apiOperations lists every route of the API. SetupRouter logs any route
+// missing here, and any entry without a route, so the two can't drift apart
+// unnoticed.
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/healthz", tag: "health", summary: "Liveness probe", public: true, unversioned: true, response: apiObject{}},
+	{method: "GET", path: "/readyz", tag: "health", summary: "Readiness probe reporting each dependency", public: true, unversioned: true, response: apiObject{}},
+
+	{method: "POST", path: "/auth/login", tag: "auth", summary: "Log in with email and password", public: true, body: loginRequest{}, response: apiLoginResult{}},
+	{method: "POST", path: "/auth/2fa", tag: "auth", summary: "Complete a login with a two-factor code", public: true, body: twoFactorLoginRequest{}, response: apiTokens{}},
+	{method: "POST", path: "/auth/refresh", tag: "auth", summary: "Exchange a refresh token for new tokens", public: true, body: refreshRequest{}, response: apiTokens{}},
+	{method: "POST", path: "/auth/forgot-password", tag: "auth", summary: "Email a password reset link", public: true, body: forgotPasswordRequest{}, response: apiMessage{}},
+	{method: "POST", path: "/auth/reset-password", tag: "auth", summary: "Set a new password with a reset token", public: true, body: resetPasswordRequest{}, response: apiMessage{}},
+	{method: "GET", path: "/auth/verify", tag: "auth", summary: "Verify an email address", public: true, query: []apiParam{queryParam("token", "string", "Verification token from the email.")}, response: apiMessage{}},
+	{method: "POST", path: "/auth/verify/resend", tag: "auth", summary: "Send a new verification email", response: apiMessage{}},
+	{method: "GET", path: "/auth/google/login", tag: "auth", summary: "Redirect to Google sign-in", public: true, status: http.StatusFound},
+	{method: "GET", path: "/auth/google/callback", tag: "auth", summary: "Finish a Google sign-in", public: true, query: []apiParam{
+		queryParam("code", "string", "Authorization code from Google."),
+		queryParam("state", "string", "State issued by /auth/google/login."),
+	}, response: apiLoginResult{}},
+	{method: "GET", path: "/auth/sessions/", tag: "auth", summary: "List the caller's sessions", response: []models.Session{}},
+	{method: "DELETE", path: "/auth/sessions/:id", tag: "auth", summary: "Revoke a session", response: apiMessage{}},
+
+	{method: "POST", path: "/users/", tag: "users", summary: "Register an account", public: true, body: models.User{}, response: apiCreatedUser{}},
+	{method: "GET", path: "/users/", tag: "users", summary: "List accounts (admin)", query: params(pageParams, sortParams, createdParams, []apiParam{
+		queryParam("fields", "string", "Comma-separated fields to return."),
+		queryParam("role", "string", "Only accounts with this role."),
+		queryParam("status", "string", "Only accounts with this status: active, suspended or deleted."),
+	}), page: models.User{}},
+	{method: "GET", path: "/users/search", tag: "users", summary: "Find accounts to share with by name or email prefix", query: []apiParam{queryParam("q", "string", "Start of a name or email, at least 2 characters.")}, response: []userSearchResult{}},
+	{method: "GET", path: "/users/me", tag: "users", summary: "Get the caller's profile", response: profileResponse{}},
+	{method: "PATCH", path: "/users/me", tag: "users", summary: "Update the caller's profile", body: updateProfileRequest{}, response: profileResponse{}},
+	{method: "DELETE", path: "/users/me", tag: "users", summary: "Delete the caller's account", response: apiDeletedUser{}},
+	{method: "POST", path: "/users/me/avatar", tag: "users", summary: "Upload the caller's avatar", multipart: "avatar", response: models.User{}},
+	{method: "DELETE", path: "/users/me/avatar", tag: "users", summary: "Remove the caller's avatar", response: apiMessage{}},
+	{method: "GET", path: "/users/:id/avatar", tag: "users", summary: "Get a user's avatar", public: true, query: []apiParam{queryParam("v", "string", "Avatar version, for immutable caching.")}, content: "image/png"},
+	{method: "GET", path: "/users/me/usage", tag: "users", summary: "Get the caller's storage usage", response: apiObject{}},
+	{method: "GET", path: "/users/me/usage/breakdown", tag: "users", summary: "Break down the caller's usage by type and folder", response: apiObject{}},
+	{method: "GET", path: "/users/:id/usage/breakdown", tag: "users", summary: "Break down a user's usage (admin)", response: apiObject{}},
+	{method: "GET", path: "/users/me/activity", tag: "users", summary: "List the caller's recent activity", query: pageParams, page: models.Event{}},
+	{method: "POST", path: "/users/me/password", tag: "users", summary: "Change the caller's password", body: changePasswordRequest{}, response: apiTokens{}},
+	{method: "POST", path: "/users/me/2fa/setup", tag: "users", summary: "Start two-factor setup", response: apiTwoFactorSetup{}},
+	{method: "POST", path: "/users/me/2fa/enable", tag: "users", summary: "Enable two-factor authentication", body: twoFactorCodeRequest{}, response: apiRecoveryCodes{}},
+	{method: "POST", path: "/users/me/2fa/disable", tag: "users", summary: "Disable two-factor authentication", body: twoFactorCodeRequest{}, response: apiMessage{}},
+	{method: "GET", path: "/users/me/apikeys", tag: "users", summary: "List the caller's API keys", response: []models.APIKey{}},
+	{method: "POST", path: "/users/me/apikeys", tag: "users", summary: "Create an API key", body: createAPIKeyRequest{}, status: http.StatusCreated, response: apiCreatedAPIKey{}},
+	{method: "DELETE", path: "/users/me/apikeys/:id", tag: "users", summary: "Delete an API key", response: apiMessage{}},
+	{method: "GET", path: "/users/:id", tag: "users", summary: "Get an account", response: models.User{}},
+	{method: "PATCH", path: "/users/:id", tag: "users", summary: "Update an account", body: UpdateUserRequest{}, response: models.User{}},
+	{method: "PUT", path: "/users/:id", tag: "users", summary: "Replace an account's editable fields", body: ReplaceUserRequest{}, response: models.User{}},
+	{method: "DELETE", path: "/users/:id", tag: "users", summary: "Delete an account (admin)", query: []apiParam{
+		queryParam("keepFiles", "boolean", "Keep the account's files."),
+		queryParam("permanent", "boolean", "Purge the account now instead of after the grace period."),
+	}, response: apiDeletedUser{}},
+
+	{method: "GET", path: "/files/", tag: "files", summary: "List the caller's files", query: fileListParams, page: fileListItem{}},
+	{method: "POST", path: "/files/", tag: "files", summary: "Upload a file", multipart: "file", query: uploadParams, status: http.StatusCreated, response: models.File{}},
+	{method: "GET", path: "/files/shared-with-me", tag: "files", summary: "List files shared with the caller or their groups", query: pageParams, page: sharedFileItem{}},
+	{method: "GET", path: "/files/trash", tag: "files", summary: "List the caller's trashed files", query: pageParams, page: models.File{}},
+	{method: "GET", path: "/files/search", tag: "files", summary: "Search file names and contents", query: params(pageParams, []apiParam{cursorParam, queryParam("q", "string", "Search terms.")}), page: fileListItem{}},
+	{method: "GET", path: "/files/starred", tag: "files", summary: "List the caller's starred files and folders", query: pageParams, page: starredItem{}},
+	{method: "GET", path: "/files/recent", tag: "files", summary: "List recently accessed files", query: pageParams, page: models.File{}},
+	{method: "POST", path: "/files/download-zip", tag: "files", summary: "Download several files as a zip archive", body: downloadZipRequest{}, content: "application/zip"},
+	{method: "POST", path: "/files/batch-delete", tag: "files", summary: "Delete up to 200 files at once", body: batchDeleteRequest{}, query: []apiParam{queryParam("permanent", "boolean", "Delete for good, including files in the trash.")}, response: batchDeleteResponse{}},
+	{method: "POST", path: "/files/batch-move", tag: "files", summary: "Move up to 200 files and folders into a folder", body: batchMoveRequest{}, query: []apiParam{queryParam("onConflict", "string", "skip (default) or rename entries whose name is taken in the target folder.")}, response: batchMoveResponse{}},
+	{method: "GET", path: "/files/:id", tag: "files", summary: "Get a file's metadata", response: models.File{}},
+	{method: "PATCH", path: "/files/:id", tag: "files", summary: "Rename or move a file or change its expiry", body: updateFileRequest{}, query: []apiParam{conflictParam}, response: models.File{}},
+	{method: "DELETE", path: "/files/:id", tag: "files", summary: "Trash or permanently delete a file", query: []apiParam{queryParam("permanent", "boolean", "Delete instead of trashing.")}, response: apiMessage{}},
+	{method: "GET", path: "/files/:id/download", tag: "files", summary: "Download a file's content", query: []apiParam{queryParam("token", "string", "Download token from /files/{id}/download-url, instead of authenticating.")}, content: "application/octet-stream"},
+	{method: "GET", path: "/files/:id/download-url", tag: "files", summary: "Get a short-lived link to a file's content", response: apiDownloadURL{}},
+	{method: "POST", path: "/files/:id/copy", tag: "files", summary: "Copy a file", body: copyFileRequest{}, query: []apiParam{conflictParam}, status: http.StatusCreated, response: apiCopiedFile{}},
+	{method: "GET", path: "/files/:id/verify", tag: "files", summary: "Re-hash a file and compare it with its checksum", response: apiObject{}},
+	{method: "GET", path: "/files/:id/thumbnail", tag: "files", summary: "Get a file's thumbnail", query: []apiParam{queryParam("size", "string", "small or medium.")}, content: "image/jpeg"},
+	{method: "GET", path: "/files/:id/image", tag: "files", summary: "Get a resized rendition of an image", query: []apiParam{
+		queryParam("w", "integer", "Width in pixels."),
+		queryParam("h", "integer", "Height in pixels."),
+		queryParam("fit", "string", "contain or cover."),
+	}, content: "image/jpeg"},
+	{method: "GET", path: "/files/:id/preview", tag: "files", summary: "Preview a document or text file", response: apiObject{}},
+	{method: "POST", path: "/files/:id/restore", tag: "files", summary: "Restore a trashed file", query: []apiParam{conflictParam}, response: apiMessage{}},
+	{method: "GET", path: "/files/:id/versions", tag: "files", summary: "List a file's versions", response: []versionItem{}},
+	{method: "GET", path: "/files/:id/versions/:n/download", tag: "files", summary: "Download an earlier version", content: "application/octet-stream"},
+	{method: "POST", path: "/files/:id/versions/:n/restore", tag: "files", summary: "Make an earlier version current", response: models.File{}},
+	{method: "POST", path: "/files/:id/star", tag: "files", summary: "Star a file", response: apiObject{}},
+	{method: "DELETE", path: "/files/:id/star", tag: "files", summary: "Unstar a file", response: apiObject{}},
+	{method: "POST", path: "/files/:id/tags", tag: "files", summary: "Add tags to a file", body: addTagsRequest{}, response: models.File{}},
+	{method: "DELETE", path: "/files/:id/tags/:tag", tag: "files", summary: "Remove a tag from a file", response: models.File{}},
+	{method: "GET", path: "/files/:id/comments", tag: "files", summary: "List a file's comments, oldest first", query: pageParams, page: models.Comment{}},
+	{method: "POST", path: "/files/:id/comments", tag: "files", summary: "Comment on a file", body: commentRequest{}, status: http.StatusCreated, response: models.Comment{}},
+	{method: "PATCH", path: "/files/:id/comments/:commentId", tag: "files", summary: "Edit a comment", body: commentRequest{}, response: models.Comment{}},
+	{method: "DELETE", path: "/files/:id/comments/:commentId", tag: "files", summary: "Delete a comment", response: apiMessage{}},
+	{method: "GET", path: "/files/:id/permissions", tag: "files", summary: "List who a file is shared with", response: []models.Permission{}},
+	{method: "POST", path: "/files/:id/permissions", tag: "files", summary: "Share a file with a user or group", body: grantPermissionRequest{}, response: models.Permission{}},
+	{method: "DELETE", path: "/files/:id/permissions/:userId", tag: "files", summary: "Stop sharing a file with a user, or a group given its id", response: apiMessage{}},
+	{method: "POST", path: "/files/upload-url", tag: "files", summary: "Reserve a file to upload straight to S3", body: createDirectUploadRequest{}, status: http.StatusCreated, response: directUploadResponse{}},
+	{method: "POST", path: "/files/:id/confirm", tag: "files", summary: "Confirm a direct upload", body: confirmDirectUploadRequest{}, query: []apiParam{uploadConflictParam}, status: http.StatusCreated, response: models.File{}},
+	{method: "POST", path: "/files/uploads", tag: "files", summary: "Start a resumable upload", body: createUploadRequest{}, status: http.StatusCreated, response: apiCreatedUpload{}},
+	{method: "GET", path: "/files/uploads/:id", tag: "files", summary: "Get a resumable upload's progress", response: apiUploadStatus{}},
+	{method: "PUT", path: "/files/uploads/:id/chunks/:n", tag: "files", summary: "Upload one chunk", rawBody: true, response: apiObject{}},
+	{method: "POST", path: "/files/uploads/:id/complete", tag: "files", summary: "Assemble a resumable upload into a file", query: []apiParam{uploadConflictParam}, status: http.StatusCreated, response: models.File{}},
+	{method: "GET", path: "/tags/", tag: "files", summary: "List the caller's tags with counts", response: apiObject{}},
+
+	{method: "GET", path: "/folders/", tag: "folders", summary: "List the top-level folder", response: apiFolderListing{}},
+	{method: "POST", path: "/folders/", tag: "folders", summary: "Create a folder", body: createFolderRequest{}, status: http.StatusCreated, response: models.Folder{}},
+	{method: "GET", path: "/folders/:id", tag: "folders", summary: "List a folder", response: apiFolderListing{}},
+	{method: "PATCH", path: "/folders/:id", tag: "folders", summary: "Rename or move a folder", body: updateFolderRequest{}, response: models.Folder{}},
+	{method: "DELETE", path: "/folders/:id", tag: "folders", summary: "Delete a folder", query: []apiParam{queryParam("recursive", "boolean", "Also trash everything inside.")}, response: apiMessage{}},
+	{method: "GET", path: "/folders/:id/download", tag: "folders", summary: "Download a folder as a zip archive", content: "application/zip"},
+	{method: "POST", path: "/folders/:id/star", tag: "folders", summary: "Star a folder", response: apiObject{}},
+	{method: "DELETE", path: "/folders/:id/star", tag: "folders", summary: "Unstar a folder", response: apiObject{}},
+
+	{method: "POST", path: "/files/:id/share", tag: "shares", summary: "Create a share link", body: shareSettings{}, status: http.StatusCreated, response: shareResponse{}},
+	{method: "GET", path: "/shares/", tag: "shares", summary: "List share links the caller manages", query: params(pageParams, []apiParam{queryParam("fileId", "string", "Only links to this file.")}), page: shareResponse{}},
+	{method: "PATCH", path: "/shares/:id", tag: "shares", summary: "Change a share link's settings", body: shareSettings{}, response: shareResponse{}},
+	{method: "DELETE", path: "/shares/:id", tag: "shares", summary: "Revoke a share link", response: apiMessage{}},
+	{method: "GET", path: "/s/:token", tag: "shares", summary: "Download through a share link, by its token or short code", public: true, query: []apiParam{queryParam("access", "string", "Download token from /s/{token}/unlock, for password-protected links.")}, content: "application/octet-stream"},
+	{method: "GET", path: "/shares/:id/analytics", tag: "shares", summary: "Report a share link's downloads over the last 30 days", response: shareAnalytics{}},
+	{method: "GET", path: "/shares/:id/qr", tag: "shares", summary: "Render a share link as a PNG QR code", query: qrParams, content: "image/png"},
+	{method: "POST", path: "/s/:token/unlock", tag: "shares", summary: "Unlock a password-protected share link", public: true, body: unlockShareRequest{}, response: apiShareAccess{}},
+	{method: "GET", path: "/s/:token/qr", tag: "shares", summary: "Render a share link that still works as a PNG QR code", public: true, query: qrParams, content: "image/png"},
+
+	{method: "GET", path: "/filerequests/", tag: "shares", summary: "List the caller's file requests", query: params(pageParams, []apiParam{queryParam("open", "boolean", "Only requests that are neither closed nor expired.")}), page: fileRequestResponse{}},
+	{method: "POST", path: "/filerequests/", tag: "shares", summary: "Create a file request link for anonymous uploads", body: fileRequestSettings{}, status: http.StatusCreated, response: fileRequestResponse{}},
+	{method: "DELETE", path: "/filerequests/:id", tag: "shares", summary: "Close a file request", response: apiMessage{}},
+	{method: "GET", path: "/r/:token", tag: "shares", summary: "Show what a file request asks for", public: true, response: publicFileRequest{}},
+	{method: "POST", path: "/r/:token/upload", tag: "shares", summary: "Upload a file through a file request, with optional name, email and password fields before it", public: true, multipart: "file", status: http.StatusCreated, response: fileRequestReceipt{}},
+
+	{method: "GET", path: "/groups/", tag: "groups", summary: "List the caller's groups", query: pageParams, page: models.Group{}},
+	{method: "POST", path: "/groups/", tag: "groups", summary: "Create a group", body: groupRequest{}, status: http.StatusCreated, response: models.Group{}},
+	{method: "GET", path: "/groups/:id", tag: "groups", summary: "Get a group", response: models.Group{}},
+	{method: "PATCH", path: "/groups/:id", tag: "groups", summary: "Rename a group", body: groupRequest{}, response: models.Group{}},
+	{method: "DELETE", path: "/groups/:id", tag: "groups", summary: "Delete a group and what is shared with it", response: apiMessage{}},
+	{method: "POST", path: "/groups/:id/members", tag: "groups", summary: "Add a member or change their role", body: addMemberRequest{}, response: models.Group{}},
+	{method: "DELETE", path: "/groups/:id/members/:userId", tag: "groups", summary: "Remove a member or leave a group", response: apiMessage{}},
+
+	{method: "GET", path: "/webhooks/", tag: "webhooks", summary: "List the caller's webhooks", response: []models.Webhook{}},
+	{method: "POST", path: "/webhooks/", tag: "webhooks", summary: "Create a webhook", body: createWebhookRequest{}, status: http.StatusCreated, response: apiCreatedWebhook{}},
+	{method: "GET", path: "/webhooks/:id", tag: "webhooks", summary: "Get a webhook", response: models.Webhook{}},
+	{method: "PATCH", path: "/webhooks/:id", tag: "webhooks", summary: "Update a webhook", body: updateWebhookRequest{}, response: models.Webhook{}},
+	{method: "DELETE", path: "/webhooks/:id", tag: "webhooks", summary: "Delete a webhook", response: apiMessage{}},
+	{method: "GET", path: "/webhooks/:id/deliveries", tag: "webhooks", summary: "List a webhook's deliveries", query: params(pageParams, []apiParam{queryParam("status", "string", "Only deliveries with this status.")}), page: models.WebhookDelivery{}},
+
+	{method: "GET", path: "/notifications/", tag: "notifications", summary: "List the caller's notifications, newest first", query: params(pageParams, []apiParam{queryParam("unread", "boolean", "Only unread notifications.")}), page: models.Notification{}},
+	{method: "POST", path: "/notifications/:id/read", tag: "notifications", summary: "Mark a notification read", response: models.Notification{}},
+	{method: "GET", path: "/notifications/stream", tag: "notifications", summary: "Stream the caller's notifications as Server-Sent Events", query: []apiParam{
+		queryParam("lastEventId", "string", "Resume after this notification, for clients that can't send Last-Event-ID."),
+	}, content: "text/event-stream"},
+
+	{method: "GET", path: "/ws", tag: "events", summary: "Stream upload, processing and share events over a WebSocket", public: true, status: http.StatusSwitchingProtocols, query: []apiParam{
+		queryParam("token", "string", "Access token, unless sent as the first message."),
+	}},
+
+	{method: "POST", path: "/admin/trash/purge", tag: "admin", summary: "Purge expired trash now", response: purgeSummary{}},
+	{method: "GET", path: "/admin/dedup/stats", tag: "admin", summary: "Report storage saved by deduplication", response: apiObject{}},
+	{method: "POST", path: "/admin/encryption/rewrap", tag: "admin", summary: "Rewrap data keys with the current master key", response: rewrapSummary{}},
+	{method: "GET", path: "/admin/audit", tag: "admin", summary: "Search the audit log", query: params(pageParams, createdParams, []apiParam{
+		queryParam("actor", "string", "Only events by this user."),
+		queryParam("target", "string", "Only events on this target."),
+		queryParam("action", "string", "Only events with this action."),
+		queryParam("targetType", "string", "Only events on this kind of target."),
+	}), page: models.Event{}},
+	{method: "GET", path: "/admin/audit/stats", tag: "admin", summary: "Count audit events by action", response: apiObject{}},
+	{method: "DELETE", path: "/admin/lockouts", tag: "admin", summary: "Clear a login lockout", query: []apiParam{
+		queryParam("email", "string", "Account email to unlock."),
+		queryParam("ip", "string", "Client address to unlock."),
+	}, response: apiMessage{}},
+	{method: "GET", path: "/admin/users/export", tag: "admin", summary: "Export accounts as CSV", query: []apiParam{
+		queryParam("role", "string", "Only accounts with this role."),
+		queryParam("status", "string", "Only accounts with this status."),
+	}, content: "text/csv"},
+	{method: "POST", path: "/admin/users/import", tag: "admin", summary: "Import accounts from JSON or CSV", multipart: "file", query: []apiParam{queryParam("invite", "boolean", "Email each new account an invitation.")}, response: importResult{}},
+	{method: "POST", path: "/admin/users/:id/suspend", tag: "admin", summary: "Suspend an account", response: apiMessage{}},
+	{method: "POST", path: "/admin/users/:id/activate", tag: "admin", summary: "Reactivate a suspended account", response: apiMessage{}},
+	{method: "POST", path: "/admin/users/:id/restore", tag: "admin", summary: "Restore a deleted account within its grace period", response: apiMessage{}},
+	{method: "GET", path: "/admin/files", tag: "admin", summary: "List every file", query: params(pageParams, createdParams, sizeParams, []apiParam{
+		queryParam("owner", "string", "Only files of this owner."),
+		queryParam("contentType", "string", "Only files of this content type."),
+		queryParam("trashed", "boolean", "Only trashed (true) or untrashed (false) files."),
+	}), page: adminFileItem{}},
+	{method: "GET", path: "/admin/files/export", tag: "admin", summary: "Export file metadata as CSV", content: "text/csv"},
+	{method: "DELETE", path: "/admin/files/:id", tag: "admin", summary: "Take a file down", body: takedownRequest{}, response: apiMessage{}},
+	{method: "GET", path: "/admin/stats", tag: "admin", summary: "Report totals across the system", response: apiObject{}},
+}
+
+// openAPIPath turns a gin route into an OpenAPI path, :id becoming {id}.
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+func openAPIPath(route string) string {
+	return ginParam.ReplaceAllString(route, "{$1}")
+}
+
+// serverURL is the OpenAPI server URL of the API mounted at prefix of
+// baseURL.
+func serverURL(baseURL string, prefix string) string {
+	if url := baseURL + prefix; url != "" {
+		return url
+	}
+	return "/"
+}
+
+// openAPISpec builds the spec of the API as cfg serves it from
+// apiOperations.
+func openAPISpec(cfg *Config) gin.H {
+	schemas := schemaRegistry{}
+	paths := gin.H{}
+
+	for _, op := range apiOperations {
+		path := openAPIPath(op.path)
+		item, _ := paths[path].(gin.H)
+		if item == nil {
+			item = gin.H{}
+			if op.unversioned {
+				item["servers"] = []gin.H{{"url": serverURL(cfg.PublicBaseURL, "")}}
+			}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.method)] = op.document(schemas)
+	}
+
+	schemas["Message"] = schemas.schemaOf(reflect.TypeOf(apiMessage{}))
+	schemas.ref(reflect.TypeOf(apiErrorResponse{}))
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "File sharing API",
+			"version": APIVersion,
+		},
+		"servers": []gin.H{{"url": serverURL(cfg.PublicBaseURL, cfg.APIPrefix)}},
+		"paths":   paths,
+		"components": gin.H{
+			"schemas": schemas,
+			"securitySchemes": gin.H{
+				"bearer": gin.H{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey": gin.H{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+	}
+}
+
+// document renders op as an OpenAPI operation object.
+func (op apiOperation) document(schemas schemaRegistry) gin.H {
+	doc := gin.H{
+		"tags":        []string{op.tag},
+		"summary":     op.summary,
+		"operationId": strings.ToLower(op.method) + strings.NewReplacer("/", "_", ":", "", "-", "_").Replace(strings.TrimSuffix(op.path, "/")),
+	}
+
+	var parameters []gin.H
+	for _, match := range ginParam.FindAllStringSubmatch(op.path, -1) {
+		parameters = append(parameters, gin.H{"name": match[1], "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+	}
+	for _, p := range op.query {
+		parameters = append(parameters, gin.H{"name": p.name, "in": "query", "description": p.description, "schema": gin.H{"type": p.kind}})
+	}
+	if len(parameters) > 0 {
+		doc["parameters"] = parameters
+	}
+
+	switch {
+	case op.multipart != "":
+		doc["requestBody"] = gin.H{"required": true, "content": gin.H{"multipart/form-data": gin.H{"schema": gin.H{
+			"type":       "object",
+			"required":   []string{op.multipart},
+			"properties": gin.H{op.multipart: gin.H{"type": "string", "format": "binary"}},
+		}}}}
+	case op.rawBody:
+		doc["requestBody"] = gin.H{"required": true, "content": gin.H{"application/octet-stream": gin.H{"schema": gin.H{"type": "string", "format": "binary"}}}}
+	case op.body != nil:
+		doc["requestBody"] = gin.H{"required": true, "content": gin.H{"application/json": gin.H{"schema": schemas.ref(reflect.TypeOf(op.body))}}}
+	}
+
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := gin.H{"description": http.StatusText(status)}
+	switch {
+	case op.content != "":
+		success["content"] = gin.H{op.content: gin.H{"schema": gin.H{"type": "string", "format": "binary"}}}
+	case op.page != nil:
+		success["content"] = gin.H{"application/json": gin.H{"schema": schemas.pageOf(reflect.TypeOf(op.page))}}
+	case op.response != nil:
+		success["content"] = gin.H{"application/json": gin.H{"schema": schemas.ref(reflect.TypeOf(op.response))}}
+	}
+
+	// Every error response is an APIError written by respondError.
+	errorResponse := func(status int) gin.H {
+		return gin.H{
+			"description": http.StatusText(status),
+			"content":     gin.H{"application/json": gin.H{"schema": gin.H{"$ref": "#/components/schemas/ErrorResponse"}}},
+		}
+	}
+	responses := gin.H{
+		stat(status):                         success,
+		stat(http.StatusInternalServerError): errorResponse(http.StatusInternalServerError),
+	}
+	if op.body != nil || op.multipart != "" || op.rawBody || len(op.query) > 0 {
+		responses[stat(http.StatusBadRequest)] = errorResponse(http.StatusBadRequest)
+	}
+	if strings.Contains(op.path, ":") {
+		responses[stat(http.StatusNotFound)] = errorResponse(http.StatusNotFound)
+	}
+	if !op.public {
+		responses[stat(http.StatusUnauthorized)] = errorResponse(http.StatusUnauthorized)
+		responses[stat(http.StatusForbidden)] = errorResponse(http.StatusForbidden)
+		doc["security"] = []gin.H{{"bearer": []string{}}, {"apiKey": []string{}}}
+	}
+	doc["responses"] = responses
+	return doc
+}
+
+func stat(status int) string {
+	return strconv.Itoa(status)
+}
+
+// schemaRegistry collects the named schemas referred to from the spec. It
+// maps Go types to JSON Schema the way encoding/json maps them to JSON:
+// json tags name the properties, "-" drops them and embedded structs are
+// flattened. A binding:"required" tag marks the property required.
+type schemaRegistry gin.H
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// ref returns a reference to t's schema for named struct types, adding the
+// schema to the registry, and t's schema inlined for anything else.
+func (r schemaRegistry) ref(t reflect.Type) gin.H {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Name() == "" || t == timeType {
+		return r.schemaOf(t)
+	}
+
+	name := schemaName(t)
+	if _, ok := r[name]; !ok {
+		r[name] = gin.H{} // placeholder, for types that refer to themselves
+		r[name] = r.schemaOf(t)
+	}
+	return gin.H{"$ref": "#/components/schemas/" + name}
+}
+
+// pageOf is the schema of a PageResult listing items of type t.
+func (r schemaRegistry) pageOf(t reflect.Type) gin.H {
+	schema := r.schemaOf(reflect.TypeOf(PageResult{}))
+	schema["properties"].(gin.H)["items"] = gin.H{"type": "array", "items": r.ref(t)}
+	return schema
+}
+
+func (r schemaRegistry) schemaOf(t reflect.Type) gin.H {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return gin.H{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return gin.H{"type": "string", "example": "65f1c2a9e4b0d3a1c2b3d4e5"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if t == reflect.TypeOf(time.Duration(0)) {
+			return gin.H{"type": "integer", "description": "Duration in nanoseconds."}
+		}
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return gin.H{"type": "string", "format": "byte"}
+		}
+		return gin.H{"type": "array", "items": r.ref(t.Elem())}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": r.ref(t.Elem())}
+	case reflect.Struct:
+		properties := gin.H{}
+		var required []string
+		r.addFields(t, properties, &required)
+		schema := gin.H{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	}
+	return gin.H{}
+}
+
+func (r schemaRegistry) addFields(t reflect.Type, properties gin.H, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				r.addFields(embedded, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = r.ref(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// schemaName names t's schema after the type, dropping the api prefix of the
+// documentation-only types above.
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	if name == "" {
+		return t.Name()
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+type DocsController struct {
+	db   *mongo.Database
+	cfg  *Config
+	spec func() gin.H
+}
+
+// NewDocsController builds the spec the first time it is asked for.
+func NewDocsController(db *mongo.Database, cfg *Config) *DocsController {
+	return &DocsController{db: db, cfg: cfg, spec: sync.OnceValue(func() gin.H { return openAPISpec(cfg) })}
+}
+
+// SetupRouter function
+func (dc *DocsController) BasicRoute(router *gin.RouterGroup) {
+	router.GET("/openapi.json", dc.Spec())
+	router.GET("/docs", dc.SwaggerUI())
+}
+
+// Spec serves the OpenAPI document.
+func (dc *DocsController) Spec() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, dc.spec())
+	}
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at /openapi.json.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>File sharing API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+// SwaggerUI serves an interactive page for trying the API.
+func (dc *DocsController) SwaggerUI() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+	}
+}
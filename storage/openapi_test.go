@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// undocumentedRoutes compares the registered routes with apiOperations and
+// returns those missing from either. Routes under prefix and their legacy
+// aliases both count as the documented route. The metrics and documentation
+// routes themselves are not listed.
+func undocumentedRoutes(routes gin.RoutesInfo, prefix string) (missing []string, stale []string) {
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		documented[op.method+" "+op.path] = true
+	}
+
+	registered := map[string]bool{}
+	for _, route := range routes {
+		path := strings.TrimPrefix(route.Path, prefix)
+		key := route.Method + " " + path
+		registered[key] = true
+		switch path {
+		case "/metrics", "/openapi.json", "/docs":
+			continue
+		}
+		if !documented[key] {
+			missing = append(missing, key)
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	return missing, stale
+}
+
+func TestAPIDocsCoverEveryRoute(t *testing.T) {
+	cfg := testConfig()
+	cfg.MetricsEnabled = true
+	cfg.APIDocsEnabled = true
+	cfg.LegacyRoutes = true
+
+	missing, stale := undocumentedRoutes(newRouter(nil, cfg).Routes(), cfg.APIPrefix)
+	for _, route := range missing {
+		t.Errorf("%s is registered but missing from the OpenAPI spec", route)
+	}
+	for _, route := range stale {
+		t.Errorf("%s is documented but not registered", route)
+	}
+}
+
+func TestUndocumentedRoutes(t *testing.T) {
+	op := apiOperations[0]
+	routes := gin.RoutesInfo{
+		{Method: op.method, Path: "/api/v1" + op.path},
+		{Method: op.method, Path: op.path},
+		{Method: "GET", Path: "/api/v1/not-in-the-spec"},
+		{Method: "GET", Path: "/api/v1/metrics"},
+	}
+
+	missing, stale := undocumentedRoutes(routes, "/api/v1")
+	if len(missing) != 1 || missing[0] != "GET /not-in-the-spec" {
+		t.Errorf("missing = %v, want only the unlisted route", missing)
+	}
+	if len(stale) != len(apiOperations)-1 {
+		t.Errorf("%d stale routes, want all but the registered one of %d", len(stale), len(apiOperations))
+	}
+}
@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const DefaultPageLimit int64 = 20
+const MaxPageLimit int64 = 100
+
+// Pagination holds the validated ?page= and ?limit= query params.
+type Pagination struct {
+	Page  int64
+	Limit int64
+}
+
+// PageResult is the response wrapper returned by list endpoints. Listings
+// that take ?cursor= also return NextCursor while there are more items, and
+// leave Page out for pages fetched by cursor.
+type PageResult struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	Page       int64       `json:"page,omitempty"`
+	Limit      int64       `json:"limit"`
+	TotalPages int64       `json:"totalPages"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// parsePagination reads ?page= and ?limit= from the request, falling back to
+// page 1 and DefaultPageLimit. Out of range values are rejected, not clamped.
+func parsePagination(c *gin.Context) (Pagination, error) {
+	p := Pagination{Page: 1, Limit: DefaultPageLimit}
+
+	if raw := c.Query("page"); raw != "" {
+		page, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || page < 1 {
+			return p, fmt.Errorf("page must be a positive integer")
+		}
+		p.Page = page
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit < 1 || limit > MaxPageLimit {
+			return p, fmt.Errorf("limit must be an integer between 1 and %d", MaxPageLimit)
+		}
+		p.Limit = limit
+	}
+
+	return p, nil
+}
+
+// FindOptions returns Find options with Skip/Limit set for this page.
+func (p Pagination) FindOptions() *options.FindOptions {
+	return options.Find().SetSkip((p.Page - 1) * p.Limit).SetLimit(p.Limit)
+}
+
+// Result wraps items and the total document count into a PageResult.
+func (p Pagination) Result(items interface{}, total int64) PageResult {
+	totalPages := total / p.Limit
+	if total%p.Limit != 0 {
+		totalPages++
+	}
+
+	return PageResult{
+		Items:      items,
+		Total:      total,
+		Page:       p.Page,
+		Limit:      p.Limit,
+		TotalPages: totalPages,
+	}
+}
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptCost is the work factor used when hashing passwords.
+var BcryptCost = bcrypt.DefaultCost
+
+const MinPasswordLength = 8
+
+// hashPassword validates the password policy and returns its bcrypt hash.
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches the stored bcrypt hash.
+func checkPassword(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// dummyPasswordHash is checked against when there is no real hash to
+// compare, so a login takes as long whether or not the account has one.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("no password set"), BcryptCost)
+	return string(hash)
+})
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != BcryptCost {
+		t.Errorf("hash %q: cost %d, %v; want a bcrypt hash of cost %d", hash, cost, err, BcryptCost)
+	}
+	if !checkPassword(hash, "correct horse") || checkPassword(hash, "correct horsE") {
+		t.Error("the hash doesn't check out against its password only")
+	}
+}
+
+func TestHashPasswordRejectsShortPasswords(t *testing.T) {
+	_, err := hashPassword(strings.Repeat("a", MinPasswordLength-1))
+	if err == nil {
+		t.Fatal("a short password was hashed")
+	}
+	if _, err := hashPassword(strings.Repeat("a", MinPasswordLength)); err != nil {
+		t.Errorf("a password of the minimum length was refused: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package routes
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// codeLanguages maps source file extensions to the language hint returned
+// with their preview.
+var codeLanguages = map[string]string{
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".css":   "css",
+	".go":    "go",
+	".html":  "html",
+	".htm":   "html",
+	".java":  "java",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".json":  "json",
+	".kt":    "kotlin",
+	".md":    "markdown",
+	".php":   "php",
+	".py":    "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".scss":  "scss",
+	".sh":    "bash",
+	".sql":   "sql",
+	".swift": "swift",
+	".toml":  "toml",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".jsx":   "jsx",
+	".xml":   "xml",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+}
+
+// textContentTypes are the non text/* types previewed as text.
+var textContentTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-yaml":     true,
+	"application/x-sh":       true,
+	"application/toml":       true,
+}
+
+// GetPreview handler returns a preview of a file: PDFs are served inline,
+// text and source files as the first Config.PreviewBytes of their content
+// converted to UTF-8. Response for text:
+//
+//	{"kind": "text"|"code", "language": "go", "encoding": "utf-8",
+//	 "content": "...", "truncated": bool, "size": n}
+//
+// Other types get 415 with a "reason" of "unsupported_type" or, for content
+// that turns out not to be text, "binary_content".
+func (fc *FileController) GetPreview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		if !scanAllowsDownload(c, fc.cfg.Scan, file.ScanResult) {
+			return
+		}
+
+		recordAccess(c, file)
+
+		media := mediaType(file.ContentType)
+		if media == "application/pdf" {
+			streamFile(c, fc.db, fc.cfg, file, "inline")
+			return
+		}
+
+		language := codeLanguages[strings.ToLower(path.Ext(file.Name))]
+		if language == "" && !strings.HasPrefix(media, "text/") && !textContentTypes[media] {
+			respondError(c, newAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, "no preview available for this file type").withDetails(gin.H{"reason": "unsupported_type", "contentType": media}))
+			return
+		}
+
+		download, err := openBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer download.Close()
+
+		limit := fc.cfg.PreviewBytes
+		raw, err := io.ReadAll(io.LimitReader(download, int64(limit)+1))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		truncated := len(raw) > limit
+		if truncated {
+			raw = raw[:limit]
+		}
+
+		name, enc := detectTextEncoding(raw, file.ContentType, truncated)
+		if enc == nil {
+			respondError(c, newAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, "file content is not text").withDetails(gin.H{"reason": "binary_content", "contentType": media}))
+			return
+		}
+
+		text, err := decodePreview(raw, name, enc, truncated)
+		if err != nil {
+			respondError(c, newAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, "file content could not be decoded as "+name).withDetails(gin.H{"reason": "binary_content", "contentType": media}))
+			return
+		}
+
+		// Non-UTF-8 sources can grow when converted; keep the limit on output.
+		if len(text) > limit {
+			text = trimPartialRune(text[:limit])
+			truncated = true
+		}
+
+		kind := "text"
+		if language != "" {
+			kind = "code"
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"kind":      kind,
+			"language":  language,
+			"encoding":  name,
+			"content":   string(text),
+			"truncated": truncated,
+			"size":      file.Size,
+		})
+	}
+}
+
+// detectTextEncoding picks the encoding of raw from its byte order mark, the
+// charset of contentType, or failing those UTF-8 if it is valid and
+// Windows-1252 otherwise. A nil encoding means raw looks binary. truncated
+// says raw may end in the middle of a character.
+func detectTextEncoding(raw []byte, contentType string, truncated bool) (string, encoding.Encoding) {
+	switch {
+	case bytes.HasPrefix(raw, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", unicode.UTF8BOM
+	case bytes.HasPrefix(raw, []byte{0xFF, 0xFE}):
+		return "utf-16le", unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(raw, []byte{0xFE, 0xFF}):
+		return "utf-16be", unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	}
+
+	if bytes.IndexByte(raw, 0) >= 0 {
+		return "", nil
+	}
+
+	if _, params, ok := strings.Cut(contentType, "charset="); ok {
+		charset := strings.ToLower(strings.Trim(strings.TrimSpace(strings.Split(params, ";")[0]), `"`))
+		if enc, err := htmlindex.Get(charset); err == nil {
+			name, _ := htmlindex.Name(enc)
+			if name == "utf-8" {
+				return name, unicode.UTF8
+			}
+			return name, enc
+		}
+	}
+
+	if utf8.Valid(raw) || (truncated && utf8.Valid(trimPartialRune(raw))) {
+		return "utf-8", unicode.UTF8
+	}
+	return "windows-1252", charmap.Windows1252
+}
+
+// decodePreview converts raw from the encoding called name to UTF-8. When
+// raw was cut short, a trailing partial character is dropped first so it
+// doesn't decode as U+FFFD.
+func decodePreview(raw []byte, name string, enc encoding.Encoding, truncated bool) ([]byte, error) {
+	if truncated {
+		switch name {
+		case "utf-8":
+			raw = trimPartialRune(raw)
+		case "utf-16le", "utf-16be":
+			raw = trimPartialUTF16(raw, name == "utf-16be")
+		}
+	}
+	return enc.NewDecoder().Bytes(raw)
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		start := len(b) - i
+		if !utf8.RuneStart(b[start]) {
+			continue
+		}
+		if !utf8.FullRune(b[start:]) {
+			return b[:start]
+		}
+		break
+	}
+	return b
+}
+
+// trimPartialUTF16 drops an odd trailing byte and an unpaired high surrogate
+// from the end of UTF-16 data.
+func trimPartialUTF16(b []byte, bigEndian bool) []byte {
+	b = b[:len(b)&^1]
+	if len(b) < 2 {
+		return b
+	}
+
+	unit := uint16(b[len(b)-2]) | uint16(b[len(b)-1])<<8
+	if bigEndian {
+		unit = uint16(b[len(b)-2])<<8 | uint16(b[len(b)-1])
+	}
+	if unit >= 0xD800 && unit < 0xDC00 {
+		return b[:len(b)-2]
+	}
+	return b
+}
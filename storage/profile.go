@@ -0,0 +1,161 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// userPreferences are the caller's notification and tracking settings, as
+// consulted by share emails and the recently accessed files history.
+type userPreferences struct {
+	EmailOnShare        bool `json:"emailOnShare"`
+	TrackRecentActivity bool `json:"trackRecentActivity"`
+}
+
+// profileUser is a models.User without its MarshalJSON, which embedded as is
+// would be promoted and hide the fields profileResponse adds. The password
+// is cleared by newProfileResponse instead.
+type profileUser models.User
+
+// profileResponse is the caller's own account as returned by GetProfile.
+// Quota, MaxUploadSize and DownloadRate are the limits in effect, whether
+// set on the account, for its role or by default; a DownloadRate of zero is
+// unlimited.
+type profileResponse struct {
+	*profileUser
+	Quota         int64           `json:"quota"`
+	MaxUploadSize int64           `json:"maxUploadSize"`
+	DownloadRate  int64           `json:"downloadRate"`
+	Preferences   userPreferences `json:"preferences"`
+}
+
+func newProfileResponse(cfg *Config, user *models.User) profileResponse {
+	user.Password = ""
+	verified := user.IsVerified()
+	user.EmailVerified = &verified
+	if user.AvatarId != nil {
+		user.AvatarURL = cfg.avatarURL(user.Id, *user.AvatarId)
+	}
+
+	return profileResponse{
+		profileUser:   (*profileUser)(user),
+		Quota:         cfg.quotaLimit(user),
+		MaxUploadSize: cfg.roleUploadLimit(user.Role),
+		DownloadRate:  cfg.downloadRate(user.Role, user.DownloadRate),
+		Preferences: userPreferences{
+			EmailOnShare:        !user.DisableShareEmails,
+			TrackRecentActivity: !user.DisableAccessTracking,
+		},
+	}
+}
+
+// GetProfile handler returns the caller's own account.
+func (uc *UserController) GetProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		user, ok := loadCurrentUser(ctx, uc.db, c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, newProfileResponse(uc.cfg, user))
+	}
+}
+
+// updateProfileRequest lists the fields a user may change on their own
+// account through UpdateProfile. Fields left nil are not touched.
+type updateProfileRequest struct {
+	Name                *string `json:"name" binding:"omitempty,min=1,max=100"`
+	EmailOnShare        *bool   `json:"emailOnShare"`
+	TrackRecentActivity *bool   `json:"trackRecentActivity"`
+	RemoveAvatar        bool    `json:"removeAvatar"`
+}
+
+func (r updateProfileRequest) update() (bson.M, []string, error) {
+	set := bson.M{}
+	if r.Name != nil {
+		set["name"] = *r.Name
+	}
+	if r.EmailOnShare != nil {
+		set["disableShareEmails"] = !*r.EmailOnShare
+	}
+	if r.TrackRecentActivity != nil {
+		set["disableAccessTracking"] = !*r.TrackRecentActivity
+	}
+
+	fields := updatedFieldNames(set)
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if r.RemoveAvatar {
+		update["$unset"] = bson.M{"avatarId": ""}
+		fields = append(fields, "avatar")
+	}
+
+	if len(update) == 0 {
+		return nil, nil, errors.New("no updatable fields provided")
+	}
+	return update, fields, nil
+}
+
+// UpdateProfile handler lets the caller edit the safe fields of their own
+// account. Email, password, role and quota changes go through their own
+// endpoints or an admin.
+func (uc *UserController) UpdateProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		var req updateProfileRequest
+		if err := decodeStrictJSON(c, &req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		update, fields, err := req.update()
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		userId := currentUserID(c)
+		var previous struct {
+			AvatarId *primitive.ObjectID `bson:"avatarId"`
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before).SetProjection(bson.M{"avatarId": 1})
+		if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": userId}, update, opts).Decode(&previous); err != nil {
+			respondError(c, orNotFound(err, "User not found"))
+			return
+		}
+
+		if req.RemoveAvatar && previous.AvatarId != nil {
+			deleteAvatar(ctx, uc.db, *previous.AvatarId)
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, userId, gin.H{"fields": fields})
+
+		// Opting out also forgets the history recorded so far.
+		if req.TrackRecentActivity != nil && !*req.TrackRecentActivity {
+			accesses := uc.db.Collection(FileAccessCollection)
+			if _, err := accesses.DeleteMany(ctx, bson.M{"userId": userId}); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+
+		user, ok := loadCurrentUser(ctx, uc.db, c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, newProfileResponse(uc.cfg, user))
+	}
+}
@@ -0,0 +1,156 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TrashPurgeBatch is how many files the purge job removes per round trip.
+const TrashPurgeBatch = 100
+
+// trashPurgeLock names the lease that keeps purge runs on one instance.
+const trashPurgeLock = "trash-purge"
+
+var errPurgeRunning = errors.New("a trash purge is already running")
+
+// purgeSummary reports what a purge run removed.
+type purgeSummary struct {
+	Files       int   `json:"files"`
+	BytesFreed  int64 `json:"bytesFreed"`
+	FailedBlobs int   `json:"failedBlobs"`
+}
+
+// StartTrashPurger runs purgeTrash, purgeDeletedUsers, expireFiles and
+// expireDirectUploads every cfg.TrashPurgeInterval until ctx is cancelled.
+func StartTrashPurger(ctx context.Context, db *mongo.Database, cfg *Config) {
+	startWorker(func() {
+		ticker := time.NewTicker(cfg.TrashPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := purgeTrash(ctx, db, cfg); err != errPurgeRunning && ctx.Err() == nil {
+					recordJob("trash_purge", err)
+					if err != nil {
+						log.Printf("trash purge failed: %v", err)
+					}
+				}
+
+				if _, err := purgeDeletedUsers(ctx, db, cfg); err != errUserPurgeRunning && ctx.Err() == nil {
+					recordJob("user_purge", err)
+					if err != nil {
+						log.Printf("user purge failed: %v", err)
+					}
+				}
+
+				if _, err := expireFiles(ctx, db, cfg); ctx.Err() == nil {
+					recordJob("file_expiry", err)
+					if err != nil {
+						log.Printf("file expiry failed: %v", err)
+					}
+				}
+
+				if _, err := expireDirectUploads(ctx, db, cfg); ctx.Err() == nil {
+					recordJob("direct_upload_expiry", err)
+					if err != nil {
+						log.Printf("direct upload expiry failed: %v", err)
+					}
+				}
+			}
+		}
+	})
+}
+
+// purgeTrash hard-deletes files trashed longer than cfg.TrashRetention ago,
+// in batches, while holding the purge lock. It returns errPurgeRunning when
+// another run holds the lock.
+func purgeTrash(ctx context.Context, db *mongo.Database, cfg *Config) (purgeSummary, error) {
+	var summary purgeSummary
+
+	acquired, err := acquireLock(ctx, db, trashPurgeLock, cfg.TrashPurgeInterval)
+	if err != nil {
+		return summary, err
+	}
+	if !acquired {
+		return summary, errPurgeRunning
+	}
+	defer func() {
+		if err := releaseLock(context.Background(), db, trashPurgeLock); err != nil {
+			log.Printf("releasing trash purge lock failed: %v", err)
+		}
+	}()
+
+	collection := db.Collection(FileCollection)
+	expired := bson.M{"deletedAt": bson.M{"$lt": time.Now().Add(-cfg.TrashRetention)}}
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "size": 1}).
+		SetSort(bson.D{{Key: "deletedAt", Value: 1}}).
+		SetLimit(TrashPurgeBatch)
+
+	for ctx.Err() == nil {
+		var batch []models.File
+		if err := findAll(ctx, collection, expired, &batch, opts); err != nil {
+			return summary, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		ids := make([]primitive.ObjectID, len(batch))
+		var bytes int64
+		for i, file := range batch {
+			ids[i] = file.Id
+			bytes += file.Size
+		}
+
+		// Re-check deletedAt so a file restored since the find is kept.
+		filter := bson.M{"_id": bson.M{"$in": ids}, "deletedAt": expired["deletedAt"]}
+		failed, err := purgeFiles(ctx, db, cfg, filter)
+		if err != nil {
+			return summary, err
+		}
+
+		summary.Files += len(batch)
+		summary.BytesFreed += bytes
+		summary.FailedBlobs += failed
+
+		if len(batch) < TrashPurgeBatch {
+			break
+		}
+	}
+
+	log.Printf("trash purge: removed %d files, freed %d bytes, %d blobs left behind", summary.Files, summary.BytesFreed, summary.FailedBlobs)
+	return summary, ctx.Err()
+}
+
+// PurgeTrash handler runs the trash purge now.
+func (ac *AdminController) PurgeTrash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		summary, err := purgeTrash(ctx, ac.db, ac.cfg)
+		if err == errPurgeRunning {
+			respondError(c, conflict(err.Error()))
+			return
+		}
+		if err != nil {
+			respondError(c, internalError("purge stopped, the summary lists what was removed so far", err).withDetails(gin.H{"summary": summary}))
+			return
+		}
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,158 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// QR code sizes accepted by ?size=, in pixels per side.
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 1024
+)
+
+// qrLevels are the error correction levels accepted by ?level=, by the
+// share of the code that can be damaged and still scan: L 7%, M 15%, Q 25%
+// and H 30%.
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// GetShareQR handler renders the URL of a share link the caller manages as
+// a PNG QR code.
+func (sc *ShareController) GetShareQR() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := sc.db.Collection(ShareCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid share ID"))
+			return
+		}
+
+		filter := bson.M{"_id": objId, "revokedAt": bson.M{"$exists": false}}
+		if !isAdmin(c) {
+			filter["$or"] = shareManagerFilter(c)
+		}
+
+		var share models.Share
+		if err := collection.FindOne(ctx, filter).Decode(&share); err != nil {
+			respondShareLookupError(c, err)
+			return
+		}
+
+		respondQRCode(c, shareURL(c, sc.cfg, &share), sc.cfg.CacheControl.Private)
+	}
+}
+
+// GetPublicShareQR handler renders a share link's URL as a PNG QR code for
+// as long as the link still works, so revoked, expired and used up links
+// stop producing codes to scan.
+func (sc *ShareController) GetPublicShareQR() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := sc.db
+
+		var share models.Share
+		if err := db.Collection(ShareCollection).FindOne(ctx, publicShareFilter(c)).Decode(&share); err != nil {
+			respondShareLookupError(c, err)
+			return
+		}
+
+		if share.Expired(time.Now()) {
+			respondError(c, gone("share link has expired"))
+			return
+		}
+		if share.MaxDownloads != nil && share.Downloads >= *share.MaxDownloads {
+			respondError(c, gone("share link download limit reached"))
+			return
+		}
+
+		count, err := db.Collection(FileCollection).CountDocuments(ctx, bson.M{"_id": share.FileId, "deletedAt": notTrashed, "expiresAt": notExpired()})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if count == 0 {
+			respondShareLookupError(c, mongo.ErrNoDocuments)
+			return
+		}
+
+		respondQRCode(c, shareURL(c, sc.cfg, &share), sc.cfg.CacheControl.Private)
+	}
+}
+
+// shareURL is the absolute URL of a share link. Without
+// cfg.PublicBaseURL it is built from the host the request came in on, since
+// a scanned code can't resolve a relative URL.
+func shareURL(c *gin.Context, cfg *Config, share *models.Share) string {
+	if cfg.PublicBaseURL != "" {
+		return cfg.apiURL("/s/" + share.Token)
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + cfg.APIPrefix + "/s/" + share.Token
+}
+
+// respondQRCode sends content as a PNG QR code, sized and error corrected as
+// ?size= and ?level= ask. The ETag covers all three, so clients revalidate
+// cheaply; every revalidation goes through the handler's checks again.
+func respondQRCode(c *gin.Context, content string, cacheControl string) {
+	size := defaultQRSize
+	if raw := c.Query("size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			respondError(c, badRequest(fmt.Sprintf("size must be between %d and %d", minQRSize, maxQRSize)))
+			return
+		}
+		size = n
+	}
+
+	level := strings.ToUpper(c.DefaultQuery("level", "M"))
+	recovery, ok := qrLevels[level]
+	if !ok {
+		respondError(c, badRequest("level must be one of L, M, Q and H"))
+		return
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%s", content, size, level)))
+	v := validators{etag: etag(hex.EncodeToString(sum[:]))}
+	c.Header("Cache-Control", cacheControl)
+	if v.respondNotModified(c) {
+		return
+	}
+
+	code, err := qrcode.New(content, recovery)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	png, err := code.PNG(size)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "image/png", png)
+}
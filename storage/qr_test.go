@@ -0,0 +1,111 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// qrRouter serves respondQRCode for content at GET /qr.
+func qrRouter(content string) *gin.Engine {
+	router := gin.New()
+	router.GET("/qr", func(c *gin.Context) { respondQRCode(c, content, "private, no-cache") })
+	return router
+}
+
+func TestRespondQRCodeEncodesContent(t *testing.T) {
+	const content = "https://files.example.com/api/v1/s/abc123"
+
+	rec := httptest.NewRecorder()
+	qrRouter(content).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/qr?size=300&level=q", nil))
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("got %d %s, want a PNG", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("decoding the PNG: %v", err)
+	}
+	if size := img.Bounds().Dx(); size != 300 || img.Bounds().Dy() != 300 {
+		t.Fatalf("image is %v, want 300x300", img.Bounds())
+	}
+
+	// Read the modules back off the image and compare them with a code for
+	// content at the level asked for.
+	want, err := qrcode.New(content, qrcode.High)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitmap := want.Bitmap()
+	modules := len(bitmap)
+	for y := 0; y < modules; y++ {
+		for x := 0; x < modules; x++ {
+			px := (2*x + 1) * 300 / (2 * modules)
+			py := (2*y + 1) * 300 / (2 * modules)
+			r, _, _, _ := img.At(px, py).RGBA()
+			if dark := r < 0x8000; dark != bitmap[y][x] {
+				t.Fatalf("module (%d, %d) is dark=%v, want %v", x, y, dark, bitmap[y][x])
+			}
+		}
+	}
+}
+
+func TestRespondQRCodeRejectsBadParameters(t *testing.T) {
+	for _, query := range []string{"size=10", "size=big", "size=4096", "level=Z"} {
+		rec := httptest.NewRecorder()
+		qrRouter("https://example.com").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/qr?"+query, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status = %d, want 400", query, rec.Code)
+			continue
+		}
+		if body := decodeError(t, rec); body.Error.Code != ErrCodeInvalidRequest {
+			t.Errorf("?%s: code = %q, want %q", query, body.Error.Code, ErrCodeInvalidRequest)
+		}
+	}
+}
+
+func TestRespondQRCodeNotModified(t *testing.T) {
+	router := qrRouter("https://example.com/s/abc")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/qr", nil))
+	tag := rec.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("no ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/qr", nil)
+	req.Header.Set("If-None-Match", tag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", rec.Code)
+	}
+}
+
+func TestShareURL(t *testing.T) {
+	cfg := testConfig()
+	cfg.APIPrefix = "/api/v1"
+	share := &models.Share{Token: "abc123"}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Host = "files.internal:8080"
+
+	cfg.PublicBaseURL = ""
+	if got, want := shareURL(c, cfg, share), "http://files.internal:8080/api/v1/s/abc123"; got != want {
+		t.Errorf("without PublicBaseURL: %q, want %q", got, want)
+	}
+
+	cfg.PublicBaseURL = "https://files.example.com"
+	if got, want := shareURL(c, cfg, share), "https://files.example.com/api/v1/s/abc123"; got != want {
+		t.Errorf("with PublicBaseURL: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// userFields maps the field names clients may request via ?fields= on user
+// listings to their document keys. Password hashes and tokens are never
+// listed here.
+var userFields = map[string]string{
+	"id":                    "_id",
+	"name":                  "name",
+	"email":                 "email",
+	"role":                  "role",
+	"quotaBytes":            "quotaBytes",
+	"usedBytes":             "usedBytes",
+	"disableAccessTracking": "disableAccessTracking",
+	"disableShareEmails":    "disableShareEmails",
+	"emailVerified":         "emailVerified",
+	"twoFactorEnabled":      "twoFactorEnabled",
+	"avatarUrl":             "avatarId",
+	"createdAt":             "createdAt",
+}
+
+// allowedFieldNames returns the sorted client-facing names of an allowlist.
+func allowedFieldNames(allowed map[string]string) []string {
+	names := make([]string, 0, len(allowed))
+	for name := range allowed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseProjection turns ?fields=a,b into a Mongo projection restricted to the
+// allowlist. Without the param every allowlisted field is projected.
+func parseProjection(c *gin.Context, allowed map[string]string) (bson.D, error) {
+	raw := c.Query("fields")
+
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := allowed[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		names = allowedFieldNames(allowed)
+	}
+
+	projection := bson.D{}
+	for _, name := range names {
+		projection = append(projection, bson.E{Key: allowed[name], Value: 1})
+	}
+	if _, ok := allowed["id"]; ok && !containsString(names, "id") {
+		projection = append(projection, bson.E{Key: "_id", Value: 0})
+	}
+
+	return projection, nil
+}
+
+// projectsKey reports whether projection includes the document key.
+func projectsKey(projection bson.D, key string) bool {
+	for _, field := range projection {
+		if field.Key == key && field.Value == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// userSortFields lists the fields GetUsers may be ordered by via ?sort=.
+var userSortFields = map[string]string{
+	"name":      "name",
+	"email":     "email",
+	"createdAt": "createdAt",
+}
+
+// parseSortOptions reads ?sort= and ?order=asc|desc and builds a sort
+// document restricted to the allowlist. _id is appended as a tie-breaker so
+// paging through equal keys stays deterministic.
+func parseSortOptions(c *gin.Context, allowed map[string]string, defaultField string, defaultOrder string) (bson.D, error) {
+	field := c.DefaultQuery("sort", defaultField)
+	key, ok := allowed[field]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", field)
+	}
+
+	direction := 1
+	switch c.DefaultQuery("order", defaultOrder) {
+	case "asc":
+	case "desc":
+		direction = -1
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	sortDoc := bson.D{{Key: key, Value: direction}}
+	if key != "_id" {
+		sortDoc = append(sortDoc, bson.E{Key: "_id", Value: direction})
+	}
+	return sortDoc, nil
+}
+
+// fileSortFields lists the fields file listings may be ordered by.
+var fileSortFields = map[string]string{
+	"name":      "name",
+	"size":      "size",
+	"createdAt": "createdAt",
+}
@@ -0,0 +1,141 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// quotaLimit returns the quota that applies to user.
+func (cfg *Config) quotaLimit(user *models.User) int64 {
+	if user.QuotaBytes != nil {
+		return *user.QuotaBytes
+	}
+	return cfg.DefaultQuotaBytes
+}
+
+// loadQuotaUser reads the fields needed for quota checks of userId.
+func loadQuotaUser(ctx context.Context, db *mongo.Database, userId primitive.ObjectID) (*models.User, error) {
+	collection := db.Collection(UserCollection)
+
+	var user models.User
+	if err := collection.FindOne(ctx, bson.M{"_id": userId}).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// respondQuotaExceeded writes the 413 response for an upload that doesn't fit.
+func respondQuotaExceeded(c *gin.Context, user *models.User, limit int64) {
+	err := newAPIError(http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "storage quota exceeded")
+	respondError(c, err.withDetails(gin.H{"used": user.UsedBytes, "limit": limit}))
+}
+
+// checkQuota rejects a write of n bytes up front when it can't fit in the
+// caller's remaining quota, writing a 413 or 500 response. It reserves
+// nothing; reserveQuota does that once the real size is known.
+func checkQuota(ctx context.Context, db *mongo.Database, cfg *Config, c *gin.Context, userId primitive.ObjectID, n int64) bool {
+	user, err := loadQuotaUser(ctx, db, userId)
+	if err != nil {
+		respondError(c, err)
+		return false
+	}
+
+	if limit := cfg.quotaLimit(user); user.UsedBytes+n > limit {
+		respondQuotaExceeded(c, user, limit)
+		return false
+	}
+	return true
+}
+
+// reserveQuota atomically adds n bytes to the user's usage if the result
+// stays within their quota, writing a 413 or 500 response when it doesn't.
+func reserveQuota(ctx context.Context, db *mongo.Database, cfg *Config, c *gin.Context, userId primitive.ObjectID, n int64) bool {
+	reserved, err := claimQuota(ctx, db, cfg.DefaultQuotaBytes, userId, n)
+	if err != nil {
+		respondError(c, err)
+		return false
+	}
+	if reserved {
+		return true
+	}
+
+	user, err := loadQuotaUser(ctx, db, userId)
+	if err != nil {
+		respondError(c, err)
+		return false
+	}
+	respondQuotaExceeded(c, user, cfg.quotaLimit(user))
+	return false
+}
+
+// claimQuota atomically adds n bytes to the user's usage if the result stays
+// within their quota, or defaultQuota if they have none, reporting whether
+// it did.
+func claimQuota(ctx context.Context, db *mongo.Database, defaultQuota int64, userId primitive.ObjectID, n int64) (bool, error) {
+	collection := db.Collection(UserCollection)
+
+	filter := bson.M{
+		"_id": userId,
+		"$expr": bson.M{"$lte": bson.A{
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$usedBytes", 0}}, n}},
+			bson.M{"$ifNull": bson.A{"$quotaBytes", defaultQuota}},
+		}},
+	}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"usedBytes": n}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// releaseQuota takes n bytes off the user's usage. Failures are logged, since
+// the content they account for is already gone.
+func releaseQuota(ctx context.Context, db *mongo.Database, userId primitive.ObjectID, n int64) {
+	// Quota is often released because the request failed, possibly by
+	// the client going away, so this must not be cancelled with it.
+	if err := deductQuota(context.WithoutCancel(ctx), db, userId, n); err != nil {
+		log.Printf("releasing %d bytes of quota for user %s failed: %v", n, userId.Hex(), err)
+	}
+}
+
+// deductQuota is releaseQuota for callers that handle the error, such as a
+// transaction that must not commit the deletion without it.
+func deductQuota(ctx context.Context, db *mongo.Database, userId primitive.ObjectID, n int64) error {
+	if n == 0 {
+		return nil
+	}
+
+	collection := db.Collection(UserCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$inc": bson.M{"usedBytes": -n}})
+	return err
+}
+
+// GetUsage handler reports the caller's storage usage against their quota.
+func (uc *UserController) GetUsage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		user, err := loadQuotaUser(ctx, uc.db, currentUserID(c))
+		if err != nil {
+			respondError(c, orNotFound(err, "User not found"))
+			return
+		}
+
+		limit := uc.cfg.quotaLimit(user)
+		percentage := 0.0
+		if limit > 0 {
+			percentage = float64(user.UsedBytes) / float64(limit) * 100
+		} else if user.UsedBytes > 0 {
+			percentage = 100
+		}
+
+		c.JSON(http.StatusOK, gin.H{"used": user.UsedBytes, "quota": limit, "percentage": percentage})
+	}
+}
@@ -0,0 +1,97 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errRangeUnsatisfiable = errors.New("requested range not satisfiable")
+var errRangeMalformed = errors.New("malformed range header")
+
+// byteRange is a single satisfiable range within a file.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// end returns the inclusive last byte offset of the range.
+func (r byteRange) end() int64 {
+	return r.start + r.length - 1
+}
+
+// parseRange parses a Range header against a file of the given size. Only a
+// single range is supported; multi-range requests are unsatisfiable. A
+// malformed header returns errRangeMalformed and should be ignored.
+func parseRange(header string, size int64) (byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return byteRange{}, errRangeMalformed
+	}
+	if strings.Contains(spec, ",") {
+		return byteRange{}, errRangeUnsatisfiable
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return byteRange{}, errRangeMalformed
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, errRangeMalformed
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, errRangeUnsatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return byteRange{start: size - n, length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, errRangeMalformed
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, errRangeMalformed
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return byteRange{}, errRangeUnsatisfiable
+	}
+	return byteRange{start: start, length: end - start + 1}, nil
+}
+
+// requestedRange resolves the request's Range header against a file of the
+// given size, reporting whether a partial response applies. The whole file
+// is sent when there is no header, when it is malformed, or when If-Range no
+// longer matches; errRangeUnsatisfiable means nothing can be sent.
+func requestedRange(c *gin.Context, v validators, size int64) (byteRange, bool, error) {
+	whole := byteRange{start: 0, length: size}
+	header := c.GetHeader("Range")
+	if header == "" || !v.rangeApplies(c) {
+		return whole, false, nil
+	}
+	rng, err := parseRange(header, size)
+	switch err {
+	case nil:
+		return rng, true, nil
+	case errRangeUnsatisfiable:
+		return byteRange{}, false, err
+	}
+	return whole, false, nil
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 1000
+	tests := []struct {
+		header string
+		start  int64
+		length int64
+		err    error
+	}{
+		{"bytes=100-", 100, 900, nil},
+		{"bytes=999-", 999, 1, nil},
+		{"bytes=1000-", 0, 0, errRangeUnsatisfiable},
+		{"bytes=-500", 500, 500, nil},
+		{"bytes=-1", 999, 1, nil},
+		{"bytes=-1000", 0, 1000, nil},
+		{"bytes=-5000", 0, 1000, nil},
+		{"bytes=-0", 0, 0, errRangeUnsatisfiable},
+		{"bytes=0-0", 0, 1, nil},
+		{"bytes=0-999", 0, 1000, nil},
+		{"bytes=998-5000", 998, 2, nil},
+		{"bytes=5000-6000", 0, 0, errRangeUnsatisfiable},
+		{"bytes=0-1,5-6", 0, 0, errRangeUnsatisfiable},
+		{"bytes=500-499", 0, 0, errRangeMalformed},
+		{"bytes=a-", 0, 0, errRangeMalformed},
+		{"bytes=5", 0, 0, errRangeMalformed},
+		{"items=0-5", 0, 0, errRangeMalformed},
+	}
+	for _, tt := range tests {
+		rng, err := parseRange(tt.header, size)
+		if err != tt.err || rng.start != tt.start || rng.length != tt.length {
+			t.Errorf("parseRange(%q) = %+v, %v; want start %d length %d, %v", tt.header, rng, err, tt.start, tt.length, tt.err)
+		}
+	}
+}
+
+func TestParseRangeEmptyFile(t *testing.T) {
+	for _, header := range []string{"bytes=0-", "bytes=-10"} {
+		if _, err := parseRange(header, 0); err != errRangeUnsatisfiable {
+			t.Errorf("parseRange(%q, 0) = %v, want unsatisfiable", header, err)
+		}
+	}
+}
+
+// gridfsBlob is the server's answer to the files and chunks lookups a GridFS
+// download of content makes, held in a single chunk.
+func gridfsBlob(t testing.TB, id primitive.ObjectID, content []byte) []bson.D {
+	return []bson.D{
+		found(t, FileBucket+".files", bson.M{"_id": id, "length": int64(len(content)), "chunkSize": int32(255 * 1024), "uploadDate": time.Now(), "filename": "clip.bin"}),
+		found(t, FileBucket+".chunks", bson.M{"_id": primitive.NewObjectID(), "files_id": id, "n": int32(0), "data": primitive.Binary{Data: content}}),
+	}
+}
+
+func TestStreamFileRanges(t *testing.T) {
+	mt := newMockDB(t)
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	file := &models.File{
+		Id:          primitive.NewObjectID(),
+		Name:        "clip.bin",
+		Size:        int64(len(content)),
+		ContentType: "application/octet-stream",
+		BlobRef:     models.BlobRef{BlobId: primitive.NewObjectID()},
+	}
+
+	tests := []struct {
+		header       string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"", http.StatusOK, "", content},
+		{"bytes=100-", http.StatusPartialContent, "bytes 100-999/1000", content[100:]},
+		{"bytes=-500", http.StatusPartialContent, "bytes 500-999/1000", content[500:]},
+		{"bytes=0-0", http.StatusPartialContent, "bytes 0-0/1000", content[:1]},
+		{"bytes=999-999", http.StatusPartialContent, "bytes 999-999/1000", content[999:]},
+		{"bytes=998-5000", http.StatusPartialContent, "bytes 998-999/1000", content[998:]},
+		{"bytes=1000-", http.StatusRequestedRangeNotSatisfiable, "bytes */1000", nil},
+		{"bytes=0-1,5-6", http.StatusRequestedRangeNotSatisfiable, "bytes */1000", nil},
+		{"bytes=5-2", http.StatusOK, "", content},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.header, func(mt *mtest.T) {
+			if tt.status != http.StatusRequestedRangeNotSatisfiable {
+				mt.AddMockResponses(gridfsBlob(mt, file.BlobId, content)...)
+			}
+			router := gin.New()
+			router.GET("/download", func(c *gin.Context) { streamFile(c, mt.DB, testConfig(), file, "attachment") })
+
+			req := httptest.NewRequest(http.MethodGet, "/download", nil)
+			if tt.header != "" {
+				req.Header.Set("Range", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status || rec.Header().Get("Content-Range") != tt.contentRange {
+				mt.Fatalf("%d with Content-Range %q, want %d with %q", rec.Code, rec.Header().Get("Content-Range"), tt.status, tt.contentRange)
+			}
+			if tt.status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if !bytes.Equal(rec.Body.Bytes(), tt.body) {
+				mt.Errorf("got %d bytes, want %d starting at %d", rec.Body.Len(), len(tt.body), tt.body[0])
+			}
+			if rec.Header().Get("Accept-Ranges") != "bytes" {
+				mt.Errorf("Accept-Ranges = %q", rec.Header().Get("Accept-Ranges"))
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var RateLimitCollection string = "rateLimits"
+
+// Rate limit stores: buckets in memory, for a single instance, or in
+// MongoDB, shared between instances.
+const (
+	rateLimitStoreMemory = "memory"
+	rateLimitStoreMongo  = "mongo"
+)
+
+// RateLimitRule is a token bucket holding up to Burst requests that refills
+// completely over Per. A burst of 0 turns the limit off.
+type RateLimitRule struct {
+	Burst int
+	Per   time.Duration
+}
+
+// rate is the refill speed in tokens per second.
+func (l RateLimitRule) rate() float64 {
+	return float64(l.Burst) / l.Per.Seconds()
+}
+
+// rateDecision is the outcome of taking a token from a bucket.
+type rateDecision struct {
+	Allowed bool
+	// Tokens is what is left in the bucket afterwards.
+	Tokens float64
+}
+
+// rateLimitStore keeps token buckets by key.
+type rateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimitRule) (rateDecision, error)
+}
+
+// rateKey names whose bucket a request draws from; an empty key skips the
+// limit.
+type rateKey func(c *gin.Context) string
+
+func byIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// byUser must run after AuthRequired.
+func byUser(c *gin.Context) string {
+	if id := currentUserID(c); !id.IsZero() {
+		return "user:" + id.Hex()
+	}
+	return byIP(c)
+}
+
+// byRequestToken keys on the :token of a public file request link.
+func byRequestToken(c *gin.Context) string {
+	return "token:" + c.Param("token")
+}
+
+// RateLimit limits requests per key to limit, answering 429 with
+// Retry-After once the bucket is empty. Every response carries the
+// X-RateLimit-* headers. If the store fails the request is let through.
+func RateLimit(db *mongo.Database, backend string, name string, limit RateLimitRule, key rateKey) gin.HandlerFunc {
+	store := rateLimitStoreFor(db, backend)
+	return func(c *gin.Context) {
+		id := key(c)
+		if limit.Burst == 0 || id == "" {
+			c.Next()
+			return
+		}
+
+		decision, err := store.Take(c.Request.Context(), name+":"+id, limit)
+		if err != nil {
+			log.Printf("rate limit %s failed, allowing request: %v", name, err)
+			c.Next()
+			return
+		}
+
+		rate := limit.rate()
+		reset := (float64(limit.Burst) - decision.Tokens) / rate
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(int(math.Floor(decision.Tokens))))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset))))
+
+		if !decision.Allowed {
+			retryAfter := (1 - decision.Tokens) / rate
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter))))
+			respondError(c, newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "rate limit exceeded, try again later"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// sharedMemoryStore is the one in-memory store of the process, so every
+// route group drawing from a bucket sees the same tokens.
+var sharedMemoryStore = newMemoryRateStore()
+
+// rateLimitStoreFor returns the store of backend, a RateLimitConfig.Store.
+func rateLimitStoreFor(db *mongo.Database, backend string) rateLimitStore {
+	if backend == rateLimitStoreMongo {
+		return &mongoRateStore{collection: db.Collection(RateLimitCollection)}
+	}
+	return sharedMemoryStore
+}
+
+// refill returns the tokens of a bucket that held tokens elapsed ago.
+func refill(tokens float64, elapsed time.Duration, limit RateLimitRule) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.rate())
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	per     time.Duration
+}
+
+// memoryRateStore keeps buckets in a map. It only limits a single instance.
+type memoryRateStore struct {
+	clock     clock
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+func newMemoryRateStore() *memoryRateStore {
+	return &memoryRateStore{clock: systemClock{}, buckets: map[string]*memoryBucket{}}
+}
+
+func (s *memoryRateStore) Take(ctx context.Context, key string, limit RateLimitRule) (rateDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = refill(bucket.tokens, now.Sub(bucket.updated), limit)
+	bucket.updated = now
+	bucket.per = limit.Per
+
+	decision := rateDecision{Allowed: bucket.tokens >= 1}
+	if decision.Allowed {
+		bucket.tokens--
+	}
+	decision.Tokens = bucket.tokens
+
+	if now.Sub(s.lastSweep) >= time.Minute {
+		s.sweep(now)
+	}
+	return decision, nil
+}
+
+// sweep drops buckets that have refilled completely; they are the same as
+// a missing one.
+func (s *memoryRateStore) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.updated) >= bucket.per {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// mongoRateStore keeps buckets as documents, refilled and drawn from in a
+// single atomic update so instances can share them.
+type mongoRateStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRateStore) Take(ctx context.Context, key string, limit RateLimitRule) (rateDecision, error) {
+	now := time.Now()
+	burst := float64(limit.Burst)
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updatedAt", now}}}}, 1000}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$min": bson.A{burst, bson.M{"$add": bson.A{
+				bson.M{"$ifNull": bson.A{"$tokens", burst}},
+				bson.M{"$multiply": bson.A{bson.M{"$max": bson.A{elapsed, 0}}, limit.rate()}},
+			}}}},
+			"updatedAt": now,
+		}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+		{{Key: "$set", Value: bson.M{
+			"tokens":    bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+			"expiresAt": now.Add(limit.Per),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var bucket struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&bucket)
+	// Two instances upserting a new bucket at once; the loser retries
+	// against the winner's document.
+	if mongo.IsDuplicateKeyError(err) {
+		err = s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&bucket)
+	}
+	if err != nil {
+		return rateDecision{}, err
+	}
+	return rateDecision{Allowed: bucket.Allowed, Tokens: bucket.Tokens}, nil
+}
@@ -0,0 +1,153 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// take draws n tokens from store's bucket key and returns how many were
+// allowed.
+func take(t *testing.T, store rateLimitStore, key string, limit RateLimitRule, n int) int {
+	t.Helper()
+	allowed := 0
+	for range n {
+		decision, err := store.Take(context.Background(), key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestMemoryRateStoreBurstAndRefill(t *testing.T) {
+	fake := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := newMemoryRateStore()
+	store.clock = fake
+	limit := RateLimitRule{Burst: 10, Per: time.Minute}
+
+	if got := take(t, store, "ip:a", limit, 12); got != 10 {
+		t.Errorf("allowed %d of 12 at once, want the burst of 10", got)
+	}
+
+	// One token comes back every 6s.
+	fake.Sleep(context.Background(), 13*time.Second)
+	if got := take(t, store, "ip:a", limit, 3); got != 2 {
+		t.Errorf("allowed %d after 13s, want 2 refilled", got)
+	}
+
+	// A bucket never holds more than its burst.
+	fake.Sleep(context.Background(), time.Hour)
+	if got := take(t, store, "ip:a", limit, 12); got != 10 {
+		t.Errorf("allowed %d after an hour, want the burst of 10", got)
+	}
+
+	if got := take(t, store, "ip:b", limit, 1); got != 1 {
+		t.Error("another key shares the spent bucket")
+	}
+}
+
+func TestMemoryRateStoreSweepsFullBuckets(t *testing.T) {
+	fake := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := newMemoryRateStore()
+	store.clock = fake
+	limit := RateLimitRule{Burst: 1, Per: time.Minute}
+
+	take(t, store, "ip:old", limit, 1)
+	fake.Sleep(context.Background(), 2*time.Minute)
+	take(t, store, "ip:new", limit, 1)
+
+	if _, kept := store.buckets["ip:old"]; kept {
+		t.Error("refilled bucket was not swept")
+	}
+	if _, kept := store.buckets["ip:new"]; !kept {
+		t.Error("bucket in use was swept")
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	router := gin.New()
+	router.GET("/", RateLimit(nil, rateLimitStoreMemory, t.Name(), RateLimitRule{Burst: 2, Per: time.Minute}, byIP), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	for _, remaining := range []string{"1", "0"} {
+		rec := get()
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want the request let through", rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Errorf("headers = %v, want limit 2 and %s remaining", rec.Header(), remaining)
+		}
+	}
+
+	rec := get()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if body := decodeError(t, rec); body.Error.Code != ErrCodeRateLimited {
+		t.Errorf("code = %q, want %q", body.Error.Code, ErrCodeRateLimited)
+	}
+	// A token takes 30s to come back, the whole bucket a minute.
+	if rec.Header().Get("Retry-After") != "30" || rec.Header().Get("X-RateLimit-Reset") != "60" {
+		t.Errorf("Retry-After %q, X-RateLimit-Reset %q, want 30 and 60", rec.Header().Get("Retry-After"), rec.Header().Get("X-RateLimit-Reset"))
+	}
+}
+
+func TestMongoRateStore(t *testing.T) {
+	mt := newMockDB(t)
+	limit := RateLimitRule{Burst: 10, Per: time.Minute}
+	bucket := func(tokens float64, allowed bool) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.M{"_id": "login:ip:a", "tokens": tokens, "allowed": allowed}})
+	}
+
+	mt.Run("take", func(mt *mtest.T) {
+		mt.AddMockResponses(bucket(4, true))
+		store := rateLimitStoreFor(mt.DB, rateLimitStoreMongo)
+		decision, err := store.Take(context.Background(), "login:ip:a", limit)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if decision != (rateDecision{Allowed: true, Tokens: 4}) {
+			mt.Errorf("decision = %+v, want the stored bucket's", decision)
+		}
+
+		cmd := sentCommand(mt, "findAndModify")
+		if cmd.Lookup("query", "_id").StringValue() != "login:ip:a" || !cmd.Lookup("upsert").Boolean() || !cmd.Lookup("new").Boolean() {
+			mt.Errorf("findAndModify = %s, want an upsert of the key returning the new bucket", cmd)
+		}
+	})
+
+	mt.Run("concurrent upsert", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11000, Message: "E11000 duplicate key error"}), bucket(0, false))
+		decision, err := rateLimitStoreFor(mt.DB, rateLimitStoreMongo).Take(context.Background(), "login:ip:a", limit)
+		if err != nil || decision.Allowed {
+			mt.Errorf("decision = %+v, %v; want the retry's answer", decision, err)
+		}
+	})
+
+	mt.Run("store fails open", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "unavailable"}))
+		router := gin.New()
+		router.GET("/", RateLimit(mt.DB, rateLimitStoreMongo, "login", limit, byIP), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusNoContent {
+			mt.Errorf("status = %d, want the request let through", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var FileAccessCollection string = "fileAccesses"
+
+// RecentFilesLimit is how many files GetRecent returns.
+const RecentFilesLimit = 20
+
+// accessQueueSize bounds the access records waiting to be written. Records
+// are dropped when it is full; tracking is best-effort.
+const accessQueueSize = 1024
+
+type accessRecord struct {
+	userId primitive.ObjectID
+	fileId primitive.ObjectID
+	at     time.Time
+}
+
+var accessRecords = make(chan accessRecord, accessQueueSize)
+
+// recordAccess notes that the caller opened file without waiting for the
+// write, so it never slows down or fails the request.
+func recordAccess(c *gin.Context, file *models.File) {
+	select {
+	case accessRecords <- accessRecord{userId: currentUserID(c), fileId: file.Id, at: time.Now()}:
+	default:
+	}
+}
+
+// StartAccessRecorder writes queued access records until ctx is cancelled.
+func StartAccessRecorder(ctx context.Context, db *mongo.Database) {
+	startWorker(func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case record := <-accessRecords:
+				err := writeAccess(ctx, db, record)
+				if ctx.Err() != nil {
+					continue
+				}
+				recordJob("access_recording", err)
+				if err != nil {
+					log.Printf("recording access to file %s failed: %v", record.fileId.Hex(), err)
+				}
+			}
+		}
+	})
+}
+
+// writeAccess upserts the record unless the user opted out of tracking.
+func writeAccess(ctx context.Context, db *mongo.Database, record accessRecord) error {
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"disableAccessTracking": 1})
+	if err := db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": record.userId}, opts).Decode(&user); err != nil {
+		return err
+	}
+	if user.DisableAccessTracking {
+		return nil
+	}
+
+	_, err := db.Collection(FileAccessCollection).UpdateOne(ctx,
+		bson.M{"userId": record.userId, "fileId": record.fileId},
+		bson.M{"$max": bson.M{"lastAccessedAt": record.at}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+type recentFile struct {
+	File           models.File `json:"file" bson:"file"`
+	LastAccessedAt time.Time   `json:"lastAccessedAt" bson:"lastAccessedAt"`
+}
+
+// GetRecent handler lists the files the caller opened most recently. Files
+// since trashed, deleted or no longer accessible are skipped. Users who opted
+// out of tracking get an empty list.
+func (fc *FileController) GetRecent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+		userId := currentUserID(c)
+
+		user, err := loadQuotaUser(ctx, fc.db, userId)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if user.DisableAccessTracking {
+			c.JSON(http.StatusOK, []recentFile{})
+			return
+		}
+
+		visible := bson.M{"file._id": bson.M{"$exists": true}, "file.deletedAt": bson.M{"$exists": false}, "file.expiresAt": notExpired()}
+		if !isAdmin(c) {
+			granted, err := grantedFileIds(ctx, db, userId)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			visible["$or"] = bson.A{bson.M{"file.ownerId": userId}, bson.M{"file._id": bson.M{"$in": granted}}}
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"userId": userId}}},
+			{{Key: "$sort", Value: bson.D{{Key: "lastAccessedAt", Value: -1}}}},
+			{{Key: "$lookup", Value: bson.M{"from": FileCollection, "localField": "fileId", "foreignField": "_id", "as": "file"}}},
+			{{Key: "$set", Value: bson.M{"file": bson.M{"$arrayElemAt": bson.A{"$file", 0}}}}},
+			{{Key: "$match", Value: visible}},
+			{{Key: "$limit", Value: RecentFilesLimit}},
+			{{Key: "$project", Value: bson.M{"file.versions": 0, "file.extractedText": 0}}},
+		}
+
+		cursor, err := db.Collection(FileAccessCollection).Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		recent := []recentFile{}
+		if err := cursor.All(ctx, &recent); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, recent)
+	}
+}
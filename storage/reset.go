@@ -0,0 +1,170 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var PasswordResetCollection string = "passwordResetTokens"
+
+// PasswordResetTTL is how long a reset link stays valid.
+const PasswordResetTTL = time.Hour
+
+// forgotPasswordLimit caps reset emails per address.
+var forgotPasswordLimit = newFailureLimiter(3, time.Hour)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ForgotPassword handler emails a reset link to the account with the given
+// address. It answers the same whether or not the account exists.
+func (ac *AuthController) ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := ac.db
+
+		var req forgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		email := normalizeEmail(req.Email)
+		if blocked, retryAfter := forgotPasswordLimit.Blocked(email); blocked {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			respondError(c, newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "too many reset requests, try again later"))
+			return
+		}
+		forgotPasswordLimit.Fail(email)
+
+		accepted := gin.H{"message": "If an account exists for that address, a reset link has been sent"}
+
+		var user models.User
+		if err := db.Collection(UserCollection).FindOne(ctx, bson.M{"email": email, "deletedAt": userNotDeleted}).Decode(&user); err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("loading user for password reset failed: %v", err)
+			}
+			c.JSON(http.StatusOK, accepted)
+			return
+		}
+
+		if err := sendPasswordReset(ctx, ac.db, ac.cfg, &user, PasswordResetTTL, mailPasswordReset); err != nil {
+			log.Printf("sending password reset to user %s failed: %v", user.Id.Hex(), err)
+		}
+
+		c.JSON(http.StatusOK, accepted)
+	}
+}
+
+// sendPasswordReset replaces any pending reset token of user with one valid
+// for ttl and queues the email template with its link.
+func sendPasswordReset(ctx context.Context, db *mongo.Database, cfg *Config, user *models.User, ttl time.Duration, template string) error {
+	collection := db.Collection(PasswordResetCollection)
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	if _, err := collection.DeleteMany(ctx, bson.M{"userId": user.Id}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	record := models.PasswordResetToken{
+		Id:        primitive.NewObjectID(),
+		UserId:    user.Id,
+		Email:     user.Email,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := collection.InsertOne(ctx, record); err != nil {
+		return err
+	}
+
+	queueMail(user.Email, template, gin.H{
+		"Name":      user.Name,
+		"Link":      cfg.passwordResetURL() + "?token=" + url.QueryEscape(token),
+		"ExpiresAt": record.ExpiresAt,
+	})
+	return nil
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ResetPassword handler consumes a reset token, sets the new password and
+// revokes every session of the account.
+func (ac *AuthController) ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := ac.db
+
+		var req resetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		// Checked before the token is used up so a weak password can be
+		// corrected with the same link.
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		var record models.PasswordResetToken
+		filter := bson.M{"tokenHash": hashToken(req.Token), "expiresAt": bson.M{"$gt": time.Now()}}
+		if err := db.Collection(PasswordResetCollection).FindOneAndDelete(ctx, filter).Decode(&record); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, badRequest("invalid or expired reset token"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		// The address must not have changed since the link was sent. Having
+		// received it also proves the address works.
+		userFilter := bson.M{"_id": record.UserId, "email": record.Email}
+		update := bson.M{
+			"$set":   bson.M{"password": hash, "emailVerified": true},
+			"$unset": bson.M{"mustResetPassword": ""},
+		}
+		result, err := db.Collection(UserCollection).UpdateOne(ctx, userFilter, update)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if result.MatchedCount == 0 {
+			respondError(c, badRequest("invalid or expired reset token"))
+			return
+		}
+
+		if err := revokeUserSessions(ctx, ac.db, record.UserId); err != nil {
+			respondError(c, err)
+			return
+		}
+		events.disconnect(record.UserId, primitive.NilObjectID)
+
+		auditAs(c, record.UserId, AuditUserUpdated, auditTargetUser, record.UserId, gin.H{"fields": []string{"password"}, "reset": true})
+		c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+	}
+}
@@ -0,0 +1,209 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	fitContain = "contain"
+	fitCover   = "cover"
+)
+
+// resizeParams is a parsed ?w=&h=&fit= query. A zero W or H is derived from
+// the source's aspect ratio.
+type resizeParams struct {
+	W   int
+	H   int
+	Fit string
+}
+
+// parseResizeParams validates the resize query against maxDimension.
+func parseResizeParams(c *gin.Context, maxDimension int) (resizeParams, error) {
+	params := resizeParams{Fit: c.DefaultQuery("fit", fitContain)}
+	if params.Fit != fitContain && params.Fit != fitCover {
+		return params, fmt.Errorf("fit must be %q or %q", fitContain, fitCover)
+	}
+
+	for _, dim := range []struct {
+		key string
+		val *int
+	}{{"w", &params.W}, {"h", &params.H}} {
+		raw := c.Query(dim.key)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxDimension {
+			return params, fmt.Errorf("%s must be between 1 and %d", dim.key, maxDimension)
+		}
+		*dim.val = n
+	}
+
+	if params.W == 0 && params.H == 0 {
+		return params, fmt.Errorf("w or h is required")
+	}
+	if params.Fit == fitCover && (params.W == 0 || params.H == 0) {
+		return params, fmt.Errorf("fit=cover needs both w and h")
+	}
+	return params, nil
+}
+
+// key identifies the rendition of the content with checksum.
+func (p resizeParams) key(checksum string) string {
+	return fmt.Sprintf("%s-%dx%d-%s", checksum, p.W, p.H, p.Fit)
+}
+
+// resizedImage is an encoded rendition held by FileController.images.
+type resizedImage struct {
+	key         string
+	contentType string
+	data        []byte
+}
+
+// lruCache keeps the most recently used renditions within a byte budget.
+// Entries are keyed on the content checksum, so re-uploads never hit stale
+// entries; those simply age out.
+type lruCache struct {
+	mu      sync.Mutex
+	budget  int64
+	used    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newLRUCache(budget int64) *lruCache {
+	return &lruCache{budget: budget, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (l *lruCache) get(key string) (*resizedImage, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(elem)
+	return elem.Value.(*resizedImage), true
+}
+
+func (l *lruCache) add(entry *resizedImage) {
+	size := int64(len(entry.data))
+	if size > l.budget {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.entries[entry.key]; ok {
+		return
+	}
+	l.entries[entry.key] = l.order.PushFront(entry)
+	l.used += size
+
+	for l.used > l.budget {
+		oldest := l.order.Back()
+		evicted := l.order.Remove(oldest).(*resizedImage)
+		delete(l.entries, evicted.key)
+		l.used -= int64(len(evicted.data))
+	}
+}
+
+// GetResizedImage handler serves an image file scaled to ?w= and/or ?h=.
+// fit=contain (the default) fits the image inside the box without enlarging
+// it; fit=cover fills the box exactly, cropping the overflow.
+func (fc *FileController) GetResizedImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		if !isImageType(file.ContentType) {
+			respondError(c, badRequest("file is not an image"))
+			return
+		}
+
+		params, err := parseResizeParams(c, fc.cfg.Images.MaxDimension)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		key := params.key(file.Checksum)
+		c.Header("Cache-Control", fc.cfg.CacheControl.Private)
+		validators := validators{modified: file.CurrentVersion().UploadedAt}
+		if file.Checksum != "" {
+			validators.etag = `"` + key + `"`
+		}
+		if validators.respondNotModified(c) {
+			return
+		}
+
+		rendition, ok := fc.images.get(key)
+		if !ok {
+			rendition, err = renderImage(ctx, fc.db, file.BlobRef, params, fc.cfg)
+			if err == errImageTooLarge {
+				respondError(c, newAPIError(http.StatusUnprocessableEntity, ErrCodeUnprocessable, err.Error()).withDetails(gin.H{"maxPixels": fc.cfg.Images.MaxPixels}))
+				return
+			}
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			rendition.key = key
+			if file.Checksum != "" {
+				fc.images.add(rendition)
+			}
+		}
+
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Data(http.StatusOK, rendition.contentType, rendition.data)
+	}
+}
+
+// renderImage decodes the stored image and encodes it resized to params.
+func renderImage(ctx context.Context, db *mongo.Database, blob models.BlobRef, params resizeParams, cfg *Config) (*resizedImage, error) {
+	img, format, err := loadImage(ctx, db, cfg, blob, cfg.Images.MaxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	var out image.Image
+	if params.Fit == fitCover {
+		out = coverImage(img, params.W, params.H)
+	} else {
+		w, h := params.W, params.H
+		if w == 0 {
+			w = cfg.Images.MaxDimension
+		}
+		if h == 0 {
+			h = cfg.Images.MaxDimension
+		}
+		out = fitWithin(img, w, h)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, out, format); err != nil {
+		return nil, err
+	}
+	return &resizedImage{contentType: encodedType(format), data: buf.Bytes()}, nil
+}
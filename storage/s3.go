@@ -0,0 +1,559 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the size of the parts of a multipart upload. Content no
+	// bigger than one part is sent with a single PUT.
+	s3PartSize = 8 << 20
+	// s3MaxCopySize is the largest object a single CopyObject can copy.
+	s3MaxCopySize = 5 << 30
+	// s3Retries bounds the attempts of a request failing with a transient
+	// error, retried with exponential backoff from s3RetryBase.
+	s3Retries   = 4
+	s3RetryBase = 100 * time.Millisecond
+)
+
+// emptySHA256 is the hex SHA-256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+var s3HTTPClient = &http.Client{}
+
+// endpoint returns the base URL requests go to.
+func (cfg S3Config) endpoint() string {
+	if cfg.Endpoint != "" {
+		return strings.TrimSuffix(cfg.Endpoint, "/")
+	}
+	return "https://s3." + cfg.Region + ".amazonaws.com"
+}
+
+// s3Error is an error response from S3.
+type s3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: status %d", e.Status)
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// s3Storage keeps content as objects in an S3 bucket, keyed by the hex
+// BlobId. Requests are signed with AWS Signature Version 4, so it works with
+// AWS S3 and compatible servers such as MinIO.
+type s3Storage struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+// Put sends content of up to s3PartSize with one PUT and anything larger
+// as a multipart upload, holding one part in memory at a time.
+func (s *s3Storage) Put(ctx context.Context, r io.Reader, meta BlobMeta) (string, error) {
+	key := meta.Id.Hex()
+	start := time.Now()
+
+	part := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, part)
+	if err == nil {
+		// Content of exactly one part is still sent with one PUT.
+		var next [1]byte
+		if _, err = io.ReadFull(r, next[:]); err == nil {
+			r = io.MultiReader(bytes.NewReader(next[:]), r)
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do(ctx, http.MethodPut, key, nil, nil, part[:n])
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		s3Duration.since(start, "put")
+		return key, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	uploadId, err := s.createMultipart(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if err := s.uploadParts(ctx, key, uploadId, r, part); err != nil {
+		s.abortMultipart(ctx, key, uploadId)
+		return "", err
+	}
+	s3Duration.since(start, "multipart_upload")
+	return key, nil
+}
+
+// uploadParts uploads first, a full part, and the rest of r as the parts of
+// uploadId, then completes the upload.
+func (s *s3Storage) uploadParts(ctx context.Context, key string, uploadId string, r io.Reader, first []byte) error {
+	var parts []s3Part
+
+	part := first
+	for number := 1; ; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadId}}
+		resp, err := s.do(ctx, http.MethodPut, key, query, nil, part)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		parts = append(parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		n, err := io.ReadFull(r, first)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		part = first[:n]
+	}
+	return s.completeMultipart(ctx, key, uploadId, parts)
+}
+
+// s3Part is an uploaded part of a multipart upload.
+type s3Part struct {
+	PartNumber int    `json:"partNumber" xml:"PartNumber"`
+	ETag       string `json:"etag" xml:"ETag"`
+}
+
+// completeMultipart assembles the object at key from the parts of uploadId.
+func (s *s3Storage) completeMultipart(ctx context.Context, key string, uploadId string, parts []s3Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadId}}, nil, body)
+	if err != nil {
+		return err
+	}
+	return readS3Result(resp, nil)
+}
+
+func (s *s3Storage) createMultipart(ctx context.Context, key string) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := readS3Result(resp, &result); err != nil {
+		return "", err
+	}
+	return result.UploadId, nil
+}
+
+// abortMultipart drops the parts of a failed upload. It is not cancelled
+// with the request, so they aren't left behind when the client goes away.
+func (s *s3Storage) abortMultipart(ctx context.Context, key string, uploadId string) {
+	resp, err := s.do(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {uploadId}}, nil, nil)
+	if err != nil {
+		Logger.Warn("aborting s3 multipart upload failed", "key", key, "uploadId", uploadId, "error", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// Get streams the object from S3 as it is read.
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.get(ctx, key, nil)
+}
+
+// GetRange streams length bytes of the object from offset start with a
+// ranged GET.
+func (s *s3Storage) GetRange(ctx context.Context, key string, start int64, length int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", start, start+length-1)}}
+	return s.get(ctx, key, header)
+}
+
+func (s *s3Storage) get(ctx context.Context, key string, header http.Header) (io.ReadCloser, error) {
+	start := time.Now()
+	resp, err := s.do(ctx, http.MethodGet, key, nil, header, nil)
+	s3Duration.since(start, "get")
+	if isS3NotFound(err) {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	s3Duration.since(start, "delete")
+	if isS3NotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Size(ctx, key)
+	if err == errBlobNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3Storage) Size(ctx context.Context, key string) (int64, error) {
+	size, _, err := s.stat(ctx, key)
+	return size, err
+}
+
+// stat returns the size and ETag of the object at key.
+func (s *s3Storage) stat(ctx context.Context, key string) (int64, string, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if isS3NotFound(err) {
+		return 0, "", errBlobNotFound
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, resp.Header.Get("ETag"), nil
+}
+
+// Copy copies the object under key to a new one with CopyObject, without the
+// content passing through the server. Objects bigger than s3MaxCopySize fail
+// with errors.ErrUnsupported.
+func (s *s3Storage) Copy(ctx context.Context, key string, size int64, meta BlobMeta) (string, error) {
+	if size > s3MaxCopySize {
+		return "", errors.ErrUnsupported
+	}
+
+	target := meta.Id.Hex()
+	start := time.Now()
+	header := http.Header{"X-Amz-Copy-Source": {"/" + s.cfg.Bucket + "/" + awsEscape(key, true)}}
+	resp, err := s.do(ctx, http.MethodPut, target, nil, header, nil)
+	if isS3NotFound(err) {
+		return "", errBlobNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	// A copy can fail after S3 has answered 200, with the error in the body.
+	if err := readS3Result(resp, nil); err != nil {
+		return "", err
+	}
+	s3Duration.since(start, "copy")
+	return target, nil
+}
+
+// Check lists at most one object of the bucket, which proves it exists and
+// the credentials can read it.
+func (s *s3Storage) Check(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodGet, "", url.Values{"list-type": {"2"}, "max-keys": {"1"}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for key, or for the bucket itself when key is
+// empty, and returns the response if it succeeded. Error responses come back
+// as an *s3Error. Requests failing with a network error, a 5xx or 429 are
+// retried; body is held in memory so it can be sent again.
+func (s *s3Storage) do(ctx context.Context, method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < s3Retries; attempt++ {
+		if attempt > 0 {
+			backoff := s3RetryBase << (attempt - 1)
+			backoff += rand.N(backoff)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		req, err := s.newRequest(ctx, method, key, query, header, body, payloadHash)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || !isTransientNetError(err) {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		lastErr = readS3Error(resp)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// newRequest builds a request signed with AWS Signature Version 4.
+func (s *s3Storage) newRequest(ctx context.Context, method string, key string, query url.Values, header http.Header, body []byte, payloadHash string) (*http.Request, error) {
+	scheme, host, path, err := s.location(key)
+	if err != nil {
+		return nil, err
+	}
+	rawQuery := canonicalQuery(query)
+
+	target := scheme + "://" + host + path
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Host and the x-amz-* headers are signed; others, like Range, may be
+	// changed by proxies and are left out.
+	signed := map[string]string{"host": host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{method, path, rawQuery, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope, signature := s.sign(now, canonicalRequest)
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+	return req, nil
+}
+
+// PresignGet returns a URL anyone can GET the object at key with until ttl
+// has passed, signed in its query string. query holds extra parameters,
+// like response-content-disposition, that are signed along with it.
+func (s *s3Storage) PresignGet(key string, query url.Values, ttl time.Duration) (string, error) {
+	return s.presign(http.MethodGet, key, query, nil, ttl)
+}
+
+// presign returns a URL for a method request on key that is valid until ttl
+// has passed without further credentials. The request must carry exactly
+// the given header, whose names are lowercase, as it is signed too.
+func (s *s3Storage) presign(method string, key string, query url.Values, header map[string]string, ttl time.Duration) (string, error) {
+	scheme, host, path, err := s.location(key)
+	if err != nil {
+		return "", err
+	}
+
+	signed := map[string]string{"host": host}
+	for name, value := range header {
+		signed[name] = value
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	now := time.Now().UTC()
+	signedQuery := url.Values{}
+	for name, values := range query {
+		signedQuery[name] = values
+	}
+	signedQuery.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	signedQuery.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+s.scope(now))
+	signedQuery.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	signedQuery.Set("X-Amz-Expires", strconv.FormatInt(int64(ttl/time.Second), 10))
+	signedQuery.Set("X-Amz-SignedHeaders", signedHeaders)
+	rawQuery := canonicalQuery(signedQuery)
+
+	canonicalRequest := strings.Join([]string{method, path, rawQuery, canonicalHeaders.String(), signedHeaders, "UNSIGNED-PAYLOAD"}, "\n")
+	_, signature := s.sign(now, canonicalRequest)
+
+	return scheme + "://" + host + path + "?" + rawQuery + "&X-Amz-Signature=" + signature, nil
+}
+
+// location returns where the object at key lives: the scheme, the host,
+// which names the bucket unless PathStyle is set, and the escaped path.
+func (s *s3Storage) location(key string) (string, string, string, error) {
+	endpoint, err := url.Parse(s.cfg.endpoint())
+	if err != nil {
+		return "", "", "", err
+	}
+
+	host := endpoint.Host
+	path := "/" + awsEscape(key, true)
+	if s.cfg.PathStyle {
+		path = "/" + awsEscape(s.cfg.Bucket, false) + path
+	} else {
+		host = s.cfg.Bucket + "." + host
+	}
+	if key == "" {
+		path = strings.TrimSuffix(path, "/") + "/"
+	}
+	return endpoint.Scheme, host, path, nil
+}
+
+// scope is the credential scope of requests signed at now.
+func (s *s3Storage) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// sign returns the credential scope and the Signature Version 4 signature
+// of canonicalRequest made at now.
+func (s *s3Storage) sign(now time.Time, canonicalRequest string) (string, string) {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := s.scope(now)
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	for _, part := range []string{s.cfg.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	return scope, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key, as Signature Version 4 expects.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, awsEscape(key, false)+"="+awsEscape(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, and
+// "/" too unless keepSlash is set.
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// readS3Result decodes the XML body of a successful response into v, if
+// given, and closes it. A body holding an <Error> is returned as an *s3Error.
+func readS3Result(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(raw, []byte("<Error>")) {
+		s3Err := &s3Error{Status: resp.StatusCode}
+		_ = xml.Unmarshal(raw, s3Err)
+		return s3Err
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(raw, v)
+}
+
+// readS3Error turns an error response into an *s3Error and closes it.
+func readS3Error(resp *http.Response) error {
+	defer resp.Body.Close()
+	s3Err := &s3Error{Status: resp.StatusCode}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = xml.Unmarshal(raw, s3Err)
+	return s3Err
+}
+
+func isS3NotFound(err error) bool {
+	var s3Err *s3Error
+	return errors.As(err, &s3Err) && s3Err.Status == http.StatusNotFound
+}
+
+// isTransientNetError reports whether a request that failed with err can be
+// sent again: timeouts, refused or reset connections and truncated replies.
+func isTransientNetError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
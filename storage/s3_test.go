@@ -0,0 +1,420 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// s3Request is what fakeS3 was asked.
+type s3Request struct {
+	method     string
+	path       string
+	query      string
+	rangeSpec  string
+	copySource string
+	bodyLen    int
+}
+
+// fakeS3 is an in-memory, path-style S3 serving one bucket: objects,
+// ranged GETs, HEAD, DELETE, CopyObject, multipart uploads and listing.
+type fakeS3 struct {
+	bucket string
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	requests []s3Request
+	// fail holds the statuses the next requests are answered with.
+	fail []int
+}
+
+// newFakeS3 starts a fakeS3 for the rest of the test and returns it with a
+// backend pointed at it.
+func newFakeS3(t *testing.T) (*fakeS3, *s3Storage) {
+	f := &fakeS3{bucket: "files", objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	cfg := S3Config{Endpoint: server.URL, Bucket: f.bucket, Region: "us-east-1", AccessKey: "test", SecretKey: "secret", PathStyle: true}
+	return f, &s3Storage{cfg: cfg, client: server.Client()}
+}
+
+// useFakeS3 makes the s3 backend of cfg, as storageBackend returns it, a
+// fakeS3 for the rest of the test.
+func useFakeS3(t *testing.T, cfg *Config) (*fakeS3, *s3Storage) {
+	f, s := newFakeS3(t)
+	cfg.Storage.S3 = s.cfg
+	savedClient := s3HTTPClient
+	s3HTTPClient = s.client
+	t.Cleanup(func() { s3HTTPClient = savedClient })
+	return f, s
+}
+
+// sent returns the requests made so far with method, or all of them when
+// method is empty.
+func (f *fakeS3) sent(method string) []s3Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var requests []s3Request
+	for _, r := range f.requests {
+		if method == "" || r.method == method {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// pendingUploads counts the multipart uploads neither completed nor aborted.
+func (f *fakeS3) pendingUploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.uploads)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, s3Request{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Range"), r.Header.Get("X-Amz-Copy-Source"), len(body)})
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") {
+		s3Fail(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if len(f.fail) > 0 {
+		status := f.fail[0]
+		f.fail = f.fail[1:]
+		s3Fail(w, status, "InternalError")
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		s3Fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		fmt.Fprint(w, "<ListBucketResult></ListBucketResult>")
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			s3Fail(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var content []byte
+		for _, part := range complete.Parts {
+			content = append(content, f.uploads[query.Get("uploadId")][part.PartNumber]...)
+		}
+		f.objects[key] = content
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, ok := f.objects[strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"+f.bucket+"/")]
+		if !ok {
+			s3Fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.objects[key] = bytes.Clone(source)
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		content, ok := f.objects[key]
+		if !ok {
+			s3Fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			return
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			w.WriteHeader(http.StatusPartialContent)
+			content = content[start:min(end+1, len(content))]
+		}
+		w.Write(content)
+	}
+}
+
+func s3Fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	if code != "" {
+		fmt.Fprintf(w, "<Error><Code>%s</Code><Message>failed</Message></Error>", code)
+	}
+}
+
+// testS3 is what an S3 backend does beyond Storage, run against a fresh
+// one from newStorage in each subtest.
+func testS3(t *testing.T, newStorage func(t *testing.T) *s3Storage) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("abcdefghij"), (2*s3PartSize+s3PartSize/2)/10)
+
+	t.Run("multipart round trip", func(t *testing.T) {
+		s := newStorage(t)
+		key, err := s.Put(ctx, bytes.NewReader(content), BlobMeta{Id: primitive.NewObjectID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Delete(ctx, key)
+		r, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, content) {
+			t.Errorf("Get returned %d bytes, %v; want the %d put", len(got), err, len(content))
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		s := newStorage(t)
+		key, err := s.Put(ctx, strings.NewReader("copied server-side"), BlobMeta{Id: primitive.NewObjectID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Delete(ctx, key)
+		copied, err := s.Copy(ctx, key, 18, BlobMeta{Id: primitive.NewObjectID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Delete(ctx, copied)
+		if size, err := s.Size(ctx, copied); err != nil || size != 18 || copied == key {
+			t.Errorf("copy %q has size %d, %v; want a new 18-byte object", copied, size, err)
+		}
+
+		if _, err := s.Copy(ctx, primitive.NewObjectID().Hex(), 18, BlobMeta{Id: primitive.NewObjectID()}); err != errBlobNotFound {
+			t.Errorf("Copy of missing content = %v, want errBlobNotFound", err)
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		s := newStorage(t)
+		if err := s.Check(ctx); err != nil {
+			t.Errorf("Check = %v", err)
+		}
+		s.cfg.Bucket = "no-such-bucket-" + primitive.NewObjectID().Hex()
+		if err := s.Check(ctx); err == nil {
+			t.Error("Check of a missing bucket succeeded")
+		}
+	})
+}
+
+func TestS3Storage(t *testing.T) {
+	newStorage := func(t *testing.T) *s3Storage {
+		_, s := newFakeS3(t)
+		return s
+	}
+	testStorage(t, func(t *testing.T) Storage { return newStorage(t) })
+	testS3(t, newStorage)
+}
+
+// TestS3StorageOnMinIO runs against the MinIO, or S3, at
+// $S3_TEST_ENDPOINT, for example a throwaway container started with
+// `docker run -d -p 9000:9000 minio/minio server /data`, signing in with
+// $S3_TEST_ACCESS_KEY and $S3_TEST_SECRET_KEY (minioadmin for both by
+// default). Each run creates a bucket of its own.
+func TestS3StorageOnMinIO(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+	cfg := S3Config{
+		Endpoint:  endpoint,
+		Bucket:    "storage-test-" + primitive.NewObjectID().Hex(),
+		Region:    "us-east-1",
+		AccessKey: os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_TEST_SECRET_KEY"),
+		PathStyle: true,
+	}
+	s := &s3Storage{cfg: cfg, client: http.DefaultClient}
+	resp, err := s.do(context.Background(), http.MethodPut, "", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("creating bucket %s: %v", cfg.Bucket, err)
+	}
+	resp.Body.Close()
+
+	newStorage := func(t *testing.T) *s3Storage { return &s3Storage{cfg: cfg, client: http.DefaultClient} }
+	testStorage(t, func(t *testing.T) Storage { return newStorage(t) })
+	testS3(t, newStorage)
+}
+
+func TestS3Multipart(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("parts", func(t *testing.T) {
+		f, s := newFakeS3(t)
+		if _, err := s.Put(ctx, bytes.NewReader(make([]byte, 2*s3PartSize+10)), BlobMeta{Id: primitive.NewObjectID()}); err != nil {
+			t.Fatal(err)
+		}
+		var sizes []int
+		for _, r := range f.sent(http.MethodPut) {
+			sizes = append(sizes, r.bodyLen)
+		}
+		if len(sizes) != 3 || sizes[0] != s3PartSize || sizes[1] != s3PartSize || sizes[2] != 10 {
+			t.Errorf("part sizes = %v, want two full parts and the rest", sizes)
+		}
+		if len(f.sent(http.MethodPost)) != 2 {
+			t.Errorf("POSTs = %v, want the upload created and completed", f.sent(http.MethodPost))
+		}
+	})
+
+	t.Run("small content is one PUT", func(t *testing.T) {
+		f, s := newFakeS3(t)
+		if _, err := s.Put(ctx, bytes.NewReader(make([]byte, s3PartSize)), BlobMeta{Id: primitive.NewObjectID()}); err != nil {
+			t.Fatal(err)
+		}
+		if puts := f.sent(http.MethodPut); len(puts) != 1 || puts[0].query != "" || len(f.sent(http.MethodPost)) != 0 {
+			t.Errorf("requests = %v, want a single plain PUT", f.sent(""))
+		}
+	})
+
+	t.Run("failed upload is aborted", func(t *testing.T) {
+		f, s := newFakeS3(t)
+		id := primitive.NewObjectID()
+		if _, err := s.Put(ctx, &failingReader{n: s3PartSize + 100}, BlobMeta{Id: id}); !errors.Is(err, errReadFailed) {
+			t.Fatalf("Put = %v, want the read error", err)
+		}
+		deletes := f.sent(http.MethodDelete)
+		if len(deletes) != 1 || !strings.Contains(deletes[0].query, "uploadId=") || f.pendingUploads() != 0 {
+			t.Errorf("deletes = %v, want the upload's parts dropped", deletes)
+		}
+	})
+}
+
+func TestS3Retries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("transient", func(t *testing.T) {
+		f, s := newFakeS3(t)
+		f.fail = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		if _, err := s.Put(ctx, strings.NewReader("eventually"), BlobMeta{Id: primitive.NewObjectID()}); err != nil {
+			t.Fatalf("Put = %v, want it retried past the transient errors", err)
+		}
+		if len(f.sent("")) != 3 {
+			t.Errorf("sent %d requests, want 3", len(f.sent("")))
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		f, s := newFakeS3(t)
+		for range s3Retries {
+			f.fail = append(f.fail, http.StatusInternalServerError)
+		}
+		var s3Err *s3Error
+		if _, err := s.Size(ctx, primitive.NewObjectID().Hex()); !errors.As(err, &s3Err) || s3Err.Status != http.StatusInternalServerError {
+			t.Fatalf("Size = %v, want the last error", err)
+		}
+		if len(f.sent("")) != s3Retries {
+			t.Errorf("sent %d requests, want %d", len(f.sent("")), s3Retries)
+		}
+	})
+
+	t.Run("not retried", func(t *testing.T) {
+		f, s := newFakeS3(t)
+		f.fail = []int{http.StatusForbidden}
+		if _, err := s.Get(ctx, primitive.NewObjectID().Hex()); err == nil {
+			t.Fatal("Get succeeded")
+		}
+		if len(f.sent("")) != 1 {
+			t.Errorf("sent %d requests, want client errors not retried", len(f.sent("")))
+		}
+	})
+}
+
+func TestS3Copy(t *testing.T) {
+	f, s := newFakeS3(t)
+	ctx := context.Background()
+	key, err := s.Put(ctx, strings.NewReader("content"), BlobMeta{Id: primitive.NewObjectID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Copy(ctx, key, 7, BlobMeta{Id: primitive.NewObjectID()}); err != nil {
+		t.Fatal(err)
+	}
+	copies := f.sent(http.MethodPut)[1:]
+	if len(copies) != 1 || copies[0].copySource != "/files/"+key || copies[0].bodyLen != 0 || len(f.sent(http.MethodGet)) != 0 {
+		t.Errorf("requests = %v, want one CopyObject without the content", f.sent(""))
+	}
+
+	if _, err := s.Copy(ctx, key, s3MaxCopySize+1, BlobMeta{Id: primitive.NewObjectID()}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Copy past the CopyObject limit = %v, want errors.ErrUnsupported", err)
+	}
+}
+
+func TestS3DownloadRange(t *testing.T) {
+	cfg := testConfig()
+	f, s := useFakeS3(t, cfg)
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	key, err := s.Put(context.Background(), bytes.NewReader(content), BlobMeta{Id: primitive.NewObjectID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &models.File{
+		Id:          primitive.NewObjectID(),
+		Name:        "clip.bin",
+		Size:        int64(len(content)),
+		ContentType: "application/octet-stream",
+		BlobRef:     models.BlobRef{BlobId: primitive.NewObjectID(), StorageBackend: StorageS3, StorageKey: key},
+	}
+	router := gin.New()
+	router.GET("/download", func(c *gin.Context) { streamFile(c, nil, cfg, file, "attachment") })
+
+	req := httptest.NewRequest(http.MethodGet, "/download", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), content[100:200]) {
+		t.Fatalf("got %d with %d bytes, want 206 with bytes 100-199", rec.Code, rec.Body.Len())
+	}
+	// The range is read from S3, not skipped to.
+	if gets := f.sent(http.MethodGet); len(gets) != 1 || gets[0].rangeSpec != "bytes=100-199" {
+		t.Errorf("GETs = %v, want one for the range", gets)
+	}
+}
@@ -0,0 +1,316 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// scanTimeout bounds the scan of one file, from connecting to clamd to
+	// its verdict.
+	scanTimeout = 10 * time.Minute
+	// scanLeaseDuration keeps other workers off a file being scanned; a
+	// worker that dies mid-scan hands the file on once it passes.
+	scanLeaseDuration = scanTimeout + time.Minute
+	// scanRetryDelay is how long a file waits after its scan failed, for
+	// example because clamd was down, before it is tried again.
+	scanRetryDelay = time.Minute
+	// scanPollInterval is how often idle workers look for files without
+	// being woken, picking up retries and other instances' uploads.
+	scanPollInterval = 30 * time.Second
+	// clamdChunkSize is the size of the chunks content is streamed in.
+	clamdChunkSize = 64 << 10
+)
+
+// errClamdSizeLimit is clamd refusing content over its StreamMaxLength.
+var errClamdSizeLimit = errors.New("content exceeds the scanner's size limit")
+
+// scanWake wakes an idle scan worker when new content is queued.
+var scanWake = make(chan struct{}, 1)
+
+// newScanResult is the scan state of freshly stored content: waiting for a
+// scan while scanning is on, none otherwise.
+func newScanResult(settings ScanConfig) models.ScanResult {
+	if settings.ClamdAddr == "" {
+		return models.ScanResult{}
+	}
+	return models.ScanResult{ScanStatus: models.ScanScanning}
+}
+
+// enqueueScan wakes a scan worker for file if its content awaits a scan.
+// The file itself is the queue entry, so nothing is lost if no worker is
+// free or clamd is down.
+func enqueueScan(file *models.File) {
+	if file.ScanStatus != models.ScanScanning {
+		return
+	}
+	select {
+	case scanWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartVirusScanner runs cfg.Scan.Workers workers scanning files awaiting a
+// scan until ctx is cancelled. It does nothing while scanning is off.
+func StartVirusScanner(ctx context.Context, db *mongo.Database, cfg *Config) {
+	if cfg.Scan.ClamdAddr == "" {
+		return
+	}
+
+	for i := 0; i < cfg.Scan.Workers; i++ {
+		startWorker(func() {
+			for {
+				scanned, err := scanNextFile(ctx, db, cfg)
+				if ctx.Err() != nil {
+					return
+				}
+				if scanned || err != nil {
+					recordJob("virus_scan", err)
+				}
+				if err != nil {
+					log.Printf("virus scan failed: %v", err)
+				}
+				if scanned && err == nil {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-scanWake:
+				case <-time.After(scanPollInterval):
+				}
+			}
+		})
+	}
+}
+
+// scanNextFile claims the oldest file awaiting a scan and records clamd's
+// verdict on it. It reports false when no file was waiting. A file whose
+// scan fails keeps waiting and is retried after scanRetryDelay; one clamd
+// won't scan at all is marked failed.
+func scanNextFile(ctx context.Context, db *mongo.Database, cfg *Config) (bool, error) {
+	collection := db.Collection(FileCollection)
+
+	now := time.Now()
+	filter := bson.M{
+		"scanStatus": models.ScanScanning,
+		"$or":        bson.A{bson.M{"scanLease": bson.M{"$exists": false}}, bson.M{"scanLease": bson.M{"$lt": now}}},
+	}
+	update := bson.M{"$set": bson.M{"scanLease": now.Add(scanLeaseDuration)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetProjection(bson.M{"_id": 1, "ownerId": 1, "name": 1, "gridfsId": 1, "storageBackend": 1, "storageKey": 1, "encrypted": 1})
+
+	var file models.File
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&file); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+
+	scanCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+	signature, err := scanBlob(scanCtx, db, cfg, file.BlobRef)
+	cancel()
+
+	status := models.ScanClean
+	switch {
+	case errors.Is(err, errClamdSizeLimit):
+		status, signature = models.ScanFailed, err.Error()
+	case err == errBlobNotFound:
+		// The content was replaced or deleted since the claim.
+		return true, nil
+	case err != nil:
+		if ctx.Err() == nil {
+			retry := bson.M{"$set": bson.M{"scanLease": time.Now().Add(scanRetryDelay)}}
+			_, _ = collection.UpdateOne(ctx, bson.M{"_id": file.Id, "gridfsId": file.BlobId}, retry)
+		}
+		return true, fmt.Errorf("file %s: %w", file.Id.Hex(), err)
+	case signature != "":
+		status = models.ScanQuarantine
+	}
+
+	set := bson.M{"scanStatus": status, "scannedAt": time.Now()}
+	if signature != "" {
+		set["scanSignature"] = signature
+	}
+	// A scan of content since replaced changes nothing.
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": file.Id, "gridfsId": file.BlobId, "scanStatus": models.ScanScanning},
+		bson.M{"$set": set, "$unset": bson.M{"scanLease": ""}},
+	)
+	if err != nil {
+		return true, err
+	}
+
+	if result.ModifiedCount == 1 {
+		notify(ctx, db, cfg, file.OwnerId, EventFileScanned, gin.H{"fileId": file.Id, "scanStatus": status, "scanSignature": signature})
+		if status == models.ScanQuarantine {
+			notifyQuarantine(ctx, db, &file, signature)
+		}
+	}
+	return true, nil
+}
+
+// notifyQuarantine tells the owner of file that it was quarantined, by mail
+// and to their webhooks.
+func notifyQuarantine(ctx context.Context, db *mongo.Database, file *models.File, signature string) {
+	emitWebhookEvent(file.OwnerId, AuditFileQuarantined, gin.H{"fileId": file.Id, "name": file.Name, "signature": signature})
+
+	var owner models.User
+	opts := options.FindOne().SetProjection(bson.M{"name": 1, "email": 1})
+	if err := db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": file.OwnerId}, opts).Decode(&owner); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("loading owner of quarantined file %s failed: %v", file.Id.Hex(), err)
+		}
+		return
+	}
+	queueMail(owner.Email, mailQuarantined, gin.H{"Name": owner.Name, "FileName": file.Name, "Signature": signature})
+}
+
+// scanBlob streams the content ref points at to the clamd at addr and
+// returns the name of the signature it matched, or "" when it is clean.
+func scanBlob(ctx context.Context, db *mongo.Database, cfg *Config, ref models.BlobRef) (string, error) {
+	download, err := openBlob(ctx, db, cfg, ref)
+	if err != nil {
+		return "", err
+	}
+	defer download.Close()
+
+	return clamdScan(ctx, cfg.Scan.ClamdAddr, download)
+}
+
+// clamdScan sends r to the clamd at addr with the INSTREAM command: a
+// sequence of chunks, each prefixed with its big-endian 32-bit length, ended
+// by an empty one. It returns the signature clamd found, if any.
+func clamdScan(ctx context.Context, addr string, r io.Reader) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	writeErr := writeInstream(conn, r)
+
+	// clamd answers, and hangs up, as soon as content goes over its size
+	// limit, so a reply is read even when sending failed.
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if len(reply) == 0 {
+		if writeErr != nil {
+			return "", writeErr
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+func writeInstream(conn net.Conn, r io.Reader) error {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamdReply reads a verdict such as "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND".
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	case strings.Contains(result, "size limit exceeded"):
+		return "", errClamdSizeLimit
+	}
+	return "", fmt.Errorf("clamd: %s", result)
+}
+
+// scanBlockReason tells why content with scan can't be downloaded, or ""
+// when it can under settings.
+func scanBlockReason(settings ScanConfig, scan models.ScanResult) string {
+	switch scan.ScanStatus {
+	case models.ScanQuarantine:
+		return "quarantined"
+	case models.ScanScanning:
+		if !settings.AllowPendingDownloads {
+			return "not scanned yet"
+		}
+	case models.ScanFailed:
+		if !settings.AllowPendingDownloads {
+			return "could not be scanned"
+		}
+	}
+	return ""
+}
+
+// scanAllowsDownload reports whether content with scan may be downloaded,
+// writing a 403 for quarantined content and a 409 for content not cleared
+// yet when it may not.
+func scanAllowsDownload(c *gin.Context, settings ScanConfig, scan models.ScanResult) bool {
+	if scanBlockReason(settings, scan) == "" {
+		return true
+	}
+
+	switch scan.ScanStatus {
+	case models.ScanQuarantine:
+		respondError(c, forbidden("file is quarantined").withDetails(gin.H{"signature": scan.ScanSignature}))
+	case models.ScanScanning:
+		c.Header("Retry-After", "30")
+		respondError(c, conflict("file is still being scanned for viruses"))
+	default:
+		respondError(c, conflict("file could not be scanned for viruses").withDetails(gin.H{"reason": scan.ScanSignature}))
+	}
+	return false
+}
+
+// scanAllowsSharing reports whether file may be shared, writing a 403 for
+// quarantined files.
+func scanAllowsSharing(c *gin.Context, file *models.File) bool {
+	if file.ScanStatus == models.ScanQuarantine {
+		respondError(c, forbidden("quarantined files can't be shared").withDetails(gin.H{"signature": file.ScanSignature}))
+		return false
+	}
+	return true
+}
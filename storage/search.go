@@ -0,0 +1,216 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// minSearchQuery is the shortest accepted ?q=, in characters.
+const minSearchQuery = 2
+
+// snippetRadius is how many bytes of context a snippet keeps on each side
+// of the first match.
+const snippetRadius = 80
+
+// snippetPart is a piece of a search snippet; Match marks the query terms so
+// clients can highlight them without parsing markup.
+type snippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+// searchHit is a file in the search results.
+type searchHit struct {
+	models.File `bson:",inline"`
+	Score       float64       `json:"score" bson:"score"`
+	Snippet     []snippetPart `json:"snippet" bson:"-"`
+}
+
+// SearchFiles handler runs a ranked full-text search over the names, tags and
+// extracted text of the live files the caller owns or has been granted.
+// Results page by ?page= or by the returned ?cursor=.
+func (fc *FileController) SearchFiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+		collection := db.Collection(FileCollection)
+
+		query := strings.TrimSpace(c.Query("q"))
+		if utf8.RuneCountInString(query) < minSearchQuery {
+			respondError(c, badRequest("q must be at least 2 characters"))
+			return
+		}
+
+		// Pages by cursor continue after the last score, so the order has
+		// to be one a range query can follow.
+		sortDoc := bson.D{{Key: "score", Value: -1}, {Key: "_id", Value: 1}}
+		page, err := parseCursorPage(c, fc.cfg.JWTSecret, sortDoc)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		granted, err := grantedFileIds(ctx, db, currentUserID(c))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		filter := bson.M{
+			"$text":     bson.M{"$search": query},
+			"deletedAt": notTrashed,
+			"expiresAt": notExpired(),
+			"$or": bson.A{
+				bson.M{"ownerId": currentUserID(c)},
+				bson.M{"_id": bson.M{"$in": granted}},
+			},
+		}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		// The text score can't be filtered on in a find, so a cursor's range
+		// condition is applied after the score is computed.
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$set", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}},
+		}
+		pipeline = append(pipeline, page.Stages()...)
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{"versions": 0}}})
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		hits := []searchHit{}
+		if err = cursor.All(ctx, &hits); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		result, err := cursorResult(page, hits, total)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		hits = result.Items.([]searchHit)
+
+		terms := searchTerms(query)
+		now := time.Now()
+		for i := range hits {
+			hits[i].Snippet = buildSnippet(hits[i].File, terms)
+			hits[i].ExpiresIn = expiresIn(hits[i].ExpiresAt, now)
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// searchTerms splits a $text query into its lowercased words, dropping the
+// quote and negation syntax.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if utf8.RuneCountInString(word) >= minSearchQuery {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// buildSnippet cuts a window of the file's extracted text, or failing that
+// its name or tags, around the first term found and splits it into matching
+// and non-matching parts.
+func buildSnippet(file models.File, terms []string) []snippetPart {
+	for _, source := range append([]string{file.ExtractedText, file.Name}, file.Tags...) {
+		first := -1
+		for _, term := range terms {
+			if i, _ := indexFold(source, term); i >= 0 && (first < 0 || i < first) {
+				first = i
+			}
+		}
+		if first < 0 {
+			continue
+		}
+
+		start := max(0, first-snippetRadius)
+		end := min(len(source), first+snippetRadius)
+		for start > 0 && !utf8.RuneStart(source[start]) {
+			start--
+		}
+		for end < len(source) && !utf8.RuneStart(source[end]) {
+			end++
+		}
+		return splitMatches(source[start:end], terms, start > 0, end < len(source))
+	}
+	return []snippetPart{}
+}
+
+// splitMatches splits text into parts at every occurrence of terms, adding
+// ellipses where the window was cut.
+func splitMatches(text string, terms []string, cutStart bool, cutEnd bool) []snippetPart {
+	text = strings.Join(strings.Fields(text), " ")
+	if cutStart {
+		text = "…" + text
+	}
+	if cutEnd {
+		text += "…"
+	}
+
+	parts := []snippetPart{}
+	for pos := 0; pos < len(text); {
+		next, length := -1, 0
+		for _, term := range terms {
+			if i, n := indexFold(text[pos:], term); i >= 0 && (next < 0 || i < next) {
+				next, length = i, n
+			}
+		}
+		if next < 0 {
+			parts = append(parts, snippetPart{Text: text[pos:]})
+			break
+		}
+		if next > 0 {
+			parts = append(parts, snippetPart{Text: text[pos : pos+next]})
+		}
+		parts = append(parts, snippetPart{Text: text[pos+next : pos+next+length], Match: true})
+		pos += next + length
+	}
+	return parts
+}
+
+// indexFold finds the first case-insensitive occurrence of term in s,
+// returning its byte offset and length in s, or -1.
+func indexFold(s string, term string) (int, int) {
+	for i := range s {
+		j, k := i, 0
+		for k < len(term) && j < len(s) {
+			a, an := utf8.DecodeRuneInString(s[j:])
+			b, bn := utf8.DecodeRuneInString(term[k:])
+			if unicode.ToLower(a) != unicode.ToLower(b) {
+				break
+			}
+			j += an
+			k += bn
+		}
+		if k == len(term) {
+			return i, j - i
+		}
+	}
+	return -1, 0
+}
@@ -0,0 +1,150 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// workers tracks the background jobs so shutdown can wait for them to stop.
+var workers sync.WaitGroup
+
+// startWorker runs a background job in its own goroutine, tracked by
+// workers.
+func startWorker(run func()) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		run()
+	}()
+}
+
+// draining is set once shutdown has begun.
+var draining atomic.Bool
+
+// drainRetryAfter is the Retry-After sent to requests refused while draining.
+const drainRetryAfter = 5 * time.Second
+
+// DrainGate refuses requests with 503 once shutdown has begun, so clients on
+// kept-alive connections retry against another instance while in-flight
+// requests finish. The probes stay reachable so readiness can report the
+// drain.
+func DrainGate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() && !containsString(probePaths, c.Request.URL.Path) {
+			c.Header("Connection", "close")
+			c.Header("Retry-After", strconv.Itoa(int(drainRetryAfter.Seconds())))
+			respondError(c, unavailable("server is shutting down"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Run connects to Mongo, serves the API on cfg.ListenAddr and shuts down
+// gracefully on SIGINT or SIGTERM. main should call this with the Config from
+// LoadConfig and exit non-zero when it returns an error.
+func Run(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	slog.SetDefault(Logger)
+
+	connectCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(cfg.MongoURI))
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer disconnect(client)
+
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return serve(signals, client, cfg, listener)
+}
+
+// serve runs the API on listener until stop is done, then shuts down in
+// order: new requests are refused and in-flight ones get
+// cfg.ShutdownGracePeriod to finish, then the background jobs are cancelled
+// and waited for. The caller closes the Mongo client afterwards.
+func serve(stop context.Context, client *mongo.Client, cfg *Config, listener net.Listener) error {
+	// The background jobs run on this context; handlers use their own
+	// request's.
+	appCtx, cancelApp := context.WithCancel(context.Background())
+	defer cancelApp()
+
+	router, err := SetupRouter(appCtx, client, cfg)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	err = serveUntil(stop, router, cfg, listener)
+	cancelApp()
+	workers.Wait()
+	return err
+}
+
+// serveUntil serves handler on listener until stop is done, then refuses new
+// requests and gives in-flight ones cfg.ShutdownGracePeriod to finish before
+// closing their connections.
+func serveUntil(stop context.Context, handler http.Handler, cfg *Config, listener net.Listener) error {
+	server := &http.Server{Handler: handler}
+	// WebSockets are hijacked connections Shutdown doesn't track.
+	server.RegisterOnShutdown(events.shutdown)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	log.Printf("listening on %s", listener.Addr())
+
+	select {
+	case err := <-served:
+		return err
+	case <-stop.Done():
+	}
+
+	log.Printf("shutting down, draining requests for up to %s", cfg.ShutdownGracePeriod)
+	draining.Store(true)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGracePeriod)
+	defer cancel()
+	shutdownErr := server.Shutdown(shutdownCtx)
+	if errors.Is(shutdownErr, context.DeadlineExceeded) {
+		log.Printf("grace period over, closing remaining connections")
+		shutdownErr = server.Close()
+	}
+	if err := <-served; err != nil && err != http.ErrServerClosed && shutdownErr == nil {
+		shutdownErr = err
+	}
+	return shutdownErr
+}
+
+// disconnect closes the Mongo client once nothing uses it any more.
+func disconnect(client *mongo.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Disconnect(ctx); err != nil {
+		log.Printf("disconnecting from Mongo failed: %v", err)
+	}
+}
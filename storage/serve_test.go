@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resetDrain undoes what a shutdown leaves behind, for the tests after.
+func resetDrain(t *testing.T) {
+	t.Cleanup(func() {
+		draining.Store(false)
+		events.mu.Lock()
+		events.closed = false
+		events.mu.Unlock()
+	})
+}
+
+// slowServer serves GET /slow behind DrainGate on a local port until stop is
+// done. The handler reports on started and answers after d, unless its
+// connection is closed first.
+func slowServer(t *testing.T, stop context.Context, cfg *Config, d time.Duration, started chan<- struct{}) (string, <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.Use(DrainGate())
+	router.GET("/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		select {
+		case <-time.After(d):
+			c.String(http.StatusOK, "done")
+		case <-c.Request.Context().Done():
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- serveUntil(stop, router, cfg, listener) }()
+	return "http://" + listener.Addr().String(), done
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	resetDrain(t)
+	cfg := testConfig()
+	cfg.ShutdownGracePeriod = 5 * time.Second
+
+	// As Run does; the signal is caught instead of killing the test.
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	started := make(chan struct{}, 1)
+	url, done := slowServer(t, signals, cfg, 300*time.Millisecond, started)
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	answered := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			answered <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		answered <- result{resp.StatusCode, string(body), err}
+	}()
+
+	<-started
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Skipf("cannot signal the test process: %v", err)
+	}
+
+	got := <-answered
+	if got.err != nil || got.status != http.StatusOK || got.body != "done" {
+		t.Errorf("in-flight request got %d %q, %v; want it to finish", got.status, got.body, got.err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("shutdown failed: %v", err)
+		}
+	case <-time.After(cfg.ShutdownGracePeriod):
+		t.Fatal("server did not stop after its last request")
+	}
+	if !draining.Load() {
+		t.Error("shutdown did not start draining")
+	}
+}
+
+func TestShutdownGracePeriodEnds(t *testing.T) {
+	resetDrain(t)
+	cfg := testConfig()
+	cfg.ShutdownGracePeriod = 50 * time.Millisecond
+
+	stop, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 1)
+	url, done := slowServer(t, stop, cfg, time.Minute, started)
+	go http.Get(url + "/slow")
+
+	<-started
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown waited past the grace period")
+	}
+}
+
+func TestDrainGate(t *testing.T) {
+	resetDrain(t)
+	router := gin.New()
+	router.Use(DrainGate())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.GET("/files", ok)
+	router.GET("/readyz", ok)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d before shutdown, want the request let through", rec.Code)
+	}
+
+	draining.Store(true)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "5" || rec.Header().Get("Connection") != "close" {
+		t.Errorf("got %d with headers %v, want 503 with Retry-After and Connection: close", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("probe got %d while draining, want it let through", rec.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SetupRouter checks cfg, prepares its database, registers every controller
+// on a new gin engine and starts the background jobs, which stop when ctx is
+// cancelled. The controllers, middleware and jobs get cfg passed in, so
+// routers built from different Configs don't share settings; only the GeoIP
+// database and the work queues the jobs drain are shared by the process. The
+// API controllers are mounted under cfg.APIPrefix, and with cfg.LegacyRoutes
+// again at the root; the probes and metrics always stay at the root. Run
+// calls it; tests can call it directly with their own client and Config.
+func SetupRouter(ctx context.Context, client *mongo.Client, cfg *Config) (*gin.Engine, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	db := client.Database(cfg.DatabaseName)
+
+	if err := prepareStorage(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := openGeoIP(cfg.ShareAnalytics.GeoIPDatabase); err != nil {
+		return nil, err
+	}
+
+	if err := markLiveUsers(ctx, db); err != nil {
+		return nil, err
+	}
+	if err := EnsureIndexes(ctx, db, cfg); err != nil {
+		return nil, err
+	}
+	if err := markLiveFiles(ctx, db); err != nil {
+		return nil, err
+	}
+
+	supported, err := detectTransactions(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	transactionsSupported = supported
+	if !supported {
+		Logger.Warn("MongoDB is a standalone server without transactions; multi-document writes run unprotected")
+	}
+
+	router := newRouter(db, cfg)
+
+	StartTrashPurger(ctx, db, cfg)
+	StartThumbnailWorker(ctx, db, cfg)
+	StartTextExtractor(ctx, db, cfg)
+	StartVirusScanner(ctx, db, cfg)
+	StartAccessRecorder(ctx, db)
+	StartAuditWriter(ctx, db, cfg)
+	StartShareAccessWriter(ctx, db)
+	StartWebhookWorker(ctx, db, cfg)
+	StartMailer(ctx, db, cfg)
+	StartAPIKeyTracker(ctx, db)
+
+	return router, nil
+}
+
+// newRouter registers the middleware and every controller's routes for cfg,
+// without touching the database.
+func newRouter(db *mongo.Database, cfg *Config) *gin.Engine {
+	router := gin.New()
+	router.Use(RequestLogger(), gin.Recovery(), CrossOrigin(cfg.CORS), DrainGate())
+	if cfg.MetricsEnabled {
+		router.Use(Metrics())
+	}
+	router.Use(Timeout(cfg), Gzip(cfg))
+
+	if cfg.MetricsEnabled {
+		NewMetricsController(db, cfg).BasicRoute(&router.RouterGroup)
+	}
+	NewHealthController(db, cfg).BasicRoute(&router.RouterGroup)
+
+	controllers := []routeController{
+		NewUserController(db, cfg),
+		NewAuthController(db, cfg),
+		NewFileController(db, cfg),
+		NewShareController(db, cfg),
+		NewFolderController(db, cfg),
+		NewAdminController(db, cfg),
+		NewWebhookController(db, cfg),
+		NewGroupController(db, cfg),
+		NewFileRequestController(db, cfg),
+		NewEventController(db, cfg),
+		NewNotificationController(db, cfg),
+	}
+	api := router.Group(cfg.APIPrefix)
+	for _, controller := range controllers {
+		controller.BasicRoute(api)
+	}
+	if cfg.APIDocsEnabled {
+		NewDocsController(db, cfg).BasicRoute(api)
+	}
+	if cfg.LegacyRoutes && cfg.APIPrefix != "" {
+		legacy := router.Group("", Deprecated(cfg))
+		for _, controller := range controllers {
+			controller.BasicRoute(legacy)
+		}
+	}
+	return router
+}
@@ -0,0 +1,137 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+var errRefreshTokenReused = errors.New("refresh token reuse detected")
+var errSessionEnded = errors.New("session ended")
+
+// randomToken returns n random bytes encoded as unpadded base64url.
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex SHA-256 of a random token. Tokens carry enough
+// entropy that a fast hash is sufficient for storage at rest.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession starts a new refresh token family for userId that lasts ttl
+// without a refresh, and returns its id and the plaintext refresh token.
+func createSession(ctx context.Context, db *mongo.Database, ttl time.Duration, userId primitive.ObjectID, c *gin.Context) (primitive.ObjectID, string, error) {
+	collection := db.Collection(SessionCollection)
+
+	token, err := randomToken(32)
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		Id:             primitive.NewObjectID(),
+		UserId:         userId,
+		TokenHash:      hashToken(token),
+		PreviousHashes: []string{},
+		UserAgent:      c.Request.UserAgent(),
+		IP:             c.ClientIP(),
+		CreatedAt:      now,
+		LastUsedAt:     now,
+		ExpiresAt:      now.Add(ttl),
+	}
+
+	if _, err := collection.InsertOne(ctx, session); err != nil {
+		return primitive.NilObjectID, "", err
+	}
+	return session.Id, token, nil
+}
+
+// rotateSession swaps a valid refresh token for a new one, extending the
+// session by ttl. Presenting a token that was already rotated out revokes
+// the whole session.
+func rotateSession(ctx context.Context, db *mongo.Database, ttl time.Duration, token string) (*models.Session, string, error) {
+	collection := db.Collection(SessionCollection)
+	oldHash := hashToken(token)
+	now := time.Now()
+
+	newToken, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filter := bson.M{
+		"tokenHash": oldHash,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"tokenHash":  hashToken(newToken),
+			"lastUsedAt": now,
+			"expiresAt":  now.Add(ttl),
+		},
+		"$push": bson.M{"previousHashes": oldHash},
+	}
+
+	var session models.Session
+	err = collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&session)
+	if err == nil {
+		return &session, newToken, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, "", err
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"previousHashes": oldHash, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": now}},
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	if result.MatchedCount > 0 {
+		return nil, "", errRefreshTokenReused
+	}
+	return nil, "", errInvalidRefreshToken
+}
+
+// revokeUserSessions revokes every active session belonging to userId.
+func revokeUserSessions(ctx context.Context, db *mongo.Database, userId primitive.ObjectID) error {
+	collection := db.Collection(SessionCollection)
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"userId": userId, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	return err
+}
+
+// checkSession fails with errSessionEnded once the session sessionId was
+// revoked or has expired.
+func checkSession(ctx context.Context, db *mongo.Database, sessionId primitive.ObjectID) error {
+	filter := bson.M{"_id": sessionId, "revokedAt": bson.M{"$exists": false}, "expiresAt": bson.M{"$gt": time.Now()}}
+	err := db.Collection(SessionCollection).FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		return errSessionEnded
+	}
+	return err
+}
@@ -0,0 +1,684 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ShareExpiryGrace is how long an expired share document is kept, answering
+// 410 Gone, before the TTL index removes it.
+const ShareExpiryGrace = 7 * 24 * time.Hour
+
+// ShareUnlockTTL is how long a download token from POST /s/:token/unlock
+// stays valid.
+const ShareUnlockTTL = 10 * time.Minute
+
+// shareUnlockFailures limits wrong password attempts per share link.
+var shareUnlockFailures = newFailureLimiter(5, 15*time.Minute)
+
+type ShareController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewShareController(db *mongo.Database, cfg *Config) *ShareController {
+	return &ShareController{db, cfg}
+}
+
+// SetupRouter function
+func (sc *ShareController) BasicRoute(router *gin.RouterGroup) {
+	limits := sc.cfg.RateLimits
+	fileRouter := router.Group("/files", AuthRequired(sc.db, sc.cfg), RequireScope(sc.cfg, scopeShares))
+	fileRouter.POST("/:id/share", RequireVerified(sc.db), sc.CreateShare())
+
+	shareRouter := router.Group("/shares", AuthRequired(sc.db, sc.cfg), RequireScope(sc.cfg, scopeShares))
+	shareRouter.GET("/", sc.GetShares())
+	shareRouter.PATCH("/:id", sc.UpdateShare())
+	shareRouter.DELETE("/:id", sc.RevokeShare())
+	shareRouter.GET("/:id/qr", sc.GetShareQR())
+	shareRouter.GET("/:id/analytics", sc.GetShareAnalytics())
+
+	// Links with a short code also draw from the much smaller code limit.
+	publicRouter := router.Group("/s", RateLimit(sc.db, limits.Store, "sharecode", limits.ShareCode, byIPForShareCode))
+	publicRouter.GET("/:token", RateLimit(sc.db, limits.Store, "download", limits.Download, byIP), sc.DownloadShare())
+	publicRouter.POST("/:token/unlock", RateLimit(sc.db, limits.Store, "unlock", limits.Unlock, byIP), sc.UnlockShare())
+	publicRouter.GET("/:token/qr", RateLimit(sc.db, limits.Store, "download", limits.Download, byIP), sc.GetPublicShareQR())
+}
+
+// shareManagerFilter matches shares the caller may manage: those on their
+// own files and those they created as an editor.
+func shareManagerFilter(c *gin.Context) bson.A {
+	userId := currentUserID(c)
+	return bson.A{bson.M{"ownerId": userId}, bson.M{"createdBy": userId}}
+}
+
+// shareResponse is a share as returned to its owner.
+type shareResponse struct {
+	models.Share `bson:",inline"`
+	URL          string `json:"url" bson:"-"`
+	ShortURL     string `json:"shortUrl,omitempty" bson:"-"`
+	ExpiresIn    *int64 `json:"expiresIn,omitempty" bson:"-"`
+	Expired      bool   `json:"expired" bson:"-"`
+	Protected    bool   `json:"protected" bson:"-"`
+}
+
+func newShareResponse(cfg *Config, share models.Share) shareResponse {
+	now := time.Now()
+	resp := shareResponse{
+		Share:     share,
+		URL:       cfg.apiURL("/s/" + share.Token),
+		Expired:   share.Expired(now),
+		Protected: share.PasswordHash != "",
+	}
+	if share.Code != "" {
+		resp.ShortURL = cfg.apiURL("/s/" + share.Code)
+	}
+	if share.ExpiresAt != nil && !resp.Expired {
+		remaining := int64(share.ExpiresAt.Sub(now).Seconds())
+		resp.ExpiresIn = &remaining
+	}
+	return resp
+}
+
+// shareSettings are the optional settings accepted when creating or updating
+// a share. ExpiresAt, TTL and NeverExpires are mutually exclusive, as are
+// Password and RemovePassword, and MaxDownloads and UnlimitedDownloads.
+// Recipients are emailed the link, and ShortCode gives it a short code as
+// well, when the share is created.
+type shareSettings struct {
+	ExpiresAt          *time.Time `json:"expiresAt"`
+	TTL                string     `json:"ttl"`
+	NeverExpires       bool       `json:"neverExpires"`
+	Password           *string    `json:"password" binding:"omitempty,min=4,max=128"`
+	RemovePassword     bool       `json:"removePassword"`
+	MaxDownloads       *int64     `json:"maxDownloads" binding:"omitempty,min=1"`
+	UnlimitedDownloads bool       `json:"unlimitedDownloads"`
+	Recipients         []string   `json:"recipients" binding:"omitempty,max=20,dive,email"`
+	ShortCode          bool       `json:"shortCode"`
+}
+
+// downloadLimit resolves the requested download limit. changed is false when
+// the request says nothing about it.
+func (s shareSettings) downloadLimit() (limit *int64, changed bool, err error) {
+	if s.MaxDownloads != nil && s.UnlimitedDownloads {
+		return nil, false, errors.New("only one of maxDownloads and unlimitedDownloads may be set")
+	}
+	if s.UnlimitedDownloads {
+		return nil, true, nil
+	}
+	return s.MaxDownloads, s.MaxDownloads != nil, nil
+}
+
+// passwordHash resolves the requested password change. changed is false when
+// the request says nothing about the password.
+func (s shareSettings) passwordHash() (hash string, changed bool, err error) {
+	if s.Password != nil && s.RemovePassword {
+		return "", false, errors.New("only one of password and removePassword may be set")
+	}
+	if s.RemovePassword {
+		return "", true, nil
+	}
+	if s.Password == nil {
+		return "", false, nil
+	}
+
+	raw, err := bcrypt.GenerateFromPassword([]byte(*s.Password), BcryptCost)
+	if err != nil {
+		return "", false, err
+	}
+	return string(raw), true, nil
+}
+
+// expiry resolves the requested expiry. changed is false when the request
+// says nothing about expiry.
+func (s shareSettings) expiry(now time.Time) (expiresAt *time.Time, changed bool, err error) {
+	set := 0
+	for _, given := range []bool{s.ExpiresAt != nil, s.TTL != "", s.NeverExpires} {
+		if given {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, false, errors.New("only one of expiresAt, ttl and neverExpires may be set")
+	}
+
+	switch {
+	case s.NeverExpires:
+		return nil, true, nil
+	case s.TTL != "":
+		ttl, err := time.ParseDuration(s.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, false, errors.New("ttl must be a positive duration such as \"24h\"")
+		}
+		at := now.Add(ttl)
+		return &at, true, nil
+	case s.ExpiresAt != nil:
+		if !s.ExpiresAt.After(now) {
+			return nil, false, errors.New("expiresAt must be in the future")
+		}
+		return s.ExpiresAt, true, nil
+	}
+	return nil, false, nil
+}
+
+// bindShareSettings binds an optional shareSettings body; an empty body means
+// no settings.
+func bindShareSettings(c *gin.Context) (shareSettings, bool) {
+	var settings shareSettings
+	if c.Request.ContentLength == 0 {
+		return settings, true
+	}
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondBindingError(c, err)
+		return settings, false
+	}
+	return settings, true
+}
+
+// CreateShare handler
+func (sc *ShareController) CreateShare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := sc.db.Collection(ShareCollection)
+
+		file, ok := findFile(ctx, sc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, sc.db, c, file, accessEditor) {
+			return
+		}
+
+		if !scanAllowsSharing(c, file) {
+			return
+		}
+
+		settings, ok := bindShareSettings(c)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		expiresAt, _, err := settings.expiry(now)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		passwordHash, _, err := settings.passwordHash()
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		maxDownloads, _, err := settings.downloadLimit()
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		token, err := randomToken(32)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		share := models.Share{
+			Id:           primitive.NewObjectID(),
+			FileId:       file.Id,
+			OwnerId:      file.OwnerId,
+			CreatedBy:    currentUserID(c),
+			Token:        token,
+			CreatedAt:    now,
+			ExpiresAt:    expiresAt,
+			PasswordHash: passwordHash,
+			MaxDownloads: maxDownloads,
+		}
+
+		if settings.ShortCode {
+			err = insertShareWithCode(ctx, collection, sc.cfg.ShareCodeLimit, &share)
+		} else {
+			_, err = collection.InsertOne(ctx, share)
+		}
+		if err == errShareCodeLimit {
+			respondError(c, conflict(err.Error()).withDetails(gin.H{"limit": sc.cfg.ShareCodeLimit}))
+			return
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditShareCreated, auditTargetShare, share.Id, gin.H{"fileId": file.Id})
+		for _, recipient := range settings.Recipients {
+			queueShareEmail(shareEmail{
+				Template:  mailLinkShared,
+				SharerId:  currentUserID(c),
+				To:        recipient,
+				FileName:  file.Name,
+				Link:      sc.cfg.apiURL("/s/" + share.Token),
+				ExpiresAt: share.ExpiresAt,
+				Protected: share.PasswordHash != "",
+			})
+		}
+		emitWebhookEvent(file.OwnerId, AuditShareCreated, gin.H{"shareId": share.Id, "fileId": file.Id, "fileName": file.Name, "expiresAt": share.ExpiresAt})
+		c.JSON(http.StatusCreated, newShareResponse(sc.cfg, share))
+	}
+}
+
+// GetShares handler
+func (sc *ShareController) GetShares() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := sc.db.Collection(ShareCollection)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter := bson.M{"$or": shareManagerFilter(c), "revokedAt": bson.M{"$exists": false}}
+		if fileId := c.Query("fileId"); fileId != "" {
+			objId, err := primitive.ObjectIDFromHex(fileId)
+			if err != nil {
+				respondError(c, badRequest("Invalid file ID"))
+				return
+			}
+			filter["fileId"] = objId
+		}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		cursor, err := collection.Find(ctx, filter, page.FindOptions().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var shares []models.Share
+		if err = cursor.All(ctx, &shares); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		items := make([]shareResponse, 0, len(shares))
+		for _, share := range shares {
+			items = append(items, newShareResponse(sc.cfg, share))
+		}
+
+		c.JSON(http.StatusOK, page.Result(items, total))
+	}
+}
+
+// UpdateShare handler
+func (sc *ShareController) UpdateShare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := sc.db.Collection(ShareCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid share ID"))
+			return
+		}
+
+		settings, ok := bindShareSettings(c)
+		if !ok {
+			return
+		}
+
+		expiresAt, expiryChanged, err := settings.expiry(time.Now())
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		passwordHash, passwordChanged, err := settings.passwordHash()
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		maxDownloads, limitChanged, err := settings.downloadLimit()
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		if settings.ShortCode {
+			respondError(c, badRequest("a short code can only be requested when the share is created"))
+			return
+		}
+
+		if !expiryChanged && !passwordChanged && !limitChanged {
+			respondError(c, badRequest("no updatable fields provided"))
+			return
+		}
+
+		set := bson.M{}
+		unset := bson.M{}
+		update := bson.M{}
+		if expiryChanged {
+			if expiresAt != nil {
+				set["expiresAt"] = *expiresAt
+			} else {
+				unset["expiresAt"] = ""
+			}
+		}
+		if passwordChanged {
+			if passwordHash != "" {
+				set["passwordHash"] = passwordHash
+			} else {
+				unset["passwordHash"] = ""
+			}
+			update["$inc"] = bson.M{"passwordVersion": 1}
+		}
+		if limitChanged {
+			if maxDownloads != nil {
+				set["maxDownloads"] = *maxDownloads
+			} else {
+				unset["maxDownloads"] = ""
+			}
+		}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+
+		filter := bson.M{"_id": objId, "revokedAt": bson.M{"$exists": false}}
+		if !isAdmin(c) {
+			filter["$or"] = shareManagerFilter(c)
+		}
+
+		var share models.Share
+		err = collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&share)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Share not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, newShareResponse(sc.cfg, share))
+	}
+}
+
+// RevokeShare handler
+func (sc *ShareController) RevokeShare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := sc.db.Collection(ShareCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid share ID"))
+			return
+		}
+
+		filter := bson.M{"_id": objId, "revokedAt": bson.M{"$exists": false}}
+		if !isAdmin(c) {
+			filter["$or"] = shareManagerFilter(c)
+		}
+
+		var share models.Share
+		if err := collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now()}}).Decode(&share); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Share not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditShareRevoked, auditTargetShare, objId, nil)
+		emitWebhookEvent(share.OwnerId, AuditShareRevoked, gin.H{"shareId": share.Id, "fileId": share.FileId})
+		c.JSON(http.StatusOK, gin.H{"message": "Share revoked successfully"})
+	}
+}
+
+// DownloadShare handler
+func (sc *ShareController) DownloadShare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := sc.db
+
+		var share models.Share
+		if err := db.Collection(ShareCollection).FindOne(ctx, publicShareFilter(c)).Decode(&share); err != nil {
+			respondShareLookupError(c, err)
+			return
+		}
+
+		if share.Expired(time.Now()) {
+			respondError(c, gone("share link has expired"))
+			return
+		}
+
+		if share.PasswordHash != "" && !validShareAccess(c, sc.cfg.JWTSecret, &share) {
+			respondError(c, unauthorized("password required").withDetails(gin.H{"passwordRequired": true}))
+			return
+		}
+
+		var file models.File
+		if err := db.Collection(FileCollection).FindOne(ctx, bson.M{"_id": share.FileId, "deletedAt": notTrashed, "expiresAt": notExpired()}).Decode(&file); err != nil {
+			respondShareLookupError(c, err)
+			return
+		}
+
+		if !scanAllowsDownload(c, sc.cfg.Scan, file.ScanResult) {
+			return
+		}
+
+		// Revalidating a cached copy isn't a download, so it is answered
+		// before the limit is charged.
+		if share.PasswordHash == "" && share.MaxDownloads == nil {
+			c.Header("Cache-Control", sc.cfg.CacheControl.Shared)
+		} else {
+			c.Header("Cache-Control", sc.cfg.CacheControl.Private)
+		}
+		validators := fileValidators(&file)
+		if validators.respondNotModified(c) {
+			return
+		}
+
+		// A range that isn't counted is still refused once the limit is
+		// reached, or the rest of the file could be fetched in pieces.
+		counted := countsAsDownload(c, validators, file.Size)
+		if counted || share.MaxDownloads != nil {
+			allowed, err := claimShareDownload(ctx, db.Collection(ShareCollection), share.Id, counted)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			if !allowed {
+				respondError(c, gone("share link download limit reached"))
+				return
+			}
+		}
+
+		if counted {
+			auditAs(c, primitive.NilObjectID, AuditShareDownloaded, auditTargetShare, share.Id, gin.H{"fileId": file.Id})
+			publishEvent(share.OwnerId, EventShareAccessed, gin.H{"shareId": share.Id, "fileId": file.Id, "name": file.Name})
+		}
+		streamFile(c, sc.db, sc.cfg, &file, "attachment")
+		if counted && c.Writer.Status() < http.StatusBadRequest {
+			recordShareAccess(c, sc.cfg, &share, int64(c.Writer.Size()))
+		}
+	}
+}
+
+// countsAsDownload reports whether a request starts a new download of a
+// file of the given size: whenever the range actually sent starts at the
+// first byte, which includes the whole file sent for a missing or malformed
+// Range or a stale If-Range. Only ranges that resume or seek within the file
+// aren't counted again.
+func countsAsDownload(c *gin.Context, v validators, size int64) bool {
+	rng, _, err := requestedRange(c, v, size)
+	return err == nil && rng.start == 0
+}
+
+// claimShareDownload reports whether the share's download counter is still
+// below maxDownloads and, if count is set, atomically increments it. Two
+// concurrent requests for the last remaining download can't both succeed.
+func claimShareDownload(ctx context.Context, collection *mongo.Collection, shareId primitive.ObjectID, count bool) (bool, error) {
+	filter := bson.M{
+		"_id":       shareId,
+		"revokedAt": bson.M{"$exists": false},
+		"suspended": shareOwnerActive,
+		"$or": bson.A{
+			bson.M{"maxDownloads": bson.M{"$exists": false}},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$downloads", "$maxDownloads"}}},
+		},
+	}
+
+	var err error
+	if count {
+		err = collection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"downloads": 1}}).Err()
+	} else {
+		err = collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	}
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type unlockShareRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// UnlockShare handler
+func (sc *ShareController) UnlockShare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := sc.db.Collection(ShareCollection)
+
+		key := shareLinkKey(c)
+		if blocked, retryAfter := shareUnlockFailures.Blocked(key); blocked {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			respondError(c, newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "too many wrong passwords, try again later"))
+			return
+		}
+
+		var req unlockShareRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		var share models.Share
+		if err := collection.FindOne(ctx, publicShareFilter(c)).Decode(&share); err != nil {
+			respondShareLookupError(c, err)
+			return
+		}
+
+		if share.Expired(time.Now()) {
+			respondError(c, gone("share link has expired"))
+			return
+		}
+
+		if share.PasswordHash == "" {
+			respondError(c, badRequest("share link is not password protected"))
+			return
+		}
+
+		if !checkPassword(share.PasswordHash, req.Password) {
+			shareUnlockFailures.Fail(key)
+			respondError(c, unauthorized("wrong password").withDetails(gin.H{"passwordRequired": true}))
+			return
+		}
+		shareUnlockFailures.Reset(key)
+
+		accessToken, expiresAt, err := issueShareAccessToken(sc.cfg.JWTSecret, &share)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"accessToken": accessToken,
+			"expiresIn":   int64(time.Until(expiresAt).Seconds()),
+			"url":         sc.cfg.apiURL("/s/" + share.Token + "?access=" + accessToken),
+		})
+	}
+}
+
+// ShareAccessClaims is the payload of a download token for a protected share.
+type ShareAccessClaims struct {
+	PasswordVersion int `json:"pv"`
+	jwt.RegisteredClaims
+}
+
+func issueShareAccessToken(secret []byte, share *models.Share) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ShareUnlockTTL)
+	claims := ShareAccessClaims{
+		PasswordVersion: share.PasswordVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   share.Id.Hex(),
+			Audience:  jwt.ClaimStrings{shareAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := signToken(secret, claims)
+	return signed, expiresAt, err
+}
+
+// validShareAccess reports whether the request carries a download token,
+// via ?access= or X-Share-Access, issued for this share's current password.
+func validShareAccess(c *gin.Context, secret []byte, share *models.Share) bool {
+	raw := c.Query("access")
+	if raw == "" {
+		raw = c.GetHeader("X-Share-Access")
+	}
+	if raw == "" {
+		return false
+	}
+
+	claims := &ShareAccessClaims{}
+	if err := parseToken(secret, raw, claims, shareAudience); err != nil {
+		return false
+	}
+	return claims.Subject == share.Id.Hex() && claims.PasswordVersion == share.PasswordVersion
+}
+
+// respondShareLookupError answers a failed public share lookup. Missing
+// shares and missing files both get the same 404 so a token reveals nothing
+// about the file behind it.
+func respondShareLookupError(c *gin.Context, err error) {
+	if err == mongo.ErrNoDocuments {
+		respondError(c, notFound("Share not found"))
+		return
+	}
+	respondError(c, err)
+}
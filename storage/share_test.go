@@ -0,0 +1,179 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func rangeContext(header string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/s/token", nil)
+	if header != "" {
+		c.Request.Header.Set("Range", header)
+	}
+	return c
+}
+
+func TestCountsAsDownload(t *testing.T) {
+	const size = 100
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"no range", "", true},
+		{"from the start", "bytes=0-", true},
+		{"first byte", "bytes=0-0", true},
+		{"resume", "bytes=50-", false},
+		{"seek", "bytes=10-20", false},
+		{"malformed", "bytes=5-2", true},
+		{"not bytes", "items=5-", true},
+		{"suffix within file", "bytes=-10", false},
+		{"suffix past start", "bytes=-999999999", true},
+		{"unsatisfiable", "bytes=500-", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countsAsDownload(rangeContext(tt.header), validators{}, size); got != tt.want {
+				t.Errorf("countsAsDownload(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountsAsDownloadStaleIfRange(t *testing.T) {
+	c := rangeContext("bytes=50-")
+	c.Request.Header.Set("If-Range", `"old"`)
+	if !countsAsDownload(c, validators{etag: `"new"`}, 100) {
+		t.Error("a range with a stale If-Range sends the whole file and should count")
+	}
+}
+
+func TestRequestedRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		start   int64
+		length  int64
+		partial bool
+		err     error
+	}{
+		{"", 0, 100, false, nil},
+		{"bytes=0-0", 0, 1, true, nil},
+		{"bytes=5-2", 0, 100, false, nil},
+		{"bytes=-999999999", 0, 100, true, nil},
+		{"bytes=90-200", 90, 10, true, nil},
+		{"bytes=100-", 0, 0, false, errRangeUnsatisfiable},
+		{"bytes=0-1,5-6", 0, 0, false, errRangeUnsatisfiable},
+	}
+	for _, tt := range tests {
+		rng, partial, err := requestedRange(rangeContext(tt.header), validators{}, 100)
+		if err != tt.err || partial != tt.partial || rng.start != tt.start || rng.length != tt.length {
+			t.Errorf("requestedRange(%q) = %+v, %v, %v; want start %d length %d, %v, %v",
+				tt.header, rng, partial, err, tt.start, tt.length, tt.partial, tt.err)
+		}
+	}
+}
+
+func TestDownloadShareLimitReached(t *testing.T) {
+	mt := newMockDB(t)
+	limit := int64(1)
+	share := models.Share{Id: primitive.NewObjectID(), FileId: primitive.NewObjectID(), Token: "token", Downloads: 1, MaxDownloads: &limit}
+	file := models.File{Id: share.FileId, Name: "report.pdf", Size: 100}
+
+	// Resuming or seeking isn't counted, but once the limit is reached it
+	// is refused like a new download, so the file can't be fetched in pieces.
+	for _, header := range []string{"", "bytes=50-"} {
+		mt.Run(header, func(mt *mtest.T) {
+			mt.AddMockResponses(found(mt, ShareCollection, share), found(mt, FileCollection, file), found(mt, ShareCollection))
+			router := gin.New()
+			router.GET("/s/:token", NewShareController(mt.DB, testConfig()).DownloadShare())
+			req := httptest.NewRequest(http.MethodGet, "/s/token", nil)
+			if header != "" {
+				req.Header.Set("Range", header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusGone {
+				mt.Fatalf("status = %d, want 410", rec.Code)
+			}
+			// Only the download from the start is counted.
+			command, filter := "findAndModify", "query"
+			if header != "" {
+				command, filter = "find", "filter"
+			}
+			claim := mt.GetAllStartedEvents()[2]
+			if claim.CommandName != command || claim.Command.Lookup(filter, "$or").IsZero() {
+				mt.Errorf("sent %s, want a %s checking the limit", claim.Command, command)
+			}
+		})
+	}
+}
+
+// TestClaimShareDownloadConcurrently runs against the server at
+// $MONGODB_TEST_URI, which needs to be no more than a standalone `docker run
+// -d -p 27017:27017 mongo:7`.
+func TestClaimShareDownloadConcurrently(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database("share_test_" + primitive.NewObjectID().Hex())
+	defer db.Drop(context.Background())
+	collection := db.Collection(ShareCollection)
+	shareId := primitive.NewObjectID()
+	if _, err := collection.InsertOne(ctx, bson.M{"_id": shareId, "downloads": 0, "maxDownloads": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	const requests = 20
+	var wg sync.WaitGroup
+	claimed := make(chan bool, requests)
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := claimShareDownload(ctx, collection, shareId, true)
+			if err != nil {
+				t.Error(err)
+			}
+			claimed <- ok
+		}()
+	}
+	wg.Wait()
+	close(claimed)
+
+	n := 0
+	for ok := range claimed {
+		if ok {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d of %d concurrent downloads claimed a share allowing 1", n, requests)
+	}
+	if ok, err := claimShareDownload(ctx, collection, shareId, false); err != nil || ok {
+		t.Errorf("uncounted range after the limit: allowed %v, err %v; want refused", ok, err)
+	}
+}
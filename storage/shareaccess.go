@@ -0,0 +1,250 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ShareAccessCollection string = "shareAccess"
+
+const (
+	// shareAccessQueueSize bounds the accesses waiting to be written; more
+	// are dropped, like audit events.
+	shareAccessQueueSize = 1024
+	// shareAccessBatchSize caps the accesses written by one InsertMany.
+	shareAccessBatchSize = 100
+	// shareAnalyticsDays is how many days GET /shares/:id/analytics counts.
+	shareAnalyticsDays = 30
+	// shareRecentAccesses is how many of the latest accesses it lists.
+	shareRecentAccesses = 20
+)
+
+var shareAccesses = make(chan models.ShareAccess, shareAccessQueueSize)
+
+// geoIP looks up the countries of share accesses while a GeoIP database is
+// configured. Countries are only recorded while it is set.
+var geoIP *geoip2.Reader
+
+// openGeoIP opens the GeoIP database at path, if any.
+func openGeoIP(path string) error {
+	if path == "" {
+		return nil
+	}
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return err
+	}
+	geoIP = reader
+	return nil
+}
+
+// recordShareAccess queues a download of share that sent bytes without
+// waiting for the write. Accesses are best effort and dropped when the queue
+// is full. Visitors are only recorded with cfg.ShareAnalytics.StoreIPs.
+func recordShareAccess(c *gin.Context, cfg *Config, share *models.Share, bytes int64) {
+	access := models.ShareAccess{
+		Id:          primitive.NewObjectID(),
+		ShareId:     share.Id,
+		OwnerId:     share.OwnerId,
+		UserAgent:   c.Request.UserAgent(),
+		BytesServed: bytes,
+		CreatedAt:   time.Now(),
+	}
+
+	ip := net.ParseIP(c.ClientIP())
+	if ip != nil && cfg.ShareAnalytics.StoreIPs {
+		access.Visitor = hashVisitorIP(cfg.JWTSecret, ip)
+	}
+	if ip != nil && geoIP != nil {
+		if record, err := geoIP.Country(ip); err == nil {
+			access.Country = record.Country.IsoCode
+		}
+	}
+
+	select {
+	case shareAccesses <- access:
+	default:
+	}
+}
+
+// hashVisitorIP clears the host bits of ip, keeping a /24 for IPv4 and a
+// /48 for IPv6, and returns a keyed hash of the rest. Without the key the
+// hash can't be reversed by trying every address.
+func hashVisitorIP(secret []byte, ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4.Mask(net.CIDRMask(24, 32))
+	} else {
+		ip = ip.Mask(net.CIDRMask(48, 128))
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(ip)
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// StartShareAccessWriter writes queued share accesses in batches until ctx
+// is cancelled.
+func StartShareAccessWriter(ctx context.Context, db *mongo.Database) {
+	collection := db.Collection(ShareAccessCollection)
+
+	startWorker(func() {
+		for {
+			var access models.ShareAccess
+			select {
+			case <-ctx.Done():
+				return
+			case access = <-shareAccesses:
+			}
+
+			batch := []interface{}{access}
+		drain:
+			for len(batch) < shareAccessBatchSize {
+				select {
+				case access := <-shareAccesses:
+					batch = append(batch, access)
+				default:
+					break drain
+				}
+			}
+
+			_, err := collection.InsertMany(ctx, batch)
+			if ctx.Err() != nil {
+				continue
+			}
+			recordJob("share_access_write", err)
+			if err != nil {
+				log.Printf("writing %d share accesses failed: %v", len(batch), err)
+			}
+		}
+	})
+}
+
+// shareAccessDay counts the accesses of one UTC day, named as 2006-01-02.
+// Visitors estimates the distinct visitors from their hashed addresses.
+type shareAccessDay struct {
+	Date        string `json:"date" bson:"_id"`
+	Count       int64  `json:"count" bson:"count"`
+	BytesServed int64  `json:"bytesServed" bson:"bytesServed"`
+	Visitors    int64  `json:"visitors" bson:"visitors"`
+}
+
+// shareAnalytics is the response of GET /shares/:id/analytics. The totals
+// cover the days listed; Recent lists the latest accesses of any age.
+type shareAnalytics struct {
+	ShareId        primitive.ObjectID   `json:"shareId"`
+	Since          time.Time            `json:"since"`
+	Count          int64                `json:"count"`
+	BytesServed    int64                `json:"bytesServed"`
+	UniqueVisitors int64                `json:"uniqueVisitors"`
+	Daily          []shareAccessDay     `json:"daily"`
+	Recent         []models.ShareAccess `json:"recent"`
+}
+
+// GetShareAnalytics handler reports how often a share link the caller
+// manages was downloaded on each of the last 30 days, by about how many
+// visitors, and its latest downloads. Visitors are only counted while IP
+// storage is on.
+func (sc *ShareController) GetShareAnalytics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := sc.db.Collection(ShareAccessCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid share ID"))
+			return
+		}
+
+		filter := bson.M{"_id": objId}
+		if !isAdmin(c) {
+			filter["$or"] = shareManagerFilter(c)
+		}
+		var share models.Share
+		opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+		if err := sc.db.Collection(ShareCollection).FindOne(ctx, filter, opts).Decode(&share); err != nil {
+			respondShareLookupError(c, err)
+			return
+		}
+
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		since := today.AddDate(0, 0, 1-shareAnalyticsDays)
+
+		counts := func(id any) bson.A {
+			return bson.A{
+				bson.M{"$group": bson.M{
+					"_id":         id,
+					"count":       bson.M{"$sum": 1},
+					"bytesServed": bson.M{"$sum": "$bytesServed"},
+					"visitors":    bson.M{"$addToSet": "$visitor"},
+				}},
+				bson.M{"$set": bson.M{"visitors": bson.M{"$size": "$visitors"}}},
+			}
+		}
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"shareId": share.Id, "createdAt": bson.M{"$gte": since}}}},
+			{{Key: "$facet", Value: bson.M{
+				"daily":  counts(bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$createdAt"}}),
+				"totals": counts(nil),
+			}}},
+		}
+
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var results []struct {
+			Daily  []shareAccessDay `bson:"daily"`
+			Totals []shareAccessDay `bson:"totals"`
+		}
+		if err := cursor.All(ctx, &results); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		analytics := shareAnalytics{ShareId: share.Id, Since: since, Daily: make([]shareAccessDay, 0, shareAnalyticsDays)}
+		days := map[string]shareAccessDay{}
+		if len(results) > 0 {
+			for _, day := range results[0].Daily {
+				days[day.Date] = day
+			}
+			if len(results[0].Totals) > 0 {
+				totals := results[0].Totals[0]
+				analytics.Count, analytics.BytesServed, analytics.UniqueVisitors = totals.Count, totals.BytesServed, totals.Visitors
+			}
+		}
+		for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+			date := day.Format("2006-01-02")
+			counted, ok := days[date]
+			if !ok {
+				counted = shareAccessDay{Date: date}
+			}
+			analytics.Daily = append(analytics.Daily, counted)
+		}
+
+		analytics.Recent = []models.ShareAccess{}
+		recent := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(shareRecentAccesses)
+		if err := findAll(ctx, collection, bson.M{"shareId": share.Id}, &analytics.Recent, recent); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, analytics)
+	}
+}
@@ -0,0 +1,119 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// shareCodeAlphabet leaves out characters easily confused when read aloud
+// or typed: 0 and O, 1, I and L. Codes are matched case-insensitively.
+const shareCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// shareCodeLength keeps codes short enough to read over the phone while
+// leaving 31^8, about 8.5e11, of them.
+const shareCodeLength = 8
+
+// shareCodeRetries bounds the codes tried when generated ones are taken.
+const shareCodeRetries = 5
+
+var errShareCodeLimit = errors.New("too many active share links with a short code; revoke one first")
+
+// randomShareCode returns a new code drawn uniformly from shareCodeAlphabet.
+func randomShareCode() (string, error) {
+	max := big.NewInt(int64(len(shareCodeAlphabet)))
+	code := make([]byte, shareCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = shareCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// insertShareWithCode gives share a free short code and inserts it, drawing
+// a new code whenever the one drawn is taken. It fails with
+// errShareCodeLimit when the creator already has limit active shares with a
+// code.
+func insertShareWithCode(ctx context.Context, collection *mongo.Collection, limit int, share *models.Share) error {
+	active, err := collection.CountDocuments(ctx, bson.M{
+		"createdBy": share.CreatedBy,
+		"code":      bson.M{"$exists": true},
+		"revokedAt": bson.M{"$exists": false},
+		"$or":       bson.A{bson.M{"expiresAt": bson.M{"$exists": false}}, bson.M{"expiresAt": bson.M{"$gt": time.Now()}}},
+	})
+	if err != nil {
+		return err
+	}
+	if active >= int64(limit) {
+		return errShareCodeLimit
+	}
+
+	for attempt := 0; attempt < shareCodeRetries; attempt++ {
+		if share.Code, err = randomShareCode(); err != nil {
+			return err
+		}
+		_, err = collection.InsertOne(ctx, share)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// normalizeShareCode returns raw upper-cased if it is shaped like a short
+// code, or "" when it isn't and must be a token.
+func normalizeShareCode(raw string) string {
+	if len(raw) != shareCodeLength {
+		return ""
+	}
+	code := strings.ToUpper(raw)
+	for i := 0; i < len(code); i++ {
+		if strings.IndexByte(shareCodeAlphabet, code[i]) < 0 {
+			return ""
+		}
+	}
+	return code
+}
+
+// publicShareFilter matches the share a public link names by its :token,
+// which is either a long token or a short code, unless it was revoked or
+// its owner is suspended.
+func publicShareFilter(c *gin.Context) bson.M {
+	filter := bson.M{"revokedAt": bson.M{"$exists": false}, "suspended": shareOwnerActive}
+	if code := normalizeShareCode(c.Param("token")); code != "" {
+		filter["code"] = code
+	} else {
+		filter["token"] = c.Param("token")
+	}
+	return filter
+}
+
+// shareLinkKey identifies the share a public link names for limits kept per
+// link, so a code's limit doesn't depend on how it was capitalised.
+func shareLinkKey(c *gin.Context) string {
+	if code := normalizeShareCode(c.Param("token")); code != "" {
+		return "code:" + code
+	}
+	return c.Param("token")
+}
+
+// byIPForShareCode keys requests for short code links by IP and skips
+// those using a long token. The much smaller keyspace of codes needs a
+// tighter limit against guessing.
+func byIPForShareCode(c *gin.Context) string {
+	if normalizeShareCode(c.Param("token")) == "" {
+		return ""
+	}
+	return byIP(c)
+}
@@ -0,0 +1,155 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sniffLen is how much of an upload is inspected to detect its type, the
+// most http.DetectContentType looks at.
+const sniffLen = 512
+
+// UnsupportedTypeError rejects an upload whose detected type the allow and
+// block lists don't admit.
+type UnsupportedTypeError struct {
+	ContentType string
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "content type " + e.ContentType + " is not allowed"
+}
+
+// executableSignatures are magic numbers http.DetectContentType doesn't know
+// and reports as application/octet-stream.
+var executableSignatures = []struct {
+	prefix      []byte
+	contentType string
+}{
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xce}, "application/x-mach-binary"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xcf}, "application/x-mach-binary"},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+	{[]byte("#!"), "application/x-sh"},
+}
+
+// activeContentTypes are types a browser may execute. The file extension is
+// never trusted to produce one of them.
+var activeContentTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/javascript":        true,
+	"application/javascript": true,
+	"text/xml":               true,
+	"application/xml":        true,
+}
+
+// extensionRefines lists the sniffed types whose extension based guess is
+// trusted, and the type families that guess may narrow them to. Office
+// documents, for instance, sniff as plain zip archives.
+var extensionRefines = map[string][]string{
+	"application/octet-stream": {"*"},
+	"text/plain":               {"text/*", "application/json", "application/x-yaml", "application/x-sh", "application/x-bat"},
+	"application/zip":          {"application/vnd.openxmlformats-officedocument.*", "application/vnd.oasis.opendocument.*", "application/epub+zip", "application/java-archive"},
+}
+
+// detectContentType works out the type of a file from its first bytes,
+// falling back to its extension for content sniffing can't tell apart.
+// Whatever type the client declared is ignored.
+func detectContentType(head []byte, filename string) string {
+	for _, signature := range executableSignatures {
+		if bytes.HasPrefix(head, signature.prefix) {
+			return signature.contentType
+		}
+	}
+
+	sniffed := http.DetectContentType(head)
+	refinable, ok := extensionRefines[mediaType(sniffed)]
+	if !ok {
+		return sniffed
+	}
+
+	guessed := extensionContentType(filename)
+	if guessed == "" || activeContentTypes[mediaType(guessed)] || !matchesContentType(refinable, guessed) {
+		return sniffed
+	}
+	return guessed
+}
+
+// extensionContentType guesses a type from the file extension.
+func extensionContentType(filename string) string {
+	switch ext := strings.ToLower(path.Ext(filename)); ext {
+	case "":
+		return ""
+	case ".exe", ".dll", ".msi", ".com", ".scr":
+		return "application/x-msdownload"
+	case ".bat", ".cmd":
+		return "application/x-bat"
+	case ".sh":
+		return "application/x-sh"
+	case ".md":
+		return "text/markdown; charset=utf-8"
+	case ".yaml", ".yml":
+		return "application/x-yaml"
+	default:
+		return mime.TypeByExtension(ext)
+	}
+}
+
+// checkContentType enforces AllowedContentTypes and BlockedContentTypes.
+func (cfg *Config) checkContentType(contentType string) error {
+	if matchesContentType(cfg.BlockedContentTypes, contentType) {
+		return &UnsupportedTypeError{ContentType: mediaType(contentType)}
+	}
+	if len(cfg.AllowedContentTypes) > 0 && !matchesContentType(cfg.AllowedContentTypes, contentType) {
+		return &UnsupportedTypeError{ContentType: mediaType(contentType)}
+	}
+	return nil
+}
+
+// matchesContentType reports whether contentType, ignoring parameters, is in
+// patterns. A pattern ending in "*" matches by prefix, and "*" alone matches
+// anything.
+func matchesContentType(patterns []string, contentType string) bool {
+	media := mediaType(contentType)
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == media {
+			return true
+		}
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(media, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType strips parameters such as charset from a content type.
+func mediaType(contentType string) string {
+	media, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(media))
+}
+
+// respondStoreError writes the response for a failed storeUpload: 415 when
+// the content type was refused, 413 when the size limit was hit, 500
+// otherwise.
+func respondStoreError(c *gin.Context, err error) {
+	var unsupported *UnsupportedTypeError
+	if errors.As(err, &unsupported) {
+		respondError(c, newAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, err.Error()).withDetails(gin.H{"contentType": unsupported.ContentType}))
+		return
+	}
+	var tooLarge *UploadTooLargeError
+	if errors.As(err, &tooLarge) {
+		respondUploadTooLarge(c, tooLarge.Limit)
+		return
+	}
+	respondError(c, err)
+}
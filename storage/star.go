@@ -0,0 +1,212 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var StarCollection string = "stars"
+
+// starredItem is an entry of GetStarred: a file or a folder, depending on
+// Kind.
+type starredItem struct {
+	Kind      string         `json:"kind" bson:"kind"`
+	File      *models.File   `json:"file,omitempty" bson:"file,omitempty"`
+	Folder    *models.Folder `json:"folder,omitempty" bson:"folder,omitempty"`
+	StarredAt time.Time      `json:"starredAt" bson:"createdAt"`
+}
+
+// setStar stars or unstars targetId for the caller. Both are idempotent.
+func setStar(ctx context.Context, db *mongo.Database, c *gin.Context, kind string, targetId primitive.ObjectID, starred bool) {
+	collection := db.Collection(StarCollection)
+	filter := bson.M{"userId": currentUserID(c), "targetId": targetId}
+
+	var err error
+	if starred {
+		update := bson.M{"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "kind": kind, "createdAt": time.Now()}}
+		_, err = collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			err = nil
+		}
+	} else {
+		_, err = collection.DeleteOne(ctx, filter)
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"starred": starred})
+}
+
+// StarFile handler stars a file the caller can view.
+func (fc *FileController) StarFile() gin.HandlerFunc {
+	return fc.starFile(true)
+}
+
+// UnstarFile handler
+func (fc *FileController) UnstarFile() gin.HandlerFunc {
+	return fc.starFile(false)
+}
+
+func (fc *FileController) starFile(starred bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		// Unstarring needs no access, so stars on files the caller lost
+		// access to can still be removed.
+		if starred && !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		setStar(ctx, fc.db, c, models.StarFile, file.Id, starred)
+	}
+}
+
+// StarFolder handler stars one of the caller's folders.
+func (fc *FolderController) StarFolder() gin.HandlerFunc {
+	return fc.starFolder(true)
+}
+
+// UnstarFolder handler
+func (fc *FolderController) UnstarFolder() gin.HandlerFunc {
+	return fc.starFolder(false)
+}
+
+func (fc *FolderController) starFolder(starred bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		folder, ok := findFolder(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if starred && !authorizeFolder(c, folder) {
+			return
+		}
+
+		setStar(ctx, fc.db, c, models.StarFolder, folder.Id, starred)
+	}
+}
+
+// GetStarred handler lists the caller's starred files and folders, most
+// recently starred first. Stars on anything trashed, deleted or no longer
+// accessible are left out rather than reported as errors.
+func (fc *FileController) GetStarred() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+		userId := currentUserID(c)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		visibleFile := bson.M{"kind": models.StarFile, "file._id": bson.M{"$exists": true}, "file.deletedAt": bson.M{"$exists": false}, "file.expiresAt": notExpired()}
+		visibleFolder := bson.M{"kind": models.StarFolder, "folder._id": bson.M{"$exists": true}}
+		if !isAdmin(c) {
+			granted, err := grantedFileIds(ctx, db, userId)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			visibleFile["$or"] = bson.A{bson.M{"file.ownerId": userId}, bson.M{"file._id": bson.M{"$in": granted}}}
+			visibleFolder["folder.ownerId"] = userId
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"userId": userId}}},
+			{{Key: "$lookup", Value: bson.M{"from": FileCollection, "localField": "targetId", "foreignField": "_id", "as": "file"}}},
+			{{Key: "$lookup", Value: bson.M{"from": FolderCollection, "localField": "targetId", "foreignField": "_id", "as": "folder"}}},
+			{{Key: "$set", Value: bson.M{
+				"file":   bson.M{"$arrayElemAt": bson.A{"$file", 0}},
+				"folder": bson.M{"$arrayElemAt": bson.A{"$folder", 0}},
+			}}},
+			{{Key: "$match", Value: bson.M{"$or": bson.A{visibleFile, visibleFolder}}}},
+			{{Key: "$project", Value: bson.M{"file.versions": 0, "file.extractedText": 0}}},
+			{{Key: "$facet", Value: bson.M{
+				"items": bson.A{
+					bson.D{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+					bson.D{{Key: "$skip", Value: (page.Page - 1) * page.Limit}},
+					bson.D{{Key: "$limit", Value: page.Limit}},
+				},
+				"total": bson.A{bson.D{{Key: "$count", Value: "n"}}},
+			}}},
+		}
+
+		cursor, err := db.Collection(StarCollection).Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var results []struct {
+			Items []starredItem `bson:"items"`
+			Total []struct {
+				N int64 `bson:"n"`
+			} `bson:"total"`
+		}
+		if err := cursor.All(ctx, &results); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		items := []starredItem{}
+		var total int64
+		if len(results) > 0 {
+			if results[0].Items != nil {
+				items = results[0].Items
+			}
+			if len(results[0].Total) > 0 {
+				total = results[0].Total[0].N
+			}
+		}
+
+		c.JSON(http.StatusOK, page.Result(items, total))
+	}
+}
+
+// markStarred sets Starred on every file the caller has starred.
+func (fc *FileController) markStarred(ctx context.Context, c *gin.Context, files []fileListItem) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(files))
+	for i, file := range files {
+		ids[i] = file.Id
+	}
+
+	stars := fc.db.Collection(StarCollection)
+	starredIds, err := stars.Distinct(ctx, "targetId", bson.M{"userId": currentUserID(c), "targetId": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+
+	starred := make(map[primitive.ObjectID]bool, len(starredIds))
+	for _, id := range starredIds {
+		if objId, ok := id.(primitive.ObjectID); ok {
+			starred[objId] = true
+		}
+	}
+	for i := range files {
+		files[i].Starred = starred[files[i].Id]
+	}
+	return nil
+}
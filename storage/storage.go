@@ -0,0 +1,480 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Storage backends for file content.
+const (
+	StorageGridFS = "gridfs"
+	StorageLocal  = "local"
+	StorageS3     = "s3"
+)
+
+var errBlobNotFound = errors.New("stored content not found")
+var errInvalidBlobKey = errors.New("invalid storage key")
+
+// BlobMeta describes content handed to Storage.Put. Id is the BlobId the
+// content will be referenced by.
+type BlobMeta struct {
+	Id       primitive.ObjectID
+	Filename string
+}
+
+// Storage keeps file content under keys it chooses. Thumbnails and avatars
+// are not file content and always stay in their GridFS buckets.
+type Storage interface {
+	// Put stores everything read from r and returns the key of the
+	// content. Nothing is left behind when it fails.
+	Put(ctx context.Context, r io.Reader, meta BlobMeta) (string, error)
+	// Get opens the content under key, failing with errBlobNotFound if
+	// there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the content under key. Content that is already gone
+	// is not an error.
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	Size(ctx context.Context, key string) (int64, error)
+}
+
+// rangeReader is implemented by backends that can read part of the content
+// without reading what comes before it.
+type rangeReader interface {
+	GetRange(ctx context.Context, key string, start int64, length int64) (io.ReadCloser, error)
+}
+
+// blobCopier is implemented by backends that can copy content without it
+// passing through the server. Copy fails with errors.ErrUnsupported for
+// content it can't copy that way.
+type blobCopier interface {
+	Copy(ctx context.Context, key string, size int64, meta BlobMeta) (string, error)
+}
+
+// blobPresigner is implemented by backends that can hand out a short-lived
+// URL the content is downloaded from directly.
+type blobPresigner interface {
+	PresignGet(key string, query url.Values, ttl time.Duration) (string, error)
+}
+
+// storageChecker is implemented by backends that can tell whether they are
+// reachable, for the readiness probe.
+type storageChecker interface {
+	Check(ctx context.Context) error
+}
+
+// storageBackend returns the backend called name. Content stored before
+// backends were configurable has no name and is in GridFS.
+func storageBackend(db *mongo.Database, cfg *Config, name string) (Storage, error) {
+	switch name {
+	case "", StorageGridFS:
+		bucket, err := fileBucket(db)
+		if err != nil {
+			return nil, err
+		}
+		return &gridfsStorage{bucket: bucket}, nil
+	case StorageLocal:
+		return &localStorage{root: cfg.Storage.LocalDir}, nil
+	case StorageS3:
+		return &s3Storage{cfg: cfg.Storage.S3, client: s3HTTPClient}, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", name)
+}
+
+// prepareStorage creates the root directory of the local backend when new
+// content goes there.
+func prepareStorage(cfg *Config) error {
+	if cfg.Storage.Backend == StorageLocal {
+		return os.MkdirAll(cfg.Storage.LocalDir, 0o750)
+	}
+	return nil
+}
+
+// putBlob stores r in cfg.Storage.Backend under a new BlobId and returns
+// where. While encryption is configured the content is encrypted on the way,
+// under a data key of its own.
+func putBlob(ctx context.Context, db *mongo.Database, cfg *Config, r io.Reader, filename string) (models.BlobRef, error) {
+	storage, err := storageBackend(db, cfg, cfg.Storage.Backend)
+	if err != nil {
+		return models.BlobRef{}, err
+	}
+
+	var sealer *sealingReader
+	if keys := cfg.Storage.Encryption.keyWrapper(); keys != nil {
+		if sealer, err = newSealingReader(ctx, keys, r); err != nil {
+			return models.BlobRef{}, err
+		}
+		r = sealer
+	}
+
+	meta := BlobMeta{Id: primitive.NewObjectID(), Filename: filename}
+	key, err := storage.Put(ctx, r, meta)
+	if err != nil {
+		return models.BlobRef{}, err
+	}
+	ref := models.BlobRef{BlobId: meta.Id, StorageBackend: cfg.Storage.Backend, StorageKey: key}
+
+	if sealer != nil {
+		sealer.key.Id = meta.Id
+		if err := saveBlobKey(ctx, db, sealer.key); err != nil {
+			_ = storage.Delete(context.WithoutCancel(ctx), key)
+			return models.BlobRef{}, err
+		}
+		ref.Encrypted = true
+	}
+	return ref, nil
+}
+
+// openBlob opens the content ref points at, decrypting it if need be.
+func openBlob(ctx context.Context, db *mongo.Database, cfg *Config, ref models.BlobRef) (io.ReadCloser, error) {
+	storage, err := storageBackend(db, cfg, ref.StorageBackend)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Encrypted {
+		return openSealedBlob(ctx, db, cfg.Storage.Encryption.keyWrapper(), storage, ref, 0, -1)
+	}
+	return storage.Get(ctx, blobKey(ref))
+}
+
+// openBlobRange opens length bytes of the content ref points at, from offset
+// start. Backends that can't read a range directly skip to it.
+func openBlobRange(ctx context.Context, db *mongo.Database, cfg *Config, ref models.BlobRef, start int64, length int64) (io.ReadCloser, error) {
+	storage, err := storageBackend(db, cfg, ref.StorageBackend)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Encrypted {
+		return openSealedBlob(ctx, db, cfg.Storage.Encryption.keyWrapper(), storage, ref, start, length)
+	}
+	if ranged, ok := storage.(rangeReader); ok {
+		return ranged.GetRange(ctx, blobKey(ref), start, length)
+	}
+
+	download, err := storage.Get(ctx, blobKey(ref))
+	if err != nil {
+		return nil, err
+	}
+	if err := skipBytes(download, start); err != nil {
+		download.Close()
+		return nil, err
+	}
+	return download, nil
+}
+
+// copyBlob copies the size bytes ref points at to a new blob without
+// streaming them through the server, when ref is in cfg.Storage.Backend and
+// it can. Encrypted content is copied as it is, with a copy of its data key;
+// plain content has to be streamed to be encrypted while encryption is
+// configured. It reports false when the content has to be streamed instead.
+func copyBlob(ctx context.Context, db *mongo.Database, cfg *Config, ref models.BlobRef, size int64) (models.BlobRef, bool, error) {
+	if backendOf(ref) != cfg.Storage.Backend || (!ref.Encrypted && cfg.Storage.Encryption.keyWrapper() != nil) {
+		return models.BlobRef{}, false, nil
+	}
+	storage, err := storageBackend(db, cfg, cfg.Storage.Backend)
+	if err != nil {
+		return models.BlobRef{}, false, err
+	}
+	copier, ok := storage.(blobCopier)
+	if !ok {
+		return models.BlobRef{}, false, nil
+	}
+
+	if ref.Encrypted {
+		if size, err = storage.Size(ctx, blobKey(ref)); err != nil {
+			return models.BlobRef{}, false, err
+		}
+	}
+
+	meta := BlobMeta{Id: primitive.NewObjectID()}
+	key, err := copier.Copy(ctx, blobKey(ref), size, meta)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return models.BlobRef{}, false, nil
+	}
+	if err != nil {
+		return models.BlobRef{}, false, err
+	}
+	copied := models.BlobRef{BlobId: meta.Id, StorageBackend: cfg.Storage.Backend, StorageKey: key, Encrypted: ref.Encrypted}
+
+	if ref.Encrypted {
+		if err := copyBlobKey(ctx, db, ref, copied); err != nil {
+			_ = storage.Delete(context.WithoutCancel(ctx), key)
+			return models.BlobRef{}, false, err
+		}
+	}
+	return copied, true, nil
+}
+
+// presignBlob returns a URL the content ref points at can be downloaded from
+// directly until ttl has passed, served with the given Content-Disposition.
+// It reports false when ref's backend can't hand out such URLs or the
+// content is encrypted.
+func presignBlob(db *mongo.Database, cfg *Config, ref models.BlobRef, disposition string, ttl time.Duration) (string, bool, error) {
+	if ref.Encrypted {
+		return "", false, nil
+	}
+	storage, err := storageBackend(db, cfg, ref.StorageBackend)
+	if err != nil {
+		return "", false, err
+	}
+	presigner, ok := storage.(blobPresigner)
+	if !ok {
+		return "", false, nil
+	}
+
+	signed, err := presigner.PresignGet(blobKey(ref), url.Values{"response-content-disposition": {disposition}}, ttl)
+	if err != nil {
+		return "", false, err
+	}
+	return signed, true, nil
+}
+
+// removeBlob deletes the content ref points at, and its data key once the
+// content is gone.
+func removeBlob(ctx context.Context, db *mongo.Database, cfg *Config, ref models.BlobRef) error {
+	storage, err := storageBackend(db, cfg, ref.StorageBackend)
+	if err != nil {
+		return err
+	}
+	if err := storage.Delete(ctx, blobKey(ref)); err != nil {
+		return err
+	}
+	if ref.Encrypted {
+		_, err = db.Collection(BlobKeyCollection).DeleteOne(ctx, bson.M{"_id": ref.BlobId})
+	}
+	return err
+}
+
+// backendOf names the backend holding ref's content.
+func backendOf(ref models.BlobRef) string {
+	if ref.StorageBackend == "" {
+		return StorageGridFS
+	}
+	return ref.StorageBackend
+}
+
+// blobKey is the key of ref's content in its backend.
+func blobKey(ref models.BlobRef) string {
+	if ref.StorageKey != "" {
+		return ref.StorageKey
+	}
+	return ref.BlobId.Hex()
+}
+
+// skipBytes discards the first n bytes of r, seeking past them when r can.
+func skipBytes(r io.Reader, n int64) error {
+	switch r := r.(type) {
+	case io.Seeker:
+		_, err := r.Seek(n, io.SeekCurrent)
+		return err
+	case *gridfs.DownloadStream:
+		_, err := r.Skip(n)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+// gridfsStorage keeps content in the file bucket, keyed by the hex BlobId.
+type gridfsStorage struct {
+	bucket *gridfs.Bucket
+}
+
+func (s *gridfsStorage) Put(ctx context.Context, r io.Reader, meta BlobMeta) (string, error) {
+	start := time.Now()
+	upload, err := s.bucket.OpenUploadStreamWithID(meta.Id, meta.Filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(upload, r); err != nil {
+		_ = upload.Abort()
+		return "", err
+	}
+	if err := upload.Close(); err != nil {
+		return "", err
+	}
+	gridfsDuration.since(start, "upload")
+	return meta.Id.Hex(), nil
+}
+
+func (s *gridfsStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return nil, errInvalidBlobKey
+	}
+
+	start := time.Now()
+	download, err := s.bucket.OpenDownloadStream(id)
+	gridfsDuration.since(start, "open_download")
+	if err == gridfs.ErrFileNotFound {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return download, nil
+}
+
+func (s *gridfsStorage) Delete(ctx context.Context, key string) error {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return errInvalidBlobKey
+	}
+
+	start := time.Now()
+	err = s.bucket.DeleteContext(ctx, id)
+	gridfsDuration.since(start, "delete")
+	if err == gridfs.ErrFileNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *gridfsStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Size(ctx, key)
+	if err == errBlobNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *gridfsStorage) Size(ctx context.Context, key string) (int64, error) {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return 0, errInvalidBlobKey
+	}
+
+	var doc struct {
+		Length int64 `bson:"length"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"length": 1})
+	err = s.bucket.GetFilesCollection().FindOne(ctx, bson.M{"_id": id}, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return 0, errBlobNotFound
+	}
+	return doc.Length, err
+}
+
+// localStorage keeps content in files below root. The two levels of
+// directories named by the leading hex digits of the SHA-256 of the key
+// spread files evenly, whatever the keys look like.
+type localStorage struct {
+	root string
+}
+
+// path returns where the content under key is kept.
+func (s *localStorage) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\.`) {
+		return "", errInvalidBlobKey
+	}
+	sum := sha256.Sum256([]byte(key))
+	shard := hex.EncodeToString(sum[:2])
+	return filepath.Join(s.root, shard[:2], shard[2:], key), nil
+}
+
+// Put writes a temporary file next to the final one and renames it into
+// place once complete, so readers never see partial content.
+func (s *localStorage) Put(ctx context.Context, r io.Reader, meta BlobMeta) (string, error) {
+	key := meta.Id.Hex()
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+key+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return key, nil
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Size(ctx, key)
+	if err == errBlobNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *localStorage) Size(ctx context.Context, key string) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, errBlobNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Check reports whether the root directory is there.
+func (s *localStorage) Check(ctx context.Context) error {
+	_, err := os.Stat(s.root)
+	return err
+}
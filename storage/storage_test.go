@@ -0,0 +1,210 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// failingReader gives n bytes of content and then fails.
+type failingReader struct {
+	n int
+}
+
+var errReadFailed = errors.New("client went away")
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, errReadFailed
+	}
+	n := min(len(p), r.n)
+	clear(p[:n])
+	r.n -= n
+	return n, nil
+}
+
+// testStorage is the behavior every Storage has to agree on, run against a
+// fresh backend from newStorage in each subtest.
+func testStorage(t *testing.T, newStorage func(t *testing.T) Storage) {
+	ctx := context.Background()
+	put := func(t *testing.T, s Storage, content []byte) string {
+		t.Helper()
+		key, err := s.Put(ctx, bytes.NewReader(content), BlobMeta{Id: primitive.NewObjectID(), Filename: "report.pdf"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	read := func(t *testing.T, r io.ReadCloser, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		s := newStorage(t)
+		content := bytes.Repeat([]byte("0123456789"), 100_000)
+		key := put(t, s, content)
+
+		r, err := s.Get(ctx, key)
+		if got := read(t, r, err); !bytes.Equal(got, content) {
+			t.Errorf("Get returned %d bytes, want the %d stored", len(got), len(content))
+		}
+		if size, err := s.Size(ctx, key); err != nil || size != int64(len(content)) {
+			t.Errorf("Size = %d, %v; want %d", size, err, len(content))
+		}
+		if ok, err := s.Exists(ctx, key); err != nil || !ok {
+			t.Errorf("Exists = %v, %v; want true", ok, err)
+		}
+		if ranged, ok := s.(rangeReader); ok {
+			r, err := ranged.GetRange(ctx, key, 500_005, 20)
+			if got := read(t, r, err); !bytes.Equal(got, content[500_005:500_025]) {
+				t.Errorf("GetRange = %q, want bytes 500005-500024", got)
+			}
+		}
+	})
+
+	t.Run("empty content", func(t *testing.T) {
+		s := newStorage(t)
+		key := put(t, s, nil)
+		r, err := s.Get(ctx, key)
+		if got := read(t, r, err); len(got) != 0 {
+			t.Errorf("Get returned %d bytes, want none", len(got))
+		}
+		if size, err := s.Size(ctx, key); err != nil || size != 0 {
+			t.Errorf("Size = %d, %v; want 0", size, err)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		s := newStorage(t)
+		key := primitive.NewObjectID().Hex()
+		if _, err := s.Get(ctx, key); err != errBlobNotFound {
+			t.Errorf("Get = %v, want errBlobNotFound", err)
+		}
+		if _, err := s.Size(ctx, key); err != errBlobNotFound {
+			t.Errorf("Size = %v, want errBlobNotFound", err)
+		}
+		if ok, err := s.Exists(ctx, key); err != nil || ok {
+			t.Errorf("Exists = %v, %v; want false", ok, err)
+		}
+		if err := s.Delete(ctx, key); err != nil {
+			t.Errorf("Delete = %v, want content that is already gone ignored", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := newStorage(t)
+		key := put(t, s, []byte("short-lived"))
+		if err := s.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := s.Exists(ctx, key); err != nil || ok {
+			t.Errorf("Exists = %v, %v after Delete; want false", ok, err)
+		}
+		if _, err := s.Get(ctx, key); err != errBlobNotFound {
+			t.Errorf("Get = %v after Delete, want errBlobNotFound", err)
+		}
+	})
+
+	t.Run("failed put", func(t *testing.T) {
+		s := newStorage(t)
+		id := primitive.NewObjectID()
+		if _, err := s.Put(ctx, &failingReader{n: 300 << 10}, BlobMeta{Id: id, Filename: "partial.bin"}); !errors.Is(err, errReadFailed) {
+			t.Fatalf("Put = %v, want the read error", err)
+		}
+		// Backends key new content by its BlobId, so that is where a
+		// leftover would be.
+		if ok, err := s.Exists(ctx, id.Hex()); err != nil || ok {
+			t.Errorf("Exists = %v, %v after a failed Put; want nothing left behind", ok, err)
+		}
+	})
+}
+
+func TestLocalStorage(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage { return &localStorage{root: t.TempDir()} })
+
+	t.Run("invalid key", func(t *testing.T) {
+		s := &localStorage{root: t.TempDir()}
+		if _, err := s.Get(context.Background(), "../../etc/passwd"); err != errInvalidBlobKey {
+			t.Errorf("Get = %v, want errInvalidBlobKey", err)
+		}
+		if err := s.Delete(context.Background(), "../../etc/passwd"); err != errInvalidBlobKey {
+			t.Errorf("Delete = %v, want errInvalidBlobKey", err)
+		}
+	})
+
+	t.Run("layout", func(t *testing.T) {
+		s := &localStorage{root: t.TempDir()}
+		key, err := s.Put(context.Background(), &failingReader{n: 10}, BlobMeta{Id: primitive.NewObjectID()})
+		if err == nil {
+			t.Fatalf("Put = %q, want the read error", key)
+		}
+		key, err = s.Put(context.Background(), strings.NewReader("content"), BlobMeta{Id: primitive.NewObjectID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var files []string
+		filepath.WalkDir(s.root, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				rel, _ := filepath.Rel(s.root, path)
+				files = append(files, rel)
+			}
+			return err
+		})
+		// Two levels of two hex digits, and no temporary file from the
+		// failed write.
+		if len(files) != 1 {
+			t.Fatalf("files = %v, want the stored one only", files)
+		}
+		parts := strings.Split(filepath.ToSlash(files[0]), "/")
+		if len(parts) != 3 || len(parts[0]) != 2 || len(parts[1]) != 2 || parts[2] != key {
+			t.Errorf("stored at %s, want <2 hex>/<2 hex>/%s", files[0], key)
+		}
+	})
+}
+
+// TestGridFSStorage runs against the server at $MONGODB_TEST_URI, which
+// needs to be no more than a standalone `docker run -d -p 27017:27017
+// mongo:7`.
+func TestGridFSStorage(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	testStorage(t, func(t *testing.T) Storage {
+		db := client.Database("storage_test_" + primitive.NewObjectID().Hex())
+		t.Cleanup(func() { db.Drop(context.Background()) })
+		bucket, err := fileBucket(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &gridfsStorage{bucket: bucket}
+	})
+}
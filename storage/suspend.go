@@ -0,0 +1,228 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrorCodeAccountSuspended is the "code" of the 403 sent to suspended
+// accounts.
+const ErrorCodeAccountSuspended = "account_suspended"
+
+// shareOwnerActive matches shares whose owner is not suspended when used as
+// the "suspended" condition of a query.
+var shareOwnerActive = bson.M{"$ne": true}
+
+// respondAccountDeleted writes the 401 for an account that no longer exists
+// or is deleted and waiting to be purged.
+func respondAccountDeleted(c *gin.Context) {
+	respondError(c, unauthorized(errAccountDeleted.Error()))
+}
+
+// respondSuspended writes the 403 for a suspended account.
+func respondSuspended(c *gin.Context) {
+	respondError(c, newAPIError(http.StatusForbidden, ErrorCodeAccountSuspended, errAccountSuspended.Error()))
+}
+
+var errAccountDeleted = errors.New("user no longer exists")
+var errAccountSuspended = errors.New("account suspended")
+
+// requireActiveAccount rejects the caller when their account was suspended
+// or deleted after their access token was issued.
+func requireActiveAccount(c *gin.Context, db *mongo.Database, userId primitive.ObjectID) bool {
+	switch err := checkActiveAccount(c.Request.Context(), db, userId); err {
+	case nil:
+		return true
+	case errAccountDeleted:
+		respondAccountDeleted(c)
+	case errAccountSuspended:
+		respondSuspended(c)
+	default:
+		respondError(c, err)
+	}
+	return false
+}
+
+// checkActiveAccount fails with errAccountDeleted or errAccountSuspended
+// unless the account userId is usable.
+func checkActiveAccount(ctx context.Context, db *mongo.Database, userId primitive.ObjectID) error {
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"status": 1, "deletedAt": 1})
+	if err := db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": userId}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errAccountDeleted
+		}
+		return err
+	}
+
+	if user.IsDeleted() {
+		return errAccountDeleted
+	}
+	if user.Status == models.UserStatusSuspended {
+		return errAccountSuspended
+	}
+	return nil
+}
+
+// SuspendUser handler blocks an account: its sessions are revoked, its tokens
+// and API keys are refused and its share links stop working until it is
+// activated again.
+func (ac *AdminController) SuspendUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := ac.db
+		collection := db.Collection(UserCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		if objId == currentUserID(c) {
+			respondError(c, badRequest("you cannot suspend yourself"))
+			return
+		}
+
+		var user models.User
+		if err := collection.FindOne(ctx, bson.M{"_id": objId}).Decode(&user); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("User not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		if user.Role == models.RoleAdmin {
+			if err := suspendAdmin(ctx, collection, user); err != nil {
+				if err == errLastAdmin {
+					respondError(c, invalidRequest(err))
+					return
+				}
+				respondError(c, err)
+				return
+			}
+		}
+
+		// The account, its sessions and its share links change together, so
+		// no share stays reachable for an account that is suspended.
+		err = withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+			update := bson.M{"$set": bson.M{"status": models.UserStatusSuspended}}
+			if _, err := collection.UpdateOne(ctx, bson.M{"_id": objId}, update); err != nil {
+				return err
+			}
+
+			if err := revokeUserSessions(ctx, ac.db, objId); err != nil {
+				return err
+			}
+
+			_, err := db.Collection(ShareCollection).UpdateMany(ctx, bson.M{"ownerId": objId}, bson.M{"$set": bson.M{"suspended": true}})
+			return err
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		events.disconnect(objId, primitive.NilObjectID)
+
+		audit(c, AuditUserSuspended, auditTargetUser, objId, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "User suspended successfully"})
+	}
+}
+
+var errLastAdmin = errors.New("cannot suspend the last active admin")
+
+// activeAdmins matches the admins other than id that can still sign in.
+func activeAdmins(id primitive.ObjectID) bson.M {
+	return bson.M{
+		"_id":       bson.M{"$ne": id},
+		"role":      models.RoleAdmin,
+		"status":    bson.M{"$ne": models.UserStatusSuspended},
+		"deletedAt": bson.M{"$exists": false},
+	}
+}
+
+// suspendAdmin suspends the admin account user unless that would leave no
+// active admin, failing with errLastAdmin. The account is suspended before
+// the others are counted and put back when none is left, so of two admins
+// suspended at once the later count sees both and one is put back: there is
+// never a moment where both checks pass.
+func suspendAdmin(ctx context.Context, collection *mongo.Collection, user models.User) error {
+	notSuspended := bson.M{"_id": user.Id, "status": bson.M{"$ne": models.UserStatusSuspended}}
+	result, err := collection.UpdateOne(ctx, notSuspended, bson.M{"$set": bson.M{"status": models.UserStatusSuspended}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	others, err := collection.CountDocuments(ctx, activeAdmins(user.Id))
+	if err == nil && others > 0 {
+		return nil
+	}
+
+	restore := bson.M{"$unset": bson.M{"status": ""}}
+	if user.Status != "" {
+		restore = bson.M{"$set": bson.M{"status": user.Status}}
+	}
+	if _, restoreErr := collection.UpdateOne(ctx, bson.M{"_id": user.Id}, restore); restoreErr != nil {
+		return restoreErr
+	}
+	if err != nil {
+		return err
+	}
+	return errLastAdmin
+}
+
+// ActivateUser handler reinstates a suspended account and its share links.
+// Sessions revoked by the suspension stay revoked.
+func (ac *AdminController) ActivateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := ac.db
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		err = withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+			update := bson.M{"$set": bson.M{"status": models.UserStatusActive}}
+			result, err := db.Collection(UserCollection).UpdateOne(ctx, bson.M{"_id": objId}, update)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return mongo.ErrNoDocuments
+			}
+
+			_, err = db.Collection(ShareCollection).UpdateMany(ctx, bson.M{"ownerId": objId}, bson.M{"$unset": bson.M{"suspended": ""}})
+			return err
+		})
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("User not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditUserActivated, auditTargetUser, objId, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "User activated successfully"})
+	}
+}
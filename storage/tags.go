@@ -0,0 +1,216 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxTagLength is the longest tag accepted, in characters.
+const MaxTagLength = 32
+
+// MaxTagsPerFile caps the tags a single file can carry.
+const MaxTagsPerFile = 20
+
+const (
+	tagModeAll = "all"
+	tagModeAny = "any"
+)
+
+var errTooManyTags = fmt.Errorf("a file can have at most %d tags", MaxTagsPerFile)
+
+type addTagsRequest struct {
+	Tags []string `json:"tags" binding:"required,min=1,max=20"`
+}
+
+// normalizeTag lowercases and trims tag, rejecting empty or overlong tags and
+// any characters other than letters, digits, '-', '_' and '.'.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", errors.New("tags must not be empty")
+	}
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		return "", fmt.Errorf("tags must be at most %d characters", MaxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return "", fmt.Errorf("tag %q may only contain letters, digits, '-', '_' and '.'", tag)
+		}
+	}
+	return tag, nil
+}
+
+// tagFilter adds the ?tag= and ?tagMode= params of GetFiles to filter. With
+// tagMode=all (the default) files need every tag, with any at least one.
+func tagFilter(c *gin.Context, filter bson.M) error {
+	raw := c.QueryArray("tag")
+	if len(raw) == 0 {
+		return nil
+	}
+
+	tags := make([]string, len(raw))
+	for i, tag := range raw {
+		normalized, err := normalizeTag(tag)
+		if err != nil {
+			return err
+		}
+		tags[i] = normalized
+	}
+
+	switch mode := c.DefaultQuery("tagMode", tagModeAll); mode {
+	case tagModeAll:
+		filter["tags"] = bson.M{"$all": tags}
+	case tagModeAny:
+		filter["tags"] = bson.M{"$in": tags}
+	default:
+		return fmt.Errorf("tagMode must be %q or %q", tagModeAll, tagModeAny)
+	}
+	return nil
+}
+
+// AddTags handler adds tags to a file. Tags already present are ignored.
+func (fc *FileController) AddTags() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FileCollection)
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessEditor) {
+			return
+		}
+
+		var req addTagsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		tags := make([]string, len(req.Tags))
+		for i, tag := range req.Tags {
+			normalized, err := normalizeTag(tag)
+			if err != nil {
+				respondError(c, invalidRequest(err))
+				return
+			}
+			tags[i] = normalized
+		}
+
+		// The size check runs in the filter so concurrent adds can't push a
+		// file past the limit.
+		filter := bson.M{
+			"_id":       file.Id,
+			"deletedAt": notTrashed,
+			"expiresAt": notExpired(),
+			"$expr": bson.M{"$lte": bson.A{
+				bson.M{"$size": bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, tags}}},
+				MaxTagsPerFile,
+			}},
+		}
+		update := bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated models.File
+		if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, invalidRequest(errTooManyTags))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// RemoveTag handler removes one tag from a file.
+func (fc *FileController) RemoveTag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FileCollection)
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessEditor) {
+			return
+		}
+
+		tag, err := normalizeTag(c.Param("tag"))
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter := bson.M{"_id": file.Id, "deletedAt": notTrashed, "tags": tag}
+		update := bson.M{"$pull": bson.M{"tags": tag}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated models.File
+		if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Tag not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+type tagUsage struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// GetTags handler lists the distinct tags on the caller's live files with
+// the number of files carrying each, most used first.
+func (fc *FileController) GetTags() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FileCollection)
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"ownerId": currentUserID(c), "deletedAt": notTrashed, "expiresAt": notExpired(), "tags.0": bson.M{"$exists": true}}}},
+			{{Key: "$unwind", Value: "$tags"}},
+			{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		}
+
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		tags := []tagUsage{}
+		if err := cursor.All(ctx, &tags); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, tags)
+	}
+}
@@ -0,0 +1,205 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// throttleChunk caps the bytes sent per wait, so a throttled download goes
+// out steadily instead of in one burst a second.
+const throttleChunk = 32 << 10
+
+// downloadRate returns the download rate in effect for an account with role
+// and its own override, if any: the override, the role's entry in
+// RoleDownloadRates, or DownloadRate.
+func (cfg *Config) downloadRate(role string, override *int64) int64 {
+	if override != nil {
+		return *override
+	}
+	if rate := cfg.RoleDownloadRates[role]; rate > 0 {
+		return rate
+	}
+	return cfg.DownloadRate
+}
+
+// clock is the time bandwidth and rate limit buckets go by, so tests can
+// run a download, or wait for a refill, without waiting for it.
+type clock interface {
+	Now() time.Time
+	// Sleep waits for d, or fails with ctx's error when ctx is done first.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bandwidthBucket is a token bucket shared by the downloads of one user or
+// share link. Tokens are bytes; it holds at most a second's worth, and goes
+// into debt when several downloads take at once, making each wait its turn.
+type bandwidthBucket struct {
+	mu     sync.Mutex
+	clock  clock
+	rate   int64
+	tokens float64
+	last   time.Time
+	users  int
+}
+
+// wait blocks until n more bytes may be sent, or fails with ctx's error when
+// the download is abandoned first.
+func (b *bandwidthBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := b.clock.Now()
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if burst := float64(b.rate); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	if err := b.clock.Sleep(ctx, delay); err != nil {
+		// The bytes won't be sent, so the others needn't wait for them.
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// bandwidthBuckets holds the buckets of the downloads in progress, by key,
+// and the clock new buckets use. A bucket is dropped once its last download
+// finishes.
+var bandwidthBuckets = struct {
+	sync.Mutex
+	m     map[string]*bandwidthBucket
+	clock clock
+}{m: map[string]*bandwidthBucket{}, clock: systemClock{}}
+
+// acquireBucket returns the bucket for key, set to rate, and counts the
+// caller among its downloads until releaseBucket.
+func acquireBucket(key string, rate int64) *bandwidthBucket {
+	bandwidthBuckets.Lock()
+	defer bandwidthBuckets.Unlock()
+
+	bucket, ok := bandwidthBuckets.m[key]
+	if !ok {
+		bucket = &bandwidthBucket{clock: bandwidthBuckets.clock, tokens: float64(rate), last: bandwidthBuckets.clock.Now()}
+		bandwidthBuckets.m[key] = bucket
+	}
+	bucket.users++
+
+	bucket.mu.Lock()
+	bucket.rate = rate
+	bucket.mu.Unlock()
+	return bucket
+}
+
+// releaseBucket ends a download counted by acquireBucket.
+func releaseBucket(key string, bucket *bandwidthBucket) {
+	bandwidthBuckets.Lock()
+	defer bandwidthBuckets.Unlock()
+
+	bucket.users--
+	if bucket.users == 0 && bandwidthBuckets.m[key] == bucket {
+		delete(bandwidthBuckets.m, key)
+	}
+}
+
+// throttledWriter writes to w no faster than its bucket allows, in chunks
+// of at most throttleChunk and one second's worth at rate.
+type throttledWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	bucket *bandwidthBucket
+	rate   int64
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := len(p)
+		if chunk > throttleChunk {
+			chunk = throttleChunk
+		}
+		if int64(chunk) > t.rate {
+			chunk = int(t.rate)
+		}
+		if err := t.bucket.wait(t.ctx, chunk); err != nil {
+			return written, err
+		}
+		n, err := t.w.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
+
+// throttleDownload returns the writer a download should be sent through:
+// c.Writer held to the caller's download rate, or for anonymous share link
+// downloads to the link's. The returned func must be called once the
+// download is done. Only the bytes actually written count, so ranges and
+// aborted downloads use up no more than they sent.
+func throttleDownload(c *gin.Context, db *mongo.Database, cfg *Config) (io.Writer, func()) {
+	var key string
+	var rate int64
+	if userId := currentUserID(c); !userId.IsZero() {
+		key, rate = "user:"+userId.Hex(), userDownloadRate(c.Request.Context(), db, cfg, userId, c.GetString("role"))
+	} else if c.Param("token") != "" {
+		key, rate = "share:"+shareLinkKey(c), cfg.ShareDownloadRate
+	}
+	if rate <= 0 {
+		return c.Writer, func() {}
+	}
+
+	bucket := acquireBucket(key, rate)
+	w := &throttledWriter{ctx: c.Request.Context(), w: c.Writer, bucket: bucket, rate: rate}
+	return w, func() { releaseBucket(key, bucket) }
+}
+
+// userDownloadRate returns the download rate of the user with userId and
+// role. When the account can't be read, the role's rate applies, so a
+// lookup failure doesn't fail the download.
+func userDownloadRate(ctx context.Context, db *mongo.Database, cfg *Config, userId primitive.ObjectID, role string) int64 {
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"downloadRate": 1})
+	if err := db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": userId}, opts).Decode(&user); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("reading download rate of user %s failed: %v", userId.Hex(), err)
+		}
+		return cfg.downloadRate(role, nil)
+	}
+	return cfg.downloadRate(role, user.DownloadRate)
+}
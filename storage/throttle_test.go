@@ -0,0 +1,181 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeClock sleeps by moving its time forward, so a throttled download
+// finishes at once and its duration can be read off the clock.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	return nil
+}
+
+// since is how far the clock has moved since start, to the millisecond.
+func (f *fakeClock) since(start time.Time) time.Duration {
+	return f.Now().Sub(start).Round(time.Millisecond)
+}
+
+// useFakeClock makes new buckets use a fake clock for the rest of the test.
+func useFakeClock(t *testing.T) *fakeClock {
+	fake := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	bandwidthBuckets.Lock()
+	saved := bandwidthBuckets.clock
+	bandwidthBuckets.clock = fake
+	bandwidthBuckets.Unlock()
+	t.Cleanup(func() {
+		bandwidthBuckets.Lock()
+		bandwidthBuckets.clock = saved
+		bandwidthBuckets.Unlock()
+	})
+	return fake
+}
+
+// download sends n bytes through w and returns how long the clock says it
+// took.
+func download(t *testing.T, fake *fakeClock, w io.Writer, n int) time.Duration {
+	t.Helper()
+	start := fake.Now()
+	if written, err := w.Write(make([]byte, n)); err != nil || written != n {
+		t.Fatalf("wrote %d of %d bytes: %v", written, n, err)
+	}
+	return fake.since(start)
+}
+
+func TestThrottlePerUser(t *testing.T) {
+	fake := useFakeClock(t)
+	const rate = 1000
+
+	first := acquireBucket("user:a", rate)
+	defer releaseBucket("user:a", first)
+	if got := download(t, fake, &throttledWriter{ctx: context.Background(), w: io.Discard, bucket: first, rate: rate}, 3*rate); got != 2*time.Second {
+		t.Errorf("3s worth of bytes took %v, want 2s after the one-second burst", got)
+	}
+
+	// A second download of the same user shares the spent bucket; another
+	// user's has its own.
+	second := acquireBucket("user:a", rate)
+	defer releaseBucket("user:a", second)
+	if got := download(t, fake, &throttledWriter{ctx: context.Background(), w: io.Discard, bucket: second, rate: rate}, rate); got != time.Second {
+		t.Errorf("same user's second download took %v, want 1s", got)
+	}
+	other := acquireBucket("user:b", rate)
+	defer releaseBucket("user:b", other)
+	if got := download(t, fake, &throttledWriter{ctx: context.Background(), w: io.Discard, bucket: other, rate: rate}, rate); got != 0 {
+		t.Errorf("another user's download took %v, want no wait", got)
+	}
+}
+
+func TestThrottlePerShare(t *testing.T) {
+	fake := useFakeClock(t)
+	cfg := testConfig()
+	cfg.ShareDownloadRate = 2000
+
+	shareDownload := func(token string) (io.Writer, func()) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/s/"+token, nil)
+		c.Params = gin.Params{{Key: "token", Value: token}}
+		return throttleDownload(c, nil, cfg)
+	}
+
+	w, done := shareDownload("abcdefghijklmnop")
+	defer done()
+	if got := download(t, fake, w, 5000); got != 1500*time.Millisecond {
+		t.Errorf("share download took %v, want 1.5s at the link's rate", got)
+	}
+
+	same, doneSame := shareDownload("abcdefghijklmnop")
+	defer doneSame()
+	if got := download(t, fake, same, 1000); got != 500*time.Millisecond {
+		t.Errorf("second download of the link took %v, want 0.5s", got)
+	}
+	other, doneOther := shareDownload("qrstuvwxyz012345")
+	defer doneOther()
+	if got := download(t, fake, other, 1000); got != 0 {
+		t.Errorf("another link's download took %v, want no wait", got)
+	}
+}
+
+func TestThrottleReleasesBuckets(t *testing.T) {
+	useFakeClock(t)
+	bucket := acquireBucket("user:c", 1000)
+	again := acquireBucket("user:c", 1000)
+	if again != bucket {
+		t.Fatal("concurrent downloads got different buckets")
+	}
+	releaseBucket("user:c", bucket)
+	releaseBucket("user:c", again)
+
+	bandwidthBuckets.Lock()
+	_, kept := bandwidthBuckets.m["user:c"]
+	bandwidthBuckets.Unlock()
+	if kept {
+		t.Error("bucket outlived its downloads")
+	}
+}
+
+func TestThrottleAbandonedDownloadRefunds(t *testing.T) {
+	fake := useFakeClock(t)
+	bucket := acquireBucket("user:d", 1000)
+	defer releaseBucket("user:d", bucket)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bucket.wait(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := bucket.wait(ctx, 1000); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	// The abandoned bytes were never sent, so the next wait is for its own
+	// bytes only.
+	start := fake.Now()
+	if err := bucket.wait(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.since(start); got != time.Second {
+		t.Errorf("wait after the abandoned download took %v, want 1s", got)
+	}
+}
+
+func TestDownloadRate(t *testing.T) {
+	cfg := testConfig()
+	cfg.DownloadRate = 100
+	cfg.RoleDownloadRates = map[string]int64{models.RoleAdmin: 500}
+	override := int64(50)
+
+	if got := cfg.downloadRate(models.RoleUser, nil); got != 100 {
+		t.Errorf("user rate = %d, want the default", got)
+	}
+	if got := cfg.downloadRate(models.RoleAdmin, nil); got != 500 {
+		t.Errorf("admin rate = %d, want the role's", got)
+	}
+	if got := cfg.downloadRate(models.RoleAdmin, &override); got != 50 {
+		t.Errorf("rate with override = %d, want the override", got)
+	}
+}
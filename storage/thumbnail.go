@@ -0,0 +1,264 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ThumbnailBucket is the GridFS bucket holding generated thumbnails.
+var ThumbnailBucket string = "thumbnails"
+
+// thumbnailSizes maps the ?size= names to the bounding box edge in pixels.
+var thumbnailSizes = map[string]int{
+	"small":  128,
+	"medium": 512,
+}
+
+// thumbnailQueueSize bounds the pending jobs. When it is full new jobs are
+// dropped; requesting the thumbnail queues it again.
+const thumbnailQueueSize = 256
+
+// thumbnailJob asks the worker for the thumbnails of one file's content.
+type thumbnailJob struct {
+	fileId  primitive.ObjectID
+	ownerId primitive.ObjectID
+	blob    models.BlobRef
+}
+
+var thumbnailJobs = make(chan thumbnailJob, thumbnailQueueSize)
+
+// thumbnailMeta is the GridFS metadata of a thumbnail. A thumbnail belongs to
+// one version of the file's content, so re-uploads and version restores
+// make it stale. Failed marks content that could not be thumbnailed.
+type thumbnailMeta struct {
+	FileId      primitive.ObjectID `bson:"fileId"`
+	Size        string             `bson:"size"`
+	SourceId    primitive.ObjectID `bson:"sourceId"`
+	ContentType string             `bson:"contentType,omitempty"`
+	Failed      bool               `bson:"failed,omitempty"`
+}
+
+type thumbnailDoc struct {
+	Id         primitive.ObjectID `bson:"_id"`
+	Length     int64              `bson:"length"`
+	UploadDate time.Time          `bson:"uploadDate"`
+	Metadata   thumbnailMeta      `bson:"metadata"`
+}
+
+func thumbnailBucket(db *mongo.Database) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(ThumbnailBucket))
+}
+
+// enqueueThumbnails queues thumbnail generation for image files without
+// waiting for it.
+func enqueueThumbnails(file *models.File) {
+	if !isImageType(file.ContentType) {
+		return
+	}
+
+	select {
+	case thumbnailJobs <- thumbnailJob{fileId: file.Id, ownerId: file.OwnerId, blob: file.BlobRef}:
+	default:
+		log.Printf("thumbnail queue full, skipping file %s", file.Id.Hex())
+	}
+}
+
+// StartThumbnailWorker generates queued thumbnails until ctx is cancelled.
+func StartThumbnailWorker(ctx context.Context, db *mongo.Database, cfg *Config) {
+	startWorker(func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case job := <-thumbnailJobs:
+				err := generateThumbnails(ctx, db, cfg, job)
+				if ctx.Err() != nil {
+					continue
+				}
+				recordJob("thumbnails", err)
+				if err != nil {
+					log.Printf("thumbnails for file %s failed: %v", job.fileId.Hex(), err)
+				}
+				publishEvent(job.ownerId, EventThumbnailReady, gin.H{"fileId": job.fileId, "failed": err != nil})
+			}
+		}
+	})
+}
+
+// generateThumbnails stores every missing thumbnail size for a job and drops
+// those left over from earlier content.
+func generateThumbnails(ctx context.Context, db *mongo.Database, cfg *Config, job thumbnailJob) error {
+	thumbs, err := thumbnailBucket(db)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteThumbnails(ctx, thumbs, bson.M{"metadata.fileId": job.fileId, "metadata.sourceId": bson.M{"$ne": job.blob.BlobId}}); err != nil {
+		return err
+	}
+
+	var missing []string
+	for size := range thumbnailSizes {
+		count, err := thumbs.GetFilesCollection().CountDocuments(ctx, thumbnailFilter(job.fileId, size, job.blob.BlobId))
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			missing = append(missing, size)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	img, format, err := loadImage(ctx, db, cfg, job.blob, cfg.Images.MaxPixels)
+	if err != nil {
+		// Record the failure so the endpoint stops waiting for it.
+		for _, size := range missing {
+			meta := thumbnailMeta{FileId: job.fileId, Size: size, SourceId: job.blob.BlobId, Failed: true}
+			if _, uploadErr := thumbs.UploadFromStream(size, eofReader{}, options.GridFSUpload().SetMetadata(meta)); uploadErr != nil {
+				return uploadErr
+			}
+		}
+		return err
+	}
+
+	for _, size := range missing {
+		edge := thumbnailSizes[size]
+		thumb := fitWithin(img, edge, edge)
+
+		meta := thumbnailMeta{FileId: job.fileId, Size: size, SourceId: job.blob.BlobId, ContentType: encodedType(format)}
+
+		upload, err := thumbs.OpenUploadStream(job.fileId.Hex()+"-"+size, options.GridFSUpload().SetMetadata(meta))
+		if err != nil {
+			return err
+		}
+		if err := encodeImage(upload, thumb, format); err != nil {
+			_ = upload.Abort()
+			return err
+		}
+		if err := upload.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func thumbnailFilter(fileId primitive.ObjectID, size string, sourceId primitive.ObjectID) bson.M {
+	return bson.M{"metadata.fileId": fileId, "metadata.size": size, "metadata.sourceId": sourceId}
+}
+
+// deleteThumbnails removes the thumbnails matching filter.
+func deleteThumbnails(ctx context.Context, thumbs *gridfs.Bucket, filter bson.M) error {
+	var docs []thumbnailDoc
+	if err := findAll(ctx, thumbs.GetFilesCollection(), filter, &docs, options.Find().SetProjection(bson.M{"_id": 1})); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := thumbs.DeleteContext(ctx, doc.Id); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeThumbnails removes the thumbnails of deleted files, logging failures.
+func purgeThumbnails(ctx context.Context, db *mongo.Database, fileIds []primitive.ObjectID) {
+	thumbs, err := thumbnailBucket(db)
+	if err == nil {
+		err = deleteThumbnails(ctx, thumbs, bson.M{"metadata.fileId": bson.M{"$in": fileIds}})
+	}
+	if err != nil {
+		log.Printf("thumbnail cleanup of %d files failed: %v", len(fileIds), err)
+	}
+}
+
+// GetThumbnail handler serves a small or medium thumbnail of an image file,
+// or 202 while it is still being generated.
+func (fc *FileController) GetThumbnail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		size := c.DefaultQuery("size", "small")
+		if _, ok := thumbnailSizes[size]; !ok {
+			respondError(c, badRequest("size must be small or medium"))
+			return
+		}
+
+		if !isImageType(file.ContentType) {
+			respondError(c, notFound("no thumbnail for this file type").withDetails(gin.H{"thumbnail": false}))
+			return
+		}
+
+		thumbs, err := thumbnailBucket(fc.db)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var doc thumbnailDoc
+		err = thumbs.GetFilesCollection().FindOne(ctx, thumbnailFilter(file.Id, size, file.BlobId)).Decode(&doc)
+		if err == mongo.ErrNoDocuments {
+			enqueueThumbnails(file)
+			c.Header("Retry-After", "2")
+			c.JSON(http.StatusAccepted, gin.H{"status": "pending"})
+			return
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if doc.Metadata.Failed {
+			respondError(c, notFound("thumbnail could not be generated").withDetails(gin.H{"thumbnail": false}))
+			return
+		}
+
+		// A thumbnail is regenerated into a new GridFS file whenever the
+		// content changes, so its id identifies the rendition.
+		c.Header("Cache-Control", fc.cfg.CacheControl.Private)
+		validators := validators{etag: `"thumb-` + doc.Id.Hex() + `"`, modified: doc.UploadDate}
+		if validators.respondNotModified(c) {
+			return
+		}
+
+		download, err := thumbs.OpenDownloadStream(doc.Id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer download.Close()
+
+		c.Header("Content-Type", doc.Metadata.ContentType)
+		c.Header("Content-Length", strconv.FormatInt(doc.Length, 10))
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Status(http.StatusOK)
+		_, _ = io.Copy(c.Writer, download)
+	}
+}
+
+// eofReader is an empty reader for failure markers.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
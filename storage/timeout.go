@@ -0,0 +1,221 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamingRoutes move file contents or work through every matching
+// document, so their time grows with the size of the files or the data
+// rather than the work asked for, and they get Config.StreamTimeout. Keys
+// are "METHOD route template", relative to Config.APIPrefix.
+var streamingRoutes = map[string]bool{
+	"POST /files/":                        true,
+	"POST /files/download-zip":            true,
+	"POST /files/batch-delete":            true,
+	"GET /files/:id/download":             true,
+	"GET /files/:id/preview":              true,
+	"GET /files/:id/image":                true,
+	"GET /files/:id/verify":               true,
+	"POST /files/:id/copy":                true,
+	"GET /files/:id/versions/:n/download": true,
+	"PUT /files/uploads/:id/chunks/:n":    true,
+	"POST /files/uploads/:id/complete":    true,
+	"POST /files/:id/confirm":             true,
+	"GET /folders/:id/download":           true,
+	"GET /s/:token":                       true,
+	"POST /r/:token/upload":               true,
+	"DELETE /users/:id":                   true,
+	"GET /admin/users/export":             true,
+	"GET /admin/files/export":             true,
+	"POST /admin/users/import":            true,
+	"POST /admin/trash/purge":             true,
+	"POST /admin/encryption/rewrap":       true,
+	"GET /notifications/stream":           true,
+}
+
+// Timeout puts a deadline on the request's context: cfg.RequestTimeout, or
+// cfg.StreamTimeout for streaming routes, zero meaning none. If it passes
+// before the handler has written anything the client gets a 504
+// request_timeout error straight away, and whatever the handler writes
+// afterwards is discarded. The handler itself keeps running
+// until it notices its context is done, which for Mongo calls is
+// immediately. A response already under way when the deadline passes is
+// cut off by the cancelled context instead.
+func Timeout(cfg *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		budget := cfg.RequestTimeout
+		if streamingRoutes[c.Request.Method+" "+cfg.routeTemplate(c)] {
+			budget = cfg.StreamTimeout
+		}
+		if budget <= 0 || c.IsWebsocket() {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), budget)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		tw := newTimeoutWriter(ctx, c)
+		c.Writer = tw
+
+		done := make(chan struct{})
+		watched := make(chan struct{})
+		go func() {
+			defer close(watched)
+			select {
+			case <-ctx.Done():
+				if ctx.Err() == context.DeadlineExceeded {
+					tw.timeout()
+				}
+			case <-done:
+			}
+		}()
+
+		defer func() {
+			close(done)
+			<-watched
+			tw.finish()
+			c.Writer = tw.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// timeoutWriter serializes the handler's writes with the 504 sent by
+// Timeout. The handler's headers are kept apart until its first write, so
+// the two never touch the same header map at once. A write that comes after
+// the deadline but before the watcher has noticed it still gets the 504.
+type timeoutWriter struct {
+	gin.ResponseWriter
+
+	ctx      context.Context
+	c        *gin.Context
+	mu       sync.Mutex
+	header   http.Header
+	status   int
+	sent     bool
+	timedOut bool
+}
+
+func newTimeoutWriter(ctx context.Context, c *gin.Context) *timeoutWriter {
+	return &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx, c: c, header: c.Writer.Header().Clone(), status: c.Writer.Status()}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.sent && !w.timedOut {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.late() {
+		w.send()
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.late() {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.send()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.late() {
+		w.send()
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sent || w.timedOut {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sent || w.timedOut
+}
+
+// send passes the handler's headers and status on to the real writer, once.
+// The caller holds mu.
+func (w *timeoutWriter) send() {
+	if w.sent {
+		return
+	}
+	w.sent = true
+
+	header := w.ResponseWriter.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range w.header {
+		header[key] = values
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// timeout answers 504 unless the handler has already started its response.
+func (w *timeoutWriter) timeout() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.late()
+}
+
+// late reports whether the deadline has passed without the handler having
+// started its response, answering 504 the first time it does. The body is
+// respondError's, written here because c.Writer is the handler's. The caller
+// holds mu.
+func (w *timeoutWriter) late() bool {
+	if w.timedOut || w.sent || w.ctx.Err() != context.DeadlineExceeded {
+		return w.timedOut
+	}
+	w.timedOut = true
+
+	status, body := errorResponse(w.c, context.DeadlineExceeded)
+	data, _ := json.Marshal(body)
+	w.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.ResponseWriter.WriteHeader(status)
+	_, _ = w.ResponseWriter.Write(data)
+	w.ResponseWriter.Flush()
+	return true
+}
+
+// finish hands on a status the handler set without writing a body, such as
+// a bare 204.
+func (w *timeoutWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.late() {
+		w.send()
+	}
+}
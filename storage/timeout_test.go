@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timeoutRouter serves handler at GET /files/:id and GET /s/:token behind
+// Timeout, with a fixed request ID so error bodies can be checked for it.
+func timeoutRouter(cfg *Config, handler gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(requestIDKey, "req-1") }, Timeout(cfg))
+	router.GET(cfg.APIPrefix+"/files/:id", handler)
+	router.GET(cfg.APIPrefix+"/s/:token", handler)
+	return router
+}
+
+// sleepThenWrite answers 200 after d, or once the request is cancelled,
+// whichever comes first; it writes either way, as a handler that ignores its
+// context would.
+func sleepThenWrite(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		select {
+		case <-time.After(d):
+		case <-c.Request.Context().Done():
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+}
+
+func TestTimeoutRespondsWithAPIError(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = 20 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	timeoutRouter(cfg, sleepThenWrite(time.Second)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cfg.APIPrefix+"/files/1", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", rec.Code)
+	}
+	body := decodeError(t, rec)
+	if body.Error.Code != ErrCodeTimeout || body.Error.RequestID != "req-1" {
+		t.Errorf("body = %+v, want code %q with the request ID", body.Error, ErrCodeTimeout)
+	}
+}
+
+func TestTimeoutLetsFastHandlersAnswer(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = 200 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	timeoutRouter(cfg, sleepThenWrite(150*time.Millisecond)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cfg.APIPrefix+"/files/1", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"ok":true}` {
+		t.Errorf("got %d %s, want the handler's response", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeoutKeepsBareStatus(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = time.Second
+
+	rec := httptest.NewRecorder()
+	timeoutRouter(cfg, func(c *gin.Context) { c.Status(http.StatusNoContent) }).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cfg.APIPrefix+"/files/1", nil))
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+}
+
+func TestTimeoutGivesStreamingRoutesTheirOwnBudget(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = 20 * time.Millisecond
+	cfg.StreamTimeout = 0
+
+	rec := httptest.NewRecorder()
+	timeoutRouter(cfg, sleepThenWrite(60*time.Millisecond)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cfg.APIPrefix+"/s/abc", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want the share download to outlast RequestTimeout", rec.Code)
+	}
+}
+
+func TestStreamingRoutesAreRegistered(t *testing.T) {
+	cfg := testConfig()
+	registered := map[string]bool{}
+	for _, route := range newRouter(nil, cfg).Routes() {
+		if path, ok := strings.CutPrefix(route.Path, cfg.APIPrefix); ok {
+			registered[route.Method+" "+path] = true
+		}
+	}
+	for route := range streamingRoutes {
+		if !registered[route] {
+			t.Errorf("%s gets the streaming timeout but is not registered", route)
+		}
+	}
+}
+
+func TestTimeoutKeepsNotFound(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = time.Second
+
+	rec := httptest.NewRecorder()
+	timeoutRouter(cfg, sleepThenWrite(0)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cfg.APIPrefix+"/no/such/route", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want gin's 404 for an unmatched route", rec.Code)
+	}
+}
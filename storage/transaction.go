@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// transactionsSupported reports whether the deployment runs multi-document
+// transactions, which takes a replica set or a sharded cluster. SetupRouter
+// sets it from detectTransactions.
+var transactionsSupported bool
+
+// detectTransactions asks the server whether it is a replica set member or
+// a mongos. A standalone server has no transactions.
+func detectTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+// withTransaction runs fn in a transaction, so either all of its writes are
+// applied or none are. The driver retries fn on transient errors, so fn must
+// pass the context it is given to every operation and have no effects
+// outside the database. Called within fn, withTransaction joins the
+// transaction already running.
+//
+// Without transaction support fn runs as a plain sequence of operations and
+// stops at its first error, keeping the writes made before it. Such failures
+// are logged for reconciliation; mongo.ErrNoDocuments is not, as fn returns
+// it when there was nothing to write.
+func withTransaction(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	if !transactionsSupported {
+		err := fn(ctx)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			Logger.Error("multi-document write failed without a transaction, earlier writes were kept", "error", err)
+		}
+		return err
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
@@ -0,0 +1,220 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// useTransactions sets transactionsSupported for the rest of the test.
+func useTransactions(t *testing.T, supported bool) {
+	saved := transactionsSupported
+	transactionsSupported = supported
+	t.Cleanup(func() { transactionsSupported = saved })
+}
+
+// sentNames lists the commands mt's client sent, in order.
+func sentNames(mt *mtest.T) []string {
+	var names []string
+	for _, event := range mt.GetAllStartedEvents() {
+		names = append(names, event.CommandName)
+	}
+	return names
+}
+
+func TestWithTransaction(t *testing.T) {
+	mt := newMockDB(t)
+	errBoom := errors.New("boom")
+	insert := func(ctx context.Context, mt *mtest.T) error {
+		_, err := mt.DB.Collection("things").InsertOne(ctx, bson.M{"n": 1})
+		return err
+	}
+
+	mt.Run("commits", func(mt *mtest.T) {
+		useTransactions(mt.T, true)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		err := withTransaction(context.Background(), mt.Client, func(ctx context.Context) error {
+			if err := insert(ctx, mt); err != nil {
+				return err
+			}
+			// A nested call joins the transaction instead of starting one.
+			return withTransaction(ctx, mt.Client, func(ctx context.Context) error { return insert(ctx, mt) })
+		})
+		if err != nil {
+			mt.Fatal(err)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if got := strings.Join(sentNames(mt), ","); got != "insert,insert,commitTransaction" {
+			mt.Fatalf("sent %s, want both inserts in one committed transaction", got)
+		}
+		if !events[0].Command.Lookup("startTransaction").Boolean() || !events[1].Command.Lookup("txnNumber").Equal(events[0].Command.Lookup("txnNumber")) {
+			mt.Errorf("inserts %s and %s are not in the same transaction", events[0].Command, events[1].Command)
+		}
+	})
+
+	mt.Run("aborts on error", func(mt *mtest.T) {
+		useTransactions(mt.T, true)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		err := withTransaction(context.Background(), mt.Client, func(ctx context.Context) error {
+			if err := insert(ctx, mt); err != nil {
+				return err
+			}
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			mt.Fatalf("err = %v, want fn's", err)
+		}
+		if got := strings.Join(sentNames(mt), ","); got != "insert,abortTransaction" {
+			mt.Errorf("sent %s, want the insert rolled back", got)
+		}
+	})
+
+	mt.Run("standalone", func(mt *mtest.T) {
+		useTransactions(mt.T, false)
+		logs := captureLogs(mt.T)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		err := withTransaction(context.Background(), mt.Client, func(ctx context.Context) error {
+			if err := insert(ctx, mt); err != nil {
+				return err
+			}
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			mt.Fatalf("err = %v, want fn's", err)
+		}
+		if _, err := sentCommand(mt, "insert").LookupErr("startTransaction"); err == nil {
+			mt.Error("insert was sent in a transaction")
+		}
+		if !strings.Contains(logs.String(), "earlier writes were kept") {
+			mt.Errorf("partial write was not logged for reconciliation: %s", logs)
+		}
+
+		logs.Reset()
+		if err := withTransaction(context.Background(), mt.Client, func(ctx context.Context) error { return mongo.ErrNoDocuments }); err != mongo.ErrNoDocuments {
+			mt.Fatalf("err = %v, want mongo.ErrNoDocuments", err)
+		}
+		if logs.Len() != 0 {
+			mt.Errorf("nothing to write was logged as a failure: %s", logs)
+		}
+	})
+}
+
+func TestDetectTransactions(t *testing.T) {
+	mt := newMockDB(t)
+	tests := map[string]struct {
+		hello bson.E
+		want  bool
+	}{
+		"replica set": {bson.E{Key: "setName", Value: "rs0"}, true},
+		"mongos":      {bson.E{Key: "msg", Value: "isdbgrid"}, true},
+		"standalone":  {bson.E{Key: "isWritablePrimary", Value: true}, false},
+	}
+	for name, tt := range tests {
+		mt.Run(name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(tt.hello))
+			got, err := detectTransactions(context.Background(), mt.Client)
+			if err != nil || got != tt.want {
+				mt.Errorf("detectTransactions = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteAccountRollsBack(t *testing.T) {
+	mt := newMockDB(t)
+
+	mt.Run("share update fails", func(mt *mtest.T) {
+		useTransactions(mt.T, true)
+		userId := primitive.NewObjectID()
+		updated := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+		mt.AddMockResponses(updated, updated, mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "shares unavailable"}), mtest.CreateSuccessResponse())
+
+		router := gin.New()
+		router.DELETE("/users/me", asUser(userId, models.RoleUser), NewUserController(mt.DB, testConfig()).DeleteMe())
+		if rec := doRequest(router, http.MethodDelete, "/users/me", nil); rec.Code != http.StatusInternalServerError {
+			mt.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body.String())
+		}
+
+		// Marking the user deleted and revoking the sessions are undone with
+		// the failed share update.
+		if got := strings.Join(sentNames(mt), ","); got != "update,update,update,abortTransaction" {
+			mt.Errorf("sent %s, want three updates in a transaction that is aborted", got)
+		}
+		if sentCommand(mt, "update").Lookup("updates", "0", "u", "$unset", "live").IsZero() {
+			mt.Error("the deleted account stays live and keeps its email")
+		}
+	})
+}
+
+// TestTransactionRollbackOnReplicaSet runs against the replica set at
+// $MONGODB_TEST_URI, for example a throwaway container started with
+// `docker run -d -p 27017:27017 mongo:7 --replSet rs0` and `rs.initiate()`.
+func TestTransactionRollbackOnReplicaSet(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	supported, err := detectTransactions(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !supported {
+		t.Fatalf("%s is not a replica set", uri)
+	}
+	useTransactions(t, true)
+
+	db := client.Database("transaction_test_" + primitive.NewObjectID().Hex())
+	defer db.Drop(context.Background())
+	// Collections can't be created inside a transaction on older servers.
+	for _, name := range []string{UserCollection, SessionCollection} {
+		if err := db.CreateCollection(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	userId := primitive.NewObjectID()
+	errInduced := errors.New("induced failure")
+	err = withTransaction(ctx, client, func(ctx context.Context) error {
+		if _, err := db.Collection(UserCollection).InsertOne(ctx, bson.M{"_id": userId}); err != nil {
+			return err
+		}
+		if _, err := db.Collection(SessionCollection).InsertOne(ctx, bson.M{"userId": userId}); err != nil {
+			return err
+		}
+		return errInduced
+	})
+	if !errors.Is(err, errInduced) {
+		t.Fatalf("err = %v, want the induced failure", err)
+	}
+
+	for _, name := range []string{UserCollection, SessionCollection} {
+		n, err := db.Collection(name).CountDocuments(ctx, bson.M{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%s kept %d documents after the rollback", name, n)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notTrashed matches files that are not in the trash when used as the
+// "deletedAt" condition of a query.
+var notTrashed = bson.M{"$exists": false}
+
+// trashUpdate moves files to the trash as of now, which frees their names.
+func trashUpdate(now time.Time) bson.M {
+	return bson.M{"$set": bson.M{"deletedAt": now}, "$unset": bson.M{"live": ""}}
+}
+
+// trashFile marks file as deleted. Its content, shares and permissions are
+// kept so it can be restored.
+func (fc *FileController) trashFile(ctx context.Context, c *gin.Context, file *models.File) {
+	collection := fc.db.Collection(FileCollection)
+
+	filter := bson.M{"_id": file.Id, "deletedAt": notTrashed}
+	result, err := collection.UpdateOne(ctx, filter, trashUpdate(time.Now()))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		respondError(c, notFound("File not found"))
+		return
+	}
+
+	audit(c, AuditFileDeleted, auditTargetFile, file.Id, gin.H{"name": file.Name, "permanent": false})
+	emitWebhookEvent(file.OwnerId, AuditFileDeleted, gin.H{"fileId": file.Id, "name": file.Name, "permanent": false})
+	c.JSON(http.StatusOK, gin.H{"message": "File moved to trash"})
+}
+
+// GetTrash handler lists the caller's trashed files, most recently deleted
+// first.
+func (fc *FileController) GetTrash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(FileCollection)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter := bson.M{"ownerId": currentUserID(c), "deletedAt": bson.M{"$exists": true}, "expiresAt": notExpired()}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		byDeletion := bson.D{{Key: "deletedAt", Value: -1}, {Key: "_id", Value: -1}}
+		cursor, err := collection.Find(ctx, filter, page.FindOptions().SetSort(byDeletion))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		files := []models.File{}
+		if err = cursor.All(ctx, &files); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, page.Result(files, total))
+	}
+}
+
+// RestoreFile handler takes a file out of the trash and puts it back in its
+// folder, or the root if that folder has since been deleted. Name clashes
+// are suffixed unless ?onConflict=error is given.
+func (fc *FileController) RestoreFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+		collection := db.Collection(FileCollection)
+
+		file, ok := lookupFile(ctx, fc.db, c, bson.M{"deletedAt": bson.M{"$exists": true}, "expiresAt": notExpired()})
+		if !ok {
+			return
+		}
+
+		if !authorizeFileOwner(c, file) {
+			return
+		}
+
+		mode, err := parseOnConflict(c, onConflictRename)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		folderId := file.FolderId
+		if folderId != nil {
+			count, err := db.Collection(FolderCollection).CountDocuments(ctx, bson.M{"_id": *folderId, "ownerId": file.OwnerId})
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			if count == 0 {
+				folderId = nil
+			}
+		}
+
+		filter := bson.M{"_id": file.Id, "deletedAt": bson.M{"$exists": true}}
+		set := bson.M{"folderId": folderId, "live": true}
+		update := bson.M{"$set": set, "$unset": bson.M{"deletedAt": ""}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var restored models.File
+		_, err = saveFileName(ctx, collection, file.OwnerId, folderId, file.Name, mode, nil, func(candidate string) error {
+			set["name"] = candidate
+			return collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&restored)
+		})
+		if err != nil {
+			respondError(c, orNotFound(orNameConflict(err), "File not found"))
+			return
+		}
+
+		c.JSON(http.StatusOK, restored)
+	}
+}
@@ -0,0 +1,430 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TOTP parameters, the defaults every authenticator app understands.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many periods either side of now are still accepted.
+	totpSkew = 1
+)
+
+// RecoveryCodeCount is how many single-use recovery codes enabling 2FA
+// hands out.
+const RecoveryCodeCount = 10
+
+// twoFactorChallengeTTL is how long the token from a password login can be
+// exchanged for a session.
+const twoFactorChallengeTTL = 5 * time.Minute
+
+// twoFactorFailures limits wrong codes per user across all of their
+// challenges.
+var twoFactorFailures = newFailureLimiter(5, 15*time.Minute)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret, base32 encoded.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode computes the RFC 6238 code of key for the given time step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the time step code is valid for, allowing totpSkew
+// periods of clock drift.
+func matchTOTP(secret string, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI is the otpauth:// URI authenticator apps scan as a QR code,
+// naming issuer as the service the account is for.
+func totpURI(issuer string, secret string, account string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// newRecoveryCodes returns RecoveryCodeCount codes like "k3m9q-x7p2a" and
+// their hashes for storage.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode drops the dash and case so codes can be typed
+// loosely.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// verifySecondFactor checks code against user's authenticator or, failing
+// that, consumes a matching recovery code. It reports which one matched.
+// A TOTP code is only accepted once: its time step must be newer than the
+// last one used.
+func verifySecondFactor(ctx context.Context, db *mongo.Database, user *models.User, code string) (string, bool, error) {
+	collection := db.Collection(UserCollection)
+
+	code = strings.TrimSpace(code)
+	if step, ok := matchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		filter := bson.M{"_id": user.Id, "$or": bson.A{
+			bson.M{"totpLastStep": bson.M{"$exists": false}},
+			bson.M{"totpLastStep": bson.M{"$lt": step}},
+		}}
+		result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totpLastStep": step}})
+		if err != nil {
+			return "", false, err
+		}
+		return "totp", result.MatchedCount == 1, nil
+	}
+
+	hash := hashToken(normalizeRecoveryCode(code))
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": user.Id, "recoveryCodes": hash},
+		bson.M{"$pull": bson.M{"recoveryCodes": hash}},
+	)
+	if err != nil {
+		return "", false, err
+	}
+	return "recovery", result.MatchedCount == 1, nil
+}
+
+// twoFactorClaims identifies the user who passed the password step.
+type twoFactorClaims struct {
+	jwt.RegisteredClaims
+}
+
+func issueTwoFactorChallenge(secret []byte, user models.User) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(twoFactorChallengeTTL)
+	claims := twoFactorClaims{jwt.RegisteredClaims{
+		Subject:   user.Id.Hex(),
+		Audience:  jwt.ClaimStrings{twoFactorAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}}
+
+	signed, err := signToken(secret, claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+type twoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// LoginTwoFactor handler finishes a login of an account with 2FA, trading
+// the challenge token and a TOTP or recovery code for a session.
+func (ac *AuthController) LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := ac.db.Collection(UserCollection)
+
+		var req twoFactorLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		claims := &twoFactorClaims{}
+		if err := parseToken(ac.cfg.JWTSecret, req.ChallengeToken, claims, twoFactorAudience); err != nil {
+			respondError(c, unauthorized("invalid or expired challenge token"))
+			return
+		}
+		userId, err := primitive.ObjectIDFromHex(claims.Subject)
+		if err != nil {
+			respondError(c, unauthorized("invalid or expired challenge token"))
+			return
+		}
+
+		key := userId.Hex()
+		if blocked, retryAfter := twoFactorFailures.Blocked(key); blocked {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			respondError(c, newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "too many failed attempts, try again later"))
+			return
+		}
+
+		var user models.User
+		if err := collection.FindOne(ctx, bson.M{"_id": userId}).Decode(&user); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, unauthorized("invalid or expired challenge token"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		if user.IsDeleted() {
+			respondAccountDeleted(c)
+			return
+		}
+		if user.Status == models.UserStatusSuspended {
+			respondSuspended(c)
+			return
+		}
+
+		// 2FA was turned off since the password step; the challenge has
+		// nothing left to prove.
+		if !user.TwoFactorEnabled {
+			respondError(c, unauthorized("invalid or expired challenge token"))
+			return
+		}
+
+		method, ok, err := verifySecondFactor(ctx, ac.db, &user, req.Code)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if !ok {
+			twoFactorFailures.Fail(key)
+			auditAs(c, primitive.NilObjectID, AuditLoginFailed, auditTargetUser, user.Id, gin.H{"email": user.Email, "twoFactor": true})
+			respondError(c, unauthorized("invalid authentication code"))
+			return
+		}
+		twoFactorFailures.Reset(key)
+
+		ac.completeLogin(ctx, c, user, gin.H{"twoFactor": method})
+	}
+}
+
+// loadCurrentUser loads the caller's account, writing the error response
+// when it can't.
+func loadCurrentUser(ctx context.Context, db *mongo.Database, c *gin.Context) (*models.User, bool) {
+	user, err := loadQuotaUser(ctx, db, currentUserID(c))
+	if err != nil {
+		respondError(c, orNotFound(err, "User not found"))
+		return nil, false
+	}
+	return user, true
+}
+
+// SetupTwoFactor handler generates a new TOTP secret for the caller. It only
+// takes effect once EnableTwoFactor has seen a code for it.
+func (uc *UserController) SetupTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		user, ok := loadCurrentUser(ctx, uc.db, c)
+		if !ok {
+			return
+		}
+
+		if user.TwoFactorEnabled {
+			respondError(c, conflict("two-factor authentication is already enabled"))
+			return
+		}
+
+		secret, err := newTOTPSecret()
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"totpPendingSecret": secret}}); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "uri": totpURI(uc.cfg.TOTPIssuer, secret, user.Email)})
+	}
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// EnableTwoFactor handler turns 2FA on once the caller proves their
+// authenticator works, and returns the recovery codes. They are shown only
+// this once.
+func (uc *UserController) EnableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		var req twoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx, uc.db, c)
+		if !ok {
+			return
+		}
+
+		if user.TwoFactorEnabled {
+			respondError(c, conflict("two-factor authentication is already enabled"))
+			return
+		}
+		if user.TOTPPendingSecret == "" {
+			respondError(c, badRequest("call /users/me/2fa/setup first"))
+			return
+		}
+
+		step, ok := matchTOTP(user.TOTPPendingSecret, strings.TrimSpace(req.Code), time.Now())
+		if !ok {
+			respondError(c, badRequest("invalid authentication code"))
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		filter := bson.M{"_id": user.Id, "totpPendingSecret": user.TOTPPendingSecret}
+		update := bson.M{
+			"$set": bson.M{
+				"twoFactorEnabled": true,
+				"totpSecret":       user.TOTPPendingSecret,
+				"totpLastStep":     step,
+				"recoveryCodes":    hashes,
+			},
+			"$unset": bson.M{"totpPendingSecret": ""},
+		}
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if result.MatchedCount == 0 {
+			respondError(c, conflict("two-factor setup was restarted, scan the new secret"))
+			return
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, user.Id, gin.H{"fields": []string{"twoFactorEnabled"}, "twoFactorEnabled": true})
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recoveryCodes": codes})
+	}
+}
+
+// DisableTwoFactor handler turns 2FA off. It takes a current TOTP or
+// recovery code.
+func (uc *UserController) DisableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		var req twoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx, uc.db, c)
+		if !ok {
+			return
+		}
+
+		if !user.TwoFactorEnabled {
+			respondError(c, conflict("two-factor authentication is not enabled"))
+			return
+		}
+
+		key := user.Id.Hex()
+		if blocked, retryAfter := twoFactorFailures.Blocked(key); blocked {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			respondError(c, newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "too many failed attempts, try again later"))
+			return
+		}
+
+		_, ok, err := verifySecondFactor(ctx, uc.db, user, req.Code)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if !ok {
+			twoFactorFailures.Fail(key)
+			respondError(c, badRequest("invalid authentication code"))
+			return
+		}
+		twoFactorFailures.Reset(key)
+
+		update := bson.M{"$unset": bson.M{
+			"twoFactorEnabled":  "",
+			"totpSecret":        "",
+			"totpPendingSecret": "",
+			"totpLastStep":      "",
+			"recoveryCodes":     "",
+		}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": user.Id}, update); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, user.Id, gin.H{"fields": []string{"twoFactorEnabled"}, "twoFactorEnabled": false})
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}
+}
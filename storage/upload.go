@@ -0,0 +1,325 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var UploadSessionCollection string = "uploadSessions"
+var UploadChunkCollection string = "uploadChunks"
+
+// MaxUploadChunkSize keeps each chunk document well under Mongo's 16 MB limit.
+const MaxUploadChunkSize int64 = 8 << 20
+
+type createUploadRequest struct {
+	Name        string `json:"name" binding:"required,max=255"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size" binding:"min=0"`
+	ChunkSize   int64  `json:"chunkSize" binding:"required,min=1"`
+	SHA256      string `json:"sha256" binding:"required,len=64,hexadecimal"`
+}
+
+// CreateUpload handler
+func (fc *FileController) CreateUpload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := fc.db.Collection(UploadSessionCollection)
+
+		var req createUploadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		if req.ChunkSize > MaxUploadChunkSize {
+			respondError(c, badRequest("chunkSize must be at most "+strconv.FormatInt(MaxUploadChunkSize, 10)))
+			return
+		}
+
+		if limit := fc.cfg.uploadLimit(c); req.Size > limit {
+			respondUploadTooLarge(c, limit)
+			return
+		}
+
+		if !checkQuota(ctx, fc.db, fc.cfg, c, currentUserID(c), req.Size) {
+			return
+		}
+
+		now := time.Now()
+		session := models.UploadSession{
+			Id:          primitive.NewObjectID(),
+			OwnerId:     currentUserID(c),
+			Name:        req.Name,
+			ContentType: req.ContentType,
+			Size:        req.Size,
+			ChunkSize:   req.ChunkSize,
+			SHA256:      normalizeHex(req.SHA256),
+			Received:    []int64{},
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(fc.cfg.UploadSessionTTL),
+		}
+
+		if _, err := collection.InsertOne(ctx, session); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"uploadId": session.Id, "chunkCount": session.ChunkCount(), "expiresAt": session.ExpiresAt})
+	}
+}
+
+// GetUpload handler
+func (fc *FileController) GetUpload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		session, ok := fc.findUpload(ctx, c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"upload":  session,
+			"missing": missingChunks(session),
+		})
+	}
+}
+
+// PutUploadChunk handler
+func (fc *FileController) PutUploadChunk() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		session, ok := fc.findUpload(ctx, c)
+		if !ok {
+			return
+		}
+
+		n, err := strconv.ParseInt(c.Param("n"), 10, 64)
+		if err != nil || n < 0 || n >= session.ChunkCount() {
+			respondError(c, badRequest("chunk number out of range"))
+			return
+		}
+
+		expected := session.ChunkLength(n)
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, expected+1))
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+		if int64(len(data)) != expected {
+			respondError(c, badRequest("chunk "+strconv.FormatInt(n, 10)+" must be exactly "+strconv.FormatInt(expected, 10)+" bytes"))
+			return
+		}
+
+		chunk := models.UploadChunk{UploadId: session.Id, N: n, Data: data, ExpiresAt: session.ExpiresAt}
+		_, err = db.Collection(UploadChunkCollection).ReplaceOne(ctx,
+			bson.M{"uploadId": session.Id, "n": n},
+			chunk,
+			options.Replace().SetUpsert(true),
+		)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var updated models.UploadSession
+		err = db.Collection(UploadSessionCollection).FindOneAndUpdate(ctx,
+			bson.M{"_id": session.Id},
+			bson.M{"$addToSet": bson.M{"received": n}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"received": 1}),
+		).Decode(&updated)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var receivedBytes int64
+		for _, m := range updated.Received {
+			receivedBytes += session.ChunkLength(m)
+		}
+		publishEvent(session.OwnerId, EventUploadProgress, gin.H{
+			"uploadId":      session.Id,
+			"n":             n,
+			"chunks":        session.ChunkCount(),
+			"received":      len(updated.Received),
+			"bytesReceived": receivedBytes,
+			"size":          session.Size,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Chunk stored", "n": n})
+	}
+}
+
+// CompleteUpload handler
+func (fc *FileController) CompleteUpload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		session, ok := fc.findUpload(ctx, c)
+		if !ok {
+			return
+		}
+
+		declared, ok := declaredChecksum(c)
+		if !ok {
+			return
+		}
+
+		mode, err := parseUploadOnConflict(c, fc.cfg.UploadOnConflict)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		if missing := missingChunks(session); len(missing) > 0 {
+			respondError(c, conflict("upload is missing chunks").withDetails(gin.H{"missing": missing}))
+			return
+		}
+
+		chunks := db.Collection(UploadChunkCollection)
+		cursor, err := chunks.Find(ctx, bson.M{"uploadId": session.Id}, options.Find().SetSort(bson.D{{Key: "n", Value: 1}}))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		file, err := storeUpload(ctx, fc.db, fc.cfg, &chunkReader{ctx: ctx, cursor: cursor}, session.Name)
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		if file.Size != session.Size || file.Checksum != session.SHA256 {
+			discardBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+			respondError(c, newAPIError(http.StatusUnprocessableEntity, ErrCodeUnprocessable, "assembled upload does not match the declared size and SHA-256"))
+			return
+		}
+
+		if declared != "" && declared != file.Checksum {
+			discardBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+			respondChecksumMismatch(c, declared, file.Checksum)
+			return
+		}
+
+		file.OwnerId = session.OwnerId
+
+		if !reserveQuota(ctx, fc.db, fc.cfg, c, session.OwnerId, file.Size) {
+			discardBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+			return
+		}
+
+		if err := dedupeUpload(ctx, fc.db, fc.cfg, file); err != nil {
+			releaseQuota(ctx, fc.db, session.OwnerId, file.Size)
+			discardBlob(ctx, fc.db, fc.cfg, file.BlobRef)
+			respondError(c, err)
+			return
+		}
+
+		saved, created, err := saveUploadedFile(ctx, fc.db, fc.cfg, file, mode)
+		if err != nil {
+			releaseQuota(ctx, fc.db, session.OwnerId, file.Size)
+			deleteBlobs(ctx, fc.db, fc.cfg, []models.BlobRef{file.BlobRef})
+			respondSaveError(c, err)
+			return
+		}
+
+		_, _ = chunks.DeleteMany(ctx, bson.M{"uploadId": session.Id})
+		_, _ = db.Collection(UploadSessionCollection).DeleteOne(ctx, bson.M{"_id": session.Id})
+
+		enqueueContentJobs(saved)
+		audit(c, AuditFileUploaded, auditTargetFile, saved.Id, gin.H{"name": saved.Name, "size": saved.Size, "version": saved.CurrentVersion().N, "resumable": true})
+		emitWebhookEvent(saved.OwnerId, AuditFileUploaded, fileEventData(saved))
+
+		if !created {
+			c.JSON(http.StatusOK, saved)
+			return
+		}
+		c.JSON(http.StatusCreated, saved)
+	}
+}
+
+// findUpload loads the caller's upload session named by the :id path param.
+func (fc *FileController) findUpload(ctx context.Context, c *gin.Context) (*models.UploadSession, bool) {
+	collection := fc.db.Collection(UploadSessionCollection)
+
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("Invalid upload ID"))
+		return nil, false
+	}
+
+	var session models.UploadSession
+	filter := bson.M{"_id": objId, "ownerId": currentUserID(c), "expiresAt": bson.M{"$gt": time.Now()}}
+	if err := collection.FindOne(ctx, filter).Decode(&session); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, notFound("Upload not found"))
+			return nil, false
+		}
+		respondError(c, err)
+		return nil, false
+	}
+
+	return &session, true
+}
+
+// missingChunks lists the chunk numbers not yet received, in order.
+func missingChunks(session *models.UploadSession) []int64 {
+	received := make(map[int64]bool, len(session.Received))
+	for _, n := range session.Received {
+		received[n] = true
+	}
+
+	missing := []int64{}
+	for n := int64(0); n < session.ChunkCount(); n++ {
+		if !received[n] {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+// chunkReader reads the data of UploadChunk documents from a cursor as one
+// continuous stream, holding a single chunk in memory at a time.
+type chunkReader struct {
+	ctx    context.Context
+	cursor *mongo.Cursor
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if !r.cursor.Next(r.ctx) {
+			if err := r.cursor.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+
+		var chunk models.UploadChunk
+		if err := r.cursor.Decode(&chunk); err != nil {
+			return 0, err
+		}
+		r.buf = chunk.Data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartSlack is how far a multipart body may exceed the file limit to
+// make room for part headers and small form fields.
+const multipartSlack = 1 << 20
+
+// UploadTooLargeError aborts an upload that grows past its size limit.
+type UploadTooLargeError struct {
+	Limit int64
+}
+
+func (e *UploadTooLargeError) Error() string {
+	return "upload exceeds the maximum size of " + strconv.FormatInt(e.Limit, 10) + " bytes"
+}
+
+// uploadLimit returns the maximum file size for the caller's role.
+func (cfg *Config) uploadLimit(c *gin.Context) int64 {
+	return cfg.roleUploadLimit(c.GetString("role"))
+}
+
+// roleUploadLimit returns the maximum file size for role: its entry in
+// RoleUploadLimits, or MaxUploadSize when it has none.
+func (cfg *Config) roleUploadLimit(role string) int64 {
+	if limit := cfg.RoleUploadLimits[role]; limit > 0 {
+		return limit
+	}
+	return cfg.MaxUploadSize
+}
+
+// respondUploadTooLarge writes the 413 response naming the limit.
+func respondUploadTooLarge(c *gin.Context, limit int64) {
+	err := &UploadTooLargeError{Limit: limit}
+	respondError(c, newAPIError(http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, err.Error()).withDetails(gin.H{"limit": limit}))
+}
+
+// limitedReader fails with *UploadTooLargeError once more than limit bytes
+// have been read, instead of silently truncating like io.LimitReader.
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func newLimitedReader(r io.Reader, limit int64) *limitedReader {
+	return &limitedReader{r: r, limit: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if remaining := l.limit - l.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, &UploadTooLargeError{Limit: l.limit}
+	}
+
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return n, &UploadTooLargeError{Limit: l.limit}
+	}
+	return n, err
+}
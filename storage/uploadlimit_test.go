@@ -0,0 +1,177 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// uploadRequest builds a multipart upload of content, preceded by a junk
+// field of padding bytes. Chunked requests declare no Content-Length.
+func uploadRequest(t testing.TB, content []byte, padding int, chunked bool) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if padding > 0 {
+		if err := form.WriteField("junk", strings.Repeat("x", padding)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, err := form.CreateFormFile(UploadFormField, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	var reader io.Reader = &body
+	if chunked {
+		// Hide the length from httptest.NewRequest.
+		reader = io.MultiReader(&body)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/files/", reader)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+// uploadRouter serves fc.UploadFile for a caller with role.
+func uploadRouter(fc *FileController, role string) *gin.Engine {
+	router := gin.New()
+	router.POST("/files/", asUser(primitive.NewObjectID(), role), fc.UploadFile())
+	return router
+}
+
+// checkTooLarge fails t unless rec is the 413 naming limit.
+func checkTooLarge(t *testing.T, rec *httptest.ResponseRecorder, limit int64) {
+	t.Helper()
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", rec.Code, rec.Body.String())
+	}
+	body := decodeError(t, rec)
+	if body.Error.Code != ErrCodePayloadTooLarge || body.Error.Details["limit"] != float64(limit) {
+		t.Errorf("error = %+v, want %s with limit %d", body.Error, ErrCodePayloadTooLarge, limit)
+	}
+}
+
+func TestUploadRejectsDeclaredLengthEarly(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxUploadSize = 1024
+	cfg.RoleUploadLimits = map[string]int64{models.RoleAdmin: 4096}
+
+	// No database: the request is refused before anything is read.
+	req := uploadRequest(t, nil, 0, false)
+	req.ContentLength = cfg.MaxUploadSize + multipartSlack + 1
+	rec := httptest.NewRecorder()
+	uploadRouter(NewFileController(nil, cfg), models.RoleUser).ServeHTTP(rec, req)
+	checkTooLarge(t, rec, cfg.MaxUploadSize)
+
+	req = uploadRequest(t, nil, 0, false)
+	req.ContentLength = 4096 + multipartSlack + 1
+	rec = httptest.NewRecorder()
+	uploadRouter(NewFileController(nil, cfg), models.RoleAdmin).ServeHTTP(rec, req)
+	checkTooLarge(t, rec, 4096)
+}
+
+func TestUploadRejectsOversizedChunkedBody(t *testing.T) {
+	mt := newMockDB(t)
+	cfg := testConfig()
+	cfg.MaxUploadSize = 1024
+
+	// The form fields alone run past the body limit, so the request fails
+	// while looking for the file part.
+	mt.Run("fields", func(mt *mtest.T) {
+		rec := httptest.NewRecorder()
+		uploadRouter(NewFileController(mt.DB, cfg), models.RoleUser).ServeHTTP(rec, uploadRequest(mt, []byte("small"), 1024+multipartSlack, true))
+		checkTooLarge(mt.T, rec, cfg.MaxUploadSize)
+	})
+}
+
+func TestUploadAbortsMidStream(t *testing.T) {
+	mt := newMockDB(t)
+	cfg := testConfig()
+	// Past the driver's 16 MiB upload buffer, so chunks are written before
+	// the limit is hit.
+	cfg.MaxUploadSize = 17 << 20
+
+	mt.Run("gridfs", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			found(mt, FileBucket+".files", bson.M{"_id": primitive.NewObjectID()}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		rec := httptest.NewRecorder()
+		uploadRouter(NewFileController(mt.DB, cfg), models.RoleUser).ServeHTTP(rec, uploadRequest(mt, make([]byte, 18<<20), 0, true))
+		checkTooLarge(mt.T, rec, cfg.MaxUploadSize)
+
+		// The chunk written before the limit was hit is removed again.
+		inserted := sentCommand(mt, "insert")
+		deleted := sentCommand(mt, "delete")
+		if inserted.Lookup("insert").StringValue() != FileBucket+".chunks" || deleted.Lookup("delete").StringValue() != FileBucket+".chunks" {
+			mt.Fatalf("insert %s, delete %s: want both on the chunks", inserted, deleted)
+		}
+		chunks, _ := inserted.Lookup("documents").Array().Values()
+		filesId := chunks[0].Document().Lookup("files_id")
+		removes, _ := deleted.Lookup("deletes").Array().Values()
+		if !matchesFilesID(removes[0].Document().Lookup("q", "files_id"), filesId) {
+			mt.Errorf("deleted %s, want the partial upload's chunks", removes[0])
+		}
+	})
+
+	mt.Run("local", func(mt *mtest.T) {
+		cfg := *cfg
+		cfg.Storage.Backend, cfg.Storage.LocalDir = StorageLocal, mt.TempDir()
+
+		rec := httptest.NewRecorder()
+		uploadRouter(NewFileController(mt.DB, &cfg), models.RoleUser).ServeHTTP(rec, uploadRequest(mt, make([]byte, 18<<20), 0, true))
+		checkTooLarge(mt.T, rec, cfg.MaxUploadSize)
+
+		var leftover []string
+		filepath.WalkDir(cfg.Storage.LocalDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				leftover = append(leftover, path)
+			}
+			return err
+		})
+		if len(leftover) > 0 {
+			mt.Errorf("partial upload left %v behind", leftover)
+		}
+	})
+}
+
+// matchesFilesID reports whether a delete's files_id condition selects id,
+// whether the driver sent it bare or wrapped in $eq.
+func matchesFilesID(cond, id bson.RawValue) bool {
+	if doc, ok := cond.DocumentOK(); ok {
+		if eq, err := doc.LookupErr("$eq"); err == nil {
+			return eq.Equal(id)
+		}
+	}
+	return cond.Equal(id)
+}
+
+func TestLimitedReader(t *testing.T) {
+	r := newLimitedReader(bytes.NewReader(make([]byte, 10)), 10)
+	if n, err := io.Copy(io.Discard, r); n != 10 || err != nil {
+		t.Errorf("read %d bytes, %v; want exactly the limit to pass", n, err)
+	}
+
+	r = newLimitedReader(bytes.NewReader(make([]byte, 11)), 10)
+	_, err := io.Copy(io.Discard, r)
+	var tooLarge *UploadTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != 10 {
+		t.Errorf("err = %v, want *UploadTooLargeError with the limit", err)
+	}
+}
@@ -0,0 +1,183 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// usageCategories lists every category of the breakdown in response order,
+// so the dashboard always gets the same keys.
+var usageCategories = []string{"images", "video", "documents", "archives", "other"}
+
+// usageCategoryExpr sorts a file into one of usageCategories by content type.
+var usageCategoryExpr = bson.M{"$switch": bson.M{
+	"branches": bson.A{
+		categoryBranch("images", "^image/"),
+		categoryBranch("video", "^video/"),
+		categoryBranch("documents", "^(text/|application/(pdf|msword|rtf|vnd\\.ms-|vnd\\.openxmlformats-officedocument|vnd\\.oasis\\.opendocument))"),
+		categoryBranch("archives", "^application/(zip|gzip|x-gzip|x-tar|x-bzip2|x-7z-compressed|x-rar-compressed|vnd\\.rar|x-xz|zstd)$"),
+	},
+	"default": "other",
+}}
+
+func categoryBranch(category string, pattern string) bson.M {
+	return bson.M{
+		"case": bson.M{"$regexMatch": bson.M{"input": bson.M{"$ifNull": bson.A{"$contentType", ""}}, "regex": pattern}},
+		"then": category,
+	}
+}
+
+// storedBytesExpr is a file's current size plus that of its versions.
+var storedBytesExpr = bson.M{"$add": bson.A{"$size", bson.M{"$sum": bson.M{"$ifNull": bson.A{"$versions.size", bson.A{}}}}}}
+
+// usageTotals counts files and their stored bytes.
+type usageTotals struct {
+	Count int64 `json:"count" bson:"count"`
+	Bytes int64 `json:"bytes" bson:"bytes"`
+}
+
+type categoryUsage struct {
+	Category string `json:"category" bson:"_id"`
+	Count    int64  `json:"count" bson:"count"`
+	Bytes    int64  `json:"bytes" bson:"bytes"`
+}
+
+type folderUsage struct {
+	FolderId *primitive.ObjectID `json:"folderId" bson:"_id"`
+	Name     string              `json:"name" bson:"name"`
+	Count    int64               `json:"count" bson:"count"`
+	Bytes    int64               `json:"bytes" bson:"bytes"`
+}
+
+// usageBreakdown is the GetUsageBreakdown response. Categories always lists
+// every entry of usageCategories; Folders uses a nil folderId and an empty
+// name for the root. Trashed files only count towards Trash.
+type usageBreakdown struct {
+	Total      usageTotals     `json:"total" bson:"-"`
+	Categories []categoryUsage `json:"categories" bson:"categories"`
+	Folders    []folderUsage   `json:"folders" bson:"folders"`
+	Largest    []models.File   `json:"largest" bson:"largest"`
+	Trash      usageTotals     `json:"trash" bson:"-"`
+	TrashFacet []usageTotals   `json:"-" bson:"trash"`
+}
+
+// GetUsageBreakdown handler breaks the storage of the caller, or with :id of
+// any user (admin only), down by content category and folder, and lists the
+// ten largest files. Response:
+//
+//	{
+//	  "total":      {"count": n, "bytes": n},
+//	  "categories": [{"category": "images", "count": n, "bytes": n}, ...],
+//	  "folders":    [{"folderId": id|null, "name": "...", "count": n, "bytes": n}, ...],
+//	  "largest":    [file, ...],
+//	  "trash":      {"count": n, "bytes": n}
+//	}
+func (uc *UserController) GetUsageBreakdown() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(FileCollection)
+
+		userId := currentUserID(c)
+		if id := c.Param("id"); id != "" {
+			objId, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				respondError(c, badRequest("Invalid user ID"))
+				return
+			}
+			userId = objId
+		}
+
+		breakdown, err := usageBreakdownFor(ctx, collection, userId)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, breakdown)
+	}
+}
+
+// usageBreakdownFor runs the breakdown aggregation for ownerId.
+func usageBreakdownFor(ctx context.Context, collection *mongo.Collection, ownerId primitive.ObjectID) (*usageBreakdown, error) {
+	live := bson.D{{Key: "$match", Value: bson.M{"deletedAt": notTrashed}}}
+	totals := bson.M{"count": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": storedBytesExpr}}
+
+	withTotals := func(key interface{}) bson.D {
+		group := bson.M{"_id": key}
+		for field, acc := range totals {
+			group[field] = acc
+		}
+		return bson.D{{Key: "$group", Value: group}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"ownerId": ownerId}}},
+		{{Key: "$facet", Value: bson.M{
+			"categories": bson.A{live, withTotals(usageCategoryExpr)},
+			"folders": bson.A{
+				live,
+				withTotals("$folderId"),
+				bson.D{{Key: "$lookup", Value: bson.M{"from": FolderCollection, "localField": "_id", "foreignField": "_id", "as": "folder"}}},
+				bson.D{{Key: "$set", Value: bson.M{"name": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$folder.name", 0}}, ""}}}}},
+				bson.D{{Key: "$project", Value: bson.M{"folder": 0}}},
+				bson.D{{Key: "$sort", Value: bson.D{{Key: "bytes", Value: -1}, {Key: "_id", Value: 1}}}},
+			},
+			"largest": bson.A{
+				live,
+				bson.D{{Key: "$sort", Value: bson.D{{Key: "size", Value: -1}, {Key: "_id", Value: 1}}}},
+				bson.D{{Key: "$limit", Value: 10}},
+				bson.D{{Key: "$project", Value: bson.M{"versions": 0}}},
+			},
+			"trash": bson.A{
+				bson.D{{Key: "$match", Value: bson.M{"deletedAt": bson.M{"$exists": true}}}},
+				withTotals(nil),
+			},
+		}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []usageBreakdown
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	breakdown := &usageBreakdown{}
+	if len(results) > 0 {
+		breakdown = &results[0]
+	}
+	if len(breakdown.TrashFacet) > 0 {
+		breakdown.Trash = breakdown.TrashFacet[0]
+	}
+
+	byCategory := make(map[string]categoryUsage, len(breakdown.Categories))
+	for _, category := range breakdown.Categories {
+		byCategory[category.Category] = category
+		breakdown.Total.Count += category.Count
+		breakdown.Total.Bytes += category.Bytes
+	}
+	breakdown.Categories = make([]categoryUsage, len(usageCategories))
+	for i, category := range usageCategories {
+		usage := byCategory[category]
+		usage.Category = category
+		breakdown.Categories[i] = usage
+	}
+
+	if breakdown.Folders == nil {
+		breakdown.Folders = []folderUsage{}
+	}
+	if breakdown.Largest == nil {
+		breakdown.Largest = []models.File{}
+	}
+	return breakdown, nil
+}
@@ -0,0 +1,132 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// usageRouter serves the breakdown at the paths BasicRoute gives it, for a
+// caller authenticated as userId with role.
+func usageRouter(uc *UserController, userId primitive.ObjectID, role string) *gin.Engine {
+	router := gin.New()
+	users := router.Group("/users", asUser(userId, role))
+	users.GET("/me/usage/breakdown", uc.GetUsageBreakdown())
+	users.GET("/:id/usage/breakdown", uc.GetUsageBreakdown())
+	return router
+}
+
+// breakdownOwner is the ownerId the breakdown aggregation matched on.
+func breakdownOwner(mt *mtest.T) primitive.ObjectID {
+	mt.Helper()
+	stages, _ := sentCommand(mt, "aggregate").Lookup("pipeline").Array().Values()
+	owner, _ := stages[0].Document().Lookup("$match", "ownerId").ObjectIDOK()
+	return owner
+}
+
+func TestGetUsageBreakdownShape(t *testing.T) {
+	mt := newMockDB(t)
+	caller := primitive.NewObjectID()
+	folder := primitive.NewObjectID()
+	largest := models.File{Id: primitive.NewObjectID(), OwnerId: caller, Name: "movie.mp4", Size: 700, ContentType: "video/mp4"}
+
+	mt.Run("populated", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, FileCollection, bson.M{
+			"categories": bson.A{
+				bson.M{"_id": "video", "count": 1, "bytes": 700},
+				bson.M{"_id": "images", "count": 2, "bytes": 300},
+			},
+			"folders": bson.A{
+				bson.M{"_id": folder, "name": "Movies", "count": 1, "bytes": 700},
+				bson.M{"_id": nil, "name": "", "count": 2, "bytes": 300},
+			},
+			"largest": bson.A{largest},
+			"trash":   bson.A{bson.M{"_id": nil, "count": 4, "bytes": 50}},
+		}))
+		rec := doRequest(usageRouter(NewUserController(mt.DB, testConfig()), caller, models.RoleUser), http.MethodGet, "/users/me/usage/breakdown", nil)
+
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if owner := breakdownOwner(mt); owner != caller {
+			mt.Errorf("matched ownerId %s, want the caller's", owner.Hex())
+		}
+
+		var body struct {
+			Total      usageTotals     `json:"total"`
+			Categories []categoryUsage `json:"categories"`
+			Folders    []folderUsage   `json:"folders"`
+			Largest    []models.File   `json:"largest"`
+			Trash      usageTotals     `json:"trash"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			mt.Fatal(err)
+		}
+		if body.Total != (usageTotals{Count: 3, Bytes: 1000}) {
+			mt.Errorf("total = %+v, want the live categories summed", body.Total)
+		}
+		if body.Trash != (usageTotals{Count: 4, Bytes: 50}) {
+			mt.Errorf("trash = %+v, want the trash facet", body.Trash)
+		}
+		want := []categoryUsage{
+			{Category: "images", Count: 2, Bytes: 300},
+			{Category: "video", Count: 1, Bytes: 700},
+			{Category: "documents"},
+			{Category: "archives"},
+			{Category: "other"},
+		}
+		if !reflect.DeepEqual(body.Categories, want) {
+			mt.Errorf("categories = %+v, want every category in order", body.Categories)
+		}
+		if len(body.Folders) != 2 || *body.Folders[0].FolderId != folder || body.Folders[1].FolderId != nil {
+			mt.Errorf("folders = %+v, want Movies then the root with a null id", body.Folders)
+		}
+		if len(body.Largest) != 1 || body.Largest[0].Id != largest.Id {
+			mt.Errorf("largest = %+v, want the stored file", body.Largest)
+		}
+	})
+
+	mt.Run("no files", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, FileCollection, bson.M{"categories": bson.A{}, "folders": bson.A{}, "largest": bson.A{}, "trash": bson.A{}}))
+		rec := doRequest(usageRouter(NewUserController(mt.DB, testConfig()), caller, models.RoleUser), http.MethodGet, "/users/me/usage/breakdown", nil)
+
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		want := `{"total":{"count":0,"bytes":0},"categories":[` +
+			`{"category":"images","count":0,"bytes":0},{"category":"video","count":0,"bytes":0},` +
+			`{"category":"documents","count":0,"bytes":0},{"category":"archives","count":0,"bytes":0},` +
+			`{"category":"other","count":0,"bytes":0}],"folders":[],"largest":[],"trash":{"count":0,"bytes":0}}`
+		if rec.Body.String() != want {
+			mt.Errorf("body = %s\nwant %s", rec.Body.String(), want)
+		}
+	})
+
+	mt.Run("another user", func(mt *mtest.T) {
+		other := primitive.NewObjectID()
+		mt.AddMockResponses(found(mt, FileCollection))
+		rec := doRequest(usageRouter(NewUserController(mt.DB, testConfig()), caller, models.RoleAdmin), http.MethodGet, "/users/"+other.Hex()+"/usage/breakdown", nil)
+
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if owner := breakdownOwner(mt); owner != other {
+			mt.Errorf("matched ownerId %s, want the :id user's", owner.Hex())
+		}
+	})
+
+	mt.Run("invalid id", func(mt *mtest.T) {
+		rec := doRequest(usageRouter(NewUserController(mt.DB, testConfig()), caller, models.RoleAdmin), http.MethodGet, "/users/nope/usage/breakdown", nil)
+
+		if rec.Code != http.StatusBadRequest {
+			mt.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
@@ -2,110 +2,578 @@ package routes
 
 import (
 	models "GinFrameWork/Models"
-	"context"
+	"errors"
+	"log"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var DataBaseName string = "Go_With"
 var UserCollection string = "users"
+var FileCollection string = "files"
+var ShareCollection string = "shares"
+var SessionCollection string = "sessions"
+
+// OrphanedOwnerID is the system owner that files are handed to when their
+// owner is deleted with ?keepFiles=true.
+var OrphanedOwnerID, _ = primitive.ObjectIDFromHex("ffffffffffffffffffffffff")
 
 type UserController struct {
-	client *mongo.Client
+	db  *mongo.Database
+	cfg *Config
 }
 
-func NewUserController(client *mongo.Client) *UserController {
-	return &UserController{client}
+func NewUserController(db *mongo.Database, cfg *Config) *UserController {
+	return &UserController{db, cfg}
 }
 
 // SetupRouter function
-func (uc *UserController) BasicRoute(router *gin.Engine, ctx context.Context) {
+func (uc *UserController) BasicRoute(router *gin.RouterGroup) {
+	limits := uc.cfg.RateLimits
 	userRouter := router.Group("/users")
-	userRouter.GET("/", uc.GetUsers(ctx))
-	userRouter.POST("/", uc.CreateUser(ctx))
-	userRouter.PATCH("/:id", uc.UpdateUser(ctx))
+	userRouter.POST("/", OptionalAuth(uc.db, uc.cfg), RequireScope(uc.cfg, scopeAccount), uc.CreateUser())
+	userRouter.GET("/:id/avatar", uc.GetAvatar())
+
+	protected := userRouter.Group("/", AuthRequired(uc.db, uc.cfg), RequireScope(uc.cfg, scopeAccount))
+	protected.GET("/", RequireRole(models.RoleAdmin), uc.GetUsers())
+	protected.GET("/search", RateLimit(uc.db, limits.Store, "user_search", limits.UserSearch, byUser), uc.SearchUsers())
+	protected.GET("/me", uc.GetProfile())
+	protected.PATCH("/me", uc.UpdateProfile())
+	protected.DELETE("/me", uc.DeleteMe())
+	protected.POST("/me/avatar", uc.UploadAvatar())
+	protected.DELETE("/me/avatar", uc.DeleteAvatar())
+	protected.GET("/me/usage", uc.GetUsage())
+	protected.GET("/me/activity", uc.GetActivity())
+	protected.GET("/me/usage/breakdown", uc.GetUsageBreakdown())
+	protected.POST("/me/password", uc.ChangePassword())
+	protected.POST("/me/2fa/setup", uc.SetupTwoFactor())
+	protected.POST("/me/2fa/enable", uc.EnableTwoFactor())
+	protected.POST("/me/2fa/disable", uc.DisableTwoFactor())
+	protected.GET("/me/apikeys", uc.GetAPIKeys())
+	protected.POST("/me/apikeys", uc.CreateAPIKey())
+	protected.DELETE("/me/apikeys/:id", uc.DeleteAPIKey())
+	protected.GET("/:id/usage/breakdown", RequireRole(models.RoleAdmin), uc.GetUsageBreakdown())
+	protected.GET("/:id", uc.GetUserByID())
+	protected.PATCH("/:id", uc.UpdateUser())
+	protected.PUT("/:id", uc.ReplaceUser())
+	protected.DELETE("/:id", RequireRole(models.RoleAdmin), uc.DeleteUser())
+}
+
+// userListFilter builds the GetUsers query from ?role=, ?status= and the
+// ?from=/?to= creation time range. Deleted accounts are only listed with
+// ?status=deleted.
+func userListFilter(c *gin.Context) (bson.M, error) {
+	filter := bson.M{"deletedAt": userNotDeleted}
+
+	switch role := c.Query("role"); role {
+	case "":
+	case models.RoleUser, models.RoleAdmin:
+		filter["role"] = role
+	default:
+		return nil, errors.New("role must be one of: user, admin")
+	}
+
+	switch status := c.Query("status"); status {
+	case "":
+	case models.UserStatusActive:
+		filter["status"] = bson.M{"$ne": models.UserStatusSuspended}
+	case models.UserStatusSuspended:
+		filter["status"] = status
+	case "deleted":
+		filter["deletedAt"] = bson.M{"$exists": true}
+	default:
+		return nil, errors.New("status must be one of: active, suspended, deleted")
+	}
+
+	if err := createdAtFilter(c, filter); err != nil {
+		return nil, err
+	}
+	return filter, nil
 }
 
 // GetUsers handler
-func (uc *UserController) GetUsers(ctx context.Context) gin.HandlerFunc {
+func (uc *UserController) GetUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		collection := uc.client.Database(DataBaseName).Collection(UserCollection)
-		cursor, err := collection.Find(ctx, bson.D{})
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		projection, err := parseProjection(c, userFields)
+		if err != nil {
+			respondError(c, invalidRequest(err).withDetails(gin.H{"allowedFields": allowedFieldNames(userFields)}))
+			return
+		}
+
+		sortDoc, err := parseSortOptions(c, userSortFields, "createdAt", "desc")
+		if err != nil {
+			respondError(c, invalidRequest(err).withDetails(gin.H{"allowedSortFields": allowedFieldNames(userSortFields)}))
+			return
+		}
+
+		filter, err := userListFilter(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		// avatarUrl is built from the user id, so it is fetched even when
+		// only the avatar was asked for, and dropped again afterwards.
+		dropId := false
+		if projectsKey(projection, "avatarId") {
+			for i, field := range projection {
+				if field.Key == "_id" && field.Value == 0 {
+					projection = append(projection[:i], projection[i+1:]...)
+					dropId = true
+					break
+				}
+			}
+		}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		cursor, err := collection.Find(ctx, filter, page.FindOptions().SetProjection(projection).SetSort(sortDoc))
+		if err != nil {
+			respondError(c, err)
 			return
 		}
 		defer cursor.Close(ctx)
 
-		var users []bson.M
+		users := []bson.M{}
 		if err = cursor.All(ctx, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, err)
 			return
 		}
+		setAvatarURLs(uc.cfg, users, dropId)
 
-		c.JSON(http.StatusOK, users)
+		c.JSON(http.StatusOK, page.Result(users, total))
+	}
+}
+
+// GetUserByID handler
+func (uc *UserController) GetUserByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		id := c.Param("id")
+		objId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		if !authorizeUser(c, objId) {
+			return
+		}
+
+		var user models.User
+		if err := collection.FindOne(ctx, bson.M{"_id": objId}).Decode(&user); err != nil {
+			respondError(c, orNotFound(err, "User not found"))
+			return
+		}
+
+		if user.AvatarId != nil {
+			user.AvatarURL = uc.cfg.avatarURL(user.Id, *user.AvatarId)
+		}
+		c.JSON(http.StatusOK, user)
 	}
 }
 
 // CreateUser handler
-func (uc *UserController) CreateUser(ctx context.Context) gin.HandlerFunc {
+func (uc *UserController) CreateUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		collection := uc.client.Database(DataBaseName).Collection(UserCollection)
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
 		var user models.User
 
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := c.ShouldBindJSON(&user); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		hash, err := hashPassword(user.Password)
+		if err != nil {
+			respondError(c, invalidRequest(err))
 			return
 		}
 
 		user.Id = primitive.NewObjectID()
+		user.Password = hash
+		user.Email = normalizeEmail(user.Email)
+		user.Status = models.UserStatusActive
+		user.UsedBytes = 0
+		verified := !uc.cfg.RequireEmailVerification
+		user.EmailVerified = &verified
+		user.TwoFactorEnabled = false
+		user.Identities = nil
+		user.MustResetPassword = false
+		user.DeletedAt = nil
+		user.DeletionKeepsFiles = false
+		user.Live = true
+		if !isAdmin(c) {
+			user.QuotaBytes = nil
+			user.DownloadRate = nil
+		}
+
+		if !isAdmin(c) || user.Role == "" {
+			user.Role = models.RoleUser
+		}
 
+		if user.Role != models.RoleAdmin && uc.cfg.BootstrapFirstAdmin {
+			count, err := collection.CountDocuments(ctx, bson.D{})
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			if count == 0 {
+				user.Role = models.RoleAdmin
+			}
+		}
+		user.CreatedAt = time.Now()
+
+		if respondPendingDeletion(ctx, c, uc.db, uc.cfg.UserDeletionGrace, user.Email) {
+			return
+		}
 		if result, err := collection.InsertOne(ctx, user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, orConflict(err, "email already registered"))
 			return
 		} else {
+			actorId := currentUserID(c)
+			if actorId.IsZero() {
+				actorId = user.Id
+			}
+			auditAs(c, actorId, AuditUserCreated, auditTargetUser, user.Id, gin.H{"role": user.Role})
+			if !verified {
+				if err := sendVerification(ctx, uc.db, uc.cfg, &user); err != nil {
+					log.Printf("sending verification to user %s failed: %v", user.Id.Hex(), err)
+				}
+			}
 			c.JSON(http.StatusOK, gin.H{"insertedID": result.InsertedID, "message": "User created successfully"})
 		}
 	}
 }
 
+// UpdateUserRequest lists the fields UpdateUser may change. Fields left nil
+// are not touched; ReplaceUserRequest is the PUT counterpart that resets
+// them.
+type UpdateUserRequest struct {
+	Name     *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Email    *string `json:"email" binding:"omitempty,email"`
+	Password *string `json:"password" binding:"omitempty,min=8"`
+	Role     *string `json:"role" binding:"omitempty,oneof=user admin"`
+	// Password is admin only: users change their own through ChangePassword.
+	// QuotaBytes sets the user's storage quota and DownloadRate their
+	// download bandwidth; admin only.
+	QuotaBytes            *int64 `json:"quotaBytes" binding:"omitempty,min=0"`
+	DownloadRate          *int64 `json:"downloadRate" binding:"omitempty,min=0"`
+	DisableAccessTracking *bool  `json:"disableAccessTracking"`
+	DisableShareEmails    *bool  `json:"disableShareEmails"`
+}
+
+// setDocument builds the $set document from the fields that were provided.
+func (r UpdateUserRequest) setDocument() (bson.M, error) {
+	set := bson.M{}
+
+	if r.Name != nil {
+		set["name"] = *r.Name
+	}
+
+	if r.Email != nil {
+		set["email"] = normalizeEmail(*r.Email)
+	}
+
+	if r.Password != nil {
+		hash, err := hashPassword(*r.Password)
+		if err != nil {
+			return nil, err
+		}
+		set["password"] = hash
+	}
+
+	if r.Role != nil {
+		set["role"] = *r.Role
+	}
+
+	if r.QuotaBytes != nil {
+		set["quotaBytes"] = *r.QuotaBytes
+	}
+
+	if r.DownloadRate != nil {
+		set["downloadRate"] = *r.DownloadRate
+	}
+
+	if r.DisableAccessTracking != nil {
+		set["disableAccessTracking"] = *r.DisableAccessTracking
+	}
+
+	if r.DisableShareEmails != nil {
+		set["disableShareEmails"] = *r.DisableShareEmails
+	}
+
+	if len(set) == 0 {
+		return nil, errors.New("no updatable fields provided")
+	}
+	return set, nil
+}
+
+// updatedFieldNames lists the keys of a $set document for the audit log. The
+// values are left out so no password hash ends up there.
+func updatedFieldNames(set bson.M) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // UpdateUser handler
-func (uc *UserController) UpdateUser(ctx context.Context) gin.HandlerFunc {
+func (uc *UserController) UpdateUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		collection := uc.client.Database(DataBaseName).Collection(UserCollection)
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
 
 		id := c.Param("id")
 		objId, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		if !authorizeUser(c, objId) {
+			return
+		}
+
+		var req UpdateUserRequest
+		if err := decodeStrictJSON(c, &req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		if req.Role != nil && !isAdmin(c) {
+			respondError(c, forbidden("only admins can change roles"))
 			return
 		}
 
-		var updatedData map[string]interface{}
-		if err := c.BindJSON(&updatedData); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if req.QuotaBytes != nil && !isAdmin(c) {
+			respondError(c, forbidden("only admins can change quotas"))
 			return
 		}
 
+		if req.DownloadRate != nil && !isAdmin(c) {
+			respondError(c, forbidden("only admins can change download rates"))
+			return
+		}
+
+		// Users change their own password through ChangePassword, which
+		// checks the current one first.
+		if req.Password != nil && !isAdmin(c) {
+			respondError(c, forbidden("use POST /users/me/password to change your password"))
+			return
+		}
+
+		updatedData, err := req.setDocument()
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		// A new address has to be verified again before the account can
+		// upload or share.
+		reverify := false
+		if req.Email != nil && uc.cfg.RequireEmailVerification {
+			var current models.User
+			opts := options.FindOne().SetProjection(bson.M{"email": 1})
+			if err := collection.FindOne(ctx, bson.M{"_id": objId}, opts).Decode(&current); err != nil {
+				respondError(c, orNotFound(err, "User not found"))
+				return
+			}
+			if current.Email != updatedData["email"] {
+				updatedData["emailVerified"] = false
+				reverify = true
+			}
+		}
+
 		update := bson.M{"$set": updatedData}
 
 		filter := bson.M{"_id": objId}
 
-		result, err := collection.UpdateOne(ctx, filter, update)
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var user models.User
+		if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user); err != nil {
+			respondError(c, orNotFound(orConflict(err, "email already registered"), "User not found"))
+			return
+		}
+
+		// A password set by an admin signs the user out everywhere, as
+		// ChangePassword does.
+		if req.Password != nil {
+			if err := revokeUserSessions(ctx, uc.db, objId); err != nil {
+				respondError(c, err)
+				return
+			}
+			events.disconnect(objId, primitive.NilObjectID)
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, objId, gin.H{"fields": updatedFieldNames(updatedData)})
+
+		if reverify {
+			if err := sendVerification(ctx, uc.db, uc.cfg, &user); err != nil {
+				log.Printf("sending verification to user %s failed: %v", objId.Hex(), err)
+			}
+		}
+
+		// Opting out also forgets the history recorded so far.
+		if req.DisableAccessTracking != nil && *req.DisableAccessTracking {
+			accesses := uc.db.Collection(FileAccessCollection)
+			if _, err := accesses.DeleteMany(ctx, bson.M{"userId": objId}); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+
+		if user.AvatarId != nil {
+			user.AvatarURL = uc.cfg.avatarURL(user.Id, *user.AvatarId)
+		}
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+// ChangePassword handler replaces the caller's password. Every existing
+// session is revoked and a fresh one is returned so only this client stays
+// signed in.
+func (uc *UserController) ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		var req changePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		var user models.User
+		if err := collection.FindOne(ctx, bson.M{"_id": currentUserID(c)}).Decode(&user); err != nil {
+			respondError(c, orNotFound(err, "User not found"))
+			return
+		}
+
+		if !checkPassword(user.Password, req.CurrentPassword) {
+			respondError(c, badRequest("current password is incorrect"))
+			return
+		}
+
+		hash, err := hashPassword(req.NewPassword)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, invalidRequest(err))
 			return
 		}
 
+		// Matching the old hash makes a concurrent change fail instead of
+		// being silently overwritten.
+		filter := bson.M{"_id": user.Id, "password": user.Password}
+		result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"password": hash}})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
 		if result.MatchedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"message": "User not found"})
+			respondError(c, conflict("password was changed concurrently, try again"))
+			return
+		}
+
+		if err := revokeUserSessions(ctx, uc.db, user.Id); err != nil {
+			respondError(c, err)
+			return
+		}
+		events.disconnect(user.Id, primitive.NilObjectID)
+
+		sessionId, refreshToken, err := createSession(ctx, uc.db, uc.cfg.RefreshTokenTTL, user.Id, c)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		token, expiresAt, err := issueAccessToken(uc.cfg, user, sessionId)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, user.Id, gin.H{"fields": []string{"password"}})
+		c.JSON(http.StatusOK, tokenResponse(token, expiresAt, refreshToken))
+	}
+}
+
+// DeleteUser handler deletes an account as DeleteMe does, for admins. With
+// ?permanent=true it is purged right away instead of after
+// Config.UserDeletionGrace. ?keepFiles=true hands its files to OrphanedOwnerID
+// rather than deleting them.
+func (uc *UserController) DeleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		id := c.Param("id")
+		objId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		if !authorizeUser(c, objId) {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
+		keepFiles := c.Query("keepFiles") == "true"
+
+		if c.Query("permanent") != "true" {
+			uc.deleteAccount(c, objId, keepFiles)
+			return
+		}
+
+		deleted, err := purgeUser(ctx, uc.db, uc.cfg, objId, nil, keepFiles)
+		if deleted {
+			audit(c, AuditUserDeleted, auditTargetUser, objId, gin.H{"keepFiles": keepFiles, "permanent": true})
+		}
+		if err != nil {
+			respondError(c, orNotFound(err, "User not found"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 	}
 }
+
+// normalizeEmail lowercases and trims an address so the unique index on
+// users.email is effectively case-insensitive.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,562 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// userRouter serves uc's handlers at the paths BasicRoute gives them, for a
+// caller authenticated as userId with role.
+func userRouter(uc *UserController, userId primitive.ObjectID, role string) *gin.Engine {
+	router := gin.New()
+	users := router.Group("/users", asUser(userId, role))
+	users.POST("/", uc.CreateUser())
+	users.GET("/", uc.GetUsers())
+	users.POST("/me/password", uc.ChangePassword())
+	users.GET("/:id", uc.GetUserByID())
+	users.PATCH("/:id", uc.UpdateUser())
+	users.PUT("/:id", uc.ReplaceUser())
+	return router
+}
+
+func TestGetUserByID(t *testing.T) {
+	mt := newMockDB(t)
+	stored := models.User{
+		Id:       primitive.NewObjectID(),
+		Name:     "Ada",
+		Email:    "ada@example.com",
+		Password: "$2a$10$abcdefghijklmnopqrstuv",
+		Role:     models.RoleUser,
+	}
+
+	mt.Run("found", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, stored))
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), stored.Id, models.RoleUser), http.MethodGet, "/users/"+stored.Id.Hex(), nil)
+
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		body := decodeJSON(mt, rec)
+		if body["id"] != stored.Id.Hex() || body["email"] != stored.Email {
+			mt.Errorf("body = %v, want the stored user", body)
+		}
+		if _, ok := body["password"]; ok {
+			mt.Error("response carries the password hash")
+		}
+	})
+
+	mt.Run("unknown id", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection))
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), primitive.NewObjectID(), models.RoleAdmin), http.MethodGet, "/users/"+primitive.NewObjectID().Hex(), nil)
+
+		if rec.Code != http.StatusNotFound {
+			mt.Fatalf("status = %d, want 404", rec.Code)
+		}
+		if body := decodeError(mt.T, rec); body.Error.Message != "User not found" {
+			mt.Errorf("message = %q", body.Error.Message)
+		}
+	})
+
+	mt.Run("malformed id", func(mt *mtest.T) {
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), primitive.NewObjectID(), models.RoleAdmin), http.MethodGet, "/users/not-an-id", nil)
+
+		if rec.Code != http.StatusBadRequest {
+			mt.Fatalf("status = %d, want 400", rec.Code)
+		}
+		if body := decodeError(mt.T, rec); body.Error.Code != ErrCodeInvalidRequest {
+			mt.Errorf("code = %q, want %q", body.Error.Code, ErrCodeInvalidRequest)
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			mt.Error("a malformed id reached the database")
+		}
+	})
+
+	mt.Run("someone else", func(mt *mtest.T) {
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), primitive.NewObjectID(), models.RoleUser), http.MethodGet, "/users/"+stored.Id.Hex(), nil)
+
+		if rec.Code != http.StatusForbidden {
+			mt.Errorf("status = %d, want 403", rec.Code)
+		}
+	})
+}
+
+// counted is the server's answer to the aggregate CountDocuments sends.
+func counted(t testing.TB, collection string, n int) bson.D {
+	return found(t, collection, bson.M{"n": n})
+}
+
+func TestGetUsersFields(t *testing.T) {
+	mt := newMockDB(t)
+	listed := models.User{Id: primitive.NewObjectID(), Name: "Ada", Email: "ada@example.com", Password: "$2a$10$hash"}
+
+	// list serves GET /users/ with query and returns the projection it sent.
+	// The mock doesn't apply it, so that is what the test checks.
+	list := func(mt *mtest.T, query string) (*httptest.ResponseRecorder, bson.Raw) {
+		mt.AddMockResponses(counted(mt, UserCollection, 1), found(mt, UserCollection, listed))
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), primitive.NewObjectID(), models.RoleAdmin), http.MethodGet, "/users/"+query, nil)
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		return rec, sentCommand(mt, "find").Lookup("projection").Document()
+	}
+
+	mt.Run("default", func(mt *mtest.T) {
+		_, projection := list(mt, "")
+		for name, key := range userFields {
+			if projection.Lookup(key).IsZero() {
+				mt.Errorf("%s is not projected", name)
+			}
+		}
+		for _, key := range []string{"password", "totpSecret", "recoveryCodes"} {
+			if !projection.Lookup(key).IsZero() {
+				mt.Errorf("%s is projected", key)
+			}
+		}
+	})
+
+	mt.Run("subset", func(mt *mtest.T) {
+		_, projection := list(mt, "?fields=name,email")
+		elements, _ := projection.Elements()
+		keys := []string{}
+		for _, element := range elements {
+			if element.Value().Int32() == 1 {
+				keys = append(keys, element.Key())
+			}
+		}
+		if strings.Join(keys, ",") != "name,email" || projection.Lookup("_id").Int32() != 0 {
+			mt.Errorf("projection = %s, want name and email without _id", projection)
+		}
+	})
+
+	mt.Run("disallowed field", func(mt *mtest.T) {
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), primitive.NewObjectID(), models.RoleAdmin), http.MethodGet, "/users/?fields=name,password", nil)
+		if rec.Code != http.StatusBadRequest {
+			mt.Fatalf("status = %d, want 400", rec.Code)
+		}
+		body := decodeError(mt.T, rec)
+		allowed, _ := body.Error.Details["allowedFields"].([]any)
+		if len(allowed) != len(userFields) {
+			mt.Errorf("allowedFields = %v, want all %d allowed fields", body.Error.Details["allowedFields"], len(userFields))
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			mt.Error("a disallowed projection reached the database")
+		}
+	})
+}
+
+// createUserConfig is a config under which CreateUser does nothing but the
+// insert: no verification email to send.
+func createUserConfig() *Config {
+	cfg := testConfig()
+	cfg.RequireEmailVerification = false
+	return cfg
+}
+
+func TestCreateUserRejectsDuplicateEmail(t *testing.T) {
+	mt := newMockDB(t)
+	body := gin.H{"name": "Ada", "email": "Ada@Example.COM", "password": "correct horse"}
+
+	mt.Run("normalized", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection), mtest.CreateSuccessResponse())
+		rec := doRequest(userRouter(NewUserController(mt.DB, createUserConfig()), primitive.NilObjectID, ""), http.MethodPost, "/users/", body)
+
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		inserted := sentCommand(mt, "insert").Lookup("documents", "0").Document()
+		if email := inserted.Lookup("email").StringValue(); email != "ada@example.com" {
+			mt.Errorf("stored email = %q, want it lowercased", email)
+		}
+		if live, ok := inserted.Lookup("live").BooleanOK(); !ok || !live {
+			mt.Error("the new account is not live, so its email is not unique")
+		}
+	})
+
+	mt.Run("taken", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection), duplicateKey())
+		rec := doRequest(userRouter(NewUserController(mt.DB, createUserConfig()), primitive.NilObjectID, ""), http.MethodPost, "/users/", body)
+
+		if rec.Code != http.StatusConflict {
+			mt.Fatalf("status = %d, want 409", rec.Code)
+		}
+		if got := decodeError(mt.T, rec); got.Error.Message != "email already registered" {
+			mt.Errorf("message = %q", got.Error.Message)
+		}
+	})
+
+	mt.Run("taken by a deleted account", func(mt *mtest.T) {
+		deletedAt := time.Now().Add(-time.Hour)
+		mt.AddMockResponses(found(mt, UserCollection, bson.M{"_id": primitive.NewObjectID(), "deletedAt": deletedAt}))
+		rec := doRequest(userRouter(NewUserController(mt.DB, createUserConfig()), primitive.NilObjectID, ""), http.MethodPost, "/users/", body)
+
+		if rec.Code != http.StatusConflict {
+			mt.Fatalf("status = %d, want 409", rec.Code)
+		}
+		if got := decodeError(mt.T, rec); got.Error.Details["purgeAt"] == nil {
+			mt.Errorf("details = %v, want when the email frees up", got.Error.Details)
+		}
+		if n := len(mt.GetAllStartedEvents()); n != 1 {
+			mt.Errorf("sent %d commands, want no insert", n)
+		}
+	})
+}
+
+func TestUserEmailIndexIsUnique(t *testing.T) {
+	for _, index := range requiredIndexes(testConfig())[UserCollection] {
+		keys := index.Keys.(bson.D)
+		if len(keys) > 0 && keys[0].Key == "email" {
+			if index.Options.Unique == nil || !*index.Options.Unique {
+				t.Error("the email index is not unique")
+			}
+			// Deleted accounts are not live, so they give up their email.
+			if want := (bson.M{"live": true}); !reflect.DeepEqual(index.Options.PartialFilterExpression, want) {
+				t.Errorf("partial filter = %v, want %v", index.Options.PartialFilterExpression, want)
+			}
+			return
+		}
+	}
+	t.Error("no index on users.email")
+}
+
+func TestCreateUserHashesPassword(t *testing.T) {
+	mt := newMockDB(t)
+
+	mt.Run("hashed", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection), mtest.CreateSuccessResponse())
+		rec := doRequest(userRouter(NewUserController(mt.DB, createUserConfig()), primitive.NilObjectID, ""), http.MethodPost, "/users/", gin.H{"name": "Ada", "email": "ada@example.com", "password": "correct horse"})
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		stored := sentCommand(mt, "insert").Lookup("documents", "0", "password").StringValue()
+		if !checkPassword(stored, "correct horse") {
+			mt.Errorf("stored password %q is not a bcrypt hash of the one sent", stored)
+		}
+		if strings.Contains(rec.Body.String(), "password") {
+			mt.Errorf("response mentions the password: %s", rec.Body.String())
+		}
+	})
+
+	mt.Run("too short", func(mt *mtest.T) {
+		rec := doRequest(userRouter(NewUserController(mt.DB, createUserConfig()), primitive.NilObjectID, ""), http.MethodPost, "/users/", gin.H{"name": "Ada", "email": "ada@example.com", "password": "short"})
+		if rec.Code != http.StatusBadRequest {
+			mt.Fatalf("status = %d, want 400", rec.Code)
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			mt.Error("a short password was stored")
+		}
+	})
+}
+
+func TestUpdateUserHashesPassword(t *testing.T) {
+	mt := newMockDB(t)
+	userId := primitive.NewObjectID()
+
+	mt.Run("hashed", func(mt *mtest.T) {
+		updated := bsonDoc(mt, models.User{Id: userId, Name: "Ada", Email: "ada@example.com", Password: "$2a$10$stored"})
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: updated}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}))
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), primitive.NewObjectID(), models.RoleAdmin), http.MethodPatch, "/users/"+userId.Hex(), gin.H{"password": "battery staple"})
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		stored := sentCommand(mt, "findAndModify").Lookup("update", "$set", "password").StringValue()
+		if !checkPassword(stored, "battery staple") {
+			mt.Errorf("$set password %q is not a bcrypt hash of the new one", stored)
+		}
+		if _, ok := decodeJSON(mt, rec)["password"]; ok {
+			mt.Error("response carries the password hash")
+		}
+
+		// The user's sessions are all revoked.
+		revoke := sentCommand(mt, "update")
+		filter := revoke.Lookup("updates").Array().Index(0).Value().Document().Lookup("q").Document()
+		if revoke.Lookup("update").StringValue() != SessionCollection || filter.Lookup("userId").ObjectID() != userId {
+			mt.Errorf("sent %s, want the user's sessions revoked", revoke)
+		}
+	})
+
+	mt.Run("from the user", func(mt *mtest.T) {
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), userId, models.RoleUser), http.MethodPatch, "/users/"+userId.Hex(), gin.H{"password": "battery staple"})
+		if rec.Code != http.StatusForbidden {
+			mt.Fatalf("status = %d, want 403", rec.Code)
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			mt.Error("the password change reached the database")
+		}
+	})
+
+	mt.Run("too short", func(mt *mtest.T) {
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), primitive.NewObjectID(), models.RoleAdmin), http.MethodPatch, "/users/"+userId.Hex(), gin.H{"password": "short"})
+		if rec.Code != http.StatusBadRequest {
+			mt.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestUpdateUserAllowlist(t *testing.T) {
+	mt := newMockDB(t)
+	userId := primitive.NewObjectID()
+	patch := func(mt *mtest.T, role string, body any) *httptest.ResponseRecorder {
+		return doRequest(userRouter(NewUserController(mt.DB, testConfig()), userId, role), http.MethodPatch, "/users/"+userId.Hex(), body)
+	}
+
+	mt.Run("role from a non-admin", func(mt *mtest.T) {
+		rec := patch(mt, models.RoleUser, gin.H{"role": models.RoleAdmin})
+		if rec.Code != http.StatusForbidden {
+			mt.Fatalf("status = %d, want 403", rec.Code)
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			mt.Error("the role change reached the database")
+		}
+	})
+
+	for name, body := range map[string]any{
+		"unknown key":   gin.H{"name": "Ada", "createdAt": "2020-01-01T00:00:00Z"},
+		"id":            gin.H{"_id": primitive.NewObjectID().Hex()},
+		"operator":      gin.H{"$set": gin.H{"role": models.RoleAdmin}},
+		"nothing":       gin.H{},
+		"invalid email": gin.H{"email": "not-an-address"},
+	} {
+		mt.Run(name, func(mt *mtest.T) {
+			rec := patch(mt, models.RoleUser, body)
+			if rec.Code != http.StatusBadRequest {
+				mt.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+			if len(mt.GetAllStartedEvents()) != 0 {
+				mt.Error("the update reached the database")
+			}
+		})
+	}
+
+	mt.Run("only provided fields", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bsonDoc(mt, models.User{Id: userId, Name: "Grace"})}))
+		rec := patch(mt, models.RoleUser, gin.H{"name": "Grace"})
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		set, _ := sentCommand(mt, "findAndModify").Lookup("update", "$set").Document().Elements()
+		if len(set) != 1 || set[0].Key() != "name" {
+			mt.Errorf("$set = %v, want only name", set)
+		}
+	})
+
+	mt.Run("email taken", func(mt *mtest.T) {
+		cfg := testConfig()
+		cfg.RequireEmailVerification = false
+		mt.AddMockResponses(duplicateKey())
+		rec := doRequest(userRouter(NewUserController(mt.DB, cfg), userId, models.RoleUser), http.MethodPatch, "/users/"+userId.Hex(), gin.H{"email": "Grace@example.com"})
+		if rec.Code != http.StatusConflict {
+			mt.Fatalf("status = %d, want 409", rec.Code)
+		}
+		if email := sentCommand(mt, "findAndModify").Lookup("update", "$set", "email").StringValue(); email != "grace@example.com" {
+			mt.Errorf("$set email = %q, want it lowercased", email)
+		}
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	mt := newMockDB(t)
+	hash, err := hashPassword("old password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Id: primitive.NewObjectID(), Name: "Ada", Email: "ada@example.com", Password: hash, Role: models.RoleUser}
+	change := func(mt *mtest.T, current, next string) *httptest.ResponseRecorder {
+		return doRequest(userRouter(NewUserController(mt.DB, testConfig()), user.Id, user.Role), http.MethodPost, "/users/me/password", gin.H{"currentPassword": current, "newPassword": next})
+	}
+	updated := func(n int) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+	}
+
+	mt.Run("revokes other sessions", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, user), updated(1), updated(2), mtest.CreateSuccessResponse())
+		rec := change(mt, "old password", "new password")
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		stored := sentCommand(mt, "update").Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set", "password").StringValue()
+		if !checkPassword(stored, "new password") {
+			mt.Errorf("stored password %q is not a bcrypt hash of the new one", stored)
+		}
+
+		// Revoking matches every open session of the user, including the one
+		// that made the change; the session handed back is created afterwards.
+		var revoke bson.Raw
+		revokedAt, createdAt := -1, -1
+		for i, event := range mt.GetAllStartedEvents() {
+			switch {
+			case event.CommandName == "update" && event.Command.Lookup("update").StringValue() == SessionCollection:
+				revoke, revokedAt = event.Command, i
+			case event.CommandName == "insert" && event.Command.Lookup("insert").StringValue() == SessionCollection:
+				createdAt = i
+			}
+		}
+		if revoke == nil || createdAt < revokedAt {
+			mt.Fatalf("revoked at command %d, created the new session at %d: want revoking first", revokedAt, createdAt)
+		}
+		revoking := revoke.Lookup("updates").Array().Index(0).Value().Document()
+		if !revoking.Lookup("multi").Boolean() {
+			mt.Error("revoking updates only one session")
+		}
+		filter := revoking.Lookup("q").Document()
+		if elems, _ := filter.Elements(); len(elems) != 2 || filter.Lookup("userId").ObjectID() != user.Id || filter.Lookup("revokedAt", "$exists").Boolean() {
+			mt.Errorf("revoked sessions matching %s, want all of the user's", filter)
+		}
+
+		var session models.Session
+		if err := bson.Unmarshal(sentCommand(mt, "insert").Lookup("documents").Array().Index(0).Value().Document(), &session); err != nil {
+			mt.Fatal(err)
+		}
+		body := decodeJSON(mt, rec)
+		claims, err := parseAccessToken(testConfig().JWTSecret, body["accessToken"].(string))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if claims.session() != session.Id {
+			mt.Errorf("access token names session %s, want the new session %s", claims.SessionId, session.Id.Hex())
+		}
+		if hashToken(body["refreshToken"].(string)) != session.TokenHash {
+			mt.Error("refresh token does not belong to the new session")
+		}
+	})
+
+	mt.Run("wrong current password", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, user))
+		rec := change(mt, "not the password", "new password")
+		if rec.Code != http.StatusBadRequest {
+			mt.Fatalf("status = %d, want 400", rec.Code)
+		}
+		if body := decodeError(mt.T, rec); body.Error.Message != "current password is incorrect" {
+			mt.Errorf("message = %q, want the wrong password named", body.Error.Message)
+		}
+		if len(mt.GetAllStartedEvents()) != 1 {
+			mt.Error("a wrong password still changed something")
+		}
+	})
+
+	mt.Run("new password too short", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, user))
+		rec := change(mt, "old password", "short")
+		if rec.Code != http.StatusBadRequest {
+			mt.Fatalf("status = %d, want 400", rec.Code)
+		}
+		if body := decodeError(mt.T, rec); body.Error.Message == "current password is incorrect" {
+			mt.Error("policy failure reported as a wrong current password")
+		}
+	})
+
+	mt.Run("changed concurrently", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, user), updated(0))
+		if rec := change(mt, "old password", "new password"); rec.Code != http.StatusConflict {
+			mt.Errorf("status = %d, want 409", rec.Code)
+		}
+	})
+}
+
+func TestGetUsersAvatarURL(t *testing.T) {
+	mt := newMockDB(t)
+	avatarId := primitive.NewObjectID()
+	listed := bson.M{"_id": primitive.NewObjectID(), "name": "Ada", "avatarId": avatarId}
+
+	for _, query := range []string{"", "?fields=id,avatarUrl", "?fields=avatarUrl"} {
+		mt.Run(query, func(mt *mtest.T) {
+			mt.AddMockResponses(counted(mt, UserCollection, 1), found(mt, UserCollection, listed))
+			cfg := testConfig()
+			rec := doRequest(userRouter(NewUserController(mt.DB, cfg), primitive.NewObjectID(), models.RoleAdmin), http.MethodGet, "/users/"+query, nil)
+			if rec.Code != http.StatusOK {
+				mt.Fatalf("status = %d, want 200", rec.Code)
+			}
+
+			items, _ := decodeJSON(mt, rec)["items"].([]any)
+			if len(items) != 1 {
+				mt.Fatalf("items = %v", items)
+			}
+			user := items[0].(map[string]any)
+			if user["avatarUrl"] != cfg.avatarURL(listed["_id"].(primitive.ObjectID), avatarId) {
+				mt.Errorf("avatarUrl = %v", user["avatarUrl"])
+			}
+			if _, hasId := user["_id"]; hasId != (query != "?fields=avatarUrl") {
+				mt.Errorf("_id returned = %v for %q", hasId, query)
+			}
+			if _, ok := user["avatarId"]; ok {
+				mt.Error("avatarId leaked into the response")
+			}
+		})
+	}
+}
+
+func TestGetUserByIDStopsWhenClientGoesAway(t *testing.T) {
+	server := newStallingMongo(t, "find")
+	uc := NewUserController(server.client(t).Database("test"), testConfig())
+	userId := primitive.NewObjectID()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/users/"+userId.Hex(), nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		userRouter(uc, userId, models.RoleUser).ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	select {
+	case <-server.stalled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query never reached the server")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler still waits on the query after the client went away")
+	}
+}
+
+func TestUpdateUserReturnsUpdatedUser(t *testing.T) {
+	mt := newMockDB(t)
+	userId := primitive.NewObjectID()
+	patch := func(mt *mtest.T) *httptest.ResponseRecorder {
+		return doRequest(userRouter(NewUserController(mt.DB, testConfig()), userId, models.RoleUser), http.MethodPatch, "/users/"+userId.Hex(), gin.H{"name": "Ada Lovelace"})
+	}
+
+	mt.Run("updated", func(mt *mtest.T) {
+		updated := bsonDoc(mt, models.User{Id: userId, Name: "Ada Lovelace", Email: "ada@example.com", Password: "$2a$10$stored"})
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: updated}))
+		rec := patch(mt)
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		if !sentCommand(mt, "findAndModify").Lookup("new").Boolean() {
+			mt.Error("the update does not ask for the document after it")
+		}
+		body := decodeJSON(mt, rec)
+		if body["name"] != "Ada Lovelace" || body["email"] != "ada@example.com" || body["id"] != userId.Hex() {
+			mt.Errorf("body = %v, want the patched user", body)
+		}
+		if _, ok := body["password"]; ok {
+			mt.Error("response carries the password hash")
+		}
+	})
+
+	mt.Run("gone", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+		if rec := patch(mt); rec.Code != http.StatusNotFound {
+			mt.Errorf("status = %d, want 404: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// userNotDeleted matches accounts that are not waiting to be purged when used
+// as the "deletedAt" condition of a query.
+var userNotDeleted = bson.M{"$exists": false}
+
+// userPurgeLock names the lease that keeps user purge runs on one instance.
+const userPurgeLock = "user-purge"
+
+var errUserPurgeRunning = errors.New("a user purge is already running")
+
+// DeleteMe handler deletes the caller's own account. It stops working at
+// once and is purged with its files after Config.UserDeletionGrace, until
+// when an admin can restore it.
+func (uc *UserController) DeleteMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uc.deleteAccount(c, currentUserID(c), false)
+	}
+}
+
+// deleteAccount marks the account of userId deleted, revokes its sessions
+// and disables its share links in one transaction, and writes the response.
+// The document stays until purgeDeletedUsers removes it.
+func (uc *UserController) deleteAccount(c *gin.Context, userId primitive.ObjectID, keepFiles bool) {
+	ctx := c.Request.Context()
+
+	db := uc.db
+	now := time.Now()
+
+	err := withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+		set := bson.M{"deletedAt": now}
+		if keepFiles {
+			set["deletionKeepsFiles"] = true
+		}
+		update := bson.M{"$set": set, "$unset": bson.M{"live": ""}}
+		result, err := db.Collection(UserCollection).UpdateOne(ctx, bson.M{"_id": userId, "deletedAt": userNotDeleted}, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+
+		if err := revokeUserSessions(ctx, db, userId); err != nil {
+			return err
+		}
+
+		_, err = db.Collection(ShareCollection).UpdateMany(ctx, bson.M{"ownerId": userId}, bson.M{"$set": bson.M{"suspended": true}})
+		return err
+	})
+	if err != nil {
+		respondError(c, orNotFound(err, "User not found"))
+		return
+	}
+	events.disconnect(userId, primitive.NilObjectID)
+
+	purgeAt := now.Add(uc.cfg.UserDeletionGrace)
+	audit(c, AuditUserDeleted, auditTargetUser, userId, gin.H{"keepFiles": keepFiles, "purgeAt": purgeAt})
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully", "purgeAt": purgeAt})
+}
+
+// RestoreUser handler undoes the deletion of an account within
+// Config.UserDeletionGrace. Its share links work again unless it is also
+// suspended; sessions revoked by the deletion stay revoked.
+func (ac *AdminController) RestoreUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := ac.db
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		err = withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+			filter := bson.M{"_id": objId, "deletedAt": bson.M{"$gt": time.Now().Add(-ac.cfg.UserDeletionGrace)}}
+			update := bson.M{"$set": bson.M{"live": true}, "$unset": bson.M{"deletedAt": "", "deletionKeepsFiles": ""}}
+			opts := options.FindOneAndUpdate().SetProjection(bson.M{"status": 1})
+
+			var user models.User
+			if err := db.Collection(UserCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&user); err != nil {
+				return err
+			}
+			if user.Status == models.UserStatusSuspended {
+				return nil
+			}
+
+			_, err := db.Collection(ShareCollection).UpdateMany(ctx, bson.M{"ownerId": objId}, bson.M{"$unset": bson.M{"suspended": ""}})
+			return err
+		})
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("No deleted user to restore"))
+				return
+			}
+			respondError(c, orConflict(err, "another account has taken this email since it was deleted"))
+			return
+		}
+
+		audit(c, AuditUserRestored, auditTargetUser, objId, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "User restored successfully"})
+	}
+}
+
+// respondPendingDeletion rejects registering email while the account that
+// had it waits to be purged, and reports whether it did.
+func respondPendingDeletion(ctx context.Context, c *gin.Context, db *mongo.Database, grace time.Duration, email string) bool {
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"deletedAt": 1})
+	filter := bson.M{"email": email, "deletedAt": bson.M{"$exists": true}}
+	if err := db.Collection(UserCollection).FindOne(ctx, filter, opts).Decode(&user); err != nil {
+		return false
+	}
+
+	purgeAt := user.DeletedAt.Add(grace)
+	message := fmt.Sprintf("an account with this email was deleted and can still be restored; the email can be registered again after %s", purgeAt.UTC().Format(time.RFC3339))
+	respondError(c, conflict(message).withDetails(gin.H{"purgeAt": purgeAt}))
+	return true
+}
+
+type cleanupFailures struct {
+	files  int64
+	shares int64
+}
+
+// purgeUser hard-deletes the account of userId, if it also matches cond,
+// with its sessions and everything cleanupUserData removes, then its avatar
+// and file content. It reports whether the account was removed; an error
+// after that describes what was left behind.
+func purgeUser(ctx context.Context, db *mongo.Database, cfg *Config, userId primitive.ObjectID, cond bson.M, keepFiles bool) (bool, error) {
+	filter := bson.M{"_id": userId}
+	for key, value := range cond {
+		filter[key] = value
+	}
+
+	var purged []models.File
+	err := withTransaction(ctx, db.Client(), func(ctx context.Context) error {
+		result, err := db.Collection(UserCollection).DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+
+		if err := revokeUserSessions(ctx, db, userId); err != nil {
+			return err
+		}
+
+		purged, err = cleanupUserData(ctx, db, userId, keepFiles)
+		return err
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, err
+		}
+		if transactionsSupported {
+			return false, internalError("User could not be deleted", err)
+		}
+		failed := leftoverUserData(ctx, db, userId)
+		message := fmt.Sprintf("User deletion stopped partway; %d files and %d shares are left behind", failed.files, failed.shares)
+		return true, internalError(message, err).withDetails(gin.H{"filesFailed": failed.files, "sharesFailed": failed.shares})
+	}
+	events.disconnect(userId, primitive.NilObjectID)
+
+	if err := deleteUserAvatars(ctx, db, userId); err != nil {
+		return true, internalError("User deleted but their avatar could not be removed", err)
+	}
+
+	if failed := purgeContent(ctx, db, cfg, purged); len(failed) > 0 {
+		message := fmt.Sprintf("User deleted but %d stored blobs could not be removed", len(failed))
+		return true, internalError(message, nil).withDetails(gin.H{"gridfsIds": failed})
+	}
+	return true, nil
+}
+
+// cleanupUserData removes the shares and their analytics, file requests,
+// grants, tokens, notifications, groups and webhooks of a deleted user and
+// either deletes their files or hands them to OrphanedOwnerID. It runs in
+// the transaction that deletes the user and returns the files it deleted,
+// whose content is removed once that commits.
+func cleanupUserData(ctx context.Context, db *mongo.Database, ownerId primitive.ObjectID, keepFiles bool) ([]models.File, error) {
+	filter := bson.M{"ownerId": ownerId}
+
+	for _, name := range []string{ShareCollection, ShareAccessCollection, FileRequestCollection} {
+		if _, err := db.Collection(name).DeleteMany(ctx, filter); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range []string{PermissionCollection, StarCollection, FileAccessCollection, VerificationCollection, PasswordResetCollection, APIKeyCollection, NotificationCollection} {
+		if _, err := db.Collection(name).DeleteMany(ctx, bson.M{"userId": ownerId}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := deleteWebhooks(ctx, db, ownerId); err != nil {
+		return nil, err
+	}
+	if err := removeUserFromGroups(ctx, db, ownerId); err != nil {
+		return nil, err
+	}
+
+	if keepFiles {
+		for _, name := range []string{PermissionCollection, FileCollection, FolderCollection} {
+			update := bson.M{"$set": bson.M{"ownerId": OrphanedOwnerID}}
+			if name == FileCollection {
+				// Nobody browses the orphaned files, and the root files of
+				// two users may share names, so they give up theirs.
+				update["$unset"] = bson.M{"live": ""}
+			}
+			if _, err := db.Collection(name).UpdateMany(ctx, filter, update); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	files, err := findFilesToPurge(ctx, db, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		if err := deleteFileRecords(ctx, db, files); err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+
+	if _, err := db.Collection(FolderCollection).DeleteMany(ctx, filter); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// leftoverUserData counts what a user deletion that failed without a
+// transaction left behind, for the response and for reconciliation.
+func leftoverUserData(ctx context.Context, db *mongo.Database, ownerId primitive.ObjectID) cleanupFailures {
+	var failed cleanupFailures
+	filter := bson.M{"ownerId": ownerId}
+	failed.files, _ = db.Collection(FileCollection).CountDocuments(ctx, filter)
+	failed.shares, _ = db.Collection(ShareCollection).CountDocuments(ctx, filter)
+	return failed
+}
+
+// purgeDeletedUsers purges the accounts deleted longer than
+// cfg.UserDeletionGrace ago while holding the user purge lock, returning how
+// many it removed. It returns errUserPurgeRunning when another run holds
+// the lock.
+func purgeDeletedUsers(ctx context.Context, db *mongo.Database, cfg *Config) (int, error) {
+	acquired, err := acquireLock(ctx, db, userPurgeLock, cfg.TrashPurgeInterval)
+	if err != nil {
+		return 0, err
+	}
+	if !acquired {
+		return 0, errUserPurgeRunning
+	}
+	defer func() {
+		if err := releaseLock(context.Background(), db, userPurgeLock); err != nil {
+			log.Printf("releasing user purge lock failed: %v", err)
+		}
+	}()
+
+	// Re-checked per account, so one restored since the find is kept.
+	expired := bson.M{"deletedAt": bson.M{"$lt": time.Now().Add(-cfg.UserDeletionGrace)}}
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "deletionKeepsFiles": 1})
+
+	var users []models.User
+	if err := findAll(ctx, db.Collection(UserCollection), expired, &users, opts); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var firstErr error
+	for _, user := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		deleted, err := purgeUser(ctx, db, cfg, user.Id, expired, user.DeletionKeepsFiles)
+		if deleted {
+			purged++
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Printf("purging deleted user %s failed: %v", user.Id.Hex(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if len(users) > 0 {
+		log.Printf("user purge: removed %d of %d deleted accounts", purged, len(users))
+	}
+	if firstErr != nil {
+		return purged, firstErr
+	}
+	return purged, ctx.Err()
+}
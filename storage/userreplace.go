@@ -0,0 +1,237 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errUserChanged = errors.New("user changed while it was being replaced, try again")
+
+// ReplaceUserRequest is the body of ReplaceUser: the whole client-managed
+// part of an account. Unlike UpdateUserRequest, where a missing field is left
+// as it is, a missing optional field is reset: the preferences to false and,
+// when an admin replaces the account, quotaBytes and downloadRate to the
+// defaults.
+type ReplaceUserRequest struct {
+	Name  string `json:"name" binding:"required,min=1,max=100"`
+	Email string `json:"email" binding:"required,email"`
+	// Password replaces the password when given. The hash is never sent
+	// to clients, so leaving it out keeps the current one. Only admins may
+	// set it: users change their own through ChangePassword.
+	Password *string `json:"password" binding:"omitempty,min=8"`
+	// Role is kept when left out, since every account has one. Only admins
+	// may change it.
+	Role string `json:"role" binding:"omitempty,oneof=user admin"`
+	// QuotaBytes and DownloadRate are managed by admins; for anyone else
+	// they are kept when left out and must be unchanged when given.
+	QuotaBytes            *int64 `json:"quotaBytes" binding:"omitempty,min=0"`
+	DownloadRate          *int64 `json:"downloadRate" binding:"omitempty,min=0"`
+	DisableAccessTracking bool   `json:"disableAccessTracking"`
+	DisableShareEmails    bool   `json:"disableShareEmails"`
+}
+
+// apply turns the request into the fields to $set and $unset on the stored
+// account. Only the client-managed fields are written, and of those only
+// the ones the caller may change, so whatever else changes in the meantime
+// is kept. It also returns the names of the fields it set or cleared.
+func (r ReplaceUserRequest) apply(admin bool) (set, unset bson.M, fields []string, err error) {
+	set, unset = bson.M{"name": r.Name, "email": normalizeEmail(r.Email)}, bson.M{}
+	fields = []string{"name", "email", "disableAccessTracking", "disableShareEmails"}
+	setOrUnset(set, unset, "disableAccessTracking", r.DisableAccessTracking)
+	setOrUnset(set, unset, "disableShareEmails", r.DisableShareEmails)
+
+	if r.Password != nil {
+		hash, err := hashPassword(*r.Password)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		set["password"] = hash
+		fields = append(fields, "password")
+	}
+
+	if admin {
+		if r.Role != "" {
+			set["role"] = r.Role
+			fields = append(fields, "role")
+		}
+
+		if r.QuotaBytes != nil {
+			set["quotaBytes"] = *r.QuotaBytes
+		} else {
+			unset["quotaBytes"] = ""
+		}
+		fields = append(fields, "quotaBytes")
+
+		if r.DownloadRate != nil {
+			set["downloadRate"] = *r.DownloadRate
+		} else {
+			unset["downloadRate"] = ""
+		}
+		fields = append(fields, "downloadRate")
+	}
+	return set, unset, fields, nil
+}
+
+// setOrUnset stores a flag that is omitted from the document while false.
+func setOrUnset(set, unset bson.M, key string, value bool) {
+	if value {
+		set[key] = true
+	} else {
+		unset[key] = ""
+	}
+}
+
+// ReplaceUser handler replaces the client-managed fields of an account with
+// the request body, as described by ReplaceUserRequest, keeping everything
+// the server manages: id, creation time, usage, status, deletion,
+// verification, two-factor and identity settings, avatar and, unless an
+// admin sets a new one, the password. Sending back a user as GET returned it
+// changes nothing.
+func (uc *UserController) ReplaceUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			respondError(c, badRequest("Invalid user ID"))
+			return
+		}
+
+		if !authorizeUser(c, objId) {
+			return
+		}
+
+		// Unlike PATCH, unknown fields are ignored, so a user can be sent
+		// back with the read-only fields GET returned.
+		var req ReplaceUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		admin := isAdmin(c)
+
+		// As in UpdateUser, users change their own password through
+		// ChangePassword, which checks the current one first.
+		if req.Password != nil && !admin {
+			respondError(c, forbidden("use POST /users/me/password to change your password"))
+			return
+		}
+
+		set, unset, fields, err := req.apply(admin)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+		update := bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+
+		// Whether the email changes depends on the account as read, so the
+		// update is made only while the email is the same and redone on the
+		// fresh account otherwise.
+		readOpts := options.FindOne().SetProjection(bson.M{"email": 1, "role": 1, "quotaBytes": 1, "downloadRate": 1})
+		updateOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		var user models.User
+		var emailChanged bool
+		err = errUserChanged
+		for attempt := 0; attempt < versionRetries && err == errUserChanged; attempt++ {
+			var current bson.M
+			if err = collection.FindOne(ctx, bson.M{"_id": objId}, readOpts).Decode(&current); err != nil {
+				break
+			}
+
+			if !admin {
+				if req.Role != "" && req.Role != current["role"] {
+					err = forbidden("only admins can change roles")
+					break
+				}
+				if req.QuotaBytes != nil && !sameInt64(current["quotaBytes"], *req.QuotaBytes) {
+					err = forbidden("only admins can change quotas")
+					break
+				}
+				if req.DownloadRate != nil && !sameInt64(current["downloadRate"], *req.DownloadRate) {
+					err = forbidden("only admins can change download rates")
+					break
+				}
+			}
+
+			// A new address has to be verified again before the account
+			// can upload or share.
+			emailChanged = set["email"] != current["email"]
+			if emailChanged && uc.cfg.RequireEmailVerification {
+				set["emailVerified"] = false
+			} else {
+				delete(set, "emailVerified")
+			}
+
+			filter := bson.M{"_id": objId, "email": current["email"]}
+			if err = collection.FindOneAndUpdate(ctx, filter, update, updateOpts).Decode(&user); err == mongo.ErrNoDocuments {
+				err = errUserChanged
+			}
+		}
+		if err != nil {
+			if err == errUserChanged {
+				respondError(c, conflict(err.Error()))
+				return
+			}
+			respondError(c, orNotFound(orConflict(err, "email already registered"), "User not found"))
+			return
+		}
+
+		// A password set by an admin signs the user out everywhere, as
+		// ChangePassword does.
+		if req.Password != nil {
+			if err := revokeUserSessions(ctx, uc.db, objId); err != nil {
+				respondError(c, err)
+				return
+			}
+			events.disconnect(objId, primitive.NilObjectID)
+		}
+
+		audit(c, AuditUserUpdated, auditTargetUser, objId, gin.H{"fields": fields, "replace": true})
+
+		if emailChanged && uc.cfg.RequireEmailVerification {
+			if err := sendVerification(ctx, uc.db, uc.cfg, &user); err != nil {
+				log.Printf("sending verification to user %s failed: %v", objId.Hex(), err)
+			}
+		}
+
+		// Opting out also forgets the history recorded so far.
+		if user.DisableAccessTracking {
+			accesses := uc.db.Collection(FileAccessCollection)
+			if _, err := accesses.DeleteMany(ctx, bson.M{"userId": objId}); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+
+		if user.AvatarId != nil {
+			user.AvatarURL = uc.cfg.avatarURL(user.Id, *user.AvatarId)
+		}
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// sameInt64 reports whether a stored integer value, such as quotaBytes,
+// equals n.
+func sameInt64(stored any, n int64) bool {
+	switch v := stored.(type) {
+	case int64:
+		return v == n
+	case int32:
+		return int64(v) == n
+	}
+	return false
+}
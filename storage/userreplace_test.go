@@ -0,0 +1,194 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// replacement is the update ReplaceUser sent for the stored account.
+func replacement(mt *mtest.T) bson.Raw {
+	mt.Helper()
+	return sentCommand(mt, "findAndModify").Lookup("update").Document()
+}
+
+func TestReplaceUser(t *testing.T) {
+	mt := newMockDB(t)
+	userId := primitive.NewObjectID()
+	quota := int64(1 << 30)
+	stored := models.User{
+		Id:                 userId,
+		Name:               "Ada",
+		Email:              "ada@example.com",
+		Password:           "$2a$10$stored",
+		Role:               models.RoleUser,
+		QuotaBytes:         &quota,
+		UsedBytes:          4096,
+		DisableShareEmails: true,
+		TwoFactorEnabled:   true,
+		TOTPSecret:         "secret",
+		CreatedAt:          time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	replaced := func(mt *mtest.T, user models.User) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bsonDoc(mt, user)})
+	}
+	put := func(mt *mtest.T, role string, body any) *httptest.ResponseRecorder {
+		return doRequest(userRouter(NewUserController(mt.DB, testConfig()), userId, role), http.MethodPut, "/users/"+userId.Hex(), body)
+	}
+
+	mt.Run("omitted fields are cleared", func(mt *mtest.T) {
+		result := stored
+		result.Name, result.DisableShareEmails = "Ada Lovelace", false
+		mt.AddMockResponses(found(mt, UserCollection, stored), replaced(mt, result))
+		rec := put(mt, models.RoleUser, gin.H{"name": "Ada Lovelace", "email": "ada@example.com"})
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		update := replacement(mt)
+		if update.Lookup("$set", "name").StringValue() != "Ada Lovelace" {
+			mt.Errorf("update = %s, want the new name set", update)
+		}
+		if _, err := update.LookupErr("$unset", "disableShareEmails"); err != nil {
+			mt.Error("disableShareEmails was kept, want it cleared when left out")
+		}
+		// Server-managed fields, and those only admins manage, are not
+		// written, so changes made to them meanwhile survive.
+		for _, key := range []string{"password", "role", "quotaBytes", "downloadRate", "usedBytes", "status", "deletedAt", "twoFactorEnabled", "totpSecret", "createdAt"} {
+			for _, op := range []string{"$set", "$unset"} {
+				if _, err := update.LookupErr(op, key); err == nil {
+					mt.Errorf("update = %s, want %s left alone", update, key)
+				}
+			}
+		}
+
+		filter := sentCommand(mt, "findAndModify").Lookup("query").Document()
+		if filter.Lookup("email").StringValue() != stored.Email {
+			mt.Errorf("filter = %s, want the email as read", filter)
+		}
+		body := decodeJSON(mt, rec)
+		if body["name"] != "Ada Lovelace" || body["disableShareEmails"] != false {
+			mt.Errorf("body = %v, want the replaced user", body)
+		}
+		if _, ok := body["password"]; ok {
+			mt.Error("response carries the password hash")
+		}
+	})
+
+	mt.Run("PATCH leaves omitted fields alone", func(mt *mtest.T) {
+		updated := stored
+		updated.Name = "Ada Lovelace"
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bsonDoc(mt, updated)}))
+		rec := doRequest(userRouter(NewUserController(mt.DB, testConfig()), userId, models.RoleUser), http.MethodPatch, "/users/"+userId.Hex(), gin.H{"name": "Ada Lovelace"})
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		update := sentCommand(mt, "findAndModify").Lookup("update").Document()
+		set, _ := update.Lookup("$set").Document().Elements()
+		if _, err := update.LookupErr("$unset"); err == nil || len(set) != 1 {
+			mt.Errorf("update = %s, want only the name set", update)
+		}
+		if decodeJSON(mt, rec)["disableShareEmails"] != true {
+			mt.Error("PATCH cleared disableShareEmails")
+		}
+	})
+
+	mt.Run("new password", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, stored), replaced(mt, stored),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}))
+		rec := put(mt, models.RoleAdmin, gin.H{"name": "Ada", "email": "ada@example.com", "password": "battery staple"})
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		if hash := replacement(mt).Lookup("$set", "password").StringValue(); !checkPassword(hash, "battery staple") {
+			mt.Errorf("password %q is not a bcrypt hash of the new one", hash)
+		}
+
+		// The user's sessions are all revoked.
+		revoke := sentCommand(mt, "update")
+		filter := revoke.Lookup("updates", "0", "q").Document()
+		if revoke.Lookup("update").StringValue() != SessionCollection || filter.Lookup("userId").ObjectID() != userId {
+			mt.Errorf("sent %s, want the user's sessions revoked", revoke)
+		}
+	})
+
+	mt.Run("password from the user", func(mt *mtest.T) {
+		rec := put(mt, models.RoleUser, gin.H{"name": "Ada", "email": "ada@example.com", "password": "battery staple"})
+		if rec.Code != http.StatusForbidden {
+			mt.Fatalf("status = %d, want 403", rec.Code)
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			mt.Error("the password change reached the database")
+		}
+	})
+
+	mt.Run("admin clears the quota", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, stored), replaced(mt, stored))
+		rec := put(mt, models.RoleAdmin, gin.H{"name": "Ada", "email": "ada@example.com", "role": models.RoleAdmin})
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		update := replacement(mt)
+		if _, err := update.LookupErr("$unset", "quotaBytes"); err != nil || update.Lookup("$set", "role").StringValue() != models.RoleAdmin {
+			mt.Errorf("update = %s, want the role changed and the default quota", update)
+		}
+	})
+
+	mt.Run("role from a non-admin", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, stored))
+		rec := put(mt, models.RoleUser, gin.H{"name": "Ada", "email": "ada@example.com", "role": models.RoleAdmin})
+		if rec.Code != http.StatusForbidden {
+			mt.Fatalf("status = %d, want 403", rec.Code)
+		}
+		if len(mt.GetAllStartedEvents()) != 1 {
+			mt.Error("the role change reached the database")
+		}
+	})
+
+	mt.Run("required fields", func(mt *mtest.T) {
+		rec := put(mt, models.RoleUser, gin.H{"name": "Ada"})
+		if rec.Code != http.StatusBadRequest {
+			mt.Fatalf("status = %d, want 400", rec.Code)
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			mt.Error("an incomplete user reached the database")
+		}
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection))
+		if rec := put(mt, models.RoleUser, gin.H{"name": "Ada", "email": "ada@example.com"}); rec.Code != http.StatusNotFound {
+			mt.Errorf("status = %d, want 404", rec.Code)
+		}
+	})
+
+	mt.Run("email taken", func(mt *mtest.T) {
+		mt.AddMockResponses(found(mt, UserCollection, stored), duplicateKey())
+		rec := put(mt, models.RoleUser, gin.H{"name": "Ada", "email": "grace@example.com"})
+		if rec.Code != http.StatusConflict {
+			mt.Fatalf("status = %d, want 409", rec.Code)
+		}
+		if got := decodeError(mt.T, rec).Error.Message; !strings.Contains(got, "email already registered") {
+			mt.Errorf("message = %q", got)
+		}
+	})
+
+	mt.Run("changed concurrently", func(mt *mtest.T) {
+		missed := mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil})
+		for i := 0; i < versionRetries; i++ {
+			mt.AddMockResponses(found(mt, UserCollection, stored), missed)
+		}
+		if rec := put(mt, models.RoleUser, gin.H{"name": "Ada", "email": "ada@example.com"}); rec.Code != http.StatusConflict {
+			mt.Errorf("status = %d, want 409 after %d attempts", rec.Code, versionRetries)
+		}
+	})
+}
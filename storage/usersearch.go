@@ -0,0 +1,107 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// userSearchLimit caps the matches of one search.
+	userSearchLimit = 10
+	// userSearchMinLength is the shortest query that is searched for.
+	userSearchMinLength = 2
+	userSearchMaxLength = 100
+)
+
+// userSearchResult is a match of SearchUsers, carrying only what a share
+// picker shows.
+type userSearchResult struct {
+	Id        primitive.ObjectID  `json:"id" bson:"_id"`
+	Name      string              `json:"name" bson:"name"`
+	Email     string              `json:"email" bson:"email"`
+	AvatarId  *primitive.ObjectID `json:"-" bson:"avatarId,omitempty"`
+	AvatarURL string              `json:"avatarUrl,omitempty" bson:"-"`
+}
+
+// SearchUsers handler finds accounts whose name or email starts with ?q=,
+// ignoring case, for picking whom to share with. Regular users only find
+// other active accounts, and see their addresses masked: enough to tell
+// matches apart, not to harvest them. Admins see every account that isn't
+// deleted, with full addresses. Queries shorter than userSearchMinLength
+// find nothing.
+func (uc *UserController) SearchUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := uc.db.Collection(UserCollection)
+
+		q := strings.TrimSpace(c.Query("q"))
+		if utf8.RuneCountInString(q) > userSearchMaxLength {
+			respondError(c, badRequest("q must be at most 100 characters"))
+			return
+		}
+		if utf8.RuneCountInString(q) < userSearchMinLength {
+			c.JSON(http.StatusOK, []userSearchResult{})
+			return
+		}
+
+		// Emails are stored lowercased, so their prefix match is case
+		// sensitive and can use the unique email index; names match
+		// case-insensitively on the name index.
+		prefix := "^" + regexp.QuoteMeta(q)
+		filter := bson.M{
+			"$or": bson.A{
+				bson.M{"name": primitive.Regex{Pattern: prefix, Options: "i"}},
+				bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.ToLower(q))}},
+			},
+			"deletedAt": userNotDeleted,
+		}
+		admin := isAdmin(c)
+		if !admin {
+			filter["_id"] = bson.M{"$ne": currentUserID(c)}
+			filter["status"] = bson.M{"$ne": models.UserStatusSuspended}
+		}
+
+		opts := options.Find().
+			SetProjection(bson.M{"_id": 1, "name": 1, "email": 1, "avatarId": 1}).
+			SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(userSearchLimit)
+
+		results := []userSearchResult{}
+		if err := findAll(ctx, collection, filter, &results, opts); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		for i := range results {
+			if results[i].AvatarId != nil {
+				results[i].AvatarURL = uc.cfg.avatarURL(results[i].Id, *results[i].AvatarId)
+			}
+			if !admin {
+				results[i].Email = maskEmail(results[i].Email)
+			}
+		}
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// maskEmail hides all but the first two characters of the local part of
+// email, e.g. "jo***@example.com".
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return "***"
+	}
+	if runes := []rune(local); len(runes) > 2 {
+		local = string(runes[:2])
+	}
+	return local + "***@" + domain
+}
@@ -0,0 +1,192 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var VerificationCollection string = "verificationTokens"
+
+// verificationExpiryGrace is how long expired tokens are kept so they can be
+// told apart from invalid ones, before the TTL index removes them.
+const verificationExpiryGrace = 7 * 24 * time.Hour
+
+// ErrorCodeEmailUnverified is the "code" of the 403 sent to unverified
+// accounts, so clients can offer to resend the email.
+const ErrorCodeEmailUnverified = "email_unverified"
+
+// verifyResendLimit caps verification emails per user.
+var verifyResendLimit = newFailureLimiter(3, time.Hour)
+
+// sendVerification replaces any pending token of user with a new one and
+// queues the verification email.
+func sendVerification(ctx context.Context, db *mongo.Database, cfg *Config, user *models.User) error {
+	collection := db.Collection(VerificationCollection)
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	if _, err := collection.DeleteMany(ctx, bson.M{"userId": user.Id}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	record := models.VerificationToken{
+		Id:        primitive.NewObjectID(),
+		UserId:    user.Id,
+		Email:     user.Email,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.VerificationTokenTTL),
+	}
+	if _, err := collection.InsertOne(ctx, record); err != nil {
+		return err
+	}
+
+	queueMail(user.Email, mailVerifyEmail, gin.H{
+		"Name":      user.Name,
+		"Link":      cfg.apiURL("/auth/verify?token=" + url.QueryEscape(token)),
+		"ExpiresAt": record.ExpiresAt,
+	})
+	return nil
+}
+
+// RequireVerified rejects callers whose email address is still unverified.
+// It must run after AuthRequired.
+func RequireVerified(db *mongo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collection := db.Collection(UserCollection)
+
+		var user models.User
+		opts := options.FindOne().SetProjection(bson.M{"emailVerified": 1})
+		if err := collection.FindOne(c.Request.Context(), bson.M{"_id": currentUserID(c)}, opts).Decode(&user); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, unauthorized("user no longer exists"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		if !user.IsVerified() {
+			respondError(c, newAPIError(http.StatusForbidden, ErrorCodeEmailUnverified, "verify your email address first"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// VerifyEmail handler consumes a verification token and marks the address
+// verified. Expired tokens get 410 with code "token_expired", unknown or
+// used ones 400 with "token_invalid".
+func (ac *AuthController) VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := ac.db
+
+		token := c.Query("token")
+		if token == "" {
+			respondError(c, newAPIError(http.StatusBadRequest, "token_invalid", "token is required"))
+			return
+		}
+
+		tokens := db.Collection(VerificationCollection)
+
+		var record models.VerificationToken
+		if err := tokens.FindOne(ctx, bson.M{"tokenHash": hashToken(token)}).Decode(&record); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, newAPIError(http.StatusBadRequest, "token_invalid", "invalid verification token"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		// Expired tokens are left in place so they keep answering 410.
+		now := time.Now()
+		if !now.Before(record.ExpiresAt) {
+			respondError(c, newAPIError(http.StatusGone, "token_expired", "verification token has expired"))
+			return
+		}
+
+		// Deleting is what makes the token single-use.
+		result, err := tokens.DeleteOne(ctx, bson.M{"_id": record.Id, "expiresAt": bson.M{"$gt": now}})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if result.DeletedCount == 0 {
+			respondError(c, newAPIError(http.StatusBadRequest, "token_invalid", "invalid verification token"))
+			return
+		}
+
+		// The address must not have changed since the token was sent.
+		filter := bson.M{"_id": record.UserId, "email": record.Email}
+		updated, err := db.Collection(UserCollection).UpdateOne(ctx, filter, bson.M{"$set": bson.M{"emailVerified": true}})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if updated.MatchedCount == 0 {
+			respondError(c, newAPIError(http.StatusBadRequest, "token_invalid", "invalid verification token"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+	}
+}
+
+// ResendVerification handler sends the caller a new verification email.
+func (ac *AuthController) ResendVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		userId := currentUserID(c)
+
+		user, err := loadQuotaUser(ctx, ac.db, userId)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("User not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		if user.IsVerified() {
+			respondError(c, conflict("email address is already verified"))
+			return
+		}
+
+		key := userId.Hex()
+		if blocked, retryAfter := verifyResendLimit.Blocked(key); blocked {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			respondError(c, newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "too many verification emails, try again later"))
+			return
+		}
+		verifyResendLimit.Fail(key)
+
+		if err := sendVerification(ctx, ac.db, ac.cfg, user); err != nil {
+			log.Printf("resending verification to user %s failed: %v", key, err)
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+	}
+}
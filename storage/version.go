@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeController is implemented by the controllers mounted under
+// Config.APIPrefix.
+type routeController interface {
+	BasicRoute(router *gin.RouterGroup)
+}
+
+// routeTemplate is the matched route relative to APIPrefix, so
+// "/files/:id" for both /api/v1/files/:id and the legacy /files/:id.
+// Settings keyed by route use it to cover both.
+func (cfg *Config) routeTemplate(c *gin.Context) string {
+	return strings.TrimPrefix(c.FullPath(), cfg.APIPrefix)
+}
+
+// apiURL is the public URL of an API path such as "/s/<token>".
+func (cfg *Config) apiURL(path string) string {
+	return cfg.PublicBaseURL + cfg.APIPrefix + path
+}
+
+// Deprecated marks responses from the legacy routes with a Deprecation
+// header and a Link to the same path under cfg.APIPrefix.
+func Deprecated(cfg *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+cfg.apiURL(c.Request.URL.Path)+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIPrefixAndLegacyRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.PublicBaseURL = "https://files.example.com"
+	router := newRouter(nil, cfg)
+
+	// Without a token both answer 401 before anything reaches the database.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cfg.APIPrefix+"/users/me", nil))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Deprecation") != "" {
+		t.Errorf("%s/users/me: status %d, Deprecation %q; want 401 and no deprecation", cfg.APIPrefix, rec.Code, rec.Header().Get("Deprecation"))
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/me", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("/users/me: status %d, want the legacy path served", rec.Code)
+	}
+	if rec.Header().Get("Deprecation") != "true" || rec.Header().Get("Link") != `<https://files.example.com`+cfg.APIPrefix+`/users/me>; rel="successor-version"` {
+		t.Errorf("legacy headers: Deprecation %q, Link %q", rec.Header().Get("Deprecation"), rec.Header().Get("Link"))
+	}
+
+	// Every API route has its legacy twin.
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+	for _, route := range router.Routes() {
+		if path, ok := strings.CutPrefix(route.Path, cfg.APIPrefix); ok && !registered[route.Method+" "+path] {
+			t.Errorf("%s %s has no legacy route", route.Method, route.Path)
+		}
+	}
+}
+
+func TestAPIPrefixFromConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.APIPrefix = "/storage/api"
+	cfg.LegacyRoutes = false
+	router := newRouter(nil, cfg)
+
+	for path, want := range map[string]int{
+		"/storage/api/users/me": http.StatusUnauthorized,
+		"/users/me":             http.StatusNotFound,
+		"/api/v1/users/me":      http.StatusNotFound,
+		"/healthz":              http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
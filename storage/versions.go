@@ -0,0 +1,288 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// versionRetries bounds how often a version change is retried when the file
+// changes underneath it.
+const versionRetries = 3
+
+var errVersionConflict = errors.New("file was modified concurrently, try again")
+var errVersionNotFound = errors.New("version not found")
+
+// versionItem is a version as listed by GetVersions.
+type versionItem struct {
+	models.FileVersion
+	Current bool `json:"current"`
+}
+
+// saveUploadedFile stores the metadata of a freshly uploaded file. When the
+// owner already has a live file of that name in the same folder, mode
+// decides: with onConflictOverwrite the upload becomes that file's current
+// version, with onConflictRename it is stored under the first free " (n)"
+// name, and with onConflictError it fails with a *NameConflictError. The
+// unique name index decides which of two concurrent uploads gets a name; an
+// overwrite of a file that changes or goes away meanwhile is retried. It
+// reports whether a new document was created.
+func saveUploadedFile(ctx context.Context, db *mongo.Database, cfg *Config, file *models.File, mode string) (*models.File, bool, error) {
+	collection := db.Collection(FileCollection)
+	name := file.Name
+	file.Version = 1
+	file.Live = true
+
+	insert := func(candidate string) error {
+		file.Name = candidate
+		_, err := collection.InsertOne(ctx, file)
+		return err
+	}
+
+	// Only renames look past the name asked for; the other modes deal with
+	// the file holding it.
+	insertMode := onConflictError
+	if mode == onConflictRename {
+		insertMode = onConflictRename
+	}
+
+	for attempt := 0; attempt < versionRetries; attempt++ {
+		_, err := saveFileName(ctx, collection, file.OwnerId, file.FolderId, name, insertMode, nil, insert)
+		if err == nil {
+			return file, true, nil
+		}
+		if !errors.Is(err, errNameConflict) || mode == onConflictRename {
+			return nil, false, err
+		}
+
+		var existing models.File
+		err = collection.FindOne(ctx, bson.M{"ownerId": file.OwnerId, "folderId": file.FolderId, "name": name, "live": true}).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if mode == onConflictError {
+			return nil, false, &NameConflictError{ExistingId: existing.Id}
+		}
+
+		file.Name = name
+		updated, err := replaceCurrentVersion(ctx, db, cfg, &existing, file.CurrentVersion(), 0)
+		if err == errVersionConflict {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return updated, false, nil
+	}
+	return nil, false, errVersionConflict
+}
+
+// replaceCurrentVersion makes next the current content of file under a new
+// version number, keeping the old current content as a version. The version
+// numbered drop, if any, is removed from the history, and versions beyond
+// limit are pruned along with their blobs. It returns errVersionConflict
+// when file changed since it was read.
+func replaceCurrentVersion(ctx context.Context, db *mongo.Database, cfg *Config, file *models.File, next models.FileVersion, drop int) (*models.File, error) {
+	collection := db.Collection(FileCollection)
+
+	versions := make([]models.FileVersion, 0, len(file.Versions)+1)
+	for _, version := range file.Versions {
+		if version.N != drop {
+			versions = append(versions, version)
+		}
+	}
+	versions = append(versions, file.CurrentVersion())
+
+	var pruned []models.BlobRef
+	var prunedBytes int64
+	limit := cfg.FileVersionLimit
+	if limit >= 0 && len(versions) > limit {
+		for _, version := range versions[:len(versions)-limit] {
+			pruned = append(pruned, version.BlobRef)
+			prunedBytes += version.Size
+		}
+		versions = versions[len(versions)-limit:]
+	}
+
+	now := time.Now()
+	filter := bson.M{"_id": file.Id, "gridfsId": file.BlobId}
+	set := bson.M{
+		"gridfsId":    next.BlobId,
+		"size":        next.Size,
+		"contentType": next.ContentType,
+		"checksum":    next.Checksum,
+		"version":     file.CurrentVersion().N + 1,
+		"versions":    versions,
+		"updatedAt":   now,
+	}
+	unset := bson.M{"extractedText": "", "scanLease": ""}
+	for key, value := range map[string]string{
+		"storageBackend": next.StorageBackend,
+		"storageKey":     next.StorageKey,
+		"scanStatus":     next.ScanStatus,
+		"scanSignature":  next.ScanSignature,
+	} {
+		if value != "" {
+			set[key] = value
+		} else {
+			unset[key] = ""
+		}
+	}
+	if next.Encrypted {
+		set["encrypted"] = true
+	} else {
+		unset["encrypted"] = ""
+	}
+	if next.ScannedAt != nil {
+		set["scannedAt"] = next.ScannedAt
+	} else {
+		unset["scannedAt"] = ""
+	}
+	update := bson.M{"$set": set, "$unset": unset}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated models.File
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errVersionConflict
+		}
+		return nil, err
+	}
+
+	if len(pruned) > 0 {
+		releaseQuota(ctx, db, file.OwnerId, prunedBytes)
+		deleteBlobs(ctx, db, cfg, pruned)
+	}
+	return &updated, nil
+}
+
+// findVersion looks up the version named by the :n path param, which may be
+// the current one.
+func findVersion(c *gin.Context, file *models.File) (models.FileVersion, bool) {
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil || n < 1 {
+		respondError(c, badRequest("version must be a positive integer"))
+		return models.FileVersion{}, false
+	}
+
+	if current := file.CurrentVersion(); current.N == n {
+		return current, true
+	}
+	for _, version := range file.Versions {
+		if version.N == n {
+			return version, true
+		}
+	}
+
+	respondError(c, notFound(errVersionNotFound.Error()))
+	return models.FileVersion{}, false
+}
+
+// GetVersions handler lists a file's versions, newest first.
+func (fc *FileController) GetVersions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		items := []versionItem{{FileVersion: file.CurrentVersion(), Current: true}}
+		for i := len(file.Versions) - 1; i >= 0; i-- {
+			items = append(items, versionItem{FileVersion: file.Versions[i]})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"items": items, "limit": fc.cfg.FileVersionLimit})
+	}
+}
+
+// DownloadVersion handler streams the content of one version of a file.
+func (fc *FileController) DownloadVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessViewer) {
+			return
+		}
+
+		version, ok := findVersion(c, file)
+		if !ok {
+			return
+		}
+
+		if !scanAllowsDownload(c, fc.cfg.Scan, version.ScanResult) {
+			return
+		}
+
+		streamFile(c, fc.db, fc.cfg, &models.File{
+			Name:        file.Name,
+			Size:        version.Size,
+			ContentType: version.ContentType,
+			Checksum:    version.Checksum,
+			CreatedAt:   version.UploadedAt,
+			BlobRef:     version.BlobRef,
+		}, "attachment")
+	}
+}
+
+// RestoreVersion handler promotes an earlier version to be the current
+// content. The content it replaces is kept as a version.
+func (fc *FileController) RestoreVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		file, ok := findFile(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFileAccess(ctx, fc.db, c, file, accessEditor) {
+			return
+		}
+
+		version, ok := findVersion(c, file)
+		if !ok {
+			return
+		}
+
+		if version.N == file.CurrentVersion().N {
+			c.JSON(http.StatusOK, file)
+			return
+		}
+
+		updated, err := replaceCurrentVersion(ctx, fc.db, fc.cfg, file, version, version.N)
+		if err == errVersionConflict {
+			respondError(c, conflict(err.Error()))
+			return
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		enqueueContentJobs(updated)
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
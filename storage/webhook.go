@@ -0,0 +1,583 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var WebhookCollection string = "webhooks"
+var WebhookDeliveryCollection string = "webhookDeliveries"
+
+// WebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+// request body keyed with the webhook's secret.
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+// webhookPollInterval is how often due retries are looked for.
+const webhookPollInterval = 5 * time.Second
+
+// webhookEvents lists the event types a webhook can subscribe to.
+var webhookEvents = []string{AuditFileUploaded, AuditFileDeleted, AuditFileQuarantined, AuditShareCreated, AuditShareRevoked}
+
+// webhookClient sends deliveries; each request is bounded by
+// WebhookConfig.Timeout.
+var webhookClient = &http.Client{
+	// A redirect could point a signed payload anywhere; report it instead.
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// webhookEvent is an event to fan out to the webhooks of OwnerId.
+type webhookEvent struct {
+	Id        primitive.ObjectID `json:"id"`
+	Type      string             `json:"type"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Data      gin.H              `json:"data"`
+	ownerId   primitive.ObjectID
+}
+
+var pendingWebhookEvents = make(chan webhookEvent, thumbnailQueueSize)
+
+// wakeWebhookWorker nudges the delivery loop when new deliveries are queued.
+var wakeWebhookWorker = make(chan struct{}, 1)
+
+type WebhookController struct {
+	db  *mongo.Database
+	cfg *Config
+}
+
+func NewWebhookController(db *mongo.Database, cfg *Config) *WebhookController {
+	return &WebhookController{db, cfg}
+}
+
+// SetupRouter function
+func (wc *WebhookController) BasicRoute(router *gin.RouterGroup) {
+	webhookRouter := router.Group("/webhooks", AuthRequired(wc.db, wc.cfg), RequireScope(wc.cfg, scopeAccount))
+	webhookRouter.GET("/", wc.GetWebhooks())
+	webhookRouter.POST("/", wc.CreateWebhook())
+	webhookRouter.GET("/:id", wc.GetWebhook())
+	webhookRouter.PATCH("/:id", wc.UpdateWebhook())
+	webhookRouter.DELETE("/:id", wc.DeleteWebhook())
+	webhookRouter.GET("/:id/deliveries", wc.GetDeliveries())
+}
+
+type createWebhookRequest struct {
+	URL    string   `json:"url" binding:"required,url,max=2048"`
+	Events []string `json:"events" binding:"required,min=1,dive,required"`
+	Secret string   `json:"secret" binding:"omitempty,min=16,max=256"`
+}
+
+type updateWebhookRequest struct {
+	URL    *string  `json:"url" binding:"omitempty,url,max=2048"`
+	Events []string `json:"events" binding:"omitempty,min=1,dive,required"`
+	Active *bool    `json:"active"`
+}
+
+// validateWebhook checks the parts of a webhook binding tags can't.
+func validateWebhook(rawURL string, events []string) error {
+	if rawURL != "" {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("url must be an absolute http or https URL")
+		}
+	}
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q, expected one of %v", event, webhookEvents)
+		}
+	}
+	return nil
+}
+
+// CreateWebhook handler registers a webhook for the caller's files. The
+// secret is generated unless given and is only returned here.
+func (wc *WebhookController) CreateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := wc.db.Collection(WebhookCollection)
+
+		var req createWebhookRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		if err := validateWebhook(req.URL, req.Events); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		secret := req.Secret
+		if secret == "" {
+			var err error
+			if secret, err = randomToken(32); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+
+		webhook := models.Webhook{
+			Id:        primitive.NewObjectID(),
+			OwnerId:   currentUserID(c),
+			URL:       req.URL,
+			Secret:    secret,
+			Events:    slices.Compact(slices.Sorted(slices.Values(req.Events))),
+			Active:    true,
+			CreatedAt: time.Now(),
+		}
+
+		if _, err := collection.InsertOne(ctx, webhook); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"webhook": webhook, "secret": secret})
+	}
+}
+
+// GetWebhooks handler lists the caller's webhooks.
+func (wc *WebhookController) GetWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := wc.db.Collection(WebhookCollection)
+
+		webhooks := []models.Webhook{}
+		opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+		if err := findAll(ctx, collection, bson.M{"ownerId": currentUserID(c)}, &webhooks, opts); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, webhooks)
+	}
+}
+
+// GetWebhook handler
+func (wc *WebhookController) GetWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		webhook, ok := wc.findWebhook(ctx, c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, webhook)
+	}
+}
+
+// UpdateWebhook handler changes a webhook's URL, events or active flag.
+// Re-activating a disabled webhook clears its failure count and reason.
+func (wc *WebhookController) UpdateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := wc.db.Collection(WebhookCollection)
+
+		webhook, ok := wc.findWebhook(ctx, c)
+		if !ok {
+			return
+		}
+
+		var req updateWebhookRequest
+		if err := decodeStrictJSON(c, &req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		set := bson.M{"updatedAt": time.Now()}
+		unset := bson.M{}
+		if req.URL != nil {
+			if err := validateWebhook(*req.URL, nil); err != nil {
+				respondError(c, invalidRequest(err))
+				return
+			}
+			set["url"] = *req.URL
+		}
+		if req.Events != nil {
+			if err := validateWebhook("", req.Events); err != nil {
+				respondError(c, invalidRequest(err))
+				return
+			}
+			set["events"] = slices.Compact(slices.Sorted(slices.Values(req.Events)))
+		}
+		if req.Active != nil {
+			set["active"] = *req.Active
+			if *req.Active {
+				set["consecutiveFailures"] = 0
+				unset["disabledReason"] = ""
+			}
+		}
+
+		update := bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated models.Webhook
+		if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": webhook.Id}, update, opts).Decode(&updated); err != nil {
+			if err == mongo.ErrNoDocuments {
+				respondError(c, notFound("Webhook not found"))
+				return
+			}
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// DeleteWebhook handler removes a webhook and its delivery history.
+func (wc *WebhookController) DeleteWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := wc.db
+
+		webhook, ok := wc.findWebhook(ctx, c)
+		if !ok {
+			return
+		}
+
+		if _, err := db.Collection(WebhookCollection).DeleteOne(ctx, bson.M{"_id": webhook.Id}); err != nil {
+			respondError(c, err)
+			return
+		}
+		if _, err := db.Collection(WebhookDeliveryCollection).DeleteMany(ctx, bson.M{"webhookId": webhook.Id}); err != nil {
+			log.Printf("webhook %s deleted but its deliveries were not: %v", webhook.Id.Hex(), err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+	}
+}
+
+// GetDeliveries handler lists a webhook's deliveries, newest first, along
+// with its status so the reason it was disabled is visible.
+func (wc *WebhookController) GetDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		collection := wc.db.Collection(WebhookDeliveryCollection)
+
+		webhook, ok := wc.findWebhook(ctx, c)
+		if !ok {
+			return
+		}
+
+		page, err := parsePagination(c)
+		if err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+
+		filter := bson.M{"webhookId": webhook.Id}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		cursor, err := collection.Find(ctx, filter, page.FindOptions().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		deliveries := []models.WebhookDelivery{}
+		if err := cursor.All(ctx, &deliveries); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"webhook": webhook, "deliveries": page.Result(deliveries, total)})
+	}
+}
+
+// findWebhook loads the caller's webhook named by the :id path param.
+func (wc *WebhookController) findWebhook(ctx context.Context, c *gin.Context) (*models.Webhook, bool) {
+	collection := wc.db.Collection(WebhookCollection)
+
+	objId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("Invalid webhook ID"))
+		return nil, false
+	}
+
+	var webhook models.Webhook
+	if err := collection.FindOne(ctx, bson.M{"_id": objId, "ownerId": currentUserID(c)}).Decode(&webhook); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondError(c, notFound("Webhook not found"))
+			return nil, false
+		}
+		respondError(c, err)
+		return nil, false
+	}
+	return &webhook, true
+}
+
+// deleteWebhooks removes every webhook of ownerId and their deliveries.
+func deleteWebhooks(ctx context.Context, db *mongo.Database, ownerId primitive.ObjectID) error {
+
+	ids, err := db.Collection(WebhookCollection).Distinct(ctx, "_id", bson.M{"ownerId": ownerId})
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	if _, err := db.Collection(WebhookDeliveryCollection).DeleteMany(ctx, bson.M{"webhookId": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+	_, err = db.Collection(WebhookCollection).DeleteMany(ctx, bson.M{"ownerId": ownerId})
+	return err
+}
+
+// emitWebhookEvent queues an event for the webhooks of ownerId without
+// waiting for it; a full queue drops the event.
+func emitWebhookEvent(ownerId primitive.ObjectID, eventType string, data gin.H) {
+	event := webhookEvent{Id: primitive.NewObjectID(), Type: eventType, CreatedAt: time.Now(), Data: data, ownerId: ownerId}
+
+	select {
+	case pendingWebhookEvents <- event:
+	default:
+		log.Printf("webhook queue full, dropping %s event for user %s", eventType, ownerId.Hex())
+	}
+}
+
+// fileEventData is the webhook payload describing an uploaded file.
+func fileEventData(file *models.File) gin.H {
+	return gin.H{
+		"fileId":      file.Id,
+		"name":        file.Name,
+		"size":        file.Size,
+		"contentType": file.ContentType,
+		"checksum":    file.Checksum,
+		"version":     file.CurrentVersion().N,
+		"folderId":    file.FolderId,
+	}
+}
+
+// StartWebhookWorker fans queued events out into deliveries and sends due
+// deliveries until ctx is cancelled. Deliveries are stored first, so retries
+// survive restarts and can be picked up by any instance.
+func StartWebhookWorker(ctx context.Context, db *mongo.Database, cfg *Config) {
+	startWorker(func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-pendingWebhookEvents:
+				err := queueDeliveries(ctx, db, event)
+				if ctx.Err() != nil {
+					continue
+				}
+				recordJob("webhook_queue", err)
+				if err != nil {
+					log.Printf("queueing %s webhook deliveries failed: %v", event.Type, err)
+				}
+			}
+		}
+	})
+
+	startWorker(func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wakeWebhookWorker:
+			}
+			sendDueDeliveries(ctx, db, cfg.Webhooks)
+		}
+	})
+}
+
+// queueDeliveries stores a delivery of event for every matching webhook.
+func queueDeliveries(ctx context.Context, db *mongo.Database, event webhookEvent) error {
+
+	var webhooks []models.Webhook
+	filter := bson.M{"ownerId": event.ownerId, "active": true, "events": event.Type}
+	if err := findAll(ctx, db.Collection(WebhookCollection), filter, &webhooks, options.Find().SetProjection(bson.M{"_id": 1})); err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]interface{}, len(webhooks))
+	for i, webhook := range webhooks {
+		deliveries[i] = models.WebhookDelivery{
+			Id:            primitive.NewObjectID(),
+			WebhookId:     webhook.Id,
+			EventId:       event.Id,
+			Event:         event.Type,
+			Body:          string(body),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: event.CreatedAt,
+			CreatedAt:     event.CreatedAt,
+		}
+	}
+	if _, err := db.Collection(WebhookDeliveryCollection).InsertMany(ctx, deliveries); err != nil {
+		return err
+	}
+
+	select {
+	case wakeWebhookWorker <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// sendDueDeliveries claims and sends pending deliveries whose time has come,
+// one at a time. Claiming pushes nextAttemptAt past the request timeout so
+// other instances leave the delivery alone meanwhile.
+func sendDueDeliveries(ctx context.Context, db *mongo.Database, settings WebhookConfig) {
+	collection := db.Collection(WebhookDeliveryCollection)
+
+	for ctx.Err() == nil {
+		now := time.Now()
+		filter := bson.M{"status": models.DeliveryPending, "nextAttemptAt": bson.M{"$lte": now}}
+		update := bson.M{"$set": bson.M{"nextAttemptAt": now.Add(2 * settings.Timeout)}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}})
+
+		var delivery models.WebhookDelivery
+		if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery); err != nil {
+			if err != mongo.ErrNoDocuments && ctx.Err() == nil {
+				log.Printf("claiming webhook delivery failed: %v", err)
+			}
+			return
+		}
+
+		if err := attemptDelivery(ctx, db, &delivery, settings); err != nil && ctx.Err() == nil {
+			log.Printf("recording webhook delivery %s failed: %v", delivery.Id.Hex(), err)
+		}
+	}
+}
+
+// attemptDelivery sends delivery once and records the outcome, scheduling a
+// retry or giving up and counting the failure against the webhook.
+func attemptDelivery(ctx context.Context, db *mongo.Database, delivery *models.WebhookDelivery, settings WebhookConfig) error {
+	deliveries := db.Collection(WebhookDeliveryCollection)
+	webhooks := db.Collection(WebhookCollection)
+
+	var webhook models.Webhook
+	if err := webhooks.FindOne(ctx, bson.M{"_id": delivery.WebhookId}).Decode(&webhook); err != nil {
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+		webhook.Active = false
+	}
+	if !webhook.Active {
+		_, err := deliveries.UpdateOne(ctx, bson.M{"_id": delivery.Id}, bson.M{"$set": bson.M{"status": models.DeliveryFailed, "lastError": "webhook is disabled"}})
+		return err
+	}
+
+	status, sendErr := sendWebhook(ctx, &webhook, delivery, settings.Timeout)
+	attempts := delivery.Attempts + 1
+	now := time.Now()
+
+	set := bson.M{"attempts": attempts, "responseStatus": status}
+	if sendErr == nil {
+		set["status"] = models.DeliverySucceeded
+		set["deliveredAt"] = now
+		if _, err := deliveries.UpdateOne(ctx, bson.M{"_id": delivery.Id}, bson.M{"$set": set, "$unset": bson.M{"lastError": ""}}); err != nil {
+			return err
+		}
+		_, err := webhooks.UpdateOne(ctx, bson.M{"_id": webhook.Id}, bson.M{"$set": bson.M{"consecutiveFailures": 0}})
+		return err
+	}
+
+	set["lastError"] = sendErr.Error()
+	if attempts < settings.MaxAttempts {
+		set["nextAttemptAt"] = now.Add(settings.backoff(attempts))
+		_, err := deliveries.UpdateOne(ctx, bson.M{"_id": delivery.Id}, bson.M{"$set": set})
+		return err
+	}
+
+	set["status"] = models.DeliveryFailed
+	if _, err := deliveries.UpdateOne(ctx, bson.M{"_id": delivery.Id}, bson.M{"$set": set}); err != nil {
+		return err
+	}
+
+	var updated models.Webhook
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := webhooks.FindOneAndUpdate(ctx, bson.M{"_id": webhook.Id}, bson.M{"$inc": bson.M{"consecutiveFailures": 1}}, opts).Decode(&updated); err != nil {
+		return err
+	}
+	if updated.Active && updated.ConsecutiveFailures >= settings.DisableAfter {
+		reason := fmt.Sprintf("disabled after %d failed deliveries in a row; last error: %s", updated.ConsecutiveFailures, sendErr.Error())
+		_, err := webhooks.UpdateOne(ctx, bson.M{"_id": webhook.Id}, bson.M{"$set": bson.M{"active": false, "disabledReason": reason}})
+		return err
+	}
+	return nil
+}
+
+// sendWebhook POSTs the delivery body within timeout, returning the response
+// status and an error for anything but a 2xx.
+func sendWebhook(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(delivery.Body))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader([]byte(delivery.Body)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.Id.Hex())
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff is the wait before retry number attempts.
+func (c WebhookConfig) backoff(attempts int) time.Duration {
+	delay := c.RetryBase
+	for i := 1; i < attempts && delay < c.RetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, c.RetryMax)
+}
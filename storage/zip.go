@@ -0,0 +1,321 @@
+package routes
+
+import (
+	models "GinFrameWork/Models"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ZipManifestName is the archive entry listing the files that were left out.
+const ZipManifestName = "_skipped.json"
+
+// zipProgressInterval spaces out the progress events of an archive.
+const zipProgressInterval = time.Second
+
+// zipEntry is a file to be written to an archive under path.
+type zipEntry struct {
+	path string
+	file models.File
+}
+
+// skippedEntry records a requested file that was not put in an archive.
+type skippedEntry struct {
+	FileId string `json:"fileId"`
+	Reason string `json:"reason"`
+}
+
+type downloadZipRequest struct {
+	FileIds []string `json:"fileIds" binding:"required,min=1,max=1000,dive,len=24,hexadecimal"`
+}
+
+// DownloadFolder handler streams a folder and everything below it as a zip
+// archive. Files the virus scan hasn't cleared are left out and listed in
+// ZipManifestName.
+func (fc *FolderController) DownloadFolder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		folder, ok := findFolder(ctx, fc.db, c)
+		if !ok {
+			return
+		}
+
+		if !authorizeFolder(c, folder) {
+			return
+		}
+
+		ids, err := descendantFolderIds(ctx, db.Collection(FolderCollection), folder.Id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var folders []models.Folder
+		if err := findAll(ctx, db.Collection(FolderCollection), bson.M{"_id": bson.M{"$in": ids}}, &folders); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		var files []models.File
+		if err := findAll(ctx, db.Collection(FileCollection), bson.M{"folderId": bson.M{"$in": ids}, "deletedAt": notTrashed, "expiresAt": notExpired()}, &files); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		// Entry paths are relative to the downloaded folder, which becomes
+		// the archive's top-level directory.
+		paths := folderPaths(folders, folder.Id)
+		dirs := make([]string, 0, len(paths))
+		for _, dir := range paths {
+			dirs = append(dirs, dir)
+		}
+
+		entries := make([]zipEntry, 0, len(files))
+		var skipped []skippedEntry
+		for _, file := range files {
+			if reason := scanBlockReason(fc.cfg.Scan, file.ScanResult); reason != "" {
+				skipped = append(skipped, skippedEntry{FileId: file.Id.Hex(), Reason: reason})
+				continue
+			}
+			entries = append(entries, zipEntry{path: path.Join(paths[*file.FolderId], file.Name), file: file})
+		}
+
+		writeZip(c, fc.db, fc.cfg, folder.Name+".zip", dirs, entries, skipped)
+	}
+}
+
+// DownloadZip handler streams a selection of files as a zip archive. Files
+// the caller can't read or the virus scan hasn't cleared are left out and
+// listed in ZipManifestName.
+func (fc *FileController) DownloadZip() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		db := fc.db
+
+		var req downloadZipRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		objIds := make([]primitive.ObjectID, 0, len(req.FileIds))
+		seen := make(map[primitive.ObjectID]bool, len(req.FileIds))
+		for _, id := range req.FileIds {
+			objId, _ := primitive.ObjectIDFromHex(id)
+			if !seen[objId] {
+				seen[objId] = true
+				objIds = append(objIds, objId)
+			}
+		}
+
+		var files []models.File
+		if err := findAll(ctx, db.Collection(FileCollection), bson.M{"_id": bson.M{"$in": objIds}, "deletedAt": notTrashed, "expiresAt": notExpired()}, &files); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		byId := make(map[primitive.ObjectID]models.File, len(files))
+		for _, file := range files {
+			byId[file.Id] = file
+		}
+
+		userId := currentUserID(c)
+		var readable []models.File
+		var skipped []skippedEntry
+		for _, objId := range objIds {
+			file, ok := byId[objId]
+			if !ok {
+				skipped = append(skipped, skippedEntry{FileId: objId.Hex(), Reason: "not found"})
+				continue
+			}
+
+			access, err := resolveFileAccess(ctx, fc.db, c, &file)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			if access < accessViewer {
+				skipped = append(skipped, skippedEntry{FileId: objId.Hex(), Reason: "access denied"})
+				continue
+			}
+			if reason := scanBlockReason(fc.cfg.Scan, file.ScanResult); reason != "" {
+				skipped = append(skipped, skippedEntry{FileId: objId.Hex(), Reason: reason})
+				continue
+			}
+			readable = append(readable, file)
+		}
+
+		// The caller's own files keep their folder path; files shared with
+		// them go to the top level so the owner's folder names stay private.
+		var owned []models.Folder
+		if err := findAll(ctx, db.Collection(FolderCollection), bson.M{"ownerId": userId}, &owned); err != nil {
+			respondError(c, err)
+			return
+		}
+		paths := folderPaths(owned, primitive.NilObjectID)
+
+		entries := make([]zipEntry, 0, len(readable))
+		for _, file := range readable {
+			name := file.Name
+			if file.OwnerId == userId && file.FolderId != nil {
+				name = path.Join(paths[*file.FolderId], file.Name)
+			}
+			entries = append(entries, zipEntry{path: name, file: file})
+		}
+
+		writeZip(c, fc.db, fc.cfg, "files.zip", nil, entries, skipped)
+	}
+}
+
+// folderPaths maps each folder to its slash separated path. With a non-zero
+// root, paths start at the root folder's name and folders outside it are
+// left out; otherwise they start at the top of the owner's tree.
+func folderPaths(folders []models.Folder, root primitive.ObjectID) map[primitive.ObjectID]string {
+	byId := make(map[primitive.ObjectID]models.Folder, len(folders))
+	for _, folder := range folders {
+		byId[folder.Id] = folder
+	}
+
+	paths := make(map[primitive.ObjectID]string, len(folders))
+	var resolve func(id primitive.ObjectID, depth int) (string, bool)
+	resolve = func(id primitive.ObjectID, depth int) (string, bool) {
+		if p, ok := paths[id]; ok {
+			return p, true
+		}
+		folder, ok := byId[id]
+		if !ok || depth > len(folders) {
+			return "", false
+		}
+
+		p := folder.Name
+		if id != root && folder.ParentId != nil {
+			parent, ok := resolve(*folder.ParentId, depth+1)
+			if !ok {
+				return "", false
+			}
+			p = path.Join(parent, folder.Name)
+		} else if !root.IsZero() && id != root {
+			return "", false
+		}
+
+		paths[id] = p
+		return p, true
+	}
+
+	for _, folder := range folders {
+		resolve(folder.Id, 0)
+	}
+	return paths
+}
+
+// writeZip streams an archive of dirs and entries straight to the response.
+// Entries are written in path order and clashing paths get " (n)" suffixes,
+// so the same input always yields the same archive. Progress is published
+// to the caller at most every zipProgressInterval. Once the response has
+// started, errors can only be logged.
+func writeZip(c *gin.Context, db *mongo.Database, cfg *Config, filename string, dirs []string, entries []zipEntry, skipped []skippedEntry) {
+	sort.Strings(dirs)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].path != entries[j].path {
+			return entries[i].path < entries[j].path
+		}
+		return entries[i].file.Id.Hex() < entries[j].file.Id.Hex()
+	})
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", contentDisposition("attachment", filename))
+	c.Status(http.StatusOK)
+
+	w, done := throttleDownload(c, db, cfg)
+	defer done()
+	archive := zip.NewWriter(w)
+	used := make(map[string]bool, len(entries)+len(dirs)+1)
+	used[ZipManifestName] = len(skipped) > 0
+
+	for _, dir := range dirs {
+		used[dir] = true
+		if _, err := archive.Create(dir + "/"); err != nil {
+			requestLog(c).Error("zip failed", "filename", filename, "error", err)
+			return
+		}
+	}
+
+	var reported time.Time
+	for i, entry := range entries {
+		name := uniqueEntryName(used, entry.path)
+		if err := writeZipEntry(c.Request.Context(), archive, db, cfg, name, &entry.file); err != nil {
+			requestLog(c).Error("zip entry failed", "filename", filename, "entry", name, "fileId", entry.file.Id.Hex(), "error", err)
+			return
+		}
+		if i == len(entries)-1 || time.Since(reported) >= zipProgressInterval {
+			reported = time.Now()
+			publishEvent(currentUserID(c), EventZipProgress, gin.H{"filename": filename, "written": i + 1, "entries": len(entries)})
+		}
+	}
+
+	if len(skipped) > 0 {
+		manifest, err := archive.Create(ZipManifestName)
+		if err == nil {
+			err = json.NewEncoder(manifest).Encode(gin.H{"skipped": skipped})
+		}
+		if err != nil {
+			requestLog(c).Error("zip manifest failed", "filename", filename, "error", err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		requestLog(c).Error("zip failed", "filename", filename, "error", err)
+	}
+}
+
+// writeZipEntry copies the stored content of file into a new archive entry.
+func writeZipEntry(ctx context.Context, archive *zip.Writer, db *mongo.Database, cfg *Config, name string, file *models.File) error {
+	download, err := openBlob(ctx, db, cfg, file.BlobRef)
+	if err != nil {
+		return err
+	}
+	defer download.Close()
+
+	w, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: file.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	sent, err := io.Copy(w, download)
+	downloadedBytes.add(float64(sent))
+	return err
+}
+
+// uniqueEntryName returns name, or the first " (n)" variant of it not yet in
+// used, and marks the result as used.
+func uniqueEntryName(used map[string]bool, name string) string {
+	name = strings.TrimPrefix(name, "/")
+	candidate := name
+	dir, base := path.Split(name)
+	for n := 1; used[candidate]; n++ {
+		candidate = dir + suffixedName(base, n)
+	}
+	used[candidate] = true
+	return candidate
+}